@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"strconv"
+)
+
+// Argument is a parameter set of the admin method
+type Argument struct {
+	args []*string
+}
+
+// NewArgument returns a Argument
+func NewArgument(args []*string) *Argument {
+	return &Argument{
+		args: args,
+	}
+}
+
+// Len returns the number of arguments
+func (arg *Argument) Len() int {
+	return len(arg.args)
+}
+
+// Int returns a int value of the index
+func (arg *Argument) Int(index int) (int, error) {
+	if index < 0 || index >= len(arg.args) {
+		return 0, ErrInvalidArgumentIndex
+	}
+	a := arg.args[index]
+	if a == nil {
+		return 0, ErrInvalidArgumentType
+	}
+	n, err := strconv.ParseInt((*a), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), err
+}
+
+// Uint16 returns a uint16 value of the index
+func (arg *Argument) Uint16(index int) (uint16, error) {
+	if index < 0 || index >= len(arg.args) {
+		return 0, ErrInvalidArgumentIndex
+	}
+	a := arg.args[index]
+	if a == nil {
+		return 0, ErrInvalidArgumentType
+	}
+	n, err := strconv.ParseUint((*a), 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(n), err
+}
+
+// String returns a string value of the index
+func (arg *Argument) String(index int) (string, error) {
+	if index < 0 || index >= len(arg.args) {
+		return "", ErrInvalidArgumentIndex
+	}
+	a := arg.args[index]
+	if a == nil {
+		return "", ErrInvalidArgumentType
+	}
+	return (*a), nil
+}
@@ -0,0 +1,14 @@
+package admin
+
+import (
+	"errors"
+)
+
+// errors
+var (
+	ErrInvalidArgumentIndex = errors.New("invalid argument index")
+	ErrInvalidArgumentType  = errors.New("invalid argument type")
+	ErrInvalidMethod        = errors.New("invalid method")
+	ErrEmptyToken           = errors.New("empty admin token")
+	ErrUnauthorized         = errors.New("unauthorized")
+)
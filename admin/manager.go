@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// JRPCRequest is a jrpc request of the admin endpoint
+type JRPCRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// JRPCResponse is a jrpc response of the admin endpoint
+type JRPCResponse struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      interface{} `json:"id"`
+	Result  interface{} `json:"result"`
+	Error   interface{} `json:"error"`
+}
+
+// Handler handles a admin method
+type Handler func(ID interface{}, arg *Argument) (interface{}, error)
+
+// Config is the configuration of the admin endpoint
+// Every request should have the "Authorization: Bearer <Token>" header
+type Config struct {
+	Bind  string
+	Token string
+}
+
+// Manager provides the admin endpoint for the operators
+type Manager struct {
+	sync.Mutex
+	Config  *Config
+	funcMap map[string]Handler
+	server  *http.Server
+}
+
+// NewManager returns a Manager
+func NewManager(c *Config) *Manager {
+	am := &Manager{
+		Config:  c,
+		funcMap: map[string]Handler{},
+	}
+	return am
+}
+
+// Add adds a admin method to the manager
+func (am *Manager) Add(Method string, fn Handler) {
+	am.Lock()
+	defer am.Unlock()
+
+	am.funcMap[Method] = fn
+}
+
+// Run runs a http server of the admin endpoint
+func (am *Manager) Run() error {
+	if am.Config == nil || am.Config.Token == "" {
+		return ErrEmptyToken
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin", am.serveHTTP)
+
+	am.Lock()
+	am.server = &http.Server{
+		Addr:    am.Config.Bind,
+		Handler: mux,
+	}
+	server := am.server
+	am.Unlock()
+
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close terminates the admin endpoint
+func (am *Manager) Close() {
+	am.Lock()
+	server := am.server
+	am.Unlock()
+
+	if server != nil {
+		server.Close()
+	}
+}
+
+func (am *Manager) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(am.Config.Token)) == 1
+}
+
+func (am *Manager) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !am.authorized(r) {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+
+	var req JRPCRequest
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := am.handleJRPC(&req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (am *Manager) handleJRPC(req *JRPCRequest) *JRPCResponse {
+	res := &JRPCResponse{
+		JSONRPC: req.JSONRPC,
+		ID:      req.ID,
+	}
+
+	args := []*string{}
+	for _, v := range req.Params {
+		switch p := v.(type) {
+		case string:
+			args = append(args, &p)
+		case json.Number:
+			s := p.String()
+			args = append(args, &s)
+		default:
+			args = append(args, nil)
+		}
+	}
+	am.Lock()
+	fn := am.funcMap[req.Method]
+	am.Unlock()
+
+	if fn == nil {
+		res.Error = ErrInvalidMethod.Error()
+		return res
+	}
+
+	ret, err := fn(req.ID, NewArgument(args))
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Result = ret
+	}
+	return res
+}
@@ -0,0 +1,311 @@
+package announce
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fletaio/common/hash"
+	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/ttlcache"
+)
+
+// defaults of the config
+const (
+	DefaultTTL          = 3
+	DefaultSeenTTL      = 10 * time.Minute
+	DefaultFetchTimeout = 5 * time.Second
+	DefaultFetchRetries = 3
+)
+
+// Store is the blocks of the chain which are announced, the chain implements it
+type Store interface {
+	// Has returns true when the block of the hash is stored
+	Has(h hash.Hash256) bool
+	// Block returns the encoded block of the hash to answer the fetches
+	Block(h hash.Hash256) ([]byte, error)
+	// Import validates and stores the fetched block, the block which returns an error is not announced
+	Import(height uint32, h hash.Hash256, block []byte) error
+}
+
+// Caster sends the message to the peer of the address, the peer manager implements it
+type Caster interface {
+	TargetCast(addr string, m message.Message) error
+}
+
+// Config is the fan-out of the announcements
+type Config struct {
+	// TTL is the hops of the announcements made by Announce, 3 is used when it is zero
+	TTL uint8
+	// Fanout is the number of the peers which an announcement is sent to, the group peers first. Zero is all of the peers.
+	Fanout int
+	// SeenTTL is the lifetime of the announced hashes which are not handled again, 10m is used when it is zero
+	SeenTTL time.Duration
+	// FetchTimeout is the time waiting the fetched block before fetching it from the next peer which announced it, 5s is used when it is zero
+	FetchTimeout time.Duration
+	// FetchRetries is the number of the next peers which the block is fetched from after the first one times out, 3 is used when it is zero
+	FetchRetries int
+	// Clock measures the TTLs and the timeouts, the real clock is used when it is nil
+	Clock clock.Clock
+}
+
+// pending is the announced block which is being fetched from the first of the announcers
+type pending struct {
+	height     uint32
+	ttl        uint8
+	announcers []string
+	retries    int
+}
+
+func (pd *pending) hasAnnouncer(addr string) bool {
+	for _, a := range pd.announcers {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// outbox is the messages which are sent after the lock of the handler is released,
+// so a slow peer doesn't hold the handling of the other announcements
+type outbox struct {
+	peers   []mesh.Peer
+	msg     *AnnounceMessage
+	fetchTo string
+	fetch   *FetchMessage
+}
+
+func (o *outbox) flush(caster Caster) {
+	if o == nil {
+		return
+	}
+	for _, p := range o.peers {
+		p.Send(o.msg)
+	}
+	if o.fetch != nil {
+		caster.TargetCast(o.fetchTo, o.fetch)
+	}
+}
+
+// Handler is the mesh.EventHandler of the block announcements.
+// The announced hashes are handled once in the SeenTTL, the unknown blocks are fetched from the peer which announced them
+// and the blocks are announced again to the group peers first after they are imported until the TTL hops.
+type Handler struct {
+	mesh.BaseEventHandler
+	sync.Mutex
+	Config *Config
+	mesh   mesh.Mesh
+	caster Caster
+	store  Store
+	mm     *message.Manager
+	seen   *ttlcache.Cache
+	fetch  *ttlcache.Cache
+}
+
+// NewHandler returns a Handler which fans out the announcements over the mesh.
+// The mesh which implements mesh.GroupMesh sends them to the group peers first.
+// The defaults are set to the copy of the config.
+func NewHandler(Config *Config, mh mesh.Mesh, caster Caster, store Store) *Handler {
+	conf := *Config
+	Config = &conf
+	if Config.TTL == 0 {
+		Config.TTL = DefaultTTL
+	}
+	if Config.SeenTTL <= 0 {
+		Config.SeenTTL = DefaultSeenTTL
+	}
+	if Config.FetchTimeout <= 0 {
+		Config.FetchTimeout = DefaultFetchTimeout
+	}
+	if Config.FetchRetries <= 0 {
+		Config.FetchRetries = DefaultFetchRetries
+	}
+	h := &Handler{
+		Config: Config,
+		mesh:   mh,
+		caster: caster,
+		store:  store,
+		mm:     message.NewManager(),
+		seen:   ttlcache.NewWithClock(time.Minute, Config.Clock),
+		fetch:  ttlcache.NewWithClock(time.Second, Config.Clock),
+	}
+	h.mm.SetCreator(AnnounceMessageType, h.messageCreator)
+	h.mm.SetCreator(FetchMessageType, h.messageCreator)
+	h.mm.SetCreator(BlockMessageType, h.messageCreator)
+	return h
+}
+
+// Close stops the expiration of the seen hashes and the fetches
+func (h *Handler) Close() {
+	h.seen.Close()
+	h.fetch.Close()
+}
+
+// Announce sends the hash of the new block of the local chain to the peers
+func (h *Handler) Announce(height uint32, hash hash.Hash256) {
+	h.seen.Set(hash, true, h.Config.SeenTTL)
+	h.fanout(&AnnounceMessage{
+		Height: height,
+		Hash:   hash,
+		TTL:    h.Config.TTL,
+	}, nil).flush(h.caster)
+}
+
+// OnRecv handles the announcements and returns message.ErrUnknownMessage for the other messages
+func (h *Handler) OnRecv(ctx context.Context, p mesh.Peer, r io.Reader, t message.Type) error {
+	m, err := h.mm.ParseMessage(r, t)
+	if err != nil {
+		return err
+	}
+	switch msg := m.(type) {
+	case *AnnounceMessage:
+		h.onAnnounce(p, msg).flush(h.caster)
+	case *FetchMessage:
+		block, err := h.store.Block(msg.Hash)
+		if err != nil {
+			return nil
+		}
+		return p.Send(&BlockMessage{
+			Height: msg.Height,
+			Hash:   msg.Hash,
+			Block:  block,
+		})
+	case *BlockMessage:
+		h.onBlock(msg).flush(h.caster)
+	}
+	return nil
+}
+
+// onAnnounce returns the fetch of the unknown block or the relay of the known one
+func (h *Handler) onAnnounce(p mesh.Peer, msg *AnnounceMessage) *outbox {
+	h.Lock()
+	defer h.Unlock()
+
+	// the other announcers of the block being fetched are the fallbacks of the fetch
+	if v, has := h.fetch.Get(msg.Hash); has {
+		if pd := v.(*pending); !pd.hasAnnouncer(p.NetAddr()) {
+			pd.announcers = append(pd.announcers, p.NetAddr())
+		}
+		return nil
+	}
+	if h.seen.Has(msg.Hash) {
+		return nil
+	}
+	h.seen.Set(msg.Hash, true, h.Config.SeenTTL)
+	if h.store.Has(msg.Hash) {
+		return h.relay(msg, []string{p.NetAddr()})
+	}
+	pd := &pending{
+		height:     msg.Height,
+		ttl:        msg.TTL,
+		announcers: []string{p.NetAddr()},
+	}
+	h.fetch.SetWithExpire(msg.Hash, pd, h.Config.FetchTimeout, h.onFetchTimeout)
+	return &outbox{fetchTo: p.NetAddr(), fetch: &FetchMessage{Height: msg.Height, Hash: msg.Hash}}
+}
+
+// onBlock returns the relay of the fetched block after it is imported
+func (h *Handler) onBlock(msg *BlockMessage) *outbox {
+	h.Lock()
+	defer h.Unlock()
+
+	v, has := h.fetch.Get(msg.Hash)
+	if !has {
+		return nil
+	}
+	pd := v.(*pending)
+	if err := h.store.Import(msg.Height, msg.Hash, msg.Block); err != nil {
+		return nil
+	}
+	h.fetch.Delete(msg.Hash)
+	return h.relay(&AnnounceMessage{Height: pd.height, Hash: msg.Hash, TTL: pd.ttl}, pd.announcers)
+}
+
+// onFetchTimeout drops the announcer which timed out and fetches the block from the next one until the FetchRetries,
+// the hash is forgotten when no announcer is left so the next announcement of it is handled again
+func (h *Handler) onFetchTimeout(key interface{}, value interface{}) {
+	h.fetchNext(key.(hash.Hash256), value.(*pending)).flush(h.caster)
+}
+
+func (h *Handler) fetchNext(hash hash.Hash256, pd *pending) *outbox {
+	h.Lock()
+	defer h.Unlock()
+
+	pd.announcers = pd.announcers[1:]
+	pd.retries++
+	if h.store.Has(hash) || len(pd.announcers) == 0 || pd.retries > h.Config.FetchRetries {
+		h.seen.Delete(hash)
+		return nil
+	}
+	h.fetch.SetWithExpire(hash, pd, h.Config.FetchTimeout, h.onFetchTimeout)
+	return &outbox{fetchTo: pd.announcers[0], fetch: &FetchMessage{Height: pd.height, Hash: hash}}
+}
+
+// relay returns the announcement with one less hop to the peers except the ones which announced it
+func (h *Handler) relay(msg *AnnounceMessage, except []string) *outbox {
+	if msg.TTL <= 1 {
+		return nil
+	}
+	return h.fanout(&AnnounceMessage{
+		Height: msg.Height,
+		Hash:   msg.Hash,
+		TTL:    msg.TTL - 1,
+	}, except)
+}
+
+func (h *Handler) fanout(msg *AnnounceMessage, except []string) *outbox {
+	return &outbox{peers: h.targets(except), msg: msg}
+}
+
+// targets returns the peers of the fan-out, the group peers come first
+func (h *Handler) targets(except []string) []mesh.Peer {
+	skip := map[string]bool{}
+	for _, addr := range except {
+		skip[addr] = true
+	}
+	list := []mesh.Peer{}
+	add := func(peers []mesh.Peer) {
+		for _, p := range peers {
+			if h.Config.Fanout > 0 && len(list) >= h.Config.Fanout {
+				return
+			}
+			if !skip[p.NetAddr()] {
+				skip[p.NetAddr()] = true
+				list = append(list, p)
+			}
+		}
+	}
+	if gm, ok := h.mesh.(mesh.GroupMesh); ok {
+		add(gm.GroupPeers())
+	}
+	add(h.mesh.Peers())
+	return list
+}
+
+func (h *Handler) messageCreator(r io.Reader, t message.Type) (message.Message, error) {
+	switch t {
+	case AnnounceMessageType:
+		msg := &AnnounceMessage{}
+		if _, err := msg.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case FetchMessageType:
+		msg := &FetchMessage{}
+		if _, err := msg.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case BlockMessageType:
+		msg := &BlockMessage{}
+		if _, err := msg.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	default:
+		return nil, message.ErrUnknownMessage
+	}
+}
@@ -0,0 +1,9 @@
+package announce
+
+import "errors"
+
+// errors
+var (
+	ErrTooLargeBlock      = errors.New("too large block")
+	ErrInvalidBlockLength = errors.New("invalid block length")
+)
@@ -0,0 +1,194 @@
+package announce
+
+import (
+	"io"
+
+	"github.com/fletaio/common/hash"
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/message"
+)
+
+// MaxBlockSize is the max size of the encoded block of the BlockMessage
+const MaxBlockSize = 32 * 1024 * 1024
+
+// types
+var (
+	AnnounceMessageType = message.DefineType("announce.AnnounceMessage")
+	FetchMessageType    = message.DefineType("announce.FetchMessage")
+	BlockMessageType    = message.DefineType("announce.BlockMessage")
+)
+
+// AnnounceMessage tells the hash of the new block to the peers, it is relayed until the TTL hops
+type AnnounceMessage struct {
+	Height uint32
+	Hash   hash.Hash256
+	TTL    uint8
+}
+
+// Type returns message type
+func (msg *AnnounceMessage) Type() message.Type {
+	return AnnounceMessageType
+}
+
+// WriteTo is a serialization function
+func (msg *AnnounceMessage) WriteTo(w io.Writer) (int64, error) {
+	var wrote int64
+	if n, err := util.WriteUint32(w, msg.Height); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := msg.Hash.WriteTo(w); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := util.WriteUint8(w, msg.TTL); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	return wrote, nil
+}
+
+// ReadFrom is a deserialization function
+func (msg *AnnounceMessage) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	if v, n, err := util.ReadUint32(r); err != nil {
+		return read, err
+	} else {
+		read += n
+		msg.Height = v
+	}
+	if n, err := msg.Hash.ReadFrom(r); err != nil {
+		return read, err
+	} else {
+		read += n
+	}
+	if v, n, err := util.ReadUint8(r); err != nil {
+		return read, err
+	} else {
+		read += n
+		msg.TTL = v
+	}
+	return read, nil
+}
+
+// FetchMessage requests the announced block to the peer which announced it
+type FetchMessage struct {
+	Height uint32
+	Hash   hash.Hash256
+}
+
+// Type returns message type
+func (msg *FetchMessage) Type() message.Type {
+	return FetchMessageType
+}
+
+// WriteTo is a serialization function
+func (msg *FetchMessage) WriteTo(w io.Writer) (int64, error) {
+	var wrote int64
+	if n, err := util.WriteUint32(w, msg.Height); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := msg.Hash.WriteTo(w); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	return wrote, nil
+}
+
+// ReadFrom is a deserialization function
+func (msg *FetchMessage) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	if v, n, err := util.ReadUint32(r); err != nil {
+		return read, err
+	} else {
+		read += n
+		msg.Height = v
+	}
+	if n, err := msg.Hash.ReadFrom(r); err != nil {
+		return read, err
+	} else {
+		read += n
+	}
+	return read, nil
+}
+
+// BlockMessage answers the FetchMessage with the encoded block of the Store
+type BlockMessage struct {
+	Height uint32
+	Hash   hash.Hash256
+	Block  []byte
+}
+
+// Type returns message type
+func (msg *BlockMessage) Type() message.Type {
+	return BlockMessageType
+}
+
+// WriteTo is a serialization function
+func (msg *BlockMessage) WriteTo(w io.Writer) (int64, error) {
+	var wrote int64
+	if len(msg.Block) > MaxBlockSize {
+		return wrote, ErrTooLargeBlock
+	}
+	if n, err := util.WriteUint32(w, msg.Height); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := msg.Hash.WriteTo(w); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := util.WriteUint32(w, uint32(len(msg.Block))); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := w.Write(msg.Block); err != nil {
+		return wrote, err
+	} else {
+		wrote += int64(n)
+	}
+	return wrote, nil
+}
+
+// ReadFrom is a deserialization function
+func (msg *BlockMessage) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	if v, n, err := util.ReadUint32(r); err != nil {
+		return read, err
+	} else {
+		read += n
+		msg.Height = v
+	}
+	if n, err := msg.Hash.ReadFrom(r); err != nil {
+		return read, err
+	} else {
+		read += n
+	}
+	if v, n, err := util.ReadUint32(r); err != nil {
+		return read, err
+	} else {
+		read += n
+		if v > MaxBlockSize {
+			return read, ErrTooLargeBlock
+		}
+		if s, ok := r.(message.Sizer); ok && int(v) > s.Remaining() {
+			return read, ErrInvalidBlockLength
+		}
+		msg.Block = make([]byte, v)
+		if n, err := util.FillBytes(r, msg.Block); err != nil {
+			return read, err
+		} else {
+			read += int64(n)
+		}
+	}
+	return read, nil
+}
@@ -1,6 +1,7 @@
 package chain
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
@@ -62,7 +63,7 @@ func (cm *Manager) Provider() Provider {
 }
 
 // OnConnected is called after accepting a peer to the peer list
-func (cm *Manager) OnConnected(p mesh.Peer) {
+func (cm *Manager) OnConnected(ctx context.Context, p mesh.Peer) {
 	cm.Lock()
 	cm.statusMap[p.ID()] = &Status{}
 	cm.Unlock()
@@ -77,7 +78,7 @@ func (cm *Manager) OnConnected(p mesh.Peer) {
 }
 
 // OnDisconnected is called when the peer is closed
-func (cm *Manager) OnDisconnected(p mesh.Peer) {
+func (cm *Manager) OnDisconnected(ctx context.Context, p mesh.Peer) {
 	cm.Lock()
 	defer cm.Unlock()
 
@@ -90,7 +91,7 @@ func (cm *Manager) OnTimerExpired(height uint32, ID string) {
 }
 
 // OnRecv is called when a message is received from the peer
-func (cm *Manager) OnRecv(p mesh.Peer, r io.Reader, t message.Type) error {
+func (cm *Manager) OnRecv(ctx context.Context, p mesh.Peer, r io.Reader, t message.Type) error {
 	m, err := cm.mm.ParseMessage(r, t)
 	if err != nil {
 		return err
@@ -145,6 +146,9 @@ func (cm *Manager) OnRecv(p mesh.Peer, r io.Reader, t message.Type) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		sm := &DataMessage{
 			Data: cd,
 		}
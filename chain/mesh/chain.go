@@ -0,0 +1,91 @@
+package mesh
+
+import (
+	"context"
+	"io"
+
+	"github.com/fletaio/framework/message"
+)
+
+// BaseEventHandler is embedded for the default no-op behavior of the events
+type BaseEventHandler struct{}
+
+// OnConnected is called when the peer is connected
+func (BaseEventHandler) OnConnected(ctx context.Context, p Peer) {}
+
+// OnDisconnected is called when the peer is disconnected
+func (BaseEventHandler) OnDisconnected(ctx context.Context, p Peer) {}
+
+// OnRecv returns ErrUnknownMessage so the message is passed to the next handler
+func (BaseEventHandler) OnRecv(ctx context.Context, p Peer, r io.Reader, t message.Type) error {
+	return message.ErrUnknownMessage
+}
+
+// Next passes the message to the next handler of the chain
+// The reader is the one of the message, a handler which has read the message should pass a new reader of it
+type Next func(ctx context.Context, p Peer, r io.Reader, t message.Type) error
+
+// ChainHandler is a handler of the chain which extends the next handlers instead of replacing them
+type ChainHandler interface {
+	OnRecv(ctx context.Context, p Peer, r io.Reader, t message.Type, next Next) error
+}
+
+// ConnectionHandler is implemented by the ChainHandler which receives the connection events
+type ConnectionHandler interface {
+	OnConnected(ctx context.Context, p Peer)
+	OnDisconnected(ctx context.Context, p Peer)
+}
+
+// ChainHandlerFunc is a function of ChainHandler
+type ChainHandlerFunc func(ctx context.Context, p Peer, r io.Reader, t message.Type, next Next) error
+
+// OnRecv calls the function
+func (f ChainHandlerFunc) OnRecv(ctx context.Context, p Peer, r io.Reader, t message.Type, next Next) error {
+	return f(ctx, p, r, t, next)
+}
+
+// Chain composes the handlers in front of the base handler in order.
+// The message is passed to the base handler when every handler calls next,
+// and the connection events are sent to the handlers which implement ConnectionHandler before the base handler.
+func Chain(base EventHandler, handlers ...ChainHandler) EventHandler {
+	return &chain{
+		base:     base,
+		handlers: append([]ChainHandler{}, handlers...),
+	}
+}
+
+type chain struct {
+	base     EventHandler
+	handlers []ChainHandler
+}
+
+func (c *chain) OnConnected(ctx context.Context, p Peer) {
+	for _, h := range c.handlers {
+		if eh, ok := h.(ConnectionHandler); ok {
+			eh.OnConnected(ctx, p)
+		}
+	}
+	c.base.OnConnected(ctx, p)
+}
+
+func (c *chain) OnDisconnected(ctx context.Context, p Peer) {
+	for _, h := range c.handlers {
+		if eh, ok := h.(ConnectionHandler); ok {
+			eh.OnDisconnected(ctx, p)
+		}
+	}
+	c.base.OnDisconnected(ctx, p)
+}
+
+func (c *chain) OnRecv(ctx context.Context, p Peer, r io.Reader, t message.Type) error {
+	return c.next(0)(ctx, p, r, t)
+}
+
+func (c *chain) next(i int) Next {
+	if i >= len(c.handlers) {
+		return c.base.OnRecv
+	}
+	return func(ctx context.Context, p Peer, r io.Reader, t message.Type) error {
+		return c.handlers[i].OnRecv(ctx, p, r, t, c.next(i+1))
+	}
+}
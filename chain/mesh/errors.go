@@ -0,0 +1,8 @@
+package mesh
+
+import "errors"
+
+// errors
+var (
+	ErrNoReplier = errors.New("no replier in the context")
+)
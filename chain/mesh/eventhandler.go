@@ -1,14 +1,37 @@
 package mesh
 
 import (
+	"context"
 	"io"
 
 	"github.com/fletaio/framework/message"
 )
 
 // EventHandler is a event handler of the mesh
+// The context of OnConnected and OnRecv is canceled when the peer is disconnected or the mesh is shutting down,
+// and the context of OnDisconnected is canceled when the mesh is shutting down.
 type EventHandler interface {
-	OnConnected(p Peer)
-	OnDisconnected(p Peer)
-	OnRecv(p Peer, r io.Reader, t message.Type) error
+	OnConnected(ctx context.Context, p Peer)
+	OnDisconnected(ctx context.Context, p Peer)
+	OnRecv(ctx context.Context, p Peer, r io.Reader, t message.Type) error
+}
+
+// PartitionHandler is notified when the local node seems to be partitioned from the network and when it is recovered
+// The registered EventHandler which implements it receives the events
+type PartitionHandler interface {
+	OnPartitionSuspected(ctx context.Context)
+	OnPartitionHealed(ctx context.Context)
+}
+
+// RelayHandler is notified when the messages relayed on behalf of the origin peer are throttled by the relay quota
+// and when they are relayed again in the next window. The registered EventHandler which implements it receives the events
+type RelayHandler interface {
+	OnRelayThrottled(ctx context.Context, origin string)
+	OnRelayRestored(ctx context.Context, origin string)
+}
+
+// CloseHandler is notified of the peers which are closed by the shutdown of the mesh, they are notified by OnDisconnected as well.
+// The registered EventHandler which implements it receives the events
+type CloseHandler interface {
+	OnClosed(p Peer)
 }
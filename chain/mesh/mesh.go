@@ -15,9 +15,17 @@ type Mesh interface {
 	Peers() []Peer
 }
 
+// GroupMesh is the Mesh which knows the group peers, they are preferred by the fan-outs
+type GroupMesh interface {
+	Mesh
+	GroupPeers() []Peer
+}
+
 // Peer is a connected node with this node
 type Peer interface {
 	message.Sender
 	ID() string
 	NetAddr() string
+	SetData(key string, value interface{})
+	GetData(key string) (interface{}, bool)
 }
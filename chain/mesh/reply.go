@@ -0,0 +1,47 @@
+package mesh
+
+import (
+	"context"
+
+	"github.com/fletaio/framework/message"
+)
+
+// Replier sends the response of the received message back to the peer which sent it
+type Replier interface {
+	Reply(m message.Message) error
+}
+
+type replierKey struct{}
+type inReplyToKey struct{}
+
+// WithReplier returns the context of the handler which replies by the replier
+func WithReplier(ctx context.Context, r Replier) context.Context {
+	return context.WithValue(ctx, replierKey{}, r)
+}
+
+// ReplierFrom returns the replier of the context of OnRecv
+func ReplierFrom(ctx context.Context) (Replier, bool) {
+	r, ok := ctx.Value(replierKey{}).(Replier)
+	return r, ok
+}
+
+// Reply sends the response to the peer of the message which is handled by the context of OnRecv,
+// the response is correlated to the message so the sender finds it by InReplyTo
+func Reply(ctx context.Context, m message.Message) error {
+	r, ok := ReplierFrom(ctx)
+	if !ok {
+		return ErrNoReplier
+	}
+	return r.Reply(m)
+}
+
+// WithInReplyTo returns the context of the handler of the response to the message of the type
+func WithInReplyTo(ctx context.Context, t message.Type) context.Context {
+	return context.WithValue(ctx, inReplyToKey{}, t)
+}
+
+// InReplyTo returns the type of the message which the received message answers when it is sent by Reply
+func InReplyTo(ctx context.Context) (message.Type, bool) {
+	t, ok := ctx.Value(inReplyToKey{}).(message.Type)
+	return t, ok
+}
@@ -1,10 +1,10 @@
 package chain
 
 import (
-	"sync"
 	"time"
 
-	"github.com/fletaio/framework/peer"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/ttlcache"
 )
 
 // RequestExpireHandler handles a request expire event
@@ -14,93 +14,68 @@ type RequestExpireHandler interface {
 
 // RequestTimer triggers a event when a request is expired
 type RequestTimer struct {
-	sync.Mutex
-	timerMap map[uint32]*requestTimerItem
-	handler  RequestExpireHandler
+	cache   *ttlcache.Cache
+	handler RequestExpireHandler
+	clock   clock.Clock
 }
 
 // NewRequestTimer returns a RequestTimer
 func NewRequestTimer(handler RequestExpireHandler) *RequestTimer {
+	return NewRequestTimerWithClock(handler, clock.Real)
+}
+
+// NewRequestTimerWithClock returns a RequestTimer whose requests are expired by the clock
+func NewRequestTimerWithClock(handler RequestExpireHandler, clk clock.Clock) *RequestTimer {
+	clk = clock.Or(clk)
 	rm := &RequestTimer{
-		timerMap: map[uint32]*requestTimerItem{},
-		handler:  handler,
+		cache:   ttlcache.NewWithClock(0, clk),
+		handler: handler,
+		clock:   clk,
 	}
+	rm.cache.SetExpireHandler(rm.onExpire)
 	return rm
 }
 
 // Exist returns the target height request exists or not
 func (rm *RequestTimer) Exist(height uint32) bool {
-	rm.Lock()
-	defer rm.Unlock()
-
-	_, has := rm.timerMap[height]
-	return has
+	return rm.cache.Has(height)
 }
 
 // Add adds the timer of the request
 func (rm *RequestTimer) Add(height uint32, t time.Duration, p interface{}, ID string) {
-	rm.Lock()
-	defer rm.Unlock()
-
-	rm.timerMap[height] = &requestTimerItem{
-		Height:    height,
-		ExpiredAt: uint64(time.Now().UnixNano()) + uint64(t),
-		P:         p,
-		ID:        ID,
-	}
+	rm.cache.Set(height, &requestTimerItem{
+		Height: height,
+		P:      p,
+		ID:     ID,
+	}, t)
 }
 
 // Remove removes the timer of the request
 func (rm *RequestTimer) Remove(height uint32) {
-	rm.Lock()
-	defer rm.Unlock()
+	rm.cache.Delete(height)
+}
 
-	delete(rm.timerMap, height)
+// Len returns the number of the requests which are not expired
+func (rm *RequestTimer) Len() int {
+	return rm.cache.Len()
 }
 
 // Run is the main loop of RequestTimer
 func (rm *RequestTimer) Run() {
-	timer := time.NewTimer(100 * time.Millisecond)
-	for {
-		select {
-		case <-timer.C:
-			expired := []*requestTimerItem{}
-			now := uint64(time.Now().UnixNano())
-			remainMap := map[uint32]*requestTimerItem{}
-			rm.Lock()
-			for h, v := range rm.timerMap {
-				if v.ExpiredAt <= now {
-					expired = append(expired, v)
-				} else {
-					remainMap[h] = v
-				}
-			}
-			rm.timerMap = remainMap
-			rm.Unlock()
-
-			if rm.handler != nil {
-				for _, v := range expired {
-					rm.handler.OnTimerExpired(v.Height, v.ID)
-				}
-			}
-			timer.Reset(100 * time.Millisecond)
-		}
+	for clock.Sleep(rm.clock, 100*time.Millisecond, nil) {
+		rm.cache.Expire()
 	}
 }
 
-func (rm *RequestTimer) isClose(r interface{}) bool {
-	if r == nil {
-		return false
-	}
-	if p, ok := r.(peer.Peer); ok {
-		return p.IsClose()
+func (rm *RequestTimer) onExpire(key interface{}, value interface{}) {
+	if rm.handler != nil {
+		v := value.(*requestTimerItem)
+		rm.handler.OnTimerExpired(v.Height, v.ID)
 	}
-	return false
 }
 
 type requestTimerItem struct {
-	Height    uint32
-	ExpiredAt uint64
-	P         interface{}
-	ID        string
+	Height uint32
+	P      interface{}
+	ID     string
 }
@@ -0,0 +1,75 @@
+package chain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+)
+
+type expireRecorder struct {
+	ch chan string
+}
+
+func (r *expireRecorder) OnTimerExpired(height uint32, ID string) {
+	r.ch <- ID
+}
+
+func TestRequestTimerExpire(t *testing.T) {
+	m := clock.NewManual(time.Unix(1000, 0))
+	h := &expireRecorder{ch: make(chan string, 4)}
+	rm := NewRequestTimerWithClock(h, m)
+	go rm.Run()
+
+	rm.Add(10, time.Second, nil, "a")
+	rm.Add(11, 2*time.Second, nil, "b")
+	rm.Add(12, time.Second, nil, "c")
+	rm.Remove(12)
+	if !rm.Exist(10) || !rm.Exist(11) || rm.Exist(12) || rm.Len() != 2 {
+		t.Fatalf("Exist = %v, %v, %v, Len() = %v", rm.Exist(10), rm.Exist(11), rm.Exist(12), rm.Len())
+	}
+
+	waitRunTimer(t, m)
+	m.Advance(time.Second)
+	select {
+	case ID := <-h.ch:
+		if ID != "a" {
+			t.Errorf("OnTimerExpired(%v), want %v", ID, "a")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnTimerExpired is not called after the timeout")
+	}
+	if rm.Exist(10) || !rm.Exist(11) {
+		t.Errorf("Exist(10) = %v, Exist(11) = %v", rm.Exist(10), rm.Exist(11))
+	}
+
+	waitRunTimer(t, m)
+	m.Advance(time.Second)
+	select {
+	case ID := <-h.ch:
+		if ID != "b" {
+			t.Errorf("OnTimerExpired(%v), want %v", ID, "b")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnTimerExpired is not called after the timeout")
+	}
+	select {
+	case ID := <-h.ch:
+		t.Errorf("OnTimerExpired(%v) of the removed request", ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if rm.Len() != 0 {
+		t.Errorf("Len() = %v, want %v", rm.Len(), 0)
+	}
+}
+
+func waitRunTimer(t *testing.T, m *clock.Manual) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.Timers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the timer of Run is not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
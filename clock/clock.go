@@ -0,0 +1,166 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the framework, so the simulations and the deterministic replays control the time
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of the clock which sends the time to C when it fires
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Real is the clock of the operating system
+var Real Clock = realClock{}
+
+// Or returns the clock or Real when it is nil
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// Since returns the time elapsed since t by the clock
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Sleep waits the duration by the clock and returns false when done is closed before
+func Sleep(c Clock, d time.Duration, done <-chan struct{}) bool {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-done:
+		return false
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{t: time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt *realTimer) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt *realTimer) Stop() bool {
+	return rt.t.Stop()
+}
+
+// Manual is the clock which is moved by Advance and Set only
+type Manual struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManual returns a Manual clock at the time
+func NewManual(now time.Time) *Manual {
+	return &Manual{
+		now: now,
+	}
+}
+
+// Now returns the time of the clock
+func (m *Manual) Now() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.now
+}
+
+// NewTimer returns a timer which fires when the clock reaches the duration from now
+func (m *Manual) NewTimer(d time.Duration) Timer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	t := &manualTimer{
+		m:  m,
+		at: m.now.Add(d),
+		ch: make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.ch <- m.now
+		return t
+	}
+	m.timers = append(m.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by the duration and fires the timers in order of their times
+func (m *Manual) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the clock to the time, the clock doesn't go backward
+func (m *Manual) Set(now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if now.Before(m.now) {
+		return
+	}
+	m.now = now
+	sort.SliceStable(m.timers, func(i, j int) bool {
+		return m.timers[i].at.Before(m.timers[j].at)
+	})
+	remain := m.timers[:0]
+	for _, t := range m.timers {
+		if t.at.After(now) {
+			remain = append(remain, t)
+			continue
+		}
+		t.ch <- t.at
+	}
+	m.timers = remain
+}
+
+// Timers returns the number of the timers which are waiting
+func (m *Manual) Timers() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.timers)
+}
+
+type manualTimer struct {
+	m  *Manual
+	at time.Time
+	ch chan time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	t.m.lock.Lock()
+	defer t.m.lock.Unlock()
+
+	for i, v := range t.m.timers {
+		if v == t {
+			t.m.timers = append(t.m.timers[:i], t.m.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
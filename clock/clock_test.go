@@ -0,0 +1,60 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	m := NewManual(start)
+	t1 := m.NewTimer(time.Second)
+	t2 := m.NewTimer(3 * time.Second)
+
+	m.Advance(2 * time.Second)
+	select {
+	case at := <-t1.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("fired at %v", at)
+		}
+	default:
+		t.Fatal("timer is not fired")
+	}
+	select {
+	case <-t2.C():
+		t.Fatal("timer is fired early")
+	default:
+	}
+	if !t2.Stop() {
+		t.Error("waiting timer is not stopped")
+	}
+	if m.Timers() != 0 {
+		t.Errorf("timers %d", m.Timers())
+	}
+	if got := Since(m, start); got != 2*time.Second {
+		t.Errorf("since %v", got)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	m := NewManual(time.Unix(0, 0))
+	done := make(chan struct{})
+	result := make(chan bool)
+	go func() {
+		result <- Sleep(m, time.Minute, done)
+	}()
+	for m.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Advance(time.Minute)
+	if !<-result {
+		t.Error("sleep is not finished")
+	}
+	go func() {
+		result <- Sleep(m, time.Minute, done)
+	}()
+	close(done)
+	if <-result {
+		t.Error("sleep is not canceled")
+	}
+}
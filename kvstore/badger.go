@@ -0,0 +1,130 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+type badgerStore struct {
+	db      *badger.DB
+	closeCh chan struct{}
+}
+
+// OpenBadger opens the badger store at the path which is the on-disk format of the previous versions
+func OpenBadger(dbPath string) (KVStore, error) {
+	opts := badger.DefaultOptions
+	opts.Dir = dbPath
+	opts.ValueDir = dbPath
+	opts.Truncate = true
+	opts.SyncWrites = true
+	opts.ValueLogFileSize = 1 << 24
+	lockfilePath := filepath.Join(opts.Dir, "LOCK")
+	os.MkdirAll(dbPath, os.ModeDir)
+
+	os.Remove(lockfilePath)
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	{
+	again:
+		if err := db.RunValueLogGC(0.7); err != nil {
+		} else {
+			goto again
+		}
+	}
+
+	s := &badgerStore{
+		db:      db,
+		closeCh: make(chan struct{}),
+	}
+	go s.runGC()
+	return s, nil
+}
+
+func (s *badgerStore) runGC() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+		}
+	again:
+		if err := s.db.RunValueLogGC(0.7); err != nil {
+		} else {
+			goto again
+		}
+	}
+}
+
+func (s *badgerStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	if err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	}); err != nil {
+		if err == badger.ErrKeyNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *badgerStore) Put(key []byte, value []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (s *badgerStore) PutBatch(keys [][]byte, values [][]byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			if err := txn.Set(key, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *badgerStore) Delete(key []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (s *badgerStore) Iterate(f func(key []byte, value []byte) bool) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if !f(item.KeyCopy(nil), value) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Close flushes and closes the store
+func (s *badgerStore) Close() error {
+	close(s.closeCh)
+	return s.db.Close()
+}
@@ -0,0 +1,94 @@
+//go:build bolt
+// +build bolt
+
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("kv")
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func init() {
+	Register(BoltDB, OpenBolt)
+}
+
+// OpenBolt opens the BoltDB file in the directory of the path
+func OpenBolt(dbPath string) (KVStore, error) {
+	os.MkdirAll(dbPath, os.ModeDir)
+	db, err := bolt.Open(filepath.Join(dbPath, "bolt.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get(key)
+		if v == nil {
+			return ErrNotFound
+		}
+		value = append([]byte{}, v...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *boltStore) Put(key []byte, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+func (s *boltStore) PutBatch(keys [][]byte, values [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for i, key := range keys {
+			if err := b.Put(key, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Delete(key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+func (s *boltStore) Iterate(f func(key []byte, value []byte) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !f(append([]byte{}, k...), append([]byte{}, v...)) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,198 @@
+package kvstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// encryption errors
+var (
+	ErrWrongStoreKey = errors.New("wrong store key")
+	ErrDecrypt       = errors.New("decrypt failed")
+)
+
+// StoreKeyEnv is the environment variable of the store key which is used when the key of the config is empty
+const StoreKeyEnv = "FLETA_STORE_KEY"
+
+// StoreKey returns the key or the StoreKeyEnv environment variable when the key is empty
+func StoreKey(key string) string {
+	if key != "" {
+		return key
+	}
+	return os.Getenv(StoreKeyEnv)
+}
+
+var verifyKey = []byte("\x00kvstore-verify")
+
+// encryptedStore encrypts the keys and the values with AES-GCM, the stored key is the HMAC of the key
+// so the lookups stay deterministic and the key itself is sealed in the value
+type encryptedStore struct {
+	store  KVStore
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewEncrypted returns a KVStore which keeps the keys and the values of the store encrypted by the key.
+// The store written by another key returns ErrWrongStoreKey and the plaintext records written before are ignored.
+func NewEncrypted(store KVStore, key string) (KVStore, error) {
+	encKey := sha256.Sum256([]byte("kvstore encryption\x00" + key))
+	macKey := sha256.Sum256([]byte("kvstore mac\x00" + key))
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &encryptedStore{
+		store:  store,
+		aead:   aead,
+		macKey: macKey[:],
+	}
+	if err := s.checkKey(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkKey stores the verifier of the key at the first open and checks it after,
+// the verifier is kept under the plain verifyKey so another key finds it
+func (s *encryptedStore) checkKey() error {
+	sealed, err := s.store.Get(verifyKey)
+	if err == ErrNotFound {
+		_, sealed, err := s.seal(verifyKey, nil)
+		if err != nil {
+			return err
+		}
+		return s.store.Put(verifyKey, sealed)
+	} else if err != nil {
+		return err
+	}
+	if _, _, err := s.open(s.hash(verifyKey), sealed); err != nil {
+		return ErrWrongStoreKey
+	}
+	return nil
+}
+
+func (s *encryptedStore) hash(key []byte) []byte {
+	h := hmac.New(sha256.New, s.macKey)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func (s *encryptedStore) seal(key []byte, value []byte) ([]byte, []byte, error) {
+	hashed := s.hash(key)
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	plain := make([]byte, 4, 4+len(key)+len(value))
+	binary.BigEndian.PutUint32(plain, uint32(len(key)))
+	plain = append(append(plain, key...), value...)
+	return hashed, s.aead.Seal(nonce, nonce, plain, hashed), nil
+}
+
+func (s *encryptedStore) open(hashed []byte, sealed []byte) ([]byte, []byte, error) {
+	ns := s.aead.NonceSize()
+	if len(sealed) < ns {
+		return nil, nil, ErrDecrypt
+	}
+	plain, err := s.aead.Open(nil, sealed[:ns], sealed[ns:], hashed)
+	if err != nil || len(plain) < 4 {
+		return nil, nil, ErrDecrypt
+	}
+	kl := binary.BigEndian.Uint32(plain)
+	if uint32(len(plain)-4) < kl {
+		return nil, nil, ErrDecrypt
+	}
+	return plain[4 : 4+kl], plain[4+kl:], nil
+}
+
+func (s *encryptedStore) Get(key []byte) ([]byte, error) {
+	hashed := s.hash(key)
+	sealed, err := s.store.Get(hashed)
+	if err != nil {
+		return nil, err
+	}
+	k, v, err := s.open(hashed, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(k, key) {
+		return nil, ErrDecrypt
+	}
+	return v, nil
+}
+
+func (s *encryptedStore) Put(key []byte, value []byte) error {
+	hashed, sealed, err := s.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(hashed, sealed)
+}
+
+func (s *encryptedStore) PutBatch(keys [][]byte, values [][]byte) error {
+	hashedKeys := make([][]byte, 0, len(keys))
+	sealedValues := make([][]byte, 0, len(values))
+	for i, key := range keys {
+		hashed, sealed, err := s.seal(key, values[i])
+		if err != nil {
+			return err
+		}
+		hashedKeys = append(hashedKeys, hashed)
+		sealedValues = append(sealedValues, sealed)
+	}
+	return PutBatch(s.store, hashedKeys, sealedValues)
+}
+
+func (s *encryptedStore) Delete(key []byte) error {
+	return s.store.Delete(s.hash(key))
+}
+
+// Iterate decrypts the records and calls f in the order of the keys like the other stores
+func (s *encryptedStore) Iterate(f func(key []byte, value []byte) bool) error {
+	keys := [][]byte{}
+	values := [][]byte{}
+	if err := s.store.Iterate(func(hashed []byte, sealed []byte) bool {
+		if bytes.Equal(hashed, verifyKey) {
+			return true
+		}
+		k, v, err := s.open(hashed, sealed)
+		if err != nil {
+			return true
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+		return true
+	}); err != nil {
+		return err
+	}
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return bytes.Compare(keys[idx[a]], keys[idx[b]]) < 0
+	})
+	for _, i := range idx {
+		if !f(keys[i], values[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *encryptedStore) Close() error {
+	return s.store.Close()
+}
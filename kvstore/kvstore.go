@@ -0,0 +1,85 @@
+package kvstore
+
+import (
+	"errors"
+	"sync"
+)
+
+// kvstore errors
+var (
+	ErrNotFound       = errors.New("key not found")
+	ErrUnknownBackend = errors.New("unknown backend")
+)
+
+// KVStore is the key value storage of the stores of the framework
+type KVStore interface {
+	// Get returns ErrNotFound when the key is not stored
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	// Iterate calls f with the copies of the stored keys and values in order until f returns false
+	Iterate(f func(key []byte, value []byte) bool) error
+	Close() error
+}
+
+// Batcher is implemented by the stores which write the pairs in a transaction
+type Batcher interface {
+	PutBatch(keys [][]byte, values [][]byte) error
+}
+
+// PutBatch writes the pairs in a transaction when the store is a Batcher, otherwise it puts them one by one
+func PutBatch(store KVStore, keys [][]byte, values [][]byte) error {
+	if b, ok := store.(Batcher); ok {
+		return b.PutBatch(keys, values)
+	}
+	for i, key := range keys {
+		if err := store.Put(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opener opens the store of the backend at the path
+type Opener func(path string) (KVStore, error)
+
+// backends
+const (
+	Badger  = "badger"
+	Memory  = "memory"
+	BoltDB  = "bolt"
+	LevelDB = "leveldb"
+)
+
+var (
+	openerLock sync.RWMutex
+	openers    = map[string]Opener{
+		Badger: OpenBadger,
+		Memory: func(path string) (KVStore, error) {
+			return NewMemory(), nil
+		},
+	}
+)
+
+// Register adds the opener of the backend, the BoltDB and the LevelDB backends are registered
+// when the framework is built with the bolt and the leveldb tags
+func Register(backend string, opener Opener) {
+	openerLock.Lock()
+	defer openerLock.Unlock()
+
+	openers[backend] = opener
+}
+
+// Open opens the store of the backend at the path, the Badger backend is used when the backend is empty
+func Open(backend string, path string) (KVStore, error) {
+	if backend == "" {
+		backend = Badger
+	}
+	openerLock.RLock()
+	opener, has := openers[backend]
+	openerLock.RUnlock()
+	if !has {
+		return nil, ErrUnknownBackend
+	}
+	return opener(path)
+}
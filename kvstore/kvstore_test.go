@@ -0,0 +1,82 @@
+package kvstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixStore(t *testing.T) {
+	db, err := Open(Memory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewPrefix(db, "a/")
+	b := NewPrefix(db, "b/")
+	a.Put([]byte("1"), []byte("x"))
+	a.Put([]byte("2"), []byte("y"))
+	b.Put([]byte("1"), []byte("z"))
+
+	if v, err := b.Get([]byte("1")); err != nil || string(v) != "z" {
+		t.Errorf("Get() = %s, %v", v, err)
+	}
+	keys := []string{}
+	a.Iterate(func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "1" || keys[1] != "2" {
+		t.Errorf("Iterate() keys = %v", keys)
+	}
+	a.Delete([]byte("1"))
+	if _, err := a.Get([]byte("1")); err != ErrNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := PutBatch(b, [][]byte{[]byte("2"), []byte("3")}, [][]byte{[]byte("v"), []byte("w")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Get([]byte("3")); err != nil || string(v) != "w" {
+		t.Errorf("Get() = %s, %v", v, err)
+	}
+	if _, err := Open("unknown", ""); err != ErrUnknownBackend {
+		t.Errorf("Open() error = %v, want %v", err, ErrUnknownBackend)
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	db := NewMemory()
+	db.Put([]byte("plain"), []byte("old"))
+	s, err := NewEncrypted(db, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put([]byte("b"), []byte("2"))
+	PutBatch(s, [][]byte{[]byte("a"), []byte("c")}, [][]byte{[]byte("1"), []byte("3")})
+
+	if v, err := s.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Errorf("Get() = %s, %v", v, err)
+	}
+	db.Iterate(func(key []byte, value []byte) bool {
+		if bytes.Equal(key, []byte("a")) || bytes.Equal(key, []byte("b")) {
+			t.Errorf("plaintext record %q = %q", key, value)
+		}
+		return true
+	})
+	keys := []string{}
+	s.Iterate(func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "c" {
+		t.Errorf("Iterate() keys = %v", keys)
+	}
+	s.Delete([]byte("b"))
+	if _, err := s.Get([]byte("b")); err != ErrNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := NewEncrypted(db, "other"); err != ErrWrongStoreKey {
+		t.Errorf("NewEncrypted() error = %v, want %v", err, ErrWrongStoreKey)
+	}
+	if _, err := NewEncrypted(db, "secret"); err != nil {
+		t.Errorf("NewEncrypted() error = %v", err)
+	}
+}
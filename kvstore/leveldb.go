@@ -0,0 +1,68 @@
+//go:build leveldb
+// +build leveldb
+
+package kvstore
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+type levelDBStore struct {
+	db *leveldb.DB
+}
+
+func init() {
+	Register(LevelDB, OpenLevelDB)
+}
+
+// OpenLevelDB opens the LevelDB directory at the path
+func OpenLevelDB(dbPath string) (KVStore, error) {
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBStore{db: db}, nil
+}
+
+func (s *levelDBStore) Get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *levelDBStore) Put(key []byte, value []byte) error {
+	return s.db.Put(key, value, &opt.WriteOptions{Sync: true})
+}
+
+func (s *levelDBStore) PutBatch(keys [][]byte, values [][]byte) error {
+	batch := new(leveldb.Batch)
+	for i, key := range keys {
+		batch.Put(key, values[i])
+	}
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (s *levelDBStore) Delete(key []byte) error {
+	return s.db.Delete(key, &opt.WriteOptions{Sync: true})
+}
+
+func (s *levelDBStore) Iterate(f func(key []byte, value []byte) bool) error {
+	it := s.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if !f(append([]byte{}, it.Key()...), append([]byte{}, it.Value()...)) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+func (s *levelDBStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,81 @@
+package kvstore
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps the values in the memory for the diskless nodes and the tests
+type memoryStore struct {
+	sync.RWMutex
+	m map[string][]byte
+}
+
+// NewMemory returns a KVStore which is not persisted
+func NewMemory() KVStore {
+	return &memoryStore{
+		m: map[string][]byte{},
+	}
+}
+
+func (s *memoryStore) Get(key []byte) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	v, has := s.m[string(key)]
+	if !has {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (s *memoryStore) Put(key []byte, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.m[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *memoryStore) PutBatch(keys [][]byte, values [][]byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, key := range keys {
+		s.m[string(key)] = append([]byte{}, values[i]...)
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.m, string(key))
+	return nil
+}
+
+func (s *memoryStore) Iterate(f func(key []byte, value []byte) bool) error {
+	s.RLock()
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	s.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v, err := s.Get([]byte(k))
+		if err == ErrNotFound {
+			continue
+		}
+		if !f([]byte(k), v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
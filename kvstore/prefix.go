@@ -0,0 +1,57 @@
+package kvstore
+
+import (
+	"bytes"
+)
+
+// prefixStore shares a store with the others by prefixing the keys
+type prefixStore struct {
+	store  KVStore
+	prefix []byte
+}
+
+// NewPrefix returns a KVStore which keeps the keys under the prefix of the store,
+// so the stores of the framework share the database of the embedder. Close doesn't close the shared store.
+func NewPrefix(store KVStore, prefix string) KVStore {
+	return &prefixStore{
+		store:  store,
+		prefix: []byte(prefix),
+	}
+}
+
+func (s *prefixStore) key(key []byte) []byte {
+	return append(append([]byte{}, s.prefix...), key...)
+}
+
+func (s *prefixStore) Get(key []byte) ([]byte, error) {
+	return s.store.Get(s.key(key))
+}
+
+func (s *prefixStore) Put(key []byte, value []byte) error {
+	return s.store.Put(s.key(key), value)
+}
+
+func (s *prefixStore) PutBatch(keys [][]byte, values [][]byte) error {
+	prefixed := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, s.key(key))
+	}
+	return PutBatch(s.store, prefixed, values)
+}
+
+func (s *prefixStore) Delete(key []byte) error {
+	return s.store.Delete(s.key(key))
+}
+
+func (s *prefixStore) Iterate(f func(key []byte, value []byte) bool) error {
+	return s.store.Iterate(func(key []byte, value []byte) bool {
+		if !bytes.HasPrefix(key, s.prefix) {
+			return true
+		}
+		return f(key[len(s.prefix):], value)
+	})
+}
+
+func (s *prefixStore) Close() error {
+	return nil
+}
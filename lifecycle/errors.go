@@ -0,0 +1,11 @@
+package lifecycle
+
+import (
+	"errors"
+)
+
+// errors
+var (
+	ErrStageTimeout   = errors.New("stage timeout")
+	ErrAlreadyStarted = errors.New("already started")
+)
@@ -0,0 +1,151 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Stage is a part of the node which is started and stopped by the Lifecycle
+type Stage interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// Funcs is a Stage of the start and the stop functions, nil functions are skipped
+type Funcs struct {
+	StartFunc func(ctx context.Context) error
+	StopFunc  func(ctx context.Context) error
+}
+
+// Start calls the StartFunc
+func (f *Funcs) Start(ctx context.Context) error {
+	if f.StartFunc == nil {
+		return nil
+	}
+	return f.StartFunc(ctx)
+}
+
+// Stop calls the StopFunc
+func (f *Funcs) Stop(ctx context.Context) error {
+	if f.StopFunc == nil {
+		return nil
+	}
+	return f.StopFunc(ctx)
+}
+
+type stage struct {
+	name    string
+	s       Stage
+	timeout time.Duration
+}
+
+// Lifecycle starts the stages in the added order and stops them in the reverse order
+// so that a stage is started after and stopped before the stages which it depends on
+type Lifecycle struct {
+	sync.Mutex
+	stages  []*stage
+	started int
+	isStart bool
+}
+
+// New returns a Lifecycle
+func New() *Lifecycle {
+	lc := &Lifecycle{
+		stages: []*stage{},
+	}
+	return lc
+}
+
+// Add adds a stage with a name, the stage is canceled when it takes longer than the timeout (zero is unlimited)
+func (lc *Lifecycle) Add(Name string, s Stage, Timeout time.Duration) {
+	lc.Lock()
+	defer lc.Unlock()
+
+	lc.stages = append(lc.stages, &stage{
+		name:    Name,
+		s:       s,
+		timeout: Timeout,
+	})
+}
+
+// Names returns the names of the stages in the start order
+func (lc *Lifecycle) Names() []string {
+	lc.Lock()
+	defer lc.Unlock()
+
+	list := make([]string, 0, len(lc.stages))
+	for _, st := range lc.stages {
+		list = append(list, st.name)
+	}
+	return list
+}
+
+// Start starts the stages in order, the started stages are stopped when a stage fails
+func (lc *Lifecycle) Start(ctx context.Context) error {
+	lc.Lock()
+	defer lc.Unlock()
+
+	if lc.isStart {
+		return ErrAlreadyStarted
+	}
+	lc.isStart = true
+	for i, st := range lc.stages {
+		if err := run(ctx, st, st.s.Start); err != nil {
+			log.Println("Start", st.name, err)
+			lc.started = i
+			lc.stop(ctx)
+			return err
+		}
+		lc.started = i + 1
+	}
+	return nil
+}
+
+// Stop stops the started stages in the reverse order and returns the first error.
+// The remaining stages are stopped even if a stage fails.
+func (lc *Lifecycle) Stop(ctx context.Context) error {
+	lc.Lock()
+	defer lc.Unlock()
+
+	return lc.stop(ctx)
+}
+
+func (lc *Lifecycle) stop(ctx context.Context) error {
+	var firstErr error
+	for i := lc.started - 1; i >= 0; i-- {
+		st := lc.stages[i]
+		log.Println("Stop", st.name)
+		if err := run(ctx, st, st.s.Stop); err != nil {
+			log.Println("Stop", st.name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	lc.started = 0
+	lc.isStart = false
+	return firstErr
+}
+
+func run(ctx context.Context, st *stage, f func(ctx context.Context) error) error {
+	if st.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.timeout)
+		defer cancel()
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f(ctx)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrStageTimeout
+		}
+		return ctx.Err()
+	}
+}
@@ -15,26 +15,26 @@ const (
 func Debug(v ...interface{}) {
 	// Msg(v...)
 	v = append([]interface{}{"DEBUG "}, v...)
-	log.Println(v)
+	log.Println(redact(v))
 }
 
 // Debugf logs a debug entry with formatting
 func Debugf(s string, v ...interface{}) {
 	// Msgf(s, v...)
 	s = "DEBUG " + s
-	log.Printf(s, v)
+	log.Printf(s, redact(v))
 }
 
 // Info logs a normal. information, entry
 func Info(v ...interface{}) {
 	v = append([]interface{}{"INFO "}, v...)
-	log.Println(v)
+	log.Println(redact(v))
 }
 
 // Infof logs a normal. information, entry with formatiing
 func Infof(s string, v ...interface{}) {
 	s = "INFO " + s
-	log.Printf(s, v)
+	log.Printf(s, redact(v))
 }
 
 // Panic logs a panic log entry
@@ -52,37 +52,37 @@ func Panicf(s string, v ...interface{}) {
 // Notice logs a notice log entry
 func Notice(v ...interface{}) {
 	v = append([]interface{}{"NOTICE "}, v...)
-	log.Println(v)
+	log.Println(redact(v))
 }
 
 // Noticef logs a notice log entry with formatting
 func Noticef(s string, v ...interface{}) {
 	s = "NOTICE " + s
-	log.Printf(s, v)
+	log.Printf(s, redact(v))
 }
 
 // Warn logs a warn log entry
 func Warn(v ...interface{}) {
 	v = append([]interface{}{"WARN "}, v...)
-	log.Println(v)
+	log.Println(redact(v))
 }
 
 // Warnf logs a warn log entry with formatting
 func Warnf(s string, v ...interface{}) {
 	s = "WARN " + s
-	log.Printf(s, v)
+	log.Printf(s, redact(v))
 }
 
 // Error logs an error log entry
 func Error(v ...interface{}) {
 	v = append([]interface{}{"ERROR "}, v...)
 	// Msg(v...)
-	log.Println(v)
+	log.Println(redact(v))
 }
 
 // Errorf logs an error log entry with formatting
 func Errorf(s string, v ...interface{}) {
 	s = "ERROR " + s
 	// Msgf(s, v...)
-	log.Printf(s, v)
+	log.Printf(s, redact(v))
 }
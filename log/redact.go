@@ -0,0 +1,89 @@
+package log
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+)
+
+// RedactMode is the way the IP addresses are hidden in the log entries
+type RedactMode int
+
+// redact modes
+const (
+	// RedactNone logs the addresses as they are
+	RedactNone RedactMode = 0
+	// RedactTruncate keeps the network part of the addresses (/24 of IPv4 and /48 of IPv6)
+	RedactTruncate RedactMode = 1
+	// RedactHash replaces the addresses by the salted hashes which are stable while the salt is the same
+	RedactHash RedactMode = 2
+)
+
+var (
+	redactLock sync.RWMutex
+	redactMode RedactMode
+	redactSalt []byte
+	// ipPattern finds the candidates of IPv4 and IPv6 addresses which are verified by net.ParseIP
+	ipPattern = regexp.MustCompile(`[0-9]{1,3}(\.[0-9]{1,3}){3}|[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7}`)
+)
+
+// SetRedaction sets the redaction of the remote addresses in the log entries.
+// It only changes the log output, so the admin API still returns the full addresses.
+func SetRedaction(mode RedactMode, salt []byte) {
+	redactLock.Lock()
+	defer redactLock.Unlock()
+
+	redactMode = mode
+	redactSalt = append([]byte{}, salt...)
+}
+
+// Addr returns the address redacted by the current mode
+func Addr(addr string) string {
+	redactLock.RLock()
+	defer redactLock.RUnlock()
+
+	return redactText(addr)
+}
+
+// redactText replaces the IP addresses of the text
+func redactText(s string) string {
+	if redactMode == RedactNone {
+		return s
+	}
+	return ipPattern.ReplaceAllStringFunc(s, func(found string) string {
+		ip := net.ParseIP(found)
+		if ip == nil {
+			return found
+		}
+		switch redactMode {
+		case RedactTruncate:
+			if ip4 := ip.To4(); ip4 != nil {
+				return ip4.Mask(net.CIDRMask(24, 32)).String()
+			}
+			return ip.Mask(net.CIDRMask(48, 128)).String()
+		default:
+			mac := hmac.New(sha256.New, redactSalt)
+			mac.Write(ip)
+			return "ip-" + hex.EncodeToString(mac.Sum(nil)[:6])
+		}
+	})
+}
+
+// redact returns the values of the log entry whose IP addresses are redacted
+func redact(v []interface{}) []interface{} {
+	redactLock.RLock()
+	defer redactLock.RUnlock()
+
+	if redactMode == RedactNone {
+		return v
+	}
+	list := make([]interface{}, 0, len(v))
+	for _, e := range v {
+		list = append(list, redactText(fmt.Sprint(e)))
+	}
+	return list
+}
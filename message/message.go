@@ -42,6 +42,18 @@ type Message interface {
 	Type() Type
 }
 
+// Releaser is implemented by the readers which reuse the buffers of the read data (e.g. the connections of the router),
+// the buffers are released after the message is parsed. The data which is read before it is verified returns the error of it by Release
+type Releaser interface {
+	Release() error
+}
+
+// Sizer is implemented by the readers which know the remaining bytes of the message being read (e.g. the connections of the router),
+// so the creators read the large payloads of the declared length directly from the reader instead of buffering them
+type Sizer interface {
+	Remaining() int
+}
+
 // Creator is a message creator function type
 type Creator func(r io.Reader, mt Type) (Message, error)
 
@@ -59,6 +71,7 @@ func NewManager() *Manager {
 }
 
 // ParseMessage receives the data stream as a Reader and processes them through the creator and returns the message.
+// The buffer of the Releaser is released after the message is read and the message is discarded by the error of the release.
 func (mm *Manager) ParseMessage(r io.Reader, mt Type) (Message, error) {
 	mm.messageMapLock.Lock()
 	//log.Info("ParseMessage", NameOfType(mt), mt)
@@ -68,6 +81,11 @@ func (mm *Manager) ParseMessage(r io.Reader, mt Type) (Message, error) {
 		return nil, ErrUnknownMessage
 	}
 	msg, err := c(r, mt)
+	if rl, ok := r.(Releaser); ok {
+		if rerr := rl.Release(); err == nil {
+			err = rerr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
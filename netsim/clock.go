@@ -0,0 +1,101 @@
+package netsim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+type event struct {
+	at  time.Time
+	seq uint64
+	f   func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Clock is the virtual clock of the simulation which moves only by Advance.
+// The scheduled events are run in order of the time and the scheduled order so that the runs are deterministic.
+type Clock struct {
+	lock   sync.Mutex
+	now    time.Time
+	seq    uint64
+	events eventQueue
+}
+
+// NewClock returns a Clock which starts at the time
+func NewClock(start time.Time) *Clock {
+	return &Clock{
+		now:    start,
+		events: eventQueue{},
+	}
+}
+
+// Now returns the virtual time
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// AfterFunc schedules the function after the duration of the virtual time
+func (c *Clock) AfterFunc(d time.Duration, f func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.seq++
+	heap.Push(&c.events, &event{
+		at:  c.now.Add(d),
+		seq: c.seq,
+		f:   f,
+	})
+}
+
+// Pending returns the number of the scheduled events
+func (c *Clock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.events.Len()
+}
+
+// Advance moves the virtual time forward by the duration and runs the due events in order.
+// It returns the number of the events run.
+func (c *Clock) Advance(d time.Duration) int {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	c.lock.Unlock()
+
+	count := 0
+	for {
+		c.lock.Lock()
+		if c.events.Len() == 0 || c.events[0].at.After(end) {
+			c.now = end
+			c.lock.Unlock()
+			return count
+		}
+		e := heap.Pop(&c.events).(*event)
+		c.now = e.at
+		c.lock.Unlock()
+
+		e.f()
+		count++
+	}
+}
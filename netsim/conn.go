@@ -0,0 +1,163 @@
+package netsim
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Addr is the address of the simulated network
+type Addr string
+
+// Network returns the name of the network
+func (a Addr) Network() string { return "netsim" }
+
+func (a Addr) String() string { return string(a) }
+
+// buffer is a direction of the connection
+type buffer struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	closed   bool
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newBuffer() *buffer {
+	b := &buffer{}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+func (b *buffer) read(bs []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for b.buf.Len() == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return 0, timeoutError{}
+		}
+		b.cond.Wait()
+	}
+	return b.buf.Read(bs)
+}
+
+func (b *buffer) write(bs []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.buf.Write(bs)
+	b.cond.Broadcast()
+}
+
+func (b *buffer) setDeadline(t time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.deadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if !t.IsZero() {
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.lock.Lock()
+			b.cond.Broadcast()
+			b.lock.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+}
+
+func (b *buffer) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.cond.Broadcast()
+}
+
+// conn is an end of the in-memory connection whose writes are delivered by the condition of the link
+type conn struct {
+	n      *Network
+	local  Addr
+	remote Addr
+	in     *buffer
+	out    *buffer
+
+	closeOnce sync.Once
+	closeLock sync.Mutex
+	closed    bool
+}
+
+func newConnPair(n *Network, a Addr, b Addr) (*conn, *conn) {
+	ab := newBuffer()
+	ba := newBuffer()
+	ca := &conn{n: n, local: a, remote: b, in: ba, out: ab}
+	cb := &conn{n: n, local: b, remote: a, in: ab, out: ba}
+	return ca, cb
+}
+
+func (c *conn) Read(bs []byte) (int, error) {
+	return c.in.read(bs)
+}
+
+func (c *conn) Write(bs []byte) (int, error) {
+	c.closeLock.Lock()
+	closed := c.closed
+	c.closeLock.Unlock()
+	if closed {
+		return 0, ErrClosedConn
+	}
+	c.n.deliver(hostOfAddr(c.local), hostOfAddr(c.remote), c.out, bs)
+	return len(bs), nil
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeLock.Lock()
+		c.closed = true
+		c.closeLock.Unlock()
+		c.in.close()
+		c.n.closeAfterDelivered(hostOfAddr(c.local), hostOfAddr(c.remote), c.out)
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing because the writes are never blocked
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func hostOfAddr(a Addr) string {
+	host, _, err := net.SplitHostPort(string(a))
+	if err != nil {
+		return string(a)
+	}
+	return host
+}
@@ -0,0 +1,23 @@
+package netsim
+
+import (
+	"errors"
+
+	"github.com/fletaio/framework/router"
+)
+
+// errors
+var (
+	ErrConnectionRefused = errors.New("connection refused")
+	ErrListenerClosed    = router.ErrListenerClosed
+	ErrAddressInUse      = errors.New("address in use")
+	ErrClosedConn        = errors.New("use of closed connection")
+	ErrWaitTimeout       = errors.New("wait timeout")
+)
+
+// timeoutError is returned when the deadline of the connection is exceeded
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,190 @@
+package netsim
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Link is the condition of the writes from a host to a host
+type Link struct {
+	// Latency is the virtual time until the writes are delivered, they are delivered at once when it is zero
+	Latency time.Duration
+	// DropRate is the probability [0, 1] that a write (a frame) is lost
+	DropRate float64
+	// Down refuses the dials and loses all the writes
+	Down bool
+}
+
+type linkKey struct {
+	from string
+	to   string
+}
+
+// Network is the in-memory network of the simulation
+type Network struct {
+	lock      sync.Mutex
+	clock     *Clock
+	rand      *rand.Rand
+	listeners map[string]*listener
+	links     map[linkKey]Link
+	nextPort  int
+}
+
+// NewNetwork returns a Network whose drops are decided by the seed
+func NewNetwork(clock *Clock, seed int64) *Network {
+	return &Network{
+		clock:     clock,
+		rand:      rand.New(rand.NewSource(seed)),
+		listeners: map[string]*listener{},
+		links:     map[linkKey]Link{},
+		nextPort:  40000,
+	}
+}
+
+// SetLink sets the condition of the writes from the host to the host, the empty host matches all the hosts
+func (n *Network) SetLink(from string, to string, l Link) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.links[linkKey{from: from, to: to}] = l
+}
+
+func (n *Network) link(from string, to string) Link {
+	for _, key := range []linkKey{{from, to}, {from, ""}, {"", to}, {"", ""}} {
+		if l, has := n.links[key]; has {
+			return l
+		}
+	}
+	return Link{}
+}
+
+func (n *Network) deliver(from string, to string, out *buffer, bs []byte) {
+	n.lock.Lock()
+	l := n.link(from, to)
+	drop := l.Down || (l.DropRate > 0 && n.rand.Float64() < l.DropRate)
+	n.lock.Unlock()
+	if drop {
+		return
+	}
+	if l.Latency <= 0 {
+		out.write(bs)
+		return
+	}
+	data := append([]byte{}, bs...)
+	n.clock.AfterFunc(l.Latency, func() {
+		out.write(data)
+	})
+}
+
+// closeAfterDelivered closes the direction after the writes in flight are delivered
+func (n *Network) closeAfterDelivered(from string, to string, out *buffer) {
+	n.lock.Lock()
+	l := n.link(from, to)
+	n.lock.Unlock()
+	if l.Latency <= 0 {
+		out.close()
+		return
+	}
+	n.clock.AfterFunc(l.Latency, out.close)
+}
+
+// Endpoint returns the transport of the host which is registered to the router
+func (n *Network) Endpoint(host string) *Endpoint {
+	return &Endpoint{
+		n:    n,
+		host: host,
+	}
+}
+
+// Endpoint is the transport of a host of the Network
+type Endpoint struct {
+	n    *Network
+	host string
+}
+
+// Listen listens the port of the address on the host of the endpoint
+func (e *Endpoint) Listen(addr string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	a := Addr(net.JoinHostPort(e.host, port))
+
+	e.n.lock.Lock()
+	defer e.n.lock.Unlock()
+
+	if _, has := e.n.listeners[string(a)]; has {
+		return nil, ErrAddressInUse
+	}
+	l := &listener{
+		n:       e.n,
+		addr:    a,
+		connCh:  make(chan net.Conn, 128),
+		closeCh: make(chan struct{}),
+	}
+	e.n.listeners[string(a)] = l
+	return l, nil
+}
+
+// DialTimeout connects to the listener of the address
+func (e *Endpoint) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	e.n.lock.Lock()
+	l, has := e.n.listeners[addr]
+	down := e.n.link(e.host, hostOfAddr(Addr(addr))).Down
+	e.n.nextPort++
+	local := Addr(net.JoinHostPort(e.host, strconv.Itoa(e.n.nextPort)))
+	e.n.lock.Unlock()
+	if !has || down {
+		return nil, ErrConnectionRefused
+	}
+
+	c, s := newConnPair(e.n, local, Addr(addr))
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case l.connCh <- s:
+		return c, nil
+	case <-l.closeCh:
+		return nil, ErrConnectionRefused
+	case <-timeoutCh:
+		return nil, timeoutError{}
+	}
+}
+
+type listener struct {
+	n         *Network
+	addr      Addr
+	connCh    chan net.Conn
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.connCh:
+		return c, nil
+	case <-l.closeCh:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.n.lock.Lock()
+		delete(l.n.listeners, string(l.addr))
+		l.n.lock.Unlock()
+		close(l.closeCh)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,223 @@
+package netsim
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fletaio/common"
+	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/peer"
+	"github.com/fletaio/framework/router"
+	"github.com/fletaio/framework/router/evilnode"
+)
+
+// Port is the port which the nodes of the simulation listen
+const Port = 3000
+
+var simCount int32
+
+// Sim builds the routers and the peer managers of the nodes connected by the in-memory network
+type Sim struct {
+	Clock      *Clock
+	Net        *Network
+	ChainCoord *common.Coordinate
+	Nodes      []*Node
+
+	lock sync.Mutex
+	name string
+	dir  string
+	seed int64
+}
+
+// NewSim returns a Sim whose network is decided by the seed.
+// The stores of the nodes are kept in a temporary directory which is removed by Close.
+func NewSim(seed int64) (*Sim, error) {
+	dir, err := ioutil.TempDir("", "netsim")
+	if err != nil {
+		return nil, err
+	}
+	clock := NewClock(time.Unix(0, 0))
+	s := &Sim{
+		Clock:      clock,
+		Net:        NewNetwork(clock, seed),
+		ChainCoord: &common.Coordinate{},
+		Nodes:      []*Node{},
+		name:       "netsim" + strconv.Itoa(int(atomic.AddInt32(&simCount, 1))),
+		dir:        dir,
+		seed:       seed,
+	}
+	return s, nil
+}
+
+// hostOf returns the IP of the index of the node
+func hostOf(index int) string {
+	index++
+	return "10." + strconv.Itoa((index>>16)&0xff) + "." + strconv.Itoa((index>>8)&0xff) + "." + strconv.Itoa(index&0xff)
+}
+
+// AddNode creates a node with a new router and a new peer manager on the next host
+func (s *Sim) AddNode() (*Node, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	host := hostOf(len(s.Nodes))
+	network := s.name + "/" + host
+	router.RegisterNetwork(network, s.Net.Endpoint(host))
+
+	dir := filepath.Join(s.dir, host)
+	r, err := router.NewRouter(&router.Config{
+		Network: network,
+		Port:    Port,
+		EvilNodeConfig: evilnode.Config{
+			StorePath:    filepath.Join(dir, "router") + "/",
+			BanEvilScore: 100,
+		},
+	}, s.ChainCoord)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := peer.NewManager(s.ChainCoord, r, &peer.Config{
+		StorePath:  filepath.Join(dir, "peer") + "/",
+		RandSource: rand.NewSource(s.seed + int64(len(s.Nodes))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		Host:    host,
+		Addr:    host + ":" + strconv.Itoa(Port),
+		Network: network,
+		Router:  r,
+		Manager: pm,
+		peers:   map[string]bool{},
+		notify:  make(chan struct{}),
+	}
+	pm.RegisterEventHandler(n)
+	s.Nodes = append(s.Nodes, n)
+	return n, nil
+}
+
+// AddNodes creates the nodes
+func (s *Sim) AddNodes(count int) ([]*Node, error) {
+	list := make([]*Node, 0, count)
+	for i := 0; i < count; i++ {
+		n, err := s.AddNode()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// Start starts the peer managers of all the nodes
+func (s *Sim) Start() {
+	s.lock.Lock()
+	nodes := append([]*Node{}, s.Nodes...)
+	s.lock.Unlock()
+
+	for _, n := range nodes {
+		n.Manager.StartManage()
+	}
+}
+
+// Connect makes the node dial the other node
+func (s *Sim) Connect(from *Node, to *Node) error {
+	return from.Manager.AddNode(to.Addr)
+}
+
+// Close closes the routers of all the nodes and removes the stores
+func (s *Sim) Close() error {
+	s.lock.Lock()
+	nodes := s.Nodes
+	s.Nodes = []*Node{}
+	s.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, n := range nodes {
+		n.Router.Shutdown(ctx)
+		router.UnregisterNetwork(n.Network)
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// Node is a node of the simulation
+type Node struct {
+	Host    string
+	Addr    string
+	Network string
+	Router  router.Router
+	Manager peer.Manager
+
+	lock   sync.Mutex
+	peers  map[string]bool
+	notify chan struct{}
+}
+
+// PeerCount returns the number of the connected peers
+func (n *Node) PeerCount() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return len(n.peers)
+}
+
+// WaitPeers waits until the node has the peers at least the count.
+// It is woken by the connection events instead of polling.
+func (n *Node) WaitPeers(count int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		n.lock.Lock()
+		if len(n.peers) >= count {
+			n.lock.Unlock()
+			return nil
+		}
+		notify := n.notify
+		n.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return ErrWaitTimeout
+		}
+	}
+}
+
+func (n *Node) changed() {
+	close(n.notify)
+	n.notify = make(chan struct{})
+}
+
+// OnConnected is called when the peer is connected
+func (n *Node) OnConnected(ctx context.Context, p mesh.Peer) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.peers[p.NetAddr()] = true
+	n.changed()
+}
+
+// OnDisconnected is called when the peer is disconnected
+func (n *Node) OnDisconnected(ctx context.Context, p mesh.Peer) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	delete(n.peers, p.NetAddr())
+	n.changed()
+}
+
+// OnRecv is called when a message is received
+func (n *Node) OnRecv(ctx context.Context, p mesh.Peer, r io.Reader, t message.Type) error {
+	return message.ErrUnknownMessage
+}
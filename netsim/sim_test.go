@@ -0,0 +1,90 @@
+package netsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockOrder(t *testing.T) {
+	c := NewClock(time.Unix(0, 0))
+	got := []int{}
+	c.AfterFunc(2*time.Second, func() { got = append(got, 3) })
+	c.AfterFunc(time.Second, func() { got = append(got, 1) })
+	c.AfterFunc(time.Second, func() { got = append(got, 2) })
+	c.AfterFunc(time.Minute, func() { got = append(got, 4) })
+
+	if n := c.Advance(2 * time.Second); n != 3 {
+		t.Errorf("Advance() = %v, want %v", n, 3)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("events run in %v, want [1 2 3]", got)
+	}
+	if !c.Now().Equal(time.Unix(2, 0)) {
+		t.Errorf("Now() = %v, want %v", c.Now(), time.Unix(2, 0))
+	}
+	if c.Pending() != 1 {
+		t.Errorf("Pending() = %v, want %v", c.Pending(), 1)
+	}
+}
+
+func TestSimRing(t *testing.T) {
+	s, err := NewSim(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	nodes, err := s.AddNodes(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	for i, n := range nodes {
+		if err := s.Connect(n, nodes[(i+1)%len(nodes)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range nodes {
+		if err := n.WaitPeers(2, 10*time.Second); err != nil {
+			t.Errorf("%v has %v peers : %v", n.Host, n.PeerCount(), err)
+		}
+	}
+}
+
+func TestSimLatency(t *testing.T) {
+	s, err := NewSim(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	nodes, err := s.AddNodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Net.SetLink("", "", Link{Latency: 100 * time.Millisecond})
+	s.Start()
+	go s.Connect(nodes[0], nodes[1])
+
+	// the handshake is not delivered until the virtual clock is advanced
+	if err := nodes[0].WaitPeers(1, 200*time.Millisecond); err != ErrWaitTimeout {
+		t.Fatalf("WaitPeers() error = %v, want %v", err, ErrWaitTimeout)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				s.Clock.Advance(100 * time.Millisecond)
+			}
+		}
+	}()
+	defer close(done)
+	for _, n := range nodes {
+		if err := n.WaitPeers(1, 10*time.Second); err != nil {
+			t.Errorf("%v has %v peers : %v", n.Host, n.PeerCount(), err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/fletaio/framework/admin"
+)
+
+// RegisterAdmin adds the peer manager methods to the admin endpoint
+func (pm *manager) RegisterAdmin(am *admin.Manager) {
+	am.Add("peer.lockStats", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.LockStats(), nil
+	})
+	am.Add("peer.nodeInfos", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.NodeInfos(), nil
+	})
+	am.Add("peer.networkHealth", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.NetworkHealth(), nil
+	})
+	am.Add("peer.goroutines", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.Goroutines(), nil
+	})
+	am.Add("peer.trace", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := arg.Int(1)
+		if err != nil {
+			return nil, err
+		}
+		pm.TracePeer(addr, time.Duration(seconds)*time.Second)
+		return pm.TracedPeers(), nil
+	})
+	am.Add("peer.traces", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.TracedPeers(), nil
+	})
+	am.Add("peer.flaps", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.FlapInfos(), nil
+	})
+	am.Add("peer.scoreHistory", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		id, _ := arg.String(0)
+		return pm.ScoreHistory(id), nil
+	})
+	am.Add("peer.relays", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.RelayUsages(), nil
+	})
+	am.Add("peer.geoStats", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return pm.GeoStats(), nil
+	})
+}
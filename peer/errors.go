@@ -6,4 +6,9 @@ import "errors"
 var (
 	ErrIsBanAddress       = errors.New("is ban address")
 	ErrIsAlreadyConnected = errors.New("is already connected")
+	ErrMismatchGenesis    = errors.New("mismatch genesis")
+	ErrFlapHoldDown       = errors.New("flap hold-down")
+	ErrInvalidConfig      = errors.New("invalid config")
+	ErrTooManyPeers       = errors.New("too many peers")
+	ErrTooLargeGossip     = errors.New("too large gossip")
 )
@@ -1,29 +1,191 @@
 package peer
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/kvstore"
+	"github.com/fletaio/framework/lifecycle"
 
 	"github.com/fletaio/common"
+	"github.com/fletaio/framework/clock"
 	"github.com/fletaio/framework/log"
 	"github.com/fletaio/framework/message"
 	"github.com/fletaio/framework/peer/peermessage"
 	"github.com/fletaio/framework/peer/storage"
 	"github.com/fletaio/framework/router"
+	"github.com/fletaio/framework/router/evilnode"
+	"github.com/fletaio/framework/ttlcache"
 )
 
 //Config is structure storing settings information
 type Config struct {
 	StorePath string
+	// StoreBackend is the kvstore backend of the StorePath (badger when it is empty, memory runs diskless)
+	// and NodeStore is used instead of opening the StorePath when it is not nil, so the embedder can share its database
+	// (it is not closed by Shutdown)
+	StoreBackend string
+	NodeStore    kvstore.KVStore
+	// StoreKey encrypts the node store at rest, the StoreKeyEnv environment variable of the kvstore is used when it is empty
+	// and the store is kept in plaintext when both are empty
+	StoreKey string
+	// SpareCount is the number of idle connections kept beyond the peer group to replace a failed group member instantly.
+	// Zero disables the spare connections.
+	SpareCount int
+	// SpoolPeers are the addresses of the peers whose undeliverable messages are kept on the disk
+	// and sent when they are reconnected. SpoolPath is StorePath + "_spool" when it is empty.
+	SpoolPeers []string
+	SpoolPath  string
+	// SpoolMaxBytes and SpoolMaxAge are the caps of the spooled messages of each peer, zero is unlimited
+	SpoolMaxBytes int64
+	SpoolMaxAge   time.Duration
+	// PartitionThreshold is the fraction of the unreachable group peers to suspect the partition of the local node
+	// when no peer list has been received during PartitionGossipTimeout. Zero disables the partition detector.
+	PartitionThreshold     float64
+	PartitionGossipTimeout time.Duration
+	// StaticPeers are the addresses which are dialed to re-bootstrap when the partition is suspected
+	StaticPeers []string
+	// CandidateProbeMin and CandidateProbeMax are the bounds of the candidate probe interval.
+	// The interval grows toward the max as the peer group is filled, 5s and 30s are used when they are zero.
+	CandidateProbeMin time.Duration
+	CandidateProbeMax time.Duration
+	// LockProfiling records the wait time of the peer group, the event handler and the node store locks
+	LockProfiling bool
+	// StageTimeout is the timeout of each start and stop stage of the manager, 10s is used when it is zero
+	StageTimeout time.Duration
+	// TargetCastRatio is the number of the targeted messages sent for each broadcast message while both are waiting for a peer.
+	// 4 is used when it is zero and 1 sends them evenly.
+	TargetCastRatio int
+	// PunishFailures is the number of the tolerated failed requests of a candidate before it is reported to the evil node manager
+	// as the PunishOffense (BadBehaviour when it is zero). It is reported again after PunishCooldown (10m when it is zero).
+	// Zero disables the punishment.
+	PunishFailures int
+	PunishOffense  evilnode.KindOfEvil
+	PunishCooldown time.Duration
+	// CandidateMaxTimeouts drops the candidate which times out or is unreachable in a row of it, the host is possibly down
+	// and it is found again by the peer lists. The refused requests count one failure and the resets two, the timeouts are not punished.
+	// Zero keeps the candidates.
+	CandidateMaxTimeouts int
+	// ReplayWindow retains the broadcasts of the ReplayTypes for it and replays them to the peers which connect in it,
+	// so the fresh connections don't miss the latest announcements. ReplayLimit caps the retained broadcasts (32 when it is zero).
+	// Zero ReplayWindow disables it.
+	ReplayWindow time.Duration
+	ReplayTypes  []message.Type
+	ReplayLimit  int
+	// DataPlaneTypes are sent by the data plane connection of the peer which is dialed with the DataPlane of the router config,
+	// so the bulk messages (e.g. the blocks of the sync) don't delay the control messages. SendData of the peer does it for any message.
+	DataPlaneTypes []message.Type
+	// FlapWindow detects the flapping peer which disconnects FlapThreshold times in it (3 when it is zero)
+	// and holds down its reconnects for FlapHoldDown (30s when it is zero), which is doubled for each flap episode up to FlapMaxHoldDown (30m when it is zero).
+	// The episodes are forgotten after FlapMaxHoldDown without a flap. Zero FlapWindow disables it.
+	FlapWindow      time.Duration
+	FlapThreshold   int
+	FlapHoldDown    time.Duration
+	FlapMaxHoldDown time.Duration
+	// RandSource decides the random choices of the manager (e.g. the fanout of the limited broadcasts and the order of the candidates),
+	// so the tests and the simulations reproduce the topology from a seed. The time seeded source is used when it is nil.
+	RandSource rand.Source
+	// Clock is the time source of the bans, the candidates, the flap damping, the punishments and the manage loops,
+	// so the simulations and the replays control the time. The real clock is used when it is nil.
+	Clock clock.Clock
+	// ProbeInterval is the period of the probe rounds which measure the propagation latency to the nodes in ProbeTTL hops (3 when it is zero).
+	// The network is degraded when the health score is under ProbeAlertScore (50 when it is zero) or the 90th percentile latency
+	// is over ProbeLatencyThreshold (2s when it is zero). Zero ProbeInterval disables the rounds but the probes of the others are still relayed.
+	ProbeInterval         time.Duration
+	ProbeTTL              int
+	ProbeLatencyThreshold time.Duration
+	ProbeAlertScore       float64
+	// CandidateTTL is the lifetime of the candidate which is not connected, the candidates are kept until they are connected when it is zero.
+	// CacheCleanupInterval is the period of removing the expired candidates, bans, probe routes and score board entries (10s when it is zero).
+	CandidateTTL         time.Duration
+	CacheCleanupInterval time.Duration
+	// RegroupInterval is the period of moving the peers into the groups of their measured round trip times (1m when it is zero, negative disables it).
+	RegroupInterval time.Duration
+	// ScoreHistoryInterval is the period of recording the score components of the group members (1m when it is zero, negative disables it)
+	// and ScoreHistorySize is the number of the samples kept for each peer (180 when it is zero).
+	ScoreHistoryInterval time.Duration
+	ScoreHistorySize     int
+	// SendFailureThreshold is the number of the consecutive failed sends which close the peer and move its address back to the candidates
+	// (3 when it is zero, negative disables it). The reconnects of the address are backed off from SendFailureBackoff (5s when it is zero)
+	// doubling for each downgrade up to SendFailureMaxBackoff (5m when it is zero).
+	SendFailureThreshold  int
+	SendFailureBackoff    time.Duration
+	SendFailureMaxBackoff time.Duration
+	// RelayQuota is the broadcast bytes relayed by ExceptCast on behalf of each origin peer in RelayQuotaWindow (1m when it is zero),
+	// counted for every receiver. The relays of the origin over it are dropped until the next window. Zero disables it.
+	RelayQuota       int64
+	RelayQuotaWindow time.Duration
+	// ResumeGrace keeps the group slot of the disconnected group peer until it reconnects by the resumed session of the router
+	// (SessionTTL of the router config), so it keeps its membership after a brief network blip. The best spare peer is promoted
+	// to the slot after the grace. Zero disables it and the slot is given to the spare at once.
+	ResumeGrace time.Duration
+	// MaxGoroutines caps the goroutines spawned for the peers (readers, peer list requests, spool flushes, failovers and broadcasts),
+	// MaxPeerGoroutines caps them per peer. The new connections and the optional sends are shed over the caps. Zero is unlimited.
+	MaxGoroutines     int
+	MaxPeerGoroutines int
+	// MaxStoredNodes is the number of the known nodes kept in the store (4096 when it is zero, negative is unlimited),
+	// the node which has not been connected for the longest time is evicted over it and when the store is loaded.
+	// ScoreBoardSize and ScoreBoardMaxAge bound the ping score board of each node (64 and 1h when they are zero, negative is unlimited).
+	MaxStoredNodes   int
+	ScoreBoardSize   int
+	ScoreBoardMaxAge time.Duration
+	// Logger receives the error logs of the manager, the log package is used when it is nil
+	Logger Logger
+	// RotateInterval is the period of adding a stored node to the peer group (20m when it is zero)
+	// and RotateRetryInterval is the period while the peer group is not filled (5s when it is zero).
+	RotateInterval      time.Duration
+	RotateRetryInterval time.Duration
+	// PeerListInterval is the least period of the peer list requests sent to a peer and the ones answered for a peer
+	// (10s when it is zero, negative doesn't throttle them). The peers which support the diff of the peer lists
+	// exchange only the nodes updated after the last list or the nodes out of the filter of the PeerListFilterRate.
+	PeerListInterval time.Duration
+	// PeerListFilterRate is the false positive rate of the bloom filter of the known nodes which is sent with the peer list requests,
+	// so the peer answers only the unknown nodes (0.01 when it is zero, negative doesn't send the filter).
+	// The filter is seeded for each request, so a node left out by a false positive is answered by a later request.
+	PeerListFilterRate float64
+	// DialInterval is the pause between the dials of the candidates, the spares and the re-bootstrap (50ms when it is zero, negative doesn't pause).
+	// The small private networks dial faster and the large public ones spread the handshakes.
+	DialInterval time.Duration
+	// KickOutThreshold is the number of the connections over which the oldest peer which is not pinned is closed
+	// when a peer is added to the group (twice the peer group size when it is zero).
+	KickOutThreshold int
+	// MaxPeers is the upper bound of the connected peers, zero is unlimited. The spare peer which has the highest ping time
+	// and is not pinned is closed to make room for a new peer at it, and the new peer is closed when no such peer is better than it.
+	MaxPeers int
+	// OutboundRatio is the fraction of the MaxPeers reserved for the outbound peers which are dialed by the local node,
+	// the inbound peers are bounded by the rest of the slots so the inbound connections can't fill all of them. Zero reserves none.
+	OutboundRatio float64
+	// TargetOutbound is the number of the outbound peers which the manager keeps by dialing the stored nodes in every OutboundInterval
+	// (5s when it is zero), the nodes which were reachable recently are dialed first. Zero disables it.
+	TargetOutbound   int
+	OutboundInterval time.Duration
+	// BootstrapNodes are dialed first when the manager is started and they are retried from BootstrapBackoff (1s when it is zero)
+	// doubling up to BootstrapMaxBackoff (1m when it is zero) until one of them is connected.
+	// They are kept in the node store and never evicted from it.
+	BootstrapNodes      []string
+	BootstrapBackoff    time.Duration
+	BootstrapMaxBackoff time.Duration
+	// GossipTTL frames the broadcasts by the envelope of their message ID for the peers which support it and keeps the seen IDs for it,
+	// so a message which loops back through the mesh is handled and forwarded at most once by the node. Zero doesn't frame the broadcasts
+	// and the seen IDs of the GossipCast are kept for 10m.
+	GossipTTL time.Duration
+	// GossipHops is the number of the hops which a message of the GossipCast is forwarded in (6 when it is zero, 255 at most),
+	// the received message is forwarded in the hops of it clamped to the GossipHops
+	GossipHops int
+	// GossipFanout is the max number of the peers which a received message of the GossipCast is forwarded to (8 when it is zero),
+	// the fanout of the origin is used when it is smaller
+	GossipFanout int
 }
 
 // peer errors
@@ -34,19 +196,29 @@ var (
 //Manager manages peer-connected networks.
 type Manager interface {
 	RegisterEventHandler(eh mesh.EventHandler)
+	Use(handlers ...mesh.ChainHandler)
 	StartManage()
+	StartManageContext(ctx context.Context) error
+	Stop()
+	Shutdown(ctx context.Context) error
 	EnforceConnect()
+	EnforceConnectContext(ctx context.Context) error
 	AddNode(addr string) error
+	AddNodeContext(ctx context.Context, addr string) error
 	BroadCast(m message.Message)
 	BroadCastLimit(m message.Message, Limint int)
 	NodeList() []string
 	ConnectedList() []string
 	TargetCast(addr string, m message.Message) error
+	TargetCastContext(ctx context.Context, addr string, m message.Message) error
 	ExceptCast(addr string, m message.Message)
 	ExceptCastLimit(addr string, m message.Message, Limit int)
+	GossipCast(m message.Message, Fanout int)
 }
 
 type manager struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
 	Config         *Config
 	ChainCoord     *common.Coordinate
 	router         router.Router
@@ -57,15 +229,46 @@ type manager struct {
 	nodeRotateIndex int
 	candidates      candidateMap
 
-	peerGroupLock sync.Mutex
+	peerGroupLock statMutex
 	connections   connectMap
 
 	peerStorage storage.PeerStorage
-
-	eventHandlerLock sync.RWMutex
+	spool       *spool
+	identities  *identityMap
+	punishes    *punishMap
+	geo         geoResolver
+	rand        *lockedRand
+	probes      *probeState
+	routines    *goroutineCounter
+	traces      *ttlcache.Cache
+	replays     *replayBuffer
+	dataTypes   map[message.Type]bool
+	flaps       *flapDamper
+	scores      *scoreHistory
+	sendBackoff *sendBackoff
+	relays      *relayQuota
+	resumes     *resumeSlots
+	peerLists   *peerListState
+	gossip      *gossipCache
+	clock       clock.Clock
+	builtin     mesh.EventHandler
+	chain       []mesh.ChainHandler
+
+	lastGossip  int64
+	partitioned int32
+
+	eventHandlerLock statRWMutex
 	eventHandler     []mesh.EventHandler
 	BanPeerInfos     *ByTime
 
+	lifecycle    *lifecycle.Lifecycle
+	loopWg       sync.WaitGroup
+	loopDone     chan struct{}
+	loopDoneOnce sync.Once
+	acceptWg     sync.WaitGroup
+	shutdownOnce sync.Once
+	closing      int32
+
 	TestMsg string
 }
 
@@ -78,38 +281,101 @@ const (
 
 //NewManager is the peerManager creator.
 //Apply messages necessary for peer management.
-func NewManager(ChainCoord *common.Coordinate, r router.Router, Config *Config) (*manager, error) {
-	ns, err := newNodeStore(Config.StorePath)
+//The options are applied in order to the defaults, a *Config sets all of the settings.
+func NewManager(ChainCoord *common.Coordinate, r router.Router, opts ...Option) (*manager, error) {
+	Config := newConfig(opts)
+	if err := Config.Validate(); err != nil {
+		return nil, err
+	}
+	store := Config.NodeStore
+	if store == nil {
+		s, err := kvstore.Open(Config.StoreBackend, Config.StorePath)
+		if err != nil {
+			return nil, err
+		}
+		store = s
+	}
+	if key := kvstore.StoreKey(Config.StoreKey); key != "" {
+		s, err := kvstore.NewEncrypted(store, key)
+		if err != nil {
+			return nil, err
+		}
+		store = s
+	}
+	ns, err := newNodeStore(store, Config.Clock, Config.MaxStoredNodes, Config.ScoreBoardSize, Config.ScoreBoardMaxAge, Config.BootstrapNodes)
 	if err != nil {
 		return nil, err
 	}
+	for _, addr := range Config.BootstrapNodes {
+		ns.LoadOrStore(addr, ns.newConnectInfo(addr, 0))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	pm := &manager{
+		ctx:            ctx,
+		cancel:         cancel,
 		Config:         Config,
 		ChainCoord:     ChainCoord,
 		router:         r,
 		MessageManager: message.NewManager(),
 		nodes:          ns,
-		candidates:     candidateMap{},
+		candidates:     newCandidateMap(Config.CandidateTTL, Config.Clock),
 		connections:    connectMap{},
 		eventHandler:   []mesh.EventHandler{},
-		BanPeerInfos:   NewByTime(),
+		BanPeerInfos:   NewByTimeWithClock(Config.Clock),
+		identities:     newIdentityMap(),
+		punishes:       newPunishMap(),
+		rand:           newLockedRand(Config.RandSource),
+		probes:         newProbeState(Config.Clock),
+		routines:       newGoroutineCounter(Config.MaxGoroutines, Config.MaxPeerGoroutines),
+		traces:         ttlcache.NewWithClock(0, Config.Clock),
+		replays:        newReplayBuffer(Config.ReplayWindow, Config.ReplayTypes, Config.ReplayLimit, Config.Clock),
+		flaps:          newFlapDamper(Config.FlapWindow, Config.FlapThreshold, Config.FlapHoldDown, Config.FlapMaxHoldDown, Config.Clock),
+		scores:         newScoreHistory(Config.ScoreHistoryInterval, Config.ScoreHistorySize),
+		sendBackoff:    newSendBackoff(Config.SendFailureBackoff, Config.SendFailureMaxBackoff, Config.Clock),
+		relays:         newRelayQuota(Config.RelayQuota, Config.RelayQuotaWindow, Config.Clock),
+		peerLists:      newPeerListState(),
+		resumes:        newResumeSlots(Config.ResumeGrace, Config.Clock),
+		gossip:         newGossipCache(Config.GossipTTL, Config.Clock),
+		clock:          clock.Or(Config.Clock),
+		loopDone:       make(chan struct{}),
 	}
 	pm.peerStorage = storage.NewPeerStorage()
+	if len(Config.DataPlaneTypes) > 0 {
+		pm.dataTypes = map[message.Type]bool{}
+		for _, t := range Config.DataPlaneTypes {
+			pm.dataTypes[t] = true
+		}
+	}
+	if Config.LockProfiling {
+		pm.peerGroupLock.stat = newLockStat("peerGroupLock")
+		pm.eventHandlerLock.stat = newLockStat("eventHandlerLock")
+		pm.nodes.l.stat = newLockStat("nodeStore")
+	}
+	if len(Config.SpoolPeers) > 0 {
+		path := Config.SpoolPath
+		if path == "" {
+			path = Config.StorePath + "_spool"
+		}
+		db, err := kvstore.Open(Config.StoreBackend, path)
+		if err != nil {
+			return nil, err
+		}
+		sp, err := newSpool(db, Config.Clock, Config.SpoolMaxBytes, Config.SpoolMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		pm.spool = sp
+	}
 
 	//add requestPeerList message
 	pm.MessageManager.SetCreator(peermessage.PeerListMessageType, peermessage.PeerListCreator)
+	pm.MessageManager.SetCreator(peermessage.PeerListDiffMessageType, peermessage.PeerListDiffCreator)
+	pm.MessageManager.SetCreator(peermessage.ProbeMessageType, peermessage.ProbeCreator)
 
-	pm.RegisterEventHandler(pm)
-
-	go func() {
-		for {
-			time.Sleep(3 * time.Second)
-			pm.connections.Range(func(addr string, p Peer) bool {
-				p.SendHeartBit()
-				return true
-			})
-		}
-	}()
+	pm.builtin = pm
+	pm.RegisterEventHandler(pm.builtin)
+	r.OnEvent(pm.onRouterEvent)
+	pm.lifecycle = pm.newLifecycle()
 
 	// mc := make(chan simulations.Msg)
 	// go func() {
@@ -136,7 +402,33 @@ func (pm *manager) errLog(msg ...interface{}) {
 		path := strings.Split(file, "/")
 		file = strings.Join(path[len(path)-3:], "/")
 	}
-	log.Error(append([]interface{}{file, " ", line, " ", pm.router.Conf().Network, " "}, msg...)...)
+	v := append([]interface{}{file, " ", line, " ", pm.router.Conf().Network, " "}, msg...)
+	if pm.Config.Logger != nil {
+		pm.Config.Logger.Error(v...)
+		return
+	}
+	log.Error(v...)
+}
+
+func (pm *manager) isLocalhost(addr string) bool {
+	localhost := pm.router.Localhost()
+	if localhost != "" && router.IsSameAddress(addr, localhost) {
+		return true
+	}
+	for _, l := range pm.router.ListenAddrs() {
+		if l == addr {
+			return true
+		}
+	}
+	return false
+}
+
+//advertiseAddr returns the address of the node told to the peer in the peer list messages
+func (pm *manager) advertiseAddr(p Peer) string {
+	if addr := pm.router.Conf().AdvertiseAddr; addr != "" {
+		return addr
+	}
+	return p.LocalAddr().String()
 }
 
 //RegisterEventHandler is Registered event handler
@@ -146,71 +438,198 @@ func (pm *manager) RegisterEventHandler(eh mesh.EventHandler) {
 	pm.eventHandlerLock.Unlock()
 }
 
+//Use puts the handlers in front of the built-in handler of the manager (e.g. the peer lists and the probes),
+//so the handlers extend it by calling next instead of replacing it
+func (pm *manager) Use(handlers ...mesh.ChainHandler) {
+	pm.eventHandlerLock.Lock()
+	defer pm.eventHandlerLock.Unlock()
+
+	pm.chain = append(pm.chain, handlers...)
+	composed := mesh.Chain(pm, pm.chain...)
+	for i, eh := range pm.eventHandler {
+		if eh == pm.builtin {
+			pm.eventHandler[i] = composed
+			break
+		}
+	}
+	pm.builtin = composed
+}
+
 //StartManage is start peer management
+//StartManage starts the accept loop, the router listeners and the manage loops in order
 func (pm *manager) StartManage() {
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		wg.Done()
-		for {
-			conn, pingTime, err := pm.router.Accept()
-			if err != nil {
-				if conn != nil {
-					conn.Close()
-				}
-				// pm.errLog(err, conn.ID())
-				continue
-			}
+	if err := pm.StartManageContext(context.Background()); err != nil {
+		pm.errLog("StartManage ", err)
+	}
+}
 
-			// ban check
-			addr := conn.ID()
-			if pm.BanPeerInfos.IsBan(addr) {
-				if hp, has := pm.connections.Load(addr); has {
-					hp.Close()
-				}
-				conn.Close()
-				// pm.errLog("BanPeerInfos.IsBan(addr) ", addr)
-				continue
+//StartManageContext starts the manager as StartManage does and the context bounds the starting of the stages.
+//The manager is shut down when the context is done, so its lifetime is tied to the context tree of the embedder
+func (pm *manager) StartManageContext(ctx context.Context) error {
+	if err := pm.lifecycle.Start(ctx); err != nil {
+		return err
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				pm.Shutdown(context.Background())
+			case <-pm.ctx.Done():
 			}
+		}()
+	}
+	return nil
+}
 
-			go func(conn router.Conn) {
-				peer := newPeer(conn, pingTime, pm.deletePeer, pm.onRecvEventHandler)
-				defer peer.Close()
-
-				err = pm.addPeer(peer)
-				if err != nil {
+// acceptLoop adds the accepted connections as the peers until the router is closed
+func (pm *manager) acceptLoop() {
+	for {
+		conn, pingTime, err := pm.router.Accept()
+		if err != nil {
+			switch e := err.(type) {
+			case *router.AcceptError:
+				pm.errLog("acceptLoop ", e)
+			default:
+				if err == router.ErrRouterClosed {
 					return
 				}
-				pm.eventHandlerLock.RLock()
-				for _, eh := range pm.eventHandler {
-					eh.OnConnected(peer)
+				if err == router.ErrListenerClosed {
+					// the dialed connections are still accepted
+					pm.errLog("acceptLoop all listeners are closed")
 				}
-				pm.eventHandlerLock.RUnlock()
-				peer.Start()
-			}(conn)
+			}
+			continue
 		}
-	}()
-	wg.Wait()
 
-	pm.router.Listen()
+		// ban check
+		addr := conn.ID()
+		if pm.BanPeerInfos.IsBan(addr) || (conn.RemoteID() != "" && pm.BanPeerInfos.IsBan(conn.RemoteID())) {
+			if hp, has := pm.connections.Load(addr); has {
+				hp.Close()
+			}
+			conn.Close()
+			// pm.errLog("BanPeerInfos.IsBan(addr) ", addr)
+			continue
+		}
+		if pm.isHeldDown(addr) {
+			pm.trace(addr, "held down")
+			conn.Close()
+			continue
+		}
 
-	go pm.manageCandidate()
-	go pm.rotatePeer()
+		// the reader goroutine is the only long lived goroutine of the peer, the new connections are shed over the caps
+		started := pm.spawn(addr, "reader", func() {
+			peer := newPeer(pm.ctx, conn, pingTime, pm.clock.Now(), pm.deletePeer, pm.onRecvEventHandler, pm.Config.TargetCastRatio)
+			peer.tracer = pm.isTraced
+			peer.dataTypes = pm.dataTypes
+			peer.onSendError = pm.onSendError
+			defer func() {
+				peer.Close()
+				pm.trace(peer.NetAddr(), "closed")
+			}()
+
+			if err := pm.addPeer(peer); err != nil {
+				pm.trace(peer.NetAddr(), "not added ", err)
+				return
+			}
+			pm.trace(peer.NetAddr(), "connected ", peer.LocalAddr(), " ping ", peer.PingTime())
+			pm.resumeSlot(peer)
+			pm.eventHandlerLock.RLock()
+			for _, eh := range pm.eventHandler {
+				eh.OnConnected(peer.ctx, peer)
+			}
+			pm.eventHandlerLock.RUnlock()
+			if pm.isSpoolPeer(peer.NetAddr()) {
+				pm.spawn(peer.NetAddr(), "spool", func() {
+					pm.flushSpool(peer)
+				})
+			}
+			if peer.Features()&router.FeatureDataPlane != 0 {
+				pm.spawn(peer.NetAddr(), "data", peer.readData)
+			}
+			if peer.Features()&router.FeatureChannels != 0 {
+				pm.spawn(peer.NetAddr(), "control", func() {
+					peer.readChannel(router.ChannelControl)
+				})
+				pm.spawn(peer.NetAddr(), "peerExchange", func() {
+					peer.readChannel(router.ChannelPeerExchange)
+				})
+			}
+			if pm.replays != nil {
+				pm.spawn(peer.NetAddr(), "replay", func() {
+					pm.replayBroadcasts(peer)
+				})
+			}
+			peer.Start()
+		})
+		if !started {
+			conn.Close()
+		}
+	}
 }
 
-func (pm *manager) onRecvEventHandler(p *peer, t message.Type) error {
+func (pm *manager) onRecvEventHandler(p *peer, r io.Reader, t message.Type) (err error) {
 	pm.eventHandlerLock.RLock()
 	defer pm.eventHandlerLock.RUnlock()
-	for _, eh := range pm.eventHandler {
-		err := eh.OnRecv(p, p, t)
+	ctx := pm.replyContext(p, t)
+	if t == replyEnvelopeType {
+		inReplyTo, inner, err := openReply(r)
+		if err != nil {
+			return err
+		}
+		t = inner
+		ctx = mesh.WithInReplyTo(pm.replyContext(p, t), inReplyTo)
+	} else if t == gossipEnvelopeType {
+		id, inner, body, err := openGossip(r)
+		if err != nil {
+			return err
+		}
+		// the body of the duplicate is already read, so the next message follows it
+		if !pm.gossip.receive(id) {
+			return nil
+		}
+		r = bytes.NewReader(body)
+		t = inner
+		ctx = pm.replyContext(p, t)
+	} else if t == gossipCastEnvelopeType {
+		gc, err := openGossipCast(r)
+		if err != nil {
+			return err
+		}
+		if !pm.gossip.receive(gc.id) {
+			return nil
+		}
+		// the message is forwarded after the handlers accept it
+		defer func() {
+			if err == nil {
+				pm.forwardGossipCast(p.NetAddr(), gc)
+			}
+		}()
+		r = bytes.NewReader(gc.body)
+		t = gc.t
+		ctx = pm.replyContext(p, t)
+	}
+	traced := pm.isTraced(p.NetAddr())
+	start := time.Now()
+	for i, eh := range pm.eventHandler {
+		err := eh.OnRecv(ctx, p, r, t)
 		if err != nil {
 			if err == message.ErrUnknownMessage {
 				continue
 			}
 			// pm.errLog("onRecvEventHandler ", err, " local ", p.LocalAddr().String(), "remote", p.ID())
+			if traced {
+				log.Info("trace ", p.NetAddr(), " recv ", message.NameOfType(t), " handler ", i, " in ", time.Now().Sub(start), " err ", err)
+			}
 			return err
 		}
-		break
+		if traced {
+			log.Info("trace ", p.NetAddr(), " recv ", message.NameOfType(t), " handler ", i, " in ", time.Now().Sub(start))
+		}
+		return nil
+	}
+	if traced {
+		log.Info("trace ", p.NetAddr(), " recv ", message.NameOfType(t), " unhandled")
 	}
 
 	return nil
@@ -218,6 +637,11 @@ func (pm *manager) onRecvEventHandler(p *peer, t message.Type) error {
 
 // EnforceConnect handles all of the Request standby nodes in the cardidate.
 func (pm *manager) EnforceConnect() {
+	pm.EnforceConnectContext(context.Background())
+}
+
+// EnforceConnectContext requests the standby nodes as EnforceConnect does until the context is done and returns the error of the context
+func (pm *manager) EnforceConnectContext(ctx context.Context) error {
 	dialList := []string{}
 	pm.candidates.rangeMap(func(addr string, cs candidateState) bool {
 		if cs == csRequestWait {
@@ -226,23 +650,39 @@ func (pm *manager) EnforceConnect() {
 		return true
 	})
 	for _, addr := range dialList {
-		err := pm.router.Request(addr)
+		if pm.isHeldDown(addr) {
+			continue
+		}
+		err := pm.router.RequestContext(ctx, addr, pm.ChainCoord)
 		if err != nil {
 			// pm.errLog("EnforceConnect error ", err)
 		}
-		time.Sleep(time.Millisecond * 50)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pm.dialInterval()):
+		}
 	}
+	return nil
 }
 
 // AddNode is used to register additional peers from outside.
 func (pm *manager) AddNode(addr string) error {
-	if pm.router.Localhost() != "" && strings.HasPrefix(addr, pm.router.Localhost()) {
+	return pm.AddNodeContext(context.Background(), addr)
+}
+
+// AddNodeContext registers the node as AddNode does and the request of it is canceled when the context is done.
+// The node is kept as the candidate so it is requested again by the manage loop
+func (pm *manager) AddNodeContext(ctx context.Context, addr string) error {
+	if pm.isLocalhost(addr) {
 		return nil
 	}
 
-	if !pm.router.EvilNodeManager().IsBanNode(addr) {
+	if pm.router.IsPinned(addr) || !pm.router.EvilNodeManager().IsBanNode(addr) {
 		pm.candidates.store(addr, csRequestWait)
-		pm.doManageCandidate(addr, csRequestWait)
+		if err := pm.requestCandidate(ctx, addr); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
 	} else {
 		// pm.errLog("AddNode router.ErrCanNotConnectToEvilNode", addr)
 		return router.ErrCanNotConnectToEvilNode
@@ -251,54 +691,155 @@ func (pm *manager) AddNode(addr string) error {
 }
 
 //BroadCast is used to propagate messages to all nodes.
+//The message which is already forwarded in the GossipTTL is not sent again.
 func (pm *manager) BroadCast(m message.Message) {
+	frame, ok := pm.gossipFrame(m)
+	if !ok {
+		return
+	}
+	pm.replays.retain(m)
 	pm.connections.Range(func(addr string, p Peer) bool {
-		p.Send(m)
+		pm.sendGossip(p, m, frame)
 		return true
 	})
 }
 
 //BroadCastLimit is used to propagate messages to limited number of nodes.
+//The nodes are chosen by the rand source of the manager.
 func (pm *manager) BroadCastLimit(m message.Message, Limit int) {
-	Count := 0
-	pm.connections.Range(func(addr string, p Peer) bool {
-		p.Send(m)
-		Count++
-		return Count < Limit
-	})
+	frame, ok := pm.gossipFrame(m)
+	if !ok {
+		return
+	}
+	pm.replays.retain(m)
+	for i, p := range pm.shuffledConnections() {
+		if i >= Limit {
+			break
+		}
+		pm.sendGossip(p, m, frame)
+	}
 }
 
 //BroadCast is used to propagate messages to all nodes.
+//The message is relayed on behalf of the excepted peer, so it is dropped when the peer is over the RelayQuota
+//and when it is already forwarded in the GossipTTL.
 func (pm *manager) ExceptCast(exceptAddr string, m message.Message) {
+	frame, ok := pm.gossipFrame(m)
+	if !ok {
+		return
+	}
+	pm.replays.retain(m)
+	targets := []Peer{}
 	pm.connections.Range(func(addr string, p Peer) bool {
 		if exceptAddr != addr {
-			p.Send(m)
+			targets = append(targets, p)
 		}
 		return true
 	})
+	if !pm.allowRelay(exceptAddr, m, len(targets)) {
+		return
+	}
+	for _, p := range targets {
+		pm.sendGossip(p, m, frame)
+	}
 }
 
 //ExceptCastLimit is used to propagate messages to limited number of nodes.
+//The nodes are chosen by the rand source of the manager and the RelayQuota of the excepted peer is applied as ExceptCast does.
 func (pm *manager) ExceptCastLimit(exceptAddr string, m message.Message, Limit int) {
-	Count := 0
-	pm.connections.Range(func(addr string, p Peer) bool {
-		if exceptAddr != addr {
-			p.Send(m)
-			Count++
+	frame, ok := pm.gossipFrame(m)
+	if !ok {
+		return
+	}
+	pm.replays.retain(m)
+	targets := []Peer{}
+	for _, p := range pm.shuffledConnections() {
+		if len(targets) >= Limit {
+			break
 		}
-		return Count < Limit
-	})
+		if exceptAddr != p.NetAddr() {
+			targets = append(targets, p)
+		}
+	}
+	if !pm.allowRelay(exceptAddr, m, len(targets)) {
+		return
+	}
+	for _, p := range targets {
+		pm.sendGossip(p, m, frame)
+	}
 }
 
 //TargetCast is used to propagate messages to all nodes.
+//The message is spooled when the target is one of the SpoolPeers and it is not delivered
 func (pm *manager) TargetCast(addr string, m message.Message) error {
+	return pm.TargetCastContext(context.Background(), addr, m)
+}
+
+//TargetCastContext sends the message as TargetCast does and returns the error of the context when it is done before the message is sent.
+//The message which is being written when the context is done may be delivered still
+func (pm *manager) TargetCastContext(ctx context.Context, addr string, m message.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if p, has := pm.connections.Load(addr); has {
-		p.Send(m)
-		return nil
+		var err error
+		if ctx.Done() == nil {
+			err = p.Send(m)
+		} else {
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- p.Send(m)
+			}()
+			select {
+			case err = <-errCh:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err == nil || !pm.isSpoolPeer(addr) {
+			return nil
+		}
+	}
+	if pm.isSpoolPeer(addr) {
+		bs, err := encodeMessage(m)
+		if err != nil {
+			return err
+		}
+		return pm.spool.Push(addr, bs)
 	}
 	return ErrNotFoundPeer
 }
 
+func (pm *manager) isSpoolPeer(addr string) bool {
+	if pm.spool == nil {
+		return false
+	}
+	for _, v := range pm.Config.SpoolPeers {
+		if v == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// flushSpool sends the spooled messages of the reconnected peer and spools the rest again when it fails
+func (pm *manager) flushSpool(p *peer) {
+	addr := p.NetAddr()
+	list, err := pm.spool.Pop(addr)
+	if err != nil {
+		pm.errLog("flushSpool ", err)
+		return
+	}
+	for i, bs := range list {
+		if err := p.sendRaw(bs, true); err != nil {
+			for _, rest := range list[i:] {
+				pm.spool.Push(addr, rest)
+			}
+			return
+		}
+	}
+}
+
 //NodeList is returns the addresses of the collected peers
 func (pm *manager) TestList() []string {
 	return []string{pm.TestMsg}
@@ -335,32 +876,49 @@ func (pm *manager) CandidateList() []string {
 	return list
 }
 
+//SpareList returns the addresses of the connected peers which are not included in the peer group.
+func (pm *manager) SpareList() []string {
+	list := make([]string, 0)
+	for _, p := range pm.spares() {
+		list = append(list, p.NetAddr())
+	}
+	return list
+}
+
 //GroupList returns a list of peer groups.
 func (pm *manager) GroupList() []string {
 	return pm.peerStorage.List()
 }
 
 // func (pm *manager) peerListHandler(m message.Message) error {
-func (pm *manager) OnRecv(p mesh.Peer, r io.Reader, t message.Type) error {
+func (pm *manager) OnRecv(ctx context.Context, p mesh.Peer, r io.Reader, t message.Type) error {
 	m, err := pm.MessageManager.ParseMessage(r, t)
 	if err != nil {
 		return err
 	}
 
 	switch m.(type) {
+	case *peermessage.Probe:
+		return pm.onProbe(p, m.(*peermessage.Probe))
 	case *peermessage.PeerList:
 		peerList := m.(*peermessage.PeerList)
 		if peerList.Request == true {
+			if !pm.answerPeerList(p.NetAddr()) {
+				return nil
+			}
 			peerList.Request = false
-			nodeMap := make(map[string]peermessage.ConnectInfo)
-			pm.nodes.Range(func(addr string, ci peermessage.ConnectInfo) bool {
-				nodeMap[addr] = ci
-				return true
-			})
-			peerList.List = nodeMap
+			// the time is taken before the snapshot so the nodes updated while making the list are sent again
+			now := pm.clock.Now().UnixNano()
+			if peerList.Diff {
+				peerList.List = pm.nodesSince(peerList.Since, peerList.Filter)
+				peerList.Since = now
+				peerList.Filter = nil
+			} else {
+				peerList.List = pm.nodesSince(0, nil)
+			}
 
 			if p, has := pm.connections.Load(peerList.From); has {
-				peerList.From = p.LocalAddr().String()
+				peerList.From = pm.advertiseAddr(p)
 				p.Send(peerList)
 			}
 
@@ -369,23 +927,33 @@ func (pm *manager) OnRecv(p mesh.Peer, r io.Reader, t message.Type) error {
 			defer pm.peerGroupLock.Unlock()
 
 			pm.candidates.delete(peerList.From)
+			atomic.StoreInt64(&pm.lastGossip, pm.clock.Now().UnixNano())
+			if peerList.Diff {
+				pm.peerLists.received(p.NetAddr(), peerList.Since)
+			}
 
+			addrs := make([]string, 0, len(peerList.List))
 			for _, ci := range peerList.List {
-				if ci.Address == pm.router.Localhost() {
+				addrs = append(addrs, ci.Address)
+			}
+			// the nodes are added in the order decided by the rand source
+			pm.rand.shuffle(addrs)
+			for _, addr := range addrs {
+				if pm.isLocalhost(addr) {
 					continue
 				}
 
-				if _, has := pm.candidates.load(ci.Address); has {
+				if _, has := pm.candidates.load(addr); has {
 					continue
 				}
-				if _, has := pm.nodes.Load(ci.Address); has {
+				if _, has := pm.nodes.Load(addr); has {
 					continue
 				}
-				if _, has := pm.connections.Load(ci.Address); has {
+				if _, has := pm.connections.Load(addr); has {
 					continue
 				}
 
-				pm.AddNode(ci.Address)
+				pm.AddNode(addr)
 			}
 
 			if p, connectionHas := pm.connections.Load(peerList.From); connectionHas {
@@ -407,61 +975,101 @@ func (pm *manager) OnRecv(p mesh.Peer, r io.Reader, t message.Type) error {
 func (pm *manager) updateScoreBoard(p Peer, ci peermessage.ConnectInfo) {
 	addr := p.NetAddr()
 
-	node := pm.nodes.LoadOrStore(addr, peermessage.NewConnectInfo(addr, p.PingTime()))
+	node := pm.nodes.LoadOrStore(addr, pm.nodes.newConnectInfo(addr, p.PingTime()))
 	node.PingScoreBoard.Store(ci.Address, ci.PingTime, p.LocalAddr().String()+" "+p.NetAddr()+" ")
 }
 
 func (pm *manager) doManageCandidate(addr string, cs candidateState) error {
-	if strings.HasPrefix(addr, pm.router.Localhost()) {
-		go pm.candidates.delete(addr)
+	if pm.isLocalhost(addr) {
+		pm.candidates.delete(addr)
 	}
 	var err error
 	switch cs {
 	case csRequestWait:
-		// go func(addr string) {
-		/*err = */
-		pm.router.Request(addr)
-		// if err != nil {
-		// pm.errLog("RequestWait err ", err)
-		// }
-		// }(addr)
+		err = pm.requestCandidate(pm.ctx, addr)
 	case csPeerListWait:
 		if p, has := pm.connections.Load(addr); has {
-			peermessage.SendRequestPeerList(p, p.LocalAddr().String())
+			pm.requestPeerList(p)
 		} else {
-			go pm.candidates.store(addr, csRequestWait)
+			pm.candidates.store(addr, csRequestWait)
 		}
 	}
 	return err
 }
 
+// requestCandidate requests the candidate and punishes it when the request fails, the canceled request is not punished
+func (pm *manager) requestCandidate(ctx context.Context, addr string) error {
+	if pm.isHeldDown(addr) {
+		return ErrFlapHoldDown
+	}
+	err := pm.router.RequestContext(ctx, addr, pm.ChainCoord)
+	if err != nil {
+		if ctx.Err() == nil && pm.punishCandidate(addr, err) {
+			pm.candidates.delete(addr)
+		}
+	} else {
+		pm.forgiveCandidate(addr)
+	}
+	return err
+}
+
+// candidateProbeInterval scales the candidate probe interval between the min and the max by the fill ratio of the peer group
+func (pm *manager) candidateProbeInterval() time.Duration {
+	min := pm.Config.CandidateProbeMin
+	if min <= 0 {
+		min = time.Second * 5
+	}
+	max := pm.Config.CandidateProbeMax
+	if max <= 0 {
+		max = time.Second * 30
+	}
+	if max < min {
+		max = min
+	}
+	ratio := float64(pm.peerStorage.Len()) / float64(storage.MaxPeerStorageLen())
+	if ratio > 1 {
+		ratio = 1
+	}
+	return min + time.Duration(float64(max-min)*ratio)
+}
+
 func (pm *manager) manageCandidate() {
 	for {
-		if pm.peerStorage.NotEnoughPeer() {
-			time.Sleep(time.Second * 5)
-		} else {
-			time.Sleep(time.Second * 30)
+		if !pm.sleep(pm.candidateProbeInterval()) {
+			return
+		}
+		for _, c := range pm.prioritizedCandidates() {
+			pm.doManageCandidate(c.addr, c.state)
+			pm.dialPause()
 		}
-		pm.candidates.rangeMap(func(addr string, cs candidateState) bool {
-			pm.doManageCandidate(addr, cs)
-			time.Sleep(time.Millisecond * 50)
-			return true
-		})
 	}
 }
 
 func (pm *manager) rotatePeer() {
 	for {
-		if pm.peerStorage.NotEnoughPeer() {
-			time.Sleep(time.Second * 5)
-		} else {
-			time.Sleep(time.Minute * 20)
+		if !pm.sleep(pm.rotateInterval()) {
+			return
 		}
 
 		pm.appendPeerStorage()
 	}
 }
 
+const defaultRegroupInterval = time.Minute
+
+// regroupPeers moves the peers into the groups of the round trip times which are measured after the handshake
+func (pm *manager) regroupPeers() {
+	d := pm.Config.RegroupInterval
+	if d == 0 {
+		d = defaultRegroupInterval
+	}
+	for pm.sleep(d) {
+		if n := pm.peerStorage.Regroup(); n > 0 {
+			log.Debug("regroup ", n, " peers")
+		}
+	}
+}
+
 func (pm *manager) appendPeerStorage() {
 	var len int
 	pm.connections.Range(func(k string, p Peer) bool {
@@ -474,7 +1082,7 @@ func (pm *manager) appendPeerStorage() {
 
 	if len == 1 {
 		pm.connections.Range(func(k string, p Peer) bool {
-			peermessage.SendRequestPeerList(p, p.LocalAddr().String())
+			pm.requestPeerList(p)
 			return false
 		})
 	}
@@ -482,10 +1090,11 @@ func (pm *manager) appendPeerStorage() {
 	for i := pm.nodeRotateIndex; i < pm.nodes.Len(); i++ {
 		p := pm.nodes.Get(i)
 		pm.nodeRotateIndex = i + 1
-		if pm.peerStorage.Have(p.Address) {
+		connectedPeer, has := pm.connections.Load(p.Address)
+		if has && pm.peerStorage.Have(connectedPeer.ID()) {
 			continue
 		}
-		if connectedPeer, has := pm.connections.Load(p.Address); has {
+		if has {
 			pm.addReadyConn(connectedPeer)
 		} else {
 			err := pm.router.Request(p.Address)
@@ -508,9 +1117,13 @@ func (pm *manager) kickOutPeerStorage() {
 		return true
 	})
 
-	if len > storage.MaxPeerStorageLen()*2 {
+	if len > pm.kickOutThreshold() {
 		var closePeer Peer
 		pm.connections.Range(func(addr string, p Peer) bool {
+			// the pinned nodes are never kicked out
+			if pm.router.IsPinned(p.ID()) {
+				return true
+			}
 			if closePeer == nil || closePeer.ConnectedTime() > p.ConnectedTime() {
 				closePeer = p
 			}
@@ -523,40 +1136,132 @@ func (pm *manager) kickOutPeerStorage() {
 }
 
 func (pm *manager) deletePeer(addr string) {
+	p, has := pm.connections.Load(addr)
 	pm.connections.Delete(addr)
+	pm.peerLists.forget(addr)
+	id := addr
+	if has {
+		id = p.ID()
+	}
+	if pm.peerStorage.Remove(id) && !pm.holdSlot(id) && !pm.isClosing() {
+		pm.promoteSpare()
+	}
 	pm.eventHandlerLock.RLock()
-	if p, has := pm.connections.Load(addr); has {
+	if has {
 		for _, eh := range pm.eventHandler {
-			eh.OnDisconnected(p)
+			eh.OnDisconnected(pm.ctx, p)
 		}
 	}
 	pm.eventHandlerLock.RUnlock()
+	if has && pm.isClosing() {
+		pm.emitClosed(p)
+		return
+	}
+	if has {
+		pm.recordDisconnect(addr)
+		pm.failover(p)
+	}
+}
+
+// spares returns the connected peers which are not included in the peer group
+func (pm *manager) spares() []Peer {
+	list := []Peer{}
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if !p.IsClose() && !pm.peerStorage.Have(p.ID()) {
+			list = append(list, p)
+		}
+		return true
+	})
+	return list
+}
+
+// promoteSpare moves the spare peer which has the lowest ping time into the peer group
+func (pm *manager) promoteSpare() {
+	var best Peer
+	for _, p := range pm.spares() {
+		if best == nil || best.PingTime() > p.PingTime() {
+			best = p
+		}
+	}
+	if best != nil {
+		pm.addReadyConn(best)
+	}
+}
+
+// manageSpare dials the stored nodes until the number of the spare peers reaches the SpareCount
+func (pm *manager) manageSpare() {
+	for {
+		if !pm.sleep(time.Second * 5) {
+			return
+		}
+
+		need := pm.Config.SpareCount - len(pm.spares())
+		if need <= 0 {
+			continue
+		}
+		for _, ci := range pm.nodes.Snapshot() {
+			if need <= 0 {
+				break
+			}
+			if pm.isLocalhost(ci.Address) {
+				continue
+			}
+			if _, has := pm.connections.Load(ci.Address); has {
+				continue
+			}
+			if pm.isHeldDown(ci.Address) {
+				continue
+			}
+			if err := pm.router.Request(ci.Address); err == nil {
+				need--
+			}
+			pm.dialPause()
+		}
+	}
 }
 
 func (pm *manager) addPeer(p Peer) error {
+	dropped, err := pm.storePeer(p)
+	// the dropped peer is closed without the lock because the handlers of the disconnection take it
+	if dropped != nil {
+		dropped.Close()
+	}
+	return err
+}
+
+func (pm *manager) storePeer(p Peer) (Peer, error) {
 	pm.peerGroupLock.Lock()
 	defer pm.peerGroupLock.Unlock()
 
 	if oldP, has := pm.connections.Load(p.NetAddr()); has {
 		oldP.Close() //deletePeer, conn.Close()
 	}
+	if !pm.dedupPeer(p) {
+		return nil, ErrIsAlreadyConnected
+	}
+	dropped, ok := pm.admitPeer(p)
+	if !ok {
+		return nil, ErrTooManyPeers
+	}
 
 	pm.kickOutPeerStorage()
 	{
 		addr := p.NetAddr()
 		pm.connections.Store(addr, p)
-		pm.nodes.Store(addr, peermessage.NewConnectInfo(addr, p.PingTime()))
+		pm.nodes.StoreSuccess(addr, pm.nodes.newConnectInfo(addr, p.PingTime()))
 		pm.candidates.store(addr, csPeerListWait)
 
-		go func(p Peer) {
-			peermessage.SendRequestPeerList(p, p.LocalAddr().String())
-		}(p)
+		// the peer list is requested again by the candidate loop when it is shed
+		pm.spawn(addr, "peerlist", func() {
+			pm.requestPeerList(p)
+		})
 	}
-	return nil
+	return dropped, nil
 }
 
 func (pm *manager) addReadyConn(p Peer) {
-	pm.peerStorage.Add(p, func(addr string) (time.Duration, bool) {
+	pm.peerStorage.Add(p, func(id string) (time.Duration, bool) {
+		addr := pm.addrOfID(id)
 		if node, has := pm.nodes.Load(addr); has {
 			return node.PingScoreBoard.Load(addr)
 		}
@@ -583,7 +1288,9 @@ func (pm *manager) Remove(netAddr string) {
 	}
 }
 func (pm *manager) RemoveByID(ID string) {
-	pm.Remove(ID)
+	for _, p := range pm.peersOfID(ID) {
+		p.Close()
+	}
 }
 
 type BanPeerInfo struct {
@@ -596,96 +1303,80 @@ func (p BanPeerInfo) String() string {
 	return fmt.Sprintf("%s Ban over %d", p.NetAddr, p.OverTime)
 }
 
-// ByTime implements sort.Interface for []BanPeerInfo on the Timeout field.
+// ByTime is the ban list whose entries are released after their timeouts
 type ByTime struct {
-	Arr []*BanPeerInfo
-	Map map[string]*BanPeerInfo
+	cache *ttlcache.Cache
+	clock clock.Clock
 }
 
 func NewByTime() *ByTime {
+	return NewByTimeWithClock(clock.Real)
+}
+
+//NewByTimeWithClock returns a ByTime whose bans are released by the clock
+func NewByTimeWithClock(clk clock.Clock) *ByTime {
+	clk = clock.Or(clk)
 	return &ByTime{
-		Arr: []*BanPeerInfo{},
-		Map: map[string]*BanPeerInfo{},
+		cache: ttlcache.NewWithClock(0, clk),
+		clock: clk,
 	}
 }
 
-func (a *ByTime) Len() int           { return len(a.Arr) }
-func (a *ByTime) Swap(i, j int)      { a.Arr[i], a.Arr[j] = a.Arr[j], a.Arr[i] }
-func (a *ByTime) Less(i, j int) bool { return a.Arr[i].Timeout < a.Arr[j].Timeout }
-
 func (a *ByTime) Add(netAddr string, Seconds int64) {
-	b, has := a.Map[netAddr]
-	if !has {
-		b = &BanPeerInfo{
-			NetAddr:  netAddr,
-			Timeout:  time.Now().UnixNano() + (int64(time.Second) * Seconds),
-			OverTime: Seconds,
-		}
-		a.Arr = append(a.Arr, b)
-		a.Map[netAddr] = b
-	} else {
-		b.Timeout = Seconds
+	if Seconds <= 0 {
+		a.Delete(netAddr)
+		return
 	}
-	sort.Sort(a)
+	a.cache.Set(netAddr, &BanPeerInfo{
+		NetAddr:  netAddr,
+		Timeout:  a.clock.Now().UnixNano() + (int64(time.Second) * Seconds),
+		OverTime: Seconds,
+	}, time.Duration(Seconds)*time.Second)
 }
 
 func (a *ByTime) Delete(netAddr string) {
-	i := a.Search(netAddr)
-	if i < 0 {
-		return
-	}
-
-	b := a.Arr[i]
-	a.Arr = append(a.Arr[:i], a.Arr[i+1:]...)
-	delete(a.Map, b.NetAddr)
+	a.cache.Delete(netAddr)
 }
 
-func (a *ByTime) Search(netAddr string) int {
-	b, has := a.Map[netAddr]
+//Get returns the ban information of the address which is not released
+func (a *ByTime) Get(netAddr string) (*BanPeerInfo, bool) {
+	v, has := a.cache.Get(netAddr)
 	if !has {
-		return -1
+		return nil, false
 	}
+	return v.(*BanPeerInfo), true
+}
 
-	i, j := 0, len(a.Arr)
-	for i < j {
-		h := int(uint(i+j) >> 1) // avoid overflow when computing h
-		// i ≤ h < j
-		if !(a.Arr[h].Timeout >= b.Timeout) {
-			i = h + 1 // preserves f(i-1) == false
-		} else {
-			j = h // preserves f(j) == true
-		}
-	}
-	// i == j, f(i-1) == false, and f(j) (= f(i)) == true  =>  answer is i.
-	return i
+func (a *ByTime) Len() int {
+	return a.cache.Len()
 }
 
 func (a *ByTime) IsBan(netAddr string) bool {
-	now := time.Now().UnixNano()
-	var slicePivot = 0
-	for i, b := range a.Arr {
-		if now < b.Timeout {
-			slicePivot = i
-			break
-		}
-		delete(a.Map, a.Arr[i].NetAddr)
-	}
+	return a.cache.Has(netAddr)
+}
 
-	a.Arr = a.Arr[slicePivot:]
-	_, has := a.Map[netAddr]
-	return has
+//Expire removes the released entries
+func (a *ByTime) Expire() {
+	a.cache.Expire()
 }
 
 func (pm *manager) Ban(netAddr string, Seconds uint32) {
 	pm.BanPeerInfos.Add(netAddr, int64(Seconds))
 	p, has := pm.connections.Load(netAddr)
 	if has {
+		if id := p.ID(); id != netAddr {
+			pm.BanPeerInfos.Add(id, int64(Seconds))
+		}
 		p.Close()
 	}
 }
 
+//BanByID bans the node id so that the node is rejected even if it is connected from the other addresses
 func (pm *manager) BanByID(ID string, Seconds uint32) {
-	pm.Ban(ID, Seconds)
+	pm.BanPeerInfos.Add(ID, int64(Seconds))
+	for _, p := range pm.peersOfID(ID) {
+		p.Close()
+	}
 }
 
 func (pm *manager) Unban(netAddr string) {
@@ -701,8 +1392,20 @@ func (pm *manager) Peers() []mesh.Peer {
 	return list
 }
 
+//GroupPeers returns the connected peers which are in the group of the peer storage
+func (pm *manager) GroupPeers() []mesh.Peer {
+	list := make([]mesh.Peer, 0)
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if !p.IsClose() && pm.peerStorage.Have(p.ID()) {
+			list = append(list, p)
+		}
+		return true
+	})
+	return list
+}
+
 //OnConnected is empty BaseEventHandler functions
-func (pm *manager) OnConnected(p mesh.Peer) {}
+func (pm *manager) OnConnected(ctx context.Context, p mesh.Peer) {}
 
 //OnDisconnected is empty BaseEventHandler functions
-func (pm *manager) OnDisconnected(p mesh.Peer) {}
+func (pm *manager) OnDisconnected(ctx context.Context, p mesh.Peer) {}
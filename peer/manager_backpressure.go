@@ -0,0 +1,41 @@
+package peer
+
+import (
+	"github.com/fletaio/framework/message"
+)
+
+//BroadCastReport is the result of the BroadCastSkipSaturated
+type BroadCastReport struct {
+	Queued []string
+	Missed []string
+}
+
+//BroadCastSkipSaturated propagates the message to all nodes without waiting the sends.
+//The peers which already have MaxPending or more sends in the outbound queue or reach the goroutine caps are skipped and reported as missed,
+//so a congested peer doesn't delay the broadcast to the others.
+func (pm *manager) BroadCastSkipSaturated(m message.Message, MaxPending int) *BroadCastReport {
+	report := &BroadCastReport{
+		Queued: []string{},
+		Missed: []string{},
+	}
+	frame, ok := pm.gossipFrame(m)
+	if !ok {
+		return report
+	}
+	pm.replays.retain(m)
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if p.Pending() >= MaxPending {
+			report.Missed = append(report.Missed, addr)
+			return true
+		}
+		if !pm.spawn(addr, "broadcast", func() {
+			pm.sendGossip(p, m, frame)
+		}) {
+			report.Missed = append(report.Missed, addr)
+			return true
+		}
+		report.Queued = append(report.Queued, addr)
+		return true
+	})
+	return report
+}
@@ -0,0 +1,53 @@
+package peer
+
+import "time"
+
+const (
+	defaultBootstrapBackoff    = time.Second
+	defaultBootstrapMaxBackoff = time.Minute
+)
+
+// bootstrap dials the BootstrapNodes as soon as the manager is started, all of them are dialed again with the backoff
+// until one of them is connected and the other nodes are found by the peer lists of it
+func (pm *manager) bootstrap() {
+	backoff := pm.Config.BootstrapBackoff
+	if backoff <= 0 {
+		backoff = defaultBootstrapBackoff
+	}
+	max := pm.Config.BootstrapMaxBackoff
+	if max <= 0 {
+		max = defaultBootstrapMaxBackoff
+	}
+	if max < backoff {
+		max = backoff
+	}
+	for {
+		connected, dialed := false, false
+		for _, addr := range pm.Config.BootstrapNodes {
+			if pm.isLocalhost(addr) {
+				continue
+			}
+			dialed = true
+			if _, has := pm.connections.Load(addr); has {
+				connected = true
+				continue
+			}
+			if err := pm.router.RequestContext(pm.ctx, addr, pm.ChainCoord); err == nil {
+				connected = true
+			}
+			if pm.ctx.Err() != nil {
+				return
+			}
+			pm.dialPause()
+		}
+		if connected || !dialed {
+			return
+		}
+		if !pm.sleep(backoff) {
+			return
+		}
+		if backoff *= 2; backoff > max {
+			backoff = max
+		}
+	}
+}
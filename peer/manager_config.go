@@ -0,0 +1,130 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/fletaio/framework/peer/storage"
+)
+
+// defaults of the tunables
+const (
+	defaultRotateInterval      = 20 * time.Minute
+	defaultRotateRetryInterval = 5 * time.Second
+	defaultDialInterval        = 50 * time.Millisecond
+)
+
+//ConfigError is ErrInvalidConfig of the field of the Config
+type ConfigError struct {
+	Field string
+}
+
+func (e *ConfigError) Error() string {
+	return ErrInvalidConfig.Error() + ": " + e.Field
+}
+
+//Validate returns the ConfigError of the first invalid setting, the zeros are the defaults
+func (c *Config) Validate() error {
+	durations := []struct {
+		field string
+		d     time.Duration
+	}{
+		{"CandidateProbeMin", c.CandidateProbeMin},
+		{"CandidateProbeMax", c.CandidateProbeMax},
+		{"StageTimeout", c.StageTimeout},
+		{"CacheCleanupInterval", c.CacheCleanupInterval},
+		{"CandidateTTL", c.CandidateTTL},
+		{"RotateInterval", c.RotateInterval},
+		{"RotateRetryInterval", c.RotateRetryInterval},
+		{"SpoolMaxAge", c.SpoolMaxAge},
+		{"FlapWindow", c.FlapWindow},
+		{"ProbeInterval", c.ProbeInterval},
+		{"ResumeGrace", c.ResumeGrace},
+		{"OutboundInterval", c.OutboundInterval},
+		{"BootstrapBackoff", c.BootstrapBackoff},
+		{"BootstrapMaxBackoff", c.BootstrapMaxBackoff},
+		{"GossipTTL", c.GossipTTL},
+	}
+	for _, v := range durations {
+		if v.d < 0 {
+			return &ConfigError{Field: v.field}
+		}
+	}
+	counts := []struct {
+		field string
+		n     int
+	}{
+		{"SpareCount", c.SpareCount},
+		{"TargetCastRatio", c.TargetCastRatio},
+		{"PunishFailures", c.PunishFailures},
+		{"CandidateMaxTimeouts", c.CandidateMaxTimeouts},
+		{"MaxGoroutines", c.MaxGoroutines},
+		{"MaxPeerGoroutines", c.MaxPeerGoroutines},
+		{"GossipHops", c.GossipHops},
+		{"GossipFanout", c.GossipFanout},
+		{"KickOutThreshold", c.KickOutThreshold},
+		{"MaxPeers", c.MaxPeers},
+		{"TargetOutbound", c.TargetOutbound},
+	}
+	for _, v := range counts {
+		if v.n < 0 {
+			return &ConfigError{Field: v.field}
+		}
+	}
+	if c.CandidateProbeMin > 0 && c.CandidateProbeMax > 0 && c.CandidateProbeMax < c.CandidateProbeMin {
+		return &ConfigError{Field: "CandidateProbeMax"}
+	}
+	if c.GossipHops > maxGossipHops {
+		return &ConfigError{Field: "GossipHops"}
+	}
+	if c.GossipFanout > maxGossipFanout {
+		return &ConfigError{Field: "GossipFanout"}
+	}
+	if c.PeerListFilterRate >= 1 {
+		return &ConfigError{Field: "PeerListFilterRate"}
+	}
+	if c.OutboundRatio < 0 || c.OutboundRatio > 1 {
+		return &ConfigError{Field: "OutboundRatio"}
+	}
+	if c.PartitionThreshold < 0 || c.PartitionThreshold > 1 {
+		return &ConfigError{Field: "PartitionThreshold"}
+	}
+	if c.KickOutThreshold > 0 && c.KickOutThreshold < storage.MaxPeerStorageLen() {
+		return &ConfigError{Field: "KickOutThreshold"}
+	}
+	return nil
+}
+
+// rotateInterval returns the RotateRetryInterval while the peer group is not filled
+func (pm *manager) rotateInterval() time.Duration {
+	if pm.peerStorage.NotEnoughPeer() {
+		if pm.Config.RotateRetryInterval > 0 {
+			return pm.Config.RotateRetryInterval
+		}
+		return defaultRotateRetryInterval
+	}
+	if pm.Config.RotateInterval > 0 {
+		return pm.Config.RotateInterval
+	}
+	return defaultRotateInterval
+}
+
+func (pm *manager) dialInterval() time.Duration {
+	if pm.Config.DialInterval == 0 {
+		return defaultDialInterval
+	}
+	return pm.Config.DialInterval
+}
+
+// dialPause waits the DialInterval between the dials
+func (pm *manager) dialPause() {
+	if d := pm.dialInterval(); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (pm *manager) kickOutThreshold() int {
+	if pm.Config.KickOutThreshold > 0 {
+		return pm.Config.KickOutThreshold
+	}
+	return storage.MaxPeerStorageLen() * 2
+}
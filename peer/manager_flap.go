@@ -0,0 +1,177 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/log"
+)
+
+const (
+	defaultFlapThreshold   = 3
+	defaultFlapHoldDown    = 30 * time.Second
+	defaultFlapMaxHoldDown = 30 * time.Minute
+)
+
+//FlapInfo is the flapping state of the address
+type FlapInfo struct {
+	Disconnects int
+	Episodes    int
+	HoldUntil   time.Time
+}
+
+type flapState struct {
+	disconnects []time.Time
+	episodes    int
+	holdUntil   time.Time
+	lastEpisode time.Time
+}
+
+//flapDamper holds down the reconnects of the addresses which connect and disconnect repeatedly in the window.
+//The hold-down is doubled for each flap episode and the episodes are forgotten after the max hold-down without a flap.
+type flapDamper struct {
+	sync.Mutex
+	window      time.Duration
+	threshold   int
+	holdDown    time.Duration
+	maxHoldDown time.Duration
+	states      map[string]*flapState
+	clock       clock.Clock
+}
+
+func newFlapDamper(window time.Duration, threshold int, holdDown time.Duration, maxHoldDown time.Duration, clk clock.Clock) *flapDamper {
+	if window <= 0 {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = defaultFlapThreshold
+	}
+	if holdDown <= 0 {
+		holdDown = defaultFlapHoldDown
+	}
+	if maxHoldDown <= 0 {
+		maxHoldDown = defaultFlapMaxHoldDown
+	}
+	if maxHoldDown < holdDown {
+		maxHoldDown = holdDown
+	}
+	return &flapDamper{
+		window:      window,
+		threshold:   threshold,
+		holdDown:    holdDown,
+		maxHoldDown: maxHoldDown,
+		states:      map[string]*flapState{},
+		clock:       clock.Or(clk),
+	}
+}
+
+//disconnected records the disconnect and starts the hold-down when the address flaps.
+//It returns the hold-down of the new episode.
+func (fd *flapDamper) disconnected(addr string) time.Duration {
+	if fd == nil {
+		return 0
+	}
+	fd.Lock()
+	defer fd.Unlock()
+
+	now := fd.clock.Now()
+	fs, has := fd.states[addr]
+	if !has {
+		fs = &flapState{}
+		fd.states[addr] = fs
+	}
+	fd.forget(fs, now)
+	fs.disconnects = append(fs.disconnects, now)
+	if len(fs.disconnects) < fd.threshold {
+		return 0
+	}
+	fs.disconnects = nil
+	fs.episodes++
+	fs.lastEpisode = now
+	hold := fd.holdDown
+	for i := 1; i < fs.episodes && hold < fd.maxHoldDown; i++ {
+		hold *= 2
+	}
+	if hold > fd.maxHoldDown {
+		hold = fd.maxHoldDown
+	}
+	fs.holdUntil = now.Add(hold)
+	return hold
+}
+
+//forget drops the disconnects out of the window and the episodes which are older than the max hold-down after the hold-down
+func (fd *flapDamper) forget(fs *flapState, now time.Time) {
+	i := 0
+	for i < len(fs.disconnects) && now.Sub(fs.disconnects[i]) > fd.window {
+		i++
+	}
+	fs.disconnects = fs.disconnects[i:]
+	if fs.episodes > 0 && now.After(fs.holdUntil.Add(fd.maxHoldDown)) {
+		fs.episodes = 0
+	}
+}
+
+//held returns true while the address is held down
+func (fd *flapDamper) held(addr string) bool {
+	if fd == nil {
+		return false
+	}
+	fd.Lock()
+	defer fd.Unlock()
+
+	fs, has := fd.states[addr]
+	return has && fd.clock.Now().Before(fs.holdUntil)
+}
+
+//expire removes the states which have nothing to remember
+func (fd *flapDamper) expire() {
+	if fd == nil {
+		return
+	}
+	fd.Lock()
+	defer fd.Unlock()
+
+	now := fd.clock.Now()
+	for addr, fs := range fd.states {
+		fd.forget(fs, now)
+		if len(fs.disconnects) == 0 && fs.episodes == 0 && now.After(fs.holdUntil) {
+			delete(fd.states, addr)
+		}
+	}
+}
+
+func (fd *flapDamper) infos() map[string]FlapInfo {
+	infos := map[string]FlapInfo{}
+	if fd == nil {
+		return infos
+	}
+	fd.Lock()
+	defer fd.Unlock()
+
+	for addr, fs := range fd.states {
+		infos[addr] = FlapInfo{
+			Disconnects: len(fs.disconnects),
+			Episodes:    fs.episodes,
+			HoldUntil:   fs.holdUntil,
+		}
+	}
+	return infos
+}
+
+//FlapInfos returns the flapping states of the addresses which disconnected recently
+func (pm *manager) FlapInfos() map[string]FlapInfo {
+	return pm.flaps.infos()
+}
+
+//isHeldDown returns true while the flapping address is not reconnected
+func (pm *manager) isHeldDown(addr string) bool {
+	return pm.flaps.held(addr) || pm.sendBackoff.held(addr)
+}
+
+//recordDisconnect feeds the disconnect of the peer to the flap damper
+func (pm *manager) recordDisconnect(addr string) {
+	if hold := pm.flaps.disconnected(addr); hold > 0 {
+		log.Info("flapping peer ", addr, " held down for ", hold)
+	}
+}
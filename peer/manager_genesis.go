@@ -0,0 +1,22 @@
+package peer
+
+import (
+	"bytes"
+
+	"github.com/fletaio/common/hash"
+)
+
+//SetGenesisCheck rejects the nodes of the other genesis (e.g. a forked network with the same chain coordinate) in the handshake
+//so that they never enter the peer pool. The extra is sent after the genesis hash and the check of it is evaluated when it is not nil.
+func (pm *manager) SetGenesisCheck(genesis hash.Hash256, extra []byte, check func(extra []byte) error) {
+	data := append(append([]byte{}, genesis[:]...), extra...)
+	pm.router.SetHandshakeCheck(data, func(remote []byte) error {
+		if len(remote) < len(genesis) || !bytes.Equal(remote[:len(genesis)], genesis[:]) {
+			return ErrMismatchGenesis
+		}
+		if check != nil {
+			return check(remote[len(genesis):])
+		}
+		return nil
+	})
+}
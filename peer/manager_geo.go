@@ -0,0 +1,105 @@
+package peer
+
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
+//GeoInfo is the location and the network of an IP address
+type GeoInfo struct {
+	Country string
+	ASN     uint32
+	Org     string
+}
+
+//GeoProvider resolves the GeoInfo of an IP address (e.g. a GeoIP database).
+//The peer of the failed lookup is counted as unknown.
+type GeoProvider interface {
+	Lookup(ip net.IP) (GeoInfo, error)
+}
+
+//GeoStats is the number of the connected peers by the country and the ASN
+type GeoStats struct {
+	Total     int
+	Unknown   int
+	Countries map[string]int
+	ASNs      map[string]int
+}
+
+//maxGeoCache is the max number of the cached lookups, the cache is cleared when it is full
+const maxGeoCache = 4096
+
+//geoResolver caches the lookups of the provider by the IP
+type geoResolver struct {
+	sync.Mutex
+	provider GeoProvider
+	cache    map[string]*GeoInfo
+}
+
+//SetGeoProvider sets the provider of the GeoStats, nil disables the enrichment
+func (pm *manager) SetGeoProvider(p GeoProvider) {
+	pm.geo.Lock()
+	defer pm.geo.Unlock()
+
+	pm.geo.provider = p
+	pm.geo.cache = map[string]*GeoInfo{}
+}
+
+//PeerGeo returns the GeoInfo of the address for the region-aware selection of the peers
+func (pm *manager) PeerGeo(addr string) (GeoInfo, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return GeoInfo{}, false
+	}
+
+	pm.geo.Lock()
+	defer pm.geo.Unlock()
+
+	if pm.geo.provider == nil {
+		return GeoInfo{}, false
+	}
+	key := ip.String()
+	gi, has := pm.geo.cache[key]
+	if !has {
+		if len(pm.geo.cache) >= maxGeoCache {
+			pm.geo.cache = map[string]*GeoInfo{}
+		}
+		if info, err := pm.geo.provider.Lookup(ip); err == nil {
+			gi = &info
+		}
+		pm.geo.cache[key] = gi
+	}
+	if gi == nil {
+		return GeoInfo{}, false
+	}
+	return *gi, true
+}
+
+//GeoStats returns the breakdown of the connected peers by the country and the ASN
+func (pm *manager) GeoStats() GeoStats {
+	gs := GeoStats{
+		Countries: map[string]int{},
+		ASNs:      map[string]int{},
+	}
+	pm.connections.Range(func(addr string, p Peer) bool {
+		gs.Total++
+		gi, has := pm.PeerGeo(p.NetAddr())
+		if !has {
+			gs.Unknown++
+			return true
+		}
+		if gi.Country != "" {
+			gs.Countries[gi.Country]++
+		}
+		if gi.ASN != 0 {
+			gs.ASNs["AS"+strconv.FormatUint(uint64(gi.ASN), 10)]++
+		}
+		return true
+	})
+	return gs
+}
@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"sync"
+
+	"github.com/fletaio/framework/log"
+)
+
+//GoroutineStats is the number of the goroutines which are spawned by the manager for the peers
+type GoroutineStats struct {
+	Total   int
+	Max     int
+	PerPeer map[string]int
+	Shed    uint64
+}
+
+//goroutineCounter counts the goroutines of the peers and sheds the new ones over the caps
+type goroutineCounter struct {
+	sync.Mutex
+	total      int
+	max        int
+	maxPerPeer int
+	perPeer    map[string]int
+	shed       uint64
+}
+
+func newGoroutineCounter(max int, maxPerPeer int) *goroutineCounter {
+	return &goroutineCounter{
+		max:        max,
+		maxPerPeer: maxPerPeer,
+		perPeer:    map[string]int{},
+	}
+}
+
+func (gc *goroutineCounter) acquire(addr string) bool {
+	gc.Lock()
+	defer gc.Unlock()
+
+	if (gc.max > 0 && gc.total >= gc.max) || (gc.maxPerPeer > 0 && gc.perPeer[addr] >= gc.maxPerPeer) {
+		gc.shed++
+		return false
+	}
+	gc.total++
+	gc.perPeer[addr]++
+	return true
+}
+
+func (gc *goroutineCounter) release(addr string) {
+	gc.Lock()
+	defer gc.Unlock()
+
+	gc.total--
+	if gc.perPeer[addr] <= 1 {
+		delete(gc.perPeer, addr)
+	} else {
+		gc.perPeer[addr]--
+	}
+}
+
+func (gc *goroutineCounter) stats() GoroutineStats {
+	gc.Lock()
+	defer gc.Unlock()
+
+	s := GoroutineStats{
+		Total:   gc.total,
+		Max:     gc.max,
+		PerPeer: make(map[string]int, len(gc.perPeer)),
+		Shed:    gc.shed,
+	}
+	for addr, n := range gc.perPeer {
+		s.PerPeer[addr] = n
+	}
+	return s
+}
+
+//spawn runs f in a new goroutine which is counted for the peer of the address.
+//It returns false without running f when the global or the per-peer cap is reached, so the callers shed the load.
+func (pm *manager) spawn(addr string, name string, f func()) bool {
+	if !pm.routines.acquire(addr) {
+		log.Debug("goroutine cap reached, shed ", name, " ", log.Addr(addr))
+		return false
+	}
+	go func() {
+		defer pm.routines.release(addr)
+		f()
+	}()
+	return true
+}
+
+//Goroutines returns the number of the goroutines of the peers
+func (pm *manager) Goroutines() GoroutineStats {
+	return pm.routines.stats()
+}
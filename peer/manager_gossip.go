@@ -0,0 +1,291 @@
+package peer
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fletaio/common/hash"
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/router"
+	"github.com/fletaio/framework/ttlcache"
+)
+
+//gossipEnvelopeType frames the broadcast with its message ID, which is the hash of the encoded message, and the length of its body,
+//so the body of the duplicate is discarded. It is sent only to the peers which negotiated router.FeatureGossip.
+var gossipEnvelopeType = message.DefineType("peer.GossipEnvelope")
+
+//gossipCastEnvelopeType frames the message of the GossipCast with its message ID, the remaining hops and the fanout of the origin.
+//It is sent only to the peers which negotiated router.FeatureGossipCast.
+var gossipCastEnvelopeType = message.DefineType("peer.GossipCastEnvelope")
+
+const (
+	defaultGossipTTL  = 10 * time.Minute
+	defaultGossipHops = 6
+	maxGossipHops     = 255
+	maxGossipBytes    = router.MaxDecompressedSize
+)
+
+//the fanout of the received GossipCast is clamped to the local config, so the remote cannot make it flood
+const (
+	defaultGossipFanout = 8
+	maxGossipFanout     = 0xFFFF
+)
+
+//states of the seen message IDs
+const (
+	gossipReceived = 1 << iota
+	gossipForwarded
+)
+
+//gossipCache keeps the message IDs of the broadcasts which are received or forwarded in the ttl,
+//so a message which loops back through the mesh is neither handled nor forwarded again
+type gossipCache struct {
+	sync.Mutex
+	ttl   time.Duration
+	seen  *ttlcache.Cache
+	clock clock.Clock
+}
+
+func newGossipCache(ttl time.Duration, clk clock.Clock) *gossipCache {
+	if ttl <= 0 {
+		ttl = defaultGossipTTL
+	}
+	return &gossipCache{
+		ttl:   ttl,
+		seen:  ttlcache.NewWithClock(0, clk),
+		clock: clock.Or(clk),
+	}
+}
+
+//mark sets the state of the message ID and returns false when any of the checked states is already set
+func (gc *gossipCache) mark(id hash.Hash256, state int, check int) bool {
+	gc.Lock()
+	defer gc.Unlock()
+
+	prev := 0
+	if v, has := gc.seen.Get(id); has {
+		prev = v.(int)
+	}
+	if prev&check != 0 {
+		return false
+	}
+	gc.seen.Set(id, prev|state, gc.ttl)
+	return true
+}
+
+//receive returns false when the message is already received or it is forwarded by the local node
+func (gc *gossipCache) receive(id hash.Hash256) bool {
+	return gc.mark(id, gossipReceived, gossipReceived|gossipForwarded)
+}
+
+//forward returns false when the message is already forwarded, the received message is forwarded once
+func (gc *gossipCache) forward(id hash.Hash256) bool {
+	return gc.mark(id, gossipForwarded, gossipForwarded)
+}
+
+func (gc *gossipCache) expire() {
+	gc.seen.Expire()
+}
+
+//gossipFrame returns the envelope of the broadcast and false when the message is already forwarded.
+//The envelope is nil when the GossipTTL is zero
+func (pm *manager) gossipFrame(m message.Message) ([]byte, bool) {
+	if pm.Config.GossipTTL <= 0 {
+		return nil, true
+	}
+	if _, has := channelTypes[m.Type()]; has {
+		return nil, true
+	}
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return nil, true
+	}
+	id := hash.Hash(bs)
+	if !pm.gossip.forward(id) {
+		return nil, false
+	}
+	return encodeGossip(id, bs), true
+}
+
+//sendGossip sends the envelope to the peer which supports it and the bare message to the others
+func (pm *manager) sendGossip(p Peer, m message.Message, frame []byte) error {
+	if frame != nil {
+		if pp, ok := p.(*peer); ok && pp.Features()&router.FeatureGossip != 0 {
+			return pp.sendRaw(frame, false)
+		}
+	}
+	return p.SendBroadcast(m)
+}
+
+func encodeGossip(id hash.Hash256, bs []byte) []byte {
+	bf := bytes.Buffer{}
+	util.WriteUint64(&bf, uint64(gossipEnvelopeType))
+	bf.Write(id[:])
+	util.WriteUint32(&bf, uint32(len(bs)-8))
+	bf.Write(bs)
+	return bf.Bytes()
+}
+
+//openGossip reads the envelope of the broadcast and returns its message ID, the type and the body of the message
+func openGossip(r io.Reader) (hash.Hash256, message.Type, []byte, error) {
+	var id hash.Hash256
+	if _, err := id.ReadFrom(r); err != nil {
+		return id, 0, nil, err
+	}
+	t, body, err := readGossipBody(r)
+	if err != nil {
+		return id, 0, nil, err
+	}
+	return id, t, body, nil
+}
+
+//readGossipBody reads the length of the body, the type of the message and the body of the envelope
+func readGossipBody(r io.Reader) (message.Type, []byte, error) {
+	size, _, err := util.ReadUint32(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > maxGossipBytes {
+		return 0, nil, ErrTooLargeGossip
+	}
+	t, _, err := util.ReadUint64(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if message.NameOfType(message.Type(t)) == "" {
+		return 0, nil, message.ErrUnknownMessage
+	}
+	body := make([]byte, size)
+	if _, err := util.FillBytes(r, body); err != nil {
+		return 0, nil, err
+	}
+	return message.Type(t), body, nil
+}
+
+//gossipCast is the message of the GossipCast which is received by the envelope
+type gossipCast struct {
+	id     hash.Hash256
+	hops   uint8
+	fanout uint16
+	t      message.Type
+	body   []byte
+}
+
+//GossipCast propagates the message to the Fanout peers chosen by the rand source of the manager
+//and each of the peers forwards it to the Fanout peers of its own until the GossipHops hops, so the message reaches the mesh
+//with high probability by far less sends than the flooding. The message is forwarded once by each node in the GossipTTL.
+//The peers which don't support it receive the message as the broadcast and don't forward it. The non-positive Fanout sends it to all of the peers
+//and the peers forward it to their GossipFanout peers.
+func (pm *manager) GossipCast(m message.Message, Fanout int) {
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return
+	}
+	id := hash.Hash(bs)
+	if !pm.gossip.forward(id) {
+		return
+	}
+	pm.replays.retain(m)
+	if Fanout <= 0 || Fanout > maxGossipFanout {
+		Fanout = 0
+	}
+	for _, p := range pm.gossipTargets("", Fanout) {
+		pm.sendGossipCast(p, id, pm.gossipHops(), uint16(Fanout), bs)
+	}
+}
+
+//forwardGossipCast sends the received message to the fanout peers except the one which sent it with one less hop.
+//The hops and the fanout of the sender are clamped to the local config. It is charged to the RelayQuota of the sender
+func (pm *manager) forwardGossipCast(from string, gc *gossipCast) {
+	if hops := pm.gossipHops(); gc.hops > hops {
+		gc.hops = hops
+	}
+	if fanout := pm.gossipFanout(); gc.fanout == 0 || int(gc.fanout) > fanout {
+		gc.fanout = uint16(fanout)
+	}
+	if gc.hops <= 1 || !pm.gossip.forward(gc.id) {
+		return
+	}
+	targets := pm.gossipTargets(from, int(gc.fanout))
+	if !pm.chargeRelay(from, gc.t, len(gc.body)+8, len(targets)) {
+		return
+	}
+	bs := make([]byte, 0, len(gc.body)+8)
+	bs = append(bs, util.Uint64ToBytes(uint64(gc.t))...)
+	bs = append(bs, gc.body...)
+	for _, p := range targets {
+		pm.sendGossipCast(p, gc.id, gc.hops-1, gc.fanout, bs)
+	}
+}
+
+//gossipTargets returns the fanout peers in random order except the address, all of the peers when the fanout is zero
+func (pm *manager) gossipTargets(except string, fanout int) []*peer {
+	targets := []*peer{}
+	for _, p := range pm.shuffledConnections() {
+		if fanout > 0 && len(targets) >= fanout {
+			break
+		}
+		if pp, ok := p.(*peer); ok && pp.NetAddr() != except {
+			targets = append(targets, pp)
+		}
+	}
+	return targets
+}
+
+//sendGossipCast sends the envelope to the peer which supports it and the bare message to the others
+func (pm *manager) sendGossipCast(p *peer, id hash.Hash256, hops uint8, fanout uint16, bs []byte) error {
+	if p.Features()&router.FeatureGossipCast == 0 {
+		return p.sendRaw(bs, false)
+	}
+	bf := bytes.Buffer{}
+	util.WriteUint64(&bf, uint64(gossipCastEnvelopeType))
+	bf.Write(id[:])
+	util.WriteUint8(&bf, hops)
+	util.WriteUint16(&bf, fanout)
+	util.WriteUint32(&bf, uint32(len(bs)-8))
+	bf.Write(bs)
+	return p.sendRaw(bf.Bytes(), false)
+}
+
+func (pm *manager) gossipFanout() int {
+	if pm.Config.GossipFanout <= 0 {
+		return defaultGossipFanout
+	}
+	return pm.Config.GossipFanout
+}
+
+func (pm *manager) gossipHops() uint8 {
+	if pm.Config.GossipHops <= 0 {
+		return defaultGossipHops
+	}
+	return uint8(pm.Config.GossipHops)
+}
+
+//openGossipCast reads the envelope of the GossipCast and the body of its message which is forwarded as it is
+func openGossipCast(r io.Reader) (*gossipCast, error) {
+	gc := &gossipCast{}
+	if _, err := gc.id.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	hops, _, err := util.ReadUint8(r)
+	if err != nil {
+		return nil, err
+	}
+	fanout, _, err := util.ReadUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	t, body, err := readGossipBody(r)
+	if err != nil {
+		return nil, err
+	}
+	gc.hops = hops
+	gc.fanout = fanout
+	gc.t = t
+	gc.body = body
+	return gc, nil
+}
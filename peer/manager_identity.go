@@ -0,0 +1,142 @@
+package peer
+
+import (
+	"sort"
+	"sync"
+)
+
+//identityMap keeps the known addresses of each node id in order of preference
+type identityMap struct {
+	l     sync.Mutex
+	addrs map[string][]string
+	// deduped is the addresses of the peers closed by the deduplication
+	deduped map[string]bool
+}
+
+func newIdentityMap() *identityMap {
+	return &identityMap{
+		addrs:   map[string][]string{},
+		deduped: map[string]bool{},
+	}
+}
+
+func (im *identityMap) add(id string, addr string) {
+	im.l.Lock()
+	defer im.l.Unlock()
+
+	for _, a := range im.addrs[id] {
+		if a == addr {
+			return
+		}
+	}
+	im.addrs[id] = append(im.addrs[id], addr)
+}
+
+//prefer moves the address to the front of the addresses of the id
+func (im *identityMap) prefer(id string, addr string) {
+	im.l.Lock()
+	defer im.l.Unlock()
+
+	list := im.addrs[id]
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i] == addr && list[j] != addr
+	})
+}
+
+func (im *identityMap) alternates(id string, addr string) []string {
+	im.l.Lock()
+	defer im.l.Unlock()
+
+	list := []string{}
+	for _, a := range im.addrs[id] {
+		if a != addr {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+func (im *identityMap) markDeduped(addr string) {
+	im.l.Lock()
+	defer im.l.Unlock()
+	im.deduped[addr] = true
+}
+
+func (im *identityMap) takeDeduped(addr string) bool {
+	im.l.Lock()
+	defer im.l.Unlock()
+	has := im.deduped[addr]
+	delete(im.deduped, addr)
+	return has
+}
+
+// dedupPeer finds the connected peer of the same node id and closes the old one when the new one has the shorter ping time.
+// It returns false when the new peer should not be added.
+func (pm *manager) dedupPeer(p Peer) bool {
+	id := p.NodeID()
+	if id == "" {
+		return true
+	}
+	addr := p.NetAddr()
+	pm.identities.add(id, addr)
+
+	var old Peer
+	pm.connections.Range(func(a string, cp Peer) bool {
+		if a != addr && cp.NodeID() == id && !cp.IsClose() {
+			old = cp
+			return false
+		}
+		return true
+	})
+	if old == nil {
+		pm.identities.prefer(id, addr)
+		return true
+	}
+
+	if old.PingTime() <= p.PingTime() {
+		return false
+	}
+	pm.identities.prefer(id, addr)
+	pm.identities.markDeduped(old.NetAddr())
+	old.Close()
+	return true
+}
+
+// failover dials the other addresses of the node of the disconnected peer in order of preference
+func (pm *manager) failover(p Peer) {
+	addr := p.NetAddr()
+	if pm.identities.takeDeduped(addr) {
+		return
+	}
+	id := p.NodeID()
+	if id == "" {
+		return
+	}
+	pm.spawn(addr, "failover", func() {
+		for _, alt := range pm.identities.alternates(id, addr) {
+			if err := pm.router.Request(alt); err == nil {
+				return
+			}
+		}
+	})
+}
+
+//peersOfID returns the connected peers which have the id
+func (pm *manager) peersOfID(id string) []Peer {
+	list := []Peer{}
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if p.ID() == id {
+			list = append(list, p)
+		}
+		return true
+	})
+	return list
+}
+
+//addrOfID returns the address of the connected peer which has the id, the id itself is returned when it is not connected
+func (pm *manager) addrOfID(id string) string {
+	if list := pm.peersOfID(id); len(list) > 0 {
+		return list[0].NetAddr()
+	}
+	return id
+}
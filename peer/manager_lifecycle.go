@@ -0,0 +1,144 @@
+package peer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/lifecycle"
+)
+
+// newLifecycle orders the stages of the manager.
+// The accept loop is started before the listeners so that no accepted connection waits for it,
+// and the manage loops are started last because they dial through the router.
+func (pm *manager) newLifecycle() *lifecycle.Lifecycle {
+	timeout := pm.Config.StageTimeout
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+	lc := lifecycle.New()
+	lc.Add("peer.accept", &lifecycle.Funcs{
+		StartFunc: pm.startAccept,
+		StopFunc:  pm.stopAccept,
+	}, timeout)
+	lc.Add("router", &lifecycle.Funcs{
+		StartFunc: func(ctx context.Context) error {
+			return pm.router.Listen()
+		},
+		StopFunc: pm.router.Shutdown,
+	}, timeout)
+	lc.Add("peer.loops", &lifecycle.Funcs{
+		StartFunc: pm.startLoops,
+		StopFunc:  pm.stopLoops,
+	}, timeout)
+	return lc
+}
+
+// Lifecycle returns the lifecycle which starts and stops the manager and its router
+func (pm *manager) Lifecycle() *lifecycle.Lifecycle {
+	return pm.lifecycle
+}
+
+func (pm *manager) startAccept(ctx context.Context) error {
+	pm.acceptWg.Add(1)
+	go func() {
+		defer pm.acceptWg.Done()
+		pm.acceptLoop()
+	}()
+	return nil
+}
+
+// stopAccept waits the accept loop which returns when the router is closed
+func (pm *manager) stopAccept(ctx context.Context) error {
+	return waitGroup(ctx, &pm.acceptWg)
+}
+
+func (pm *manager) startLoops(ctx context.Context) error {
+	loops := []func(){pm.manageCandidate, pm.rotatePeer, pm.expireCaches}
+	if len(pm.Config.BootstrapNodes) > 0 {
+		loops = append(loops, pm.bootstrap)
+	}
+	if pm.Config.SpareCount > 0 {
+		loops = append(loops, pm.manageSpare)
+	}
+	if pm.Config.TargetOutbound > 0 {
+		loops = append(loops, pm.manageOutbound)
+	}
+	if pm.Config.PartitionThreshold > 0 {
+		loops = append(loops, pm.detectPartition)
+	}
+	if pm.Config.ProbeInterval > 0 {
+		loops = append(loops, pm.probeLoop)
+	}
+	if pm.Config.RegroupInterval >= 0 {
+		loops = append(loops, pm.regroupPeers)
+	}
+	if pm.scores != nil {
+		loops = append(loops, pm.recordScores)
+	}
+	for _, f := range loops {
+		pm.loopWg.Add(1)
+		go func(f func()) {
+			defer pm.loopWg.Done()
+			f()
+		}(f)
+	}
+	return nil
+}
+
+func (pm *manager) stopLoops(ctx context.Context) error {
+	pm.loopDoneOnce.Do(func() {
+		close(pm.loopDone)
+	})
+	return waitGroup(ctx, &pm.loopWg)
+}
+
+const defaultCacheCleanupInterval = 10 * time.Second
+
+// expireCaches removes the expired candidates, bans, probe routes, score board entries and flap states in every CacheCleanupInterval
+func (pm *manager) expireCaches() {
+	d := pm.Config.CacheCleanupInterval
+	if d <= 0 {
+		d = defaultCacheCleanupInterval
+	}
+	for pm.sleep(d) {
+		pm.candidates.expire()
+		pm.BanPeerInfos.Expire()
+		pm.probes.routes.Expire()
+		pm.nodes.PruneScoreBoards()
+		pm.traces.Expire()
+		pm.flaps.expire()
+		pm.sendBackoff.expire()
+		pm.relays.expire()
+		pm.resumes.expire()
+		pm.gossip.expire()
+	}
+}
+
+// sleep waits the duration and returns false when the manage loops are stopped
+func (pm *manager) sleep(d time.Duration) bool {
+	timer := pm.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-pm.loopDone:
+		return false
+	case <-pm.ctx.Done():
+		return false
+	}
+}
+
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,125 @@
+package peer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockWaitBounds are the upper bounds of the buckets of the lock wait histogram
+// The waits longer than the last bound are counted in the last bucket
+var LockWaitBounds = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// LockStats is the contention statistics of a lock
+type LockStats struct {
+	Name         string
+	Acquisitions uint64
+	TotalWait    time.Duration
+	MaxWait      time.Duration
+	Histogram    []uint64
+}
+
+type lockStat struct {
+	name         string
+	acquisitions uint64
+	totalWait    int64
+	maxWait      int64
+	buckets      []uint64
+}
+
+func newLockStat(name string) *lockStat {
+	return &lockStat{
+		name:    name,
+		buckets: make([]uint64, len(LockWaitBounds)+1),
+	}
+}
+
+func (ls *lockStat) observe(wait time.Duration) {
+	atomic.AddUint64(&ls.acquisitions, 1)
+	atomic.AddInt64(&ls.totalWait, int64(wait))
+	for {
+		max := atomic.LoadInt64(&ls.maxWait)
+		if int64(wait) <= max || atomic.CompareAndSwapInt64(&ls.maxWait, max, int64(wait)) {
+			break
+		}
+	}
+	i := 0
+	for i < len(LockWaitBounds) && wait > LockWaitBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&ls.buckets[i], 1)
+}
+
+func (ls *lockStat) stats() LockStats {
+	s := LockStats{
+		Name:         ls.name,
+		Acquisitions: atomic.LoadUint64(&ls.acquisitions),
+		TotalWait:    time.Duration(atomic.LoadInt64(&ls.totalWait)),
+		MaxWait:      time.Duration(atomic.LoadInt64(&ls.maxWait)),
+		Histogram:    make([]uint64, len(ls.buckets)),
+	}
+	for i := range ls.buckets {
+		s.Histogram[i] = atomic.LoadUint64(&ls.buckets[i])
+	}
+	return s
+}
+
+// statMutex is a sync.Mutex which records the wait time of Lock when the stat is set
+type statMutex struct {
+	sync.Mutex
+	stat *lockStat
+}
+
+func (m *statMutex) Lock() {
+	if m.stat == nil {
+		m.Mutex.Lock()
+		return
+	}
+	start := time.Now()
+	m.Mutex.Lock()
+	m.stat.observe(time.Now().Sub(start))
+}
+
+// statRWMutex is a sync.RWMutex which records the wait time of Lock and RLock when the stat is set
+type statRWMutex struct {
+	sync.RWMutex
+	stat *lockStat
+}
+
+func (m *statRWMutex) Lock() {
+	if m.stat == nil {
+		m.RWMutex.Lock()
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.stat.observe(time.Now().Sub(start))
+}
+
+func (m *statRWMutex) RLock() {
+	if m.stat == nil {
+		m.RWMutex.RLock()
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.stat.observe(time.Now().Sub(start))
+}
+
+// LockStats returns the contention statistics of the locks of the manager, it is empty when the LockProfiling is disabled
+func (pm *manager) LockStats() []LockStats {
+	list := []LockStats{}
+	for _, ls := range []*lockStat{pm.peerGroupLock.stat, pm.eventHandlerLock.stat, pm.nodes.l.stat} {
+		if ls != nil {
+			list = append(list, ls.stats())
+		}
+	}
+	return list
+}
@@ -2,92 +2,156 @@ package peer
 
 import (
 	"bytes"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/dgraph-io/badger"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
 	"github.com/fletaio/framework/peer/peermessage"
+	"github.com/fletaio/framework/ttlcache"
 )
 
 //NodeStore is the structure of the connection information.
 type nodeStore struct {
-	l  sync.Mutex
-	db *badger.DB
-	a  []peermessage.ConnectInfo
-	m  map[string]*peermessage.ConnectInfo
+	l        statMutex
+	db       kvstore.KVStore
+	a        []*peermessage.ConnectInfo
+	m        map[string]*peermessage.ConnectInfo
+	snapshot []peermessage.ConnectInfo
+	times    map[string]*nodeTimes
+
+	maxNodes  int
+	scoreSize int
+	scoreAge  time.Duration
+	keep      map[string]bool
+	clock     clock.Clock
 }
 
-//NewNodeStore is creator of NodeStore
-func newNodeStore(dbpath string) (*nodeStore, error) {
-	db, err := openNodesDB(dbpath)
-	if err != nil {
-		return nil, err
+const defaultMaxStoredNodes = 4096
+
+//NewNodeStore is creator of NodeStore, it keeps maxNodes nodes (4096 when it is zero, negative is unlimited)
+//and the score boards of the nodes are bounded by scoreSize and scoreAge. The nodes of the keep are never evicted
+//and the first seen and the last success times of the nodes are taken from the clock
+func newNodeStore(db kvstore.KVStore, clk clock.Clock, maxNodes int, scoreSize int, scoreAge time.Duration, keep []string) (*nodeStore, error) {
+	if maxNodes == 0 {
+		maxNodes = defaultMaxStoredNodes
 	}
 	n := &nodeStore{
-		db: db,
+		db:        db,
+		times:     map[string]*nodeTimes{},
+		maxNodes:  maxNodes,
+		scoreSize: scoreSize,
+		scoreAge:  scoreAge,
+		keep:      map[string]bool{},
+		clock:     clock.Or(clk),
+	}
+	for _, addr := range keep {
+		n.keep[addr] = true
 	}
+	broken := [][]byte{}
 
-	if err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			value, err := it.Item().ValueCopy(nil)
-			if err != nil {
-				return err
-			}
-			bf := bytes.NewBuffer(value)
-
-			var ci peermessage.ConnectInfo
-			ci.ReadFrom(bf)
-			ci.PingScoreBoard = &peermessage.ScoreBoardMap{}
-			n.LoadOrStore(ci.Address, ci)
+	if err := db.Iterate(func(key []byte, value []byte) bool {
+		bf := bytes.NewBuffer(value)
+
+		var ci peermessage.ConnectInfo
+		if _, err := ci.ReadFrom(bf); err != nil || ci.Address == "" {
+			broken = append(broken, key)
+			return true
 		}
-		return nil
+		ci.PingScoreBoard = n.newScoreBoard()
+		// the times are appended to the connect info and the old records don't have them
+		nt := &nodeTimes{}
+		nt.ReadFrom(bf)
+		n.times[ci.Address] = nt
+		n.load(ci)
+		return true
 	}); err != nil {
 		return nil, err
 	}
+	n.compact(broken)
 	return n, nil
 }
-func openNodesDB(dbPath string) (*badger.DB, error) {
-	opts := badger.DefaultOptions
-	opts.Dir = dbPath
-	opts.ValueDir = dbPath
-	opts.Truncate = true
-	opts.SyncWrites = true
-	opts.ValueLogFileSize = 1 << 24
-	lockfilePath := filepath.Join(opts.Dir, "LOCK")
-	os.MkdirAll(dbPath, os.ModeDir)
 
-	os.Remove(lockfilePath)
+// load adds the connect info read from the disk without saving it again
+func (n *nodeStore) load(ci peermessage.ConnectInfo) {
+	n.l.Lock()
+	defer n.l.Unlock()
 
-	db, err := badger.Open(opts)
-	if err != nil {
-		return nil, err
+	if n.m == nil {
+		n.m = map[string]*peermessage.ConnectInfo{}
+	}
+	if _, has := n.m[ci.Address]; has {
+		return
 	}
+	n.a = append(n.a, &ci)
+	n.m[ci.Address] = &ci
+}
 
-	{
-	again:
-		if err := db.RunValueLogGC(0.7); err != nil {
-		} else {
-			goto again
+// compact removes the broken records and the oldest nodes over the maxNodes from the disk after loading
+func (n *nodeStore) compact(broken [][]byte) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	if len(broken) > 0 {
+		for _, key := range broken {
+			n.db.Delete(key)
+		}
+	}
+	for n.maxNodes > 0 && len(n.m) > n.maxNodes {
+		if !n.unsafeEvict("") {
+			break
 		}
 	}
+}
 
-	ticker := time.NewTicker(5 * time.Minute)
-	go func() {
-		for range ticker.C {
-		again:
-			if err := db.RunValueLogGC(0.7); err != nil {
-			} else {
-				goto again
-			}
+// unsafeEvict removes the node which has not been connected for the longest time except the given key and the kept nodes,
+// it returns false when no node is removable
+func (n *nodeStore) unsafeEvict(except string) bool {
+	idx := -1
+	var oldest nodeTimes
+	for i, ci := range n.a {
+		if ci.Address == except || n.keep[ci.Address] {
+			continue
+		}
+		var nt nodeTimes
+		if v, has := n.times[ci.Address]; has {
+			nt = *v
 		}
-	}()
+		if idx < 0 || nt.LastSuccess < oldest.LastSuccess || (nt.LastSuccess == oldest.LastSuccess && nt.FirstSeen < oldest.FirstSeen) {
+			idx = i
+			oldest = nt
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+	key := n.a[idx].Address
+	n.a = append(n.a[:idx], n.a[idx+1:]...)
+	delete(n.m, key)
+	delete(n.times, key)
+	n.snapshot = nil
+	n.db.Delete([]byte(key))
+	return true
+}
+
+func (n *nodeStore) newScoreBoard() *peermessage.ScoreBoardMap {
+	return peermessage.NewScoreBoardMap(n.scoreSize, n.scoreAge)
+}
+
+// newConnectInfo returns a ConnectInfo which has the score board bounded by the store
+func (n *nodeStore) newConnectInfo(addr string, t time.Duration) peermessage.ConnectInfo {
+	ci := peermessage.NewConnectInfo(addr, t)
+	ci.PingScoreBoard = n.newScoreBoard()
+	return ci
+}
 
-	return db, nil
+// PruneScoreBoards removes the stale entries of the score boards
+func (n *nodeStore) PruneScoreBoards() {
+	for _, ci := range n.Snapshot() {
+		if ci.PingScoreBoard != nil {
+			ci.PingScoreBoard.Prune()
+		}
+	}
 }
 
 // LoadOrStore returns the existing value for the key if present.
@@ -117,10 +181,11 @@ func (n *nodeStore) Store(key string, value peermessage.ConnectInfo) {
 }
 
 func (n *nodeStore) unsafeStore(key string, value peermessage.ConnectInfo) {
+	n.snapshot = nil
 	if 0 == len(n.m) {
-		n.a = []peermessage.ConnectInfo{value}
+		n.a = []*peermessage.ConnectInfo{&value}
 		n.m = map[string]*peermessage.ConnectInfo{
-			key: &n.a[0],
+			key: &value,
 		}
 	} else {
 		v, has := n.m[key]
@@ -129,26 +194,66 @@ func (n *nodeStore) unsafeStore(key string, value peermessage.ConnectInfo) {
 			v.PingTime = value.PingTime
 			v.PingScoreBoard = value.PingScoreBoard
 		} else {
-			n.a = append(n.a, value)
+			n.a = append(n.a, &value)
 			n.m[key] = &value
 		}
 	}
-	n.db.Update(func(txn *badger.Txn) error {
-		bf := bytes.Buffer{}
-		value.WriteTo(&bf)
-		if err := txn.Set([]byte(key), bf.Bytes()); err != nil {
-			return err
+	n.unsafeSave(key, value, n.unsafeTimes(key))
+	for n.maxNodes > 0 && len(n.m) > n.maxNodes {
+		if !n.unsafeEvict(key) {
+			break
 		}
-		return nil
-	})
+	}
+}
+
+// unsafeTimes returns the times of the node and the first seen time is recorded when it is unknown
+func (n *nodeStore) unsafeTimes(key string) *nodeTimes {
+	nt, has := n.times[key]
+	if !has {
+		nt = &nodeTimes{}
+		n.times[key] = nt
+	}
+	if nt.FirstSeen == 0 {
+		nt.FirstSeen = n.clock.Now().UnixNano()
+	}
+	return nt
+}
+
+// StoreSuccess sets the value for a key and records the successful connection of the node
+func (n *nodeStore) StoreSuccess(key string, value peermessage.ConnectInfo) {
+	n.l.Lock()
+	defer n.l.Unlock()
+	n.unsafeTimes(key).LastSuccess = n.clock.Now().UnixNano()
+	n.unsafeStore(key, value)
+}
+
+// Times returns the first seen and the last successful connection times of the node
+func (n *nodeStore) Times(key string) (firstSeen int64, lastSuccess int64) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	if nt, has := n.times[key]; has {
+		return nt.FirstSeen, nt.LastSuccess
+	}
+	return 0, 0
+}
+
+func (n *nodeStore) unsafeSave(key string, value peermessage.ConnectInfo, nt *nodeTimes) {
+	bf := bytes.Buffer{}
+	value.WriteTo(&bf)
+	nt.WriteTo(&bf)
+	n.db.Put([]byte(key), bf.Bytes())
 }
 
 // Get returns the value stored in the array for a index
 func (n *nodeStore) Get(i int) peermessage.ConnectInfo {
-	if i < 0 || i > len(n.a) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	if i < 0 || i >= len(n.a) {
 		return peermessage.ConnectInfo{}
 	}
-	return n.a[i]
+	return *n.a[i]
 }
 
 // Load returns the value stored in the map for a key, or nil if no
@@ -173,14 +278,28 @@ func (n *nodeStore) Load(key string) (p peermessage.ConnectInfo, has bool) {
 // 	delete(n.m, key)
 // }
 
-// Range calls f sequentially for each key and value present in the map.
-// If f returns false, range stops the iteration.
-func (n *nodeStore) Range(f func(string, peermessage.ConnectInfo) bool) {
+// Snapshot returns a point-in-time copy of the stored connection informations.
+// The copy is shared by the readers until the next store, so it must not be modified.
+func (n *nodeStore) Snapshot() []peermessage.ConnectInfo {
 	n.l.Lock()
 	defer n.l.Unlock()
 
-	for key, value := range n.m {
-		if !f(key, *value) {
+	if n.snapshot == nil {
+		snapshot := make([]peermessage.ConnectInfo, 0, len(n.a))
+		for _, v := range n.a {
+			snapshot = append(snapshot, *v)
+		}
+		n.snapshot = snapshot
+	}
+	return n.snapshot
+}
+
+// Range calls f sequentially for each key and value of the snapshot of the map.
+// If f returns false, range stops the iteration.
+// f can store values while iterating because the snapshot is not affected by them.
+func (n *nodeStore) Range(f func(string, peermessage.ConnectInfo) bool) {
+	for _, value := range n.Snapshot() {
+		if !f(value.Address, value) {
 			break
 		}
 	}
@@ -227,21 +346,39 @@ func (n *connectMap) Range(f func(string, Peer) bool) {
 	})
 }
 
+// Len returns the number of the connected peers
+func (n *connectMap) Len() int {
+	var l int
+	n.m.Range(func(k, p interface{}) bool {
+		l++
+		return true
+	})
+	return l
+}
+
 //CandidateMap is the structure of candidate list
 type candidateMap struct {
-	m sync.Map
+	c   *ttlcache.Cache
+	ttl time.Duration
 }
 
-// Store sets the value for a key.
+func newCandidateMap(ttl time.Duration, clk clock.Clock) candidateMap {
+	return candidateMap{
+		c:   ttlcache.NewWithClock(0, clk),
+		ttl: ttl,
+	}
+}
+
+// Store sets the value for a key, the key is removed after the ttl of the map.
 func (n *candidateMap) store(key string, value candidateState) {
-	n.m.Store(key, value)
+	n.c.Set(key, value, n.ttl)
 }
 
 // Load returns the value stored in the map for a key, or nil if no
 // value is present.
 // The ok result indicates whether value was found in the map.
 func (n *candidateMap) load(key string) (candidateState, bool) {
-	i, has := n.m.Load(key)
+	i, has := n.c.Get(key)
 	if has {
 		return i.(candidateState), has
 	}
@@ -251,13 +388,29 @@ func (n *candidateMap) load(key string) (candidateState, bool) {
 
 // Delete deletes the value for a key.
 func (n *candidateMap) delete(key string) {
-	n.m.Delete(key)
+	n.c.Delete(key)
 }
 
 // Range calls f sequentially for each key and value present in the map.
 // If f returns false, range stops the iteration.
 func (n *candidateMap) rangeMap(f func(string, candidateState) bool) {
-	n.m.Range(func(k, c interface{}) bool {
+	n.c.Range(func(k, c interface{}) bool {
 		return f(k.(string), c.(candidateState))
 	})
 }
+
+// Len returns the number of the candidates
+func (n *candidateMap) len() int {
+	return n.c.Len()
+}
+
+func (n *candidateMap) expire() {
+	n.c.Expire()
+}
+
+//Close flushes the stored nodes by closing the database
+func (n *nodeStore) Close() error {
+	n.l.Lock()
+	defer n.l.Unlock()
+	return n.db.Close()
+}
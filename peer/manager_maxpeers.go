@@ -0,0 +1,71 @@
+package peer
+
+import "math"
+
+// admitPeer makes room for the new peer when the connections reach the MaxPeers or the inbound peer reaches the inbound slots.
+// The spare peer of the highest ping time which is not pinned is closed when the new peer is better than it or pinned,
+// so the group peers and the pinned peers are never dropped and the new peer is rejected otherwise.
+//The dropped peer is returned to be closed after the peerGroupLock is unlocked
+func (pm *manager) admitPeer(p Peer) (Peer, bool) {
+	if pm.Config.MaxPeers <= 0 {
+		return nil, true
+	}
+	if !p.Outbound() && pm.countInbound() >= pm.inboundSlots() {
+		return pm.makeRoom(p, true)
+	}
+	if pm.countPeers() >= pm.Config.MaxPeers {
+		return pm.makeRoom(p, false)
+	}
+	return nil, true
+}
+
+// inboundSlots returns the slots of the MaxPeers which are not reserved for the outbound peers
+func (pm *manager) inboundSlots() int {
+	return pm.Config.MaxPeers - int(math.Ceil(float64(pm.Config.MaxPeers)*pm.Config.OutboundRatio))
+}
+
+//countPeers and countInbound don't count the peers which are being closed
+func (pm *manager) countPeers() int {
+	var n int
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if !p.IsClose() {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+func (pm *manager) countInbound() int {
+	var n int
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if !p.IsClose() && !p.Outbound() {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// makeRoom returns the worst spare peer to be dropped for the new peer, only the inbound spares are dropped for the inbound slots
+func (pm *manager) makeRoom(p Peer, inbound bool) (Peer, bool) {
+	var worst Peer
+	for _, sp := range pm.spares() {
+		if pm.router.IsPinned(sp.ID()) || (inbound && sp.Outbound()) {
+			continue
+		}
+		if worst == nil || worst.PingTime() < sp.PingTime() {
+			worst = sp
+		}
+	}
+	if worst == nil {
+		pm.trace(p.NetAddr(), "rejected over max peers")
+		return nil, false
+	}
+	if !pm.router.IsPinned(p.ID()) && p.PingTime() >= worst.PingTime() {
+		pm.trace(p.NetAddr(), "rejected over max peers")
+		return nil, false
+	}
+	pm.trace(worst.NetAddr(), "dropped over max peers")
+	return worst, true
+}
@@ -0,0 +1,109 @@
+package peer
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/fletaio/common/util"
+)
+
+//nodeTimes is the first seen and the last successful connection times of a node in unix nano
+type nodeTimes struct {
+	FirstSeen   int64
+	LastSuccess int64
+}
+
+// WriteTo is a serialization function
+func (nt *nodeTimes) WriteTo(w io.Writer) (int64, error) {
+	var wrote int64
+	for _, v := range []int64{nt.FirstSeen, nt.LastSuccess} {
+		n, err := util.WriteUint64(w, uint64(v))
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+	}
+	return wrote, nil
+}
+
+// ReadFrom is a deserialization function
+func (nt *nodeTimes) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	for _, p := range []*int64{&nt.FirstSeen, &nt.LastSuccess} {
+		v, n, err := util.ReadUint64(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		*p = int64(v)
+	}
+	return read, nil
+}
+
+//NodeInfo is the structured information of a collected node
+type NodeInfo struct {
+	Address     string
+	PingTime    time.Duration
+	Scores      int
+	FirstSeen   time.Time
+	LastSuccess time.Time
+	LastFailure string
+}
+
+//NodeInfos returns the informations of the collected nodes, the zero times are unknown
+func (pm *manager) NodeInfos() []NodeInfo {
+	list := []NodeInfo{}
+	for _, ci := range pm.nodes.Snapshot() {
+		info := NodeInfo{
+			Address:  ci.Address,
+			PingTime: ci.PingTime,
+			Scores:   ci.PingScoreBoard.Len(),
+		}
+		firstSeen, lastSuccess := pm.nodes.Times(ci.Address)
+		if firstSeen != 0 {
+			info.FirstSeen = time.Unix(0, firstSeen)
+		}
+		if lastSuccess != 0 {
+			info.LastSuccess = time.Unix(0, lastSuccess)
+		}
+		if kind, has := pm.lastFailure(ci.Address); has {
+			info.LastFailure = kind.String()
+		}
+		list = append(list, info)
+	}
+	return list
+}
+
+type candidate struct {
+	addr        string
+	state       candidateState
+	lastSuccess int64
+}
+
+//prioritizedCandidates returns the candidates in order of the recency of the last successful connection,
+//so the nodes which were reachable recently are probed first
+func (pm *manager) prioritizedCandidates() []candidate {
+	states := map[string]candidateState{}
+	addrs := []string{}
+	pm.candidates.rangeMap(func(addr string, cs candidateState) bool {
+		states[addr] = cs
+		addrs = append(addrs, addr)
+		return true
+	})
+	// the candidates of the same recency are probed in the order decided by the rand source
+	pm.rand.shuffle(addrs)
+	list := make([]candidate, 0, len(addrs))
+	for _, addr := range addrs {
+		_, lastSuccess := pm.nodes.Times(addr)
+		list = append(list, candidate{
+			addr:        addr,
+			state:       states[addr],
+			lastSuccess: lastSuccess,
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].lastSuccess > list[j].lastSuccess
+	})
+	return list
+}
@@ -0,0 +1,203 @@
+package peer
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
+)
+
+const defaultStorePath = "./_data/peer/"
+
+//Option sets the settings of the manager made by NewManager.
+//*Config is the Option which sets all of the settings, so the settings which are omitted are the defaults of it
+type Option interface {
+	apply(c *Config)
+}
+
+type optionFunc func(c *Config)
+
+func (f optionFunc) apply(c *Config) {
+	f(c)
+}
+
+func (c *Config) apply(dst *Config) {
+	if c != nil {
+		*dst = *c
+	}
+}
+
+//Logger receives the error logs of the manager
+type Logger interface {
+	Error(v ...interface{})
+}
+
+//newConfig applies the options to the defaults.
+//The leading *Config is used as it is, so the caller keeps sharing the settings with the manager as before
+func newConfig(opts []Option) *Config {
+	c := &Config{}
+	for i, opt := range opts {
+		if cfg, ok := opt.(*Config); ok && cfg != nil && i == 0 {
+			c = cfg
+			continue
+		}
+		opt.apply(c)
+	}
+	if c.StorePath == "" && c.NodeStore == nil {
+		c.StorePath = defaultStorePath
+	}
+	return c
+}
+
+//WithStorePath sets the path of the node store (./_data/peer/ by default) and the kvstore backend of it (badger when it is empty)
+func WithStorePath(path string, backend string) Option {
+	return optionFunc(func(c *Config) {
+		c.StorePath = path
+		c.StoreBackend = backend
+	})
+}
+
+//WithNodeStore sets the database of the embedder which is used as the node store instead of opening the store path
+func WithNodeStore(store kvstore.KVStore) Option {
+	return optionFunc(func(c *Config) {
+		c.NodeStore = store
+	})
+}
+
+//WithCandidateProbe sets the bounds of the candidate probe interval (5s and 30s by default)
+func WithCandidateProbe(min time.Duration, max time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.CandidateProbeMin = min
+		c.CandidateProbeMax = max
+	})
+}
+
+//WithCacheCleanupInterval sets the period of removing the expired candidates and bans (10s by default)
+func WithCacheCleanupInterval(d time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.CacheCleanupInterval = d
+	})
+}
+
+//WithRegroupInterval sets the period of regrouping the peers (1m by default, negative disables it)
+func WithRegroupInterval(d time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.RegroupInterval = d
+	})
+}
+
+//WithStageTimeout sets the timeout of each start and stop stage (10s by default)
+func WithStageTimeout(d time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.StageTimeout = d
+	})
+}
+
+//WithSpareCount sets the number of the spare connections kept beyond the peer group (none by default)
+func WithSpareCount(n int) Option {
+	return optionFunc(func(c *Config) {
+		c.SpareCount = n
+	})
+}
+
+//WithMaxStoredNodes sets the number of the known nodes kept in the store (4096 by default, negative is unlimited)
+func WithMaxStoredNodes(n int) Option {
+	return optionFunc(func(c *Config) {
+		c.MaxStoredNodes = n
+	})
+}
+
+//WithMaxGoroutines sets the caps of the goroutines spawned for the peers in total and per peer (unlimited by default)
+func WithMaxGoroutines(total int, perPeer int) Option {
+	return optionFunc(func(c *Config) {
+		c.MaxGoroutines = total
+		c.MaxPeerGoroutines = perPeer
+	})
+}
+
+//WithLogger sets the logger of the errors of the manager (the log package by default)
+func WithLogger(l Logger) Option {
+	return optionFunc(func(c *Config) {
+		c.Logger = l
+	})
+}
+
+//WithClock sets the time source of the manager (the real clock by default)
+func WithClock(clk clock.Clock) Option {
+	return optionFunc(func(c *Config) {
+		c.Clock = clk
+	})
+}
+
+//WithRandSource sets the source of the random choices of the manager (the time seeded source by default)
+func WithRandSource(src rand.Source) Option {
+	return optionFunc(func(c *Config) {
+		c.RandSource = src
+	})
+}
+
+//WithRotateInterval sets the periods of adding a stored node to the peer group while it is filled and while it is not (20m and 5s by default)
+func WithRotateInterval(d time.Duration, retry time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.RotateInterval = d
+		c.RotateRetryInterval = retry
+	})
+}
+
+//WithDialInterval sets the pause between the dials (50ms by default, negative doesn't pause)
+func WithDialInterval(d time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.DialInterval = d
+	})
+}
+
+//WithMaxPeers sets the upper bound of the connected peers (unlimited by default)
+func WithMaxPeers(n int) Option {
+	return optionFunc(func(c *Config) {
+		c.MaxPeers = n
+	})
+}
+
+//WithOutboundRatio sets the fraction of the MaxPeers reserved for the outbound peers (none by default)
+func WithOutboundRatio(ratio float64) Option {
+	return optionFunc(func(c *Config) {
+		c.OutboundRatio = ratio
+	})
+}
+
+//WithTargetOutbound sets the number of the outbound peers kept by dialing the stored nodes and the period of it (disabled and 5s by default)
+func WithTargetOutbound(n int, interval time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.TargetOutbound = n
+		c.OutboundInterval = interval
+	})
+}
+
+//WithGossipTTL sets the lifetime of the seen message IDs of the broadcasts (disabled by default)
+func WithGossipTTL(ttl time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.GossipTTL = ttl
+	})
+}
+
+//WithGossipHops sets the number of the hops which a message of the GossipCast is forwarded in (6 by default)
+func WithGossipHops(hops int) Option {
+	return optionFunc(func(c *Config) {
+		c.GossipHops = hops
+	})
+}
+
+//WithGossipFanout sets the max number of the peers which a received message of the GossipCast is forwarded to (8 by default)
+func WithGossipFanout(fanout int) Option {
+	return optionFunc(func(c *Config) {
+		c.GossipFanout = fanout
+	})
+}
+
+//WithBootstrapNodes sets the nodes which are dialed first and retried with the backoff until one of them is connected
+func WithBootstrapNodes(addrs ...string) Option {
+	return optionFunc(func(c *Config) {
+		c.BootstrapNodes = addrs
+	})
+}
@@ -0,0 +1,70 @@
+package peer
+
+import (
+	"sort"
+	"time"
+)
+
+const defaultOutboundInterval = 5 * time.Second
+
+// manageOutbound dials the stored nodes until the outbound peers reach the TargetOutbound in every OutboundInterval,
+// instead of waiting the candidate cycle and the rotation which adds a peer in each pass
+func (pm *manager) manageOutbound() {
+	d := pm.Config.OutboundInterval
+	if d <= 0 {
+		d = defaultOutboundInterval
+	}
+	for pm.sleep(d) {
+		need := pm.Config.TargetOutbound - pm.countOutbound()
+		if need <= 0 {
+			continue
+		}
+		for _, addr := range pm.outboundCandidates() {
+			if need <= 0 {
+				break
+			}
+			if err := pm.router.RequestContext(pm.ctx, addr, pm.ChainCoord); err == nil {
+				need--
+			}
+			if pm.ctx.Err() != nil {
+				return
+			}
+			pm.dialPause()
+		}
+	}
+}
+
+func (pm *manager) countOutbound() int {
+	var n int
+	pm.connections.Range(func(addr string, p Peer) bool {
+		if p.Outbound() {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// outboundCandidates returns the stored nodes which are not connected in order of the recency of the last successful connection,
+// the nodes of the same recency are in the order decided by the rand source
+func (pm *manager) outboundCandidates() []string {
+	addrs := []string{}
+	for _, ci := range pm.nodes.Snapshot() {
+		if pm.isLocalhost(ci.Address) || pm.isHeldDown(ci.Address) {
+			continue
+		}
+		if _, has := pm.connections.Load(ci.Address); has {
+			continue
+		}
+		addrs = append(addrs, ci.Address)
+	}
+	pm.rand.shuffle(addrs)
+	lastSuccess := make(map[string]int64, len(addrs))
+	for _, addr := range addrs {
+		_, lastSuccess[addr] = pm.nodes.Times(addr)
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return lastSuccess[addrs[i]] > lastSuccess[addrs[j]]
+	})
+	return addrs
+}
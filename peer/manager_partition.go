@@ -0,0 +1,110 @@
+package peer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/log"
+	"github.com/fletaio/framework/peer/storage"
+)
+
+const defaultPartitionGossipTimeout = 5 * time.Minute
+
+//IsPartitioned returns the local node is suspected to be partitioned or not
+func (pm *manager) IsPartitioned() bool {
+	return atomic.LoadInt32(&pm.partitioned) == 1
+}
+
+// unreachableRatio returns the fraction of the group slots which are not filled by the connected peers
+// among the slots which could be filled by the known nodes
+func (pm *manager) unreachableRatio() float64 {
+	expected := 0
+	for _, ci := range pm.nodes.Snapshot() {
+		if !pm.isLocalhost(ci.Address) {
+			expected++
+		}
+	}
+	if expected > storage.MaxPeerStorageLen() {
+		expected = storage.MaxPeerStorageLen()
+	}
+	if expected == 0 {
+		return 0
+	}
+	connected := pm.peerStorage.Len()
+	if connected >= expected {
+		return 0
+	}
+	return 1 - float64(connected)/float64(expected)
+}
+
+func (pm *manager) isGossipStale() bool {
+	timeout := pm.Config.PartitionGossipTimeout
+	if timeout <= 0 {
+		timeout = defaultPartitionGossipTimeout
+	}
+	last := atomic.LoadInt64(&pm.lastGossip)
+	if last == 0 {
+		atomic.CompareAndSwapInt64(&pm.lastGossip, 0, pm.clock.Now().UnixNano())
+		return false
+	}
+	return pm.clock.Now().Sub(time.Unix(0, last)) > timeout
+}
+
+// detectPartition checks the reachability of the group peers and the staleness of the gossip.
+// It emits the partition events to the PartitionHandlers and re-bootstraps aggressively while the partition is suspected.
+func (pm *manager) detectPartition() {
+	for {
+		if !pm.sleep(time.Second * 5) {
+			return
+		}
+
+		suspected := pm.unreachableRatio() >= pm.Config.PartitionThreshold && pm.isGossipStale()
+		if suspected {
+			if atomic.CompareAndSwapInt32(&pm.partitioned, 0, 1) {
+				log.Info("partition suspected ", pm.router.Localhost())
+				pm.emitPartition(true)
+			}
+			pm.rebootstrap()
+		} else if atomic.CompareAndSwapInt32(&pm.partitioned, 1, 0) {
+			log.Info("partition healed ", pm.router.Localhost())
+			pm.emitPartition(false)
+		}
+	}
+}
+
+func (pm *manager) emitPartition(suspected bool) {
+	pm.eventHandlerLock.RLock()
+	defer pm.eventHandlerLock.RUnlock()
+	for _, eh := range pm.eventHandler {
+		if ph, ok := eh.(mesh.PartitionHandler); ok {
+			if suspected {
+				ph.OnPartitionSuspected(pm.ctx)
+			} else {
+				ph.OnPartitionHealed(pm.ctx)
+			}
+		}
+	}
+}
+
+// rebootstrap dials the static peers and all of the known nodes which are not connected
+func (pm *manager) rebootstrap() {
+	addrs := append([]string{}, pm.Config.StaticPeers...)
+	for _, ci := range pm.nodes.Snapshot() {
+		addrs = append(addrs, ci.Address)
+	}
+	for _, addr := range addrs {
+		if pm.isLocalhost(addr) {
+			continue
+		}
+		if _, has := pm.connections.Load(addr); has {
+			continue
+		}
+		pm.router.Request(addr)
+		pm.dialPause()
+	}
+	pm.connections.Range(func(addr string, p Peer) bool {
+		pm.requestPeerList(p)
+		return true
+	})
+}
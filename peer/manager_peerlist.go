@@ -0,0 +1,143 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/peer/peermessage"
+	"github.com/fletaio/framework/router"
+)
+
+const (
+	defaultPeerListInterval   = 10 * time.Second
+	defaultPeerListFilterRate = 0.01
+)
+
+// peerListState keeps the peer list exchanges of the connected peers
+type peerListState struct {
+	sync.Mutex
+	since     map[string]int64
+	requested map[string]time.Time
+	answered  map[string]time.Time
+}
+
+func newPeerListState() *peerListState {
+	return &peerListState{
+		since:     map[string]int64{},
+		requested: map[string]time.Time{},
+		answered:  map[string]time.Time{},
+	}
+}
+
+// allow records the exchange of the address and returns false when the last one is in the interval
+func (ps *peerListState) allow(m map[string]time.Time, addr string, now time.Time, d time.Duration) bool {
+	ps.Lock()
+	defer ps.Unlock()
+	if last, has := m[addr]; has && d > 0 && now.Sub(last) < d {
+		return false
+	}
+	m[addr] = now
+	return true
+}
+
+func (ps *peerListState) sinceOf(addr string) int64 {
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.since[addr]
+}
+
+func (ps *peerListState) received(addr string, since int64) {
+	ps.Lock()
+	defer ps.Unlock()
+	ps.since[addr] = since
+}
+
+// forget removes the exchanges of the disconnected peer, so the full list is asked when it is connected again
+func (ps *peerListState) forget(addr string) {
+	ps.Lock()
+	defer ps.Unlock()
+	delete(ps.since, addr)
+	delete(ps.requested, addr)
+	delete(ps.answered, addr)
+}
+
+func (pm *manager) peerListInterval() time.Duration {
+	if pm.Config.PeerListInterval == 0 {
+		return defaultPeerListInterval
+	}
+	return pm.Config.PeerListInterval
+}
+
+// requestPeerList asks the peer list to the peer unless it is asked in the PeerListInterval,
+// the peer which supports router.FeaturePeerListDiff is asked the nodes out of the bloom filter of the known nodes
+// or the nodes updated after its last list when the filter is not sent.
+// The filter is seeded for each request, so the node left out by a false positive is answered by the later one
+// and the time of the last list is not sent with it because it would leave the node out for good
+func (pm *manager) requestPeerList(p Peer) {
+	addr := p.NetAddr()
+	if !pm.peerLists.allow(pm.peerLists.requested, addr, pm.clock.Now(), pm.peerListInterval()) {
+		return
+	}
+	msg := &peermessage.PeerList{
+		Request: true,
+		From:    pm.advertiseAddr(p),
+	}
+	if p.Features()&router.FeaturePeerListDiff != 0 {
+		msg.Diff = true
+		if pm.Config.PeerListFilterRate >= 0 {
+			msg.Filter = pm.knownFilter()
+		} else {
+			msg.Since = pm.peerLists.sinceOf(addr)
+		}
+	}
+	p.Send(msg)
+}
+
+// knownFilter returns the bloom filter of the stored nodes and the candidates at the PeerListFilterRate
+func (pm *manager) knownFilter() *peermessage.Bloom {
+	addrs := []string{}
+	for _, ci := range pm.nodes.Snapshot() {
+		addrs = append(addrs, ci.Address)
+	}
+	pm.candidates.rangeMap(func(addr string, cs candidateState) bool {
+		addrs = append(addrs, addr)
+		return true
+	})
+	rate := pm.Config.PeerListFilterRate
+	if rate == 0 {
+		rate = defaultPeerListFilterRate
+	}
+	pm.rand.Lock()
+	seed := pm.rand.r.Uint32()
+	pm.rand.Unlock()
+	filter := peermessage.NewBloom(len(addrs), rate, seed)
+	for _, addr := range addrs {
+		filter.Add(addr)
+	}
+	return filter
+}
+
+// answerPeerList returns false when a request of the peer is answered in the PeerListInterval
+func (pm *manager) answerPeerList(addr string) bool {
+	return pm.peerLists.allow(pm.peerLists.answered, addr, pm.clock.Now(), pm.peerListInterval())
+}
+
+// nodesSince returns the stored nodes which are seen or connected after the time, all of them when it is zero.
+// The nodes in the filter of the requester are left out
+func (pm *manager) nodesSince(since int64, filter *peermessage.Bloom) map[string]peermessage.ConnectInfo {
+	snapshot := pm.nodes.Snapshot()
+	nodeMap := make(map[string]peermessage.ConnectInfo, len(snapshot))
+	for _, ci := range snapshot {
+		if filter != nil && filter.Has(ci.Address) {
+			continue
+		}
+		if since > 0 {
+			firstSeen, lastSuccess := pm.nodes.Times(ci.Address)
+			if firstSeen <= since && lastSuccess <= since {
+				continue
+			}
+		}
+		nodeMap[ci.Address] = ci
+	}
+	return nodeMap
+}
@@ -0,0 +1,251 @@
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/log"
+	"github.com/fletaio/framework/peer/peermessage"
+	"github.com/fletaio/framework/ttlcache"
+)
+
+// probe defaults
+const (
+	defaultProbeTTL              = 3
+	defaultProbeLatencyThreshold = 2 * time.Second
+	defaultProbeAlertScore       = 50
+	probeRouteTTL                = time.Minute
+)
+
+//NetworkHealth is the result of the last probe round of the local node
+type NetworkHealth struct {
+	Score         float64
+	Reached       int
+	Known         int
+	MedianLatency time.Duration
+	P90Latency    time.Duration
+	MaxHops       int
+	MeasuredTime  time.Time
+	Degraded      bool
+}
+
+type probeRoute struct {
+	prev string
+}
+
+type probeRound struct {
+	id        uint64
+	start     time.Time
+	latencies map[string]time.Duration
+	maxHops   int
+}
+
+//probeState relays the probes of the other nodes and collects the echoes of the own probes
+type probeState struct {
+	sync.Mutex
+	routes *ttlcache.Cache
+	round  *probeRound
+	health NetworkHealth
+	alert  func(NetworkHealth)
+}
+
+func newProbeState(clk clock.Clock) *probeState {
+	return &probeState{
+		routes: ttlcache.NewWithClock(0, clk),
+	}
+}
+
+//SetHealthAlert sets the callback which is called when the probe round finds the propagation degraded
+func (pm *manager) SetHealthAlert(alert func(NetworkHealth)) {
+	pm.probes.Lock()
+	defer pm.probes.Unlock()
+
+	pm.probes.alert = alert
+}
+
+//NetworkHealth returns the health of the last finished probe round
+func (pm *manager) NetworkHealth() NetworkHealth {
+	pm.probes.Lock()
+	defer pm.probes.Unlock()
+
+	return pm.probes.health
+}
+
+//SendProbe finishes the current probe round and floods a new probe to the connected peers
+func (pm *manager) SendProbe() {
+	pm.finishProbeRound()
+
+	pm.rand.Lock()
+	id := pm.rand.r.Uint64()
+	pm.rand.Unlock()
+
+	ttl := pm.Config.ProbeTTL
+	if ttl <= 0 {
+		ttl = defaultProbeTTL
+	}
+	pm.probes.Lock()
+	pm.probes.round = &probeRound{
+		id:        id,
+		start:     pm.clock.Now(),
+		latencies: map[string]time.Duration{},
+	}
+	pm.probes.Unlock()
+
+	probe := &peermessage.Probe{
+		ID:     id,
+		Origin: pm.router.NodeID(),
+		TTL:    uint8(ttl),
+	}
+	pm.connections.Range(func(addr string, p Peer) bool {
+		p.SendBroadcast(probe)
+		return true
+	})
+}
+
+func (pm *manager) probeLoop() {
+	for {
+		if !pm.sleep(pm.Config.ProbeInterval) {
+			return
+		}
+		pm.SendProbe()
+	}
+}
+
+//onProbe echoes and relays the probe or passes the echo back to the origin
+func (pm *manager) onProbe(p mesh.Peer, probe *peermessage.Probe) error {
+	own := pm.router.NodeID()
+	if probe.Echo {
+		if probe.Origin == own {
+			pm.recordEcho(probe)
+			return nil
+		}
+		route, has := pm.probes.routes.Get(probe.ID)
+		if has {
+			if prev, has := pm.connections.Load(route.(*probeRoute).prev); has {
+				prev.Send(probe)
+			}
+		}
+		return nil
+	}
+
+	if probe.Origin == own {
+		return nil
+	}
+	pm.probes.Lock()
+	seen := pm.probes.routes.Has(probe.ID)
+	if !seen {
+		pm.probes.routes.Set(probe.ID, &probeRoute{prev: p.NetAddr()}, probeRouteTTL)
+	}
+	pm.probes.Unlock()
+	if seen {
+		return nil
+	}
+
+	hops := probe.Hops + 1
+	p.Send(&peermessage.Probe{
+		ID:       probe.ID,
+		Origin:   probe.Origin,
+		Receiver: own,
+		Hops:     hops,
+		TTL:      probe.TTL,
+		Echo:     true,
+	})
+	if hops < probe.TTL {
+		pm.ExceptCast(p.NetAddr(), &peermessage.Probe{
+			ID:     probe.ID,
+			Origin: probe.Origin,
+			Hops:   hops,
+			TTL:    probe.TTL,
+		})
+	}
+	return nil
+}
+
+//recordEcho keeps the first echo of each receiver of the current round
+func (pm *manager) recordEcho(probe *peermessage.Probe) {
+	pm.probes.Lock()
+	defer pm.probes.Unlock()
+
+	round := pm.probes.round
+	if round == nil || round.id != probe.ID {
+		return
+	}
+	if _, has := round.latencies[probe.Receiver]; has {
+		return
+	}
+	round.latencies[probe.Receiver] = pm.clock.Now().Sub(round.start) / 2
+	if int(probe.Hops) > round.maxHops {
+		round.maxHops = int(probe.Hops)
+	}
+}
+
+//finishProbeRound computes the health score from the reach and the latency of the current round.
+//The score is 100 when every known node is reached within the latency threshold.
+func (pm *manager) finishProbeRound() {
+	pm.probes.Lock()
+	round := pm.probes.round
+	pm.probes.round = nil
+	alert := pm.probes.alert
+	pm.probes.Unlock()
+	if round == nil {
+		return
+	}
+
+	threshold := pm.Config.ProbeLatencyThreshold
+	if threshold <= 0 {
+		threshold = defaultProbeLatencyThreshold
+	}
+	alertScore := pm.Config.ProbeAlertScore
+	if alertScore <= 0 {
+		alertScore = defaultProbeAlertScore
+	}
+
+	latencies := make([]time.Duration, 0, len(round.latencies))
+	for _, l := range round.latencies {
+		latencies = append(latencies, l)
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	known := 0
+	for _, ci := range pm.nodes.Snapshot() {
+		if !pm.isLocalhost(ci.Address) {
+			known++
+		}
+	}
+	if known < len(latencies) {
+		known = len(latencies)
+	}
+
+	health := NetworkHealth{
+		Reached:      len(latencies),
+		Known:        known,
+		MaxHops:      round.maxHops,
+		MeasuredTime: pm.clock.Now(),
+	}
+	if len(latencies) > 0 {
+		health.MedianLatency = latencies[len(latencies)/2]
+		health.P90Latency = latencies[len(latencies)*9/10]
+		reach := float64(len(latencies)) / float64(known)
+		speed := 1.0
+		if health.P90Latency > threshold {
+			speed = float64(threshold) / float64(health.P90Latency)
+		}
+		health.Score = 100 * reach * speed
+	}
+	health.Degraded = health.Score < alertScore || health.P90Latency > threshold
+
+	pm.probes.Lock()
+	pm.probes.health = health
+	pm.probes.Unlock()
+
+	if health.Degraded {
+		log.Warn("network health degraded ", health.Score, " reached ", health.Reached, "/", health.Known, " p90 ", health.P90Latency)
+		if alert != nil {
+			alert(health)
+		}
+	}
+}
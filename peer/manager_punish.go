@@ -0,0 +1,123 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/router"
+	"github.com/fletaio/framework/router/evilnode"
+)
+
+//punishState is the failed requests of a candidate
+type punishState struct {
+	failures   int
+	timeouts   int
+	lastKind   router.DialErrorKind
+	lastReport time.Time
+}
+
+//punishMap counts the failed requests of the candidates
+type punishMap struct {
+	l      sync.Mutex
+	states map[string]*punishState
+}
+
+func newPunishMap() *punishMap {
+	return &punishMap{
+		states: map[string]*punishState{},
+	}
+}
+
+//isPunishable returns true when the request error is caused by the candidate itself
+func isPunishable(err error) bool {
+	switch err {
+	case nil, router.ErrRedialBackoff, router.ErrCannotRequestToLocal, router.ErrCanNotConnectToEvilNode,
+		router.ErrDuplicateAccept, router.ErrRouterClosed, router.ErrMismatchCoordinate:
+		return false
+	default:
+		return true
+	}
+}
+
+//failureWeight returns the failures counted for the class of the request error.
+//The host which is possibly down is not punished and the reset after the connection is counted twice.
+func failureWeight(kind router.DialErrorKind) int {
+	switch kind {
+	case router.DialTimeout, router.DialUnreachable:
+		return 0
+	case router.DialReset:
+		return 2
+	default:
+		return 1
+	}
+}
+
+//punishCandidate reports the candidate to the evil node manager when it fails more than the tolerated failures.
+//The candidate is reported again only after the cool-down.
+//It returns true when the candidate has been unreachable CandidateMaxTimeouts times in a row and should be dropped.
+func (pm *manager) punishCandidate(addr string, err error) bool {
+	if !isPunishable(err) {
+		return false
+	}
+	kind := router.ClassifyDialError(err)
+
+	pm.punishes.l.Lock()
+	defer pm.punishes.l.Unlock()
+
+	ps, has := pm.punishes.states[addr]
+	if !has {
+		ps = &punishState{}
+		pm.punishes.states[addr] = ps
+	}
+	ps.lastKind = kind
+	if kind == router.DialTimeout || kind == router.DialUnreachable {
+		ps.timeouts++
+		if pm.Config.CandidateMaxTimeouts > 0 && ps.timeouts >= pm.Config.CandidateMaxTimeouts {
+			delete(pm.punishes.states, addr)
+			return true
+		}
+	} else {
+		ps.timeouts = 0
+	}
+	ps.failures += failureWeight(kind)
+	if pm.Config.PunishFailures <= 0 || ps.failures <= pm.Config.PunishFailures {
+		return false
+	}
+	cooldown := pm.Config.PunishCooldown
+	if cooldown <= 0 {
+		cooldown = time.Minute * 10
+	}
+	if pm.clock.Now().Sub(ps.lastReport) < cooldown {
+		return false
+	}
+	offense := pm.Config.PunishOffense
+	if offense == 0 {
+		offense = evilnode.BadBehaviour
+	}
+	ps.lastReport = pm.clock.Now()
+	ps.failures = 0
+	if err := pm.router.EvilNodeManager().TellOn(addr, offense); err != nil {
+		pm.errLog("punishCandidate ", err)
+	}
+	return false
+}
+
+//lastFailure returns the class of the last failed request of the candidate which is not connected since
+func (pm *manager) lastFailure(addr string) (router.DialErrorKind, bool) {
+	pm.punishes.l.Lock()
+	defer pm.punishes.l.Unlock()
+
+	ps, has := pm.punishes.states[addr]
+	if !has {
+		return router.DialOther, false
+	}
+	return ps.lastKind, true
+}
+
+//forgiveCandidate resets the failures of the candidate which is connected
+func (pm *manager) forgiveCandidate(addr string) {
+	pm.punishes.l.Lock()
+	defer pm.punishes.l.Unlock()
+
+	delete(pm.punishes.states, addr)
+}
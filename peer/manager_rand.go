@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+//lockedRand is a rand.Rand which is safe for the concurrent use
+type lockedRand struct {
+	sync.Mutex
+	r *rand.Rand
+}
+
+func newLockedRand(src rand.Source) *lockedRand {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &lockedRand{
+		r: rand.New(src),
+	}
+}
+
+//shuffle sorts the list and shuffles it, so the order only depends on the rand source
+func (lr *lockedRand) shuffle(list []string) {
+	sort.Strings(list)
+
+	lr.Lock()
+	defer lr.Unlock()
+	lr.r.Shuffle(len(list), func(i, j int) {
+		list[i], list[j] = list[j], list[i]
+	})
+}
+
+//shuffledConnections returns the connected peers in the order decided by the rand source of the manager
+func (pm *manager) shuffledConnections() []Peer {
+	peers := map[string]Peer{}
+	addrs := []string{}
+	pm.connections.Range(func(addr string, p Peer) bool {
+		peers[addr] = p
+		addrs = append(addrs, addr)
+		return true
+	})
+	pm.rand.shuffle(addrs)
+	list := make([]Peer, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, peers[addr])
+	}
+	return list
+}
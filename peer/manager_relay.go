@@ -0,0 +1,168 @@
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/log"
+	"github.com/fletaio/framework/message"
+)
+
+const defaultRelayQuotaWindow = time.Minute
+
+//RelayUsage is the bytes relayed on behalf of the origin peer in the current window of the relay quota
+type RelayUsage struct {
+	Origin    string
+	Relayed   int64
+	Dropped   int64
+	Throttled bool
+	Since     time.Time
+}
+
+//relayQuota limits the broadcast bytes which are relayed for each origin peer in a window,
+//so a peer can't use the mesh to amplify its messages for free
+type relayQuota struct {
+	sync.Mutex
+	quota   int64
+	window  time.Duration
+	origins map[string]*RelayUsage
+	clock   clock.Clock
+}
+
+//newRelayQuota returns nil when the quota is not positive
+func newRelayQuota(quota int64, window time.Duration, clk clock.Clock) *relayQuota {
+	if quota <= 0 {
+		return nil
+	}
+	if window <= 0 {
+		window = defaultRelayQuotaWindow
+	}
+	return &relayQuota{
+		quota:   quota,
+		window:  window,
+		origins: map[string]*RelayUsage{},
+		clock:   clock.Or(clk),
+	}
+}
+
+//charge counts the bytes relayed for the origin and returns false when they are over the quota.
+//The changes of the throttled state of the origin are returned to be emitted.
+func (rq *relayQuota) charge(origin string, bytes int64) (allowed bool, throttled bool, restored bool) {
+	rq.Lock()
+	defer rq.Unlock()
+
+	now := rq.clock.Now()
+	u, has := rq.origins[origin]
+	if !has {
+		u = &RelayUsage{Origin: origin, Since: now}
+		rq.origins[origin] = u
+	}
+	if now.Sub(u.Since) >= rq.window {
+		restored = u.Throttled
+		u.Relayed = 0
+		u.Dropped = 0
+		u.Throttled = false
+		u.Since = now
+	}
+	if u.Relayed+bytes > rq.quota {
+		u.Dropped += bytes
+		if !u.Throttled {
+			u.Throttled = true
+			throttled = true
+		}
+		return false, throttled, restored
+	}
+	u.Relayed += bytes
+	return true, false, restored
+}
+
+//expire forgets the origins which didn't relay in the last window
+func (rq *relayQuota) expire() {
+	if rq == nil {
+		return
+	}
+	rq.Lock()
+	defer rq.Unlock()
+
+	now := rq.clock.Now()
+	for origin, u := range rq.origins {
+		if now.Sub(u.Since) >= 2*rq.window {
+			delete(rq.origins, origin)
+		}
+	}
+}
+
+func (rq *relayQuota) usages() []RelayUsage {
+	if rq == nil {
+		return []RelayUsage{}
+	}
+	rq.Lock()
+	defer rq.Unlock()
+
+	list := make([]RelayUsage, 0, len(rq.origins))
+	for _, u := range rq.origins {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Relayed+list[i].Dropped > list[j].Relayed+list[j].Dropped
+	})
+	return list
+}
+
+//RelayUsages returns the relayed bytes of the origin peers in the current windows, the heaviest first
+func (pm *manager) RelayUsages() []RelayUsage {
+	return pm.relays.usages()
+}
+
+//allowRelay charges the message sent to the receivers on behalf of the origin and returns false when the origin is over the quota.
+//The messages which are not relayed for a connected peer are not limited.
+func (pm *manager) allowRelay(origin string, m message.Message, receivers int) bool {
+	if pm.relays == nil || receivers <= 0 {
+		return true
+	}
+	if _, has := pm.connections.Load(origin); !has {
+		return true
+	}
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return true
+	}
+	return pm.chargeRelay(origin, m.Type(), len(bs), receivers)
+}
+
+//chargeRelay charges the encoded message of the type as allowRelay does
+func (pm *manager) chargeRelay(origin string, t message.Type, size int, receivers int) bool {
+	if pm.relays == nil || receivers <= 0 {
+		return true
+	}
+	if _, has := pm.connections.Load(origin); !has {
+		return true
+	}
+	allowed, throttled, restored := pm.relays.charge(origin, int64(size*receivers))
+	// the events are emitted apart from the relay which is usually in the OnRecv of the origin holding the handler lock
+	if restored {
+		go pm.emitRelay(origin, false)
+	}
+	if throttled {
+		log.Warn("relay throttled ", origin, " ", message.NameOfType(t))
+		go pm.emitRelay(origin, true)
+	}
+	return allowed
+}
+
+func (pm *manager) emitRelay(origin string, throttled bool) {
+	pm.eventHandlerLock.RLock()
+	defer pm.eventHandlerLock.RUnlock()
+	for _, eh := range pm.eventHandler {
+		if rh, ok := eh.(mesh.RelayHandler); ok {
+			if throttled {
+				rh.OnRelayThrottled(pm.ctx, origin)
+			} else {
+				rh.OnRelayRestored(pm.ctx, origin)
+			}
+		}
+	}
+}
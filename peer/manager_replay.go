@@ -0,0 +1,101 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/message"
+)
+
+const defaultReplayLimit = 32
+
+type replayEntry struct {
+	bs []byte
+	at time.Time
+}
+
+//replayBuffer retains the recent broadcasts of the selected types for the peers which connect shortly after
+type replayBuffer struct {
+	sync.Mutex
+	window  time.Duration
+	limit   int
+	types   map[message.Type]bool
+	entries []replayEntry
+	clock   clock.Clock
+}
+
+func newReplayBuffer(window time.Duration, types []message.Type, limit int, clk clock.Clock) *replayBuffer {
+	if window <= 0 || len(types) == 0 {
+		return nil
+	}
+	if limit <= 0 {
+		limit = defaultReplayLimit
+	}
+	rb := &replayBuffer{
+		window: window,
+		limit:  limit,
+		types:  map[message.Type]bool{},
+		clock:  clock.Or(clk),
+	}
+	for _, t := range types {
+		rb.types[t] = true
+	}
+	return rb
+}
+
+//retain keeps the broadcast when its type is selected
+func (rb *replayBuffer) retain(m message.Message) {
+	if rb == nil || !rb.types[m.Type()] {
+		return
+	}
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return
+	}
+
+	rb.Lock()
+	defer rb.Unlock()
+
+	now := rb.clock.Now()
+	rb.prune(now)
+	rb.entries = append(rb.entries, replayEntry{bs: bs, at: now})
+	if len(rb.entries) > rb.limit {
+		rb.entries = rb.entries[len(rb.entries)-rb.limit:]
+	}
+}
+
+//recent returns the broadcasts in the window in order
+func (rb *replayBuffer) recent() [][]byte {
+	if rb == nil {
+		return nil
+	}
+	rb.Lock()
+	defer rb.Unlock()
+
+	rb.prune(rb.clock.Now())
+	list := make([][]byte, 0, len(rb.entries))
+	for _, e := range rb.entries {
+		list = append(list, e.bs)
+	}
+	return list
+}
+
+func (rb *replayBuffer) prune(now time.Time) {
+	i := 0
+	for i < len(rb.entries) && now.Sub(rb.entries[i].at) > rb.window {
+		i++
+	}
+	if i > 0 {
+		rb.entries = append([]replayEntry{}, rb.entries[i:]...)
+	}
+}
+
+//replayBroadcasts sends the broadcasts of the ReplayWindow to the peer which is just connected
+func (pm *manager) replayBroadcasts(p *peer) {
+	for _, bs := range pm.replays.recent() {
+		if err := p.sendRaw(bs, false); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/router"
+)
+
+//replyEnvelopeType frames the response with the type of the message which it answers
+//It is sent only to the peers which negotiated router.FeatureReply.
+var replyEnvelopeType = message.DefineType("peer.ReplyEnvelope")
+
+//replier routes the responses of a received message to the peer which sent it
+type replier struct {
+	pm        *manager
+	p         *peer
+	inReplyTo message.Type
+}
+
+//Reply sends the response by the connection of the message
+//and by the connection of the address when the peer is reconnected in the meantime
+func (rp *replier) Reply(m message.Message) error {
+	p := rp.p
+	if p.IsClose() {
+		np, has := rp.pm.connections.Load(p.NetAddr())
+		if !has {
+			return rp.pm.TargetCast(p.NetAddr(), m)
+		}
+		if np, ok := np.(*peer); ok {
+			p = np
+		} else {
+			return np.Send(m)
+		}
+	}
+	if p.Features()&router.FeatureReply == 0 {
+		return p.Send(m)
+	}
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+	return p.sendRaw(encodeReply(rp.inReplyTo, bs), true)
+}
+
+func encodeReply(inReplyTo message.Type, bs []byte) []byte {
+	bf := bytes.Buffer{}
+	util.WriteUint64(&bf, uint64(replyEnvelopeType))
+	util.WriteUint64(&bf, uint64(inReplyTo))
+	bf.Write(bs)
+	return bf.Bytes()
+}
+
+//replyContext returns the context of the handlers of the message which is received from the peer
+func (pm *manager) replyContext(p *peer, t message.Type) context.Context {
+	return mesh.WithReplier(p.ctx, &replier{
+		pm:        pm,
+		p:         p,
+		inReplyTo: t,
+	})
+}
+
+//openReply reads the envelope of the response and returns the type of the message which it answers and its own type
+func openReply(r io.Reader) (message.Type, message.Type, error) {
+	inReplyTo, _, err := util.ReadUint64(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	t, _, err := util.ReadUint64(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if message.NameOfType(message.Type(t)) == "" {
+		return 0, 0, message.ErrUnknownMessage
+	}
+	return message.Type(inReplyTo), message.Type(t), nil
+}
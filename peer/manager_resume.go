@@ -0,0 +1,55 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/ttlcache"
+)
+
+//resumeSlots keeps the group slots of the disconnected group peers until they reconnect by the resumed sessions of the router,
+//so a brief network blip doesn't hand their slots to the spare peers
+type resumeSlots struct {
+	grace time.Duration
+	slots *ttlcache.Cache
+}
+
+//newResumeSlots returns nil when the grace is not positive
+func newResumeSlots(grace time.Duration, clk clock.Clock) *resumeSlots {
+	if grace <= 0 {
+		return nil
+	}
+	return &resumeSlots{
+		grace: grace,
+		slots: ttlcache.NewWithClock(0, clk),
+	}
+}
+
+func (rs *resumeSlots) expire() {
+	if rs == nil {
+		return
+	}
+	rs.slots.Expire()
+}
+
+//holdSlot keeps the slot of the group peer which is disconnected and returns false when the slots are not kept.
+//The slot is given to the best spare peer when the peer doesn't resume in the grace.
+func (pm *manager) holdSlot(id string) bool {
+	if pm.resumes == nil {
+		return false
+	}
+	pm.resumes.slots.SetWithExpire(id, true, pm.resumes.grace, func(key interface{}, value interface{}) {
+		pm.promoteSpare()
+	})
+	return true
+}
+
+//resumeSlot puts the peer which resumed its session back into the group when its slot is kept
+func (pm *manager) resumeSlot(p Peer) {
+	if pm.resumes == nil || !p.Resumed() || !pm.resumes.slots.Has(p.ID()) {
+		return
+	}
+	pm.resumes.slots.Delete(p.ID())
+	pm.trace(p.NetAddr(), "resumed the group slot")
+	pm.addReadyConn(p)
+}
@@ -0,0 +1,145 @@
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/peer/storage"
+)
+
+const (
+	defaultScoreHistoryInterval = time.Minute
+	defaultScoreHistorySize     = 180
+)
+
+//ScoreSample is the score components of a group member at the time
+type ScoreSample struct {
+	Time       time.Time
+	Group      int
+	Ping       time.Duration
+	Stability  time.Duration
+	Usefulness time.Duration
+	Score      time.Duration
+}
+
+//scoreRing is the ring buffer of the samples of a peer
+type scoreRing struct {
+	samples []ScoreSample
+	next    int
+	full    bool
+	last    time.Time
+}
+
+func (sr *scoreRing) push(s ScoreSample) {
+	sr.samples[sr.next] = s
+	sr.next = (sr.next + 1) % len(sr.samples)
+	if sr.next == 0 {
+		sr.full = true
+	}
+	sr.last = s.Time
+}
+
+func (sr *scoreRing) list() []ScoreSample {
+	if !sr.full {
+		return append([]ScoreSample{}, sr.samples[:sr.next]...)
+	}
+	return append(append([]ScoreSample{}, sr.samples[sr.next:]...), sr.samples[:sr.next]...)
+}
+
+//scoreHistory keeps the recent samples of the group members, the samples of the peer which left the group
+//are kept until they are older than the whole ring so the operator sees why it was rotated out
+type scoreHistory struct {
+	sync.Mutex
+	size  int
+	keep  time.Duration
+	rings map[string]*scoreRing
+}
+
+func newScoreHistory(interval time.Duration, size int) *scoreHistory {
+	if interval < 0 {
+		return nil
+	}
+	if interval == 0 {
+		interval = defaultScoreHistoryInterval
+	}
+	if size <= 0 {
+		size = defaultScoreHistorySize
+	}
+	return &scoreHistory{
+		size:  size,
+		keep:  interval * time.Duration(size),
+		rings: map[string]*scoreRing{},
+	}
+}
+
+func (sh *scoreHistory) record(now time.Time, list []storage.ScoreComponents) {
+	sh.Lock()
+	defer sh.Unlock()
+
+	for _, sc := range list {
+		sr, has := sh.rings[sc.ID]
+		if !has {
+			sr = &scoreRing{samples: make([]ScoreSample, sh.size)}
+			sh.rings[sc.ID] = sr
+		}
+		sr.push(ScoreSample{
+			Time:       now,
+			Group:      sc.Group,
+			Ping:       sc.Ping,
+			Stability:  sc.Stability,
+			Usefulness: sc.Usefulness,
+			Score:      sc.Score,
+		})
+	}
+	for id, sr := range sh.rings {
+		if now.Sub(sr.last) > sh.keep {
+			delete(sh.rings, id)
+		}
+	}
+}
+
+func (sh *scoreHistory) history(id string) []ScoreSample {
+	sh.Lock()
+	defer sh.Unlock()
+
+	sr, has := sh.rings[id]
+	if !has {
+		return []ScoreSample{}
+	}
+	return sr.list()
+}
+
+func (sh *scoreHistory) ids() []string {
+	sh.Lock()
+	defer sh.Unlock()
+
+	list := make([]string, 0, len(sh.rings))
+	for id := range sh.rings {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list
+}
+
+//recordScores samples the score components of the group members in every ScoreHistoryInterval
+func (pm *manager) recordScores() {
+	d := pm.Config.ScoreHistoryInterval
+	if d == 0 {
+		d = defaultScoreHistoryInterval
+	}
+	for pm.sleep(d) {
+		pm.scores.record(pm.clock.Now(), pm.peerStorage.Scores())
+	}
+}
+
+//ScoreHistory returns the recorded score samples of the peer in order, the peers of the history are returned when the id is empty
+func (pm *manager) ScoreHistory(id string) interface{} {
+	if pm.scores == nil {
+		return nil
+	}
+	if id == "" {
+		return pm.scores.ids()
+	}
+	return pm.scores.history(id)
+}
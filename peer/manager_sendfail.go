@@ -0,0 +1,117 @@
+package peer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/log"
+)
+
+const (
+	defaultSendFailureThreshold  = 3
+	defaultSendFailureBackoff    = 5 * time.Second
+	defaultSendFailureMaxBackoff = 5 * time.Minute
+)
+
+type sendBackoffState struct {
+	episodes int
+	until    time.Time
+}
+
+//sendBackoff delays the reconnects of the addresses which were downgraded by the send failures.
+//The backoff is doubled for each downgrade and the downgrades are forgotten after the max backoff without one.
+type sendBackoff struct {
+	sync.Mutex
+	base   time.Duration
+	max    time.Duration
+	states map[string]*sendBackoffState
+	clock  clock.Clock
+}
+
+func newSendBackoff(base time.Duration, max time.Duration, clk clock.Clock) *sendBackoff {
+	if base <= 0 {
+		base = defaultSendFailureBackoff
+	}
+	if max <= 0 {
+		max = defaultSendFailureMaxBackoff
+	}
+	if max < base {
+		max = base
+	}
+	return &sendBackoff{
+		base:   base,
+		max:    max,
+		states: map[string]*sendBackoffState{},
+		clock:  clock.Or(clk),
+	}
+}
+
+//fail records the downgrade of the address and returns the backoff of its reconnects
+func (sb *sendBackoff) fail(addr string) time.Duration {
+	sb.Lock()
+	defer sb.Unlock()
+
+	st, has := sb.states[addr]
+	if !has {
+		st = &sendBackoffState{}
+		sb.states[addr] = st
+	}
+	d := sb.base
+	for i := 0; i < st.episodes && d < sb.max; i++ {
+		d *= 2
+	}
+	if d > sb.max {
+		d = sb.max
+	}
+	st.episodes++
+	st.until = sb.clock.Now().Add(d)
+	return d
+}
+
+func (sb *sendBackoff) held(addr string) bool {
+	sb.Lock()
+	defer sb.Unlock()
+
+	st, has := sb.states[addr]
+	return has && sb.clock.Now().Before(st.until)
+}
+
+func (sb *sendBackoff) expire() {
+	sb.Lock()
+	defer sb.Unlock()
+
+	now := sb.clock.Now()
+	for addr, st := range sb.states {
+		if now.Sub(st.until) > sb.max {
+			delete(sb.states, addr)
+		}
+	}
+}
+
+//onSendError closes the peer whose sends failed SendFailureThreshold times in a row without waiting for the read side,
+//and moves its address back to the candidates with the backoff
+func (pm *manager) onSendError(p *peer, failures int, err error) {
+	threshold := pm.Config.SendFailureThreshold
+	if threshold == 0 {
+		threshold = defaultSendFailureThreshold
+	}
+	if threshold < 0 || failures < threshold || p.IsClose() {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&p.downgraded, 0, 1) {
+		return
+	}
+	// the sender holds the send slot of the peer, so the peer is closed apart from it
+	go pm.downgrade(p, failures, err)
+}
+
+func (pm *manager) downgrade(p *peer, failures int, err error) {
+	addr := p.NetAddr()
+	hold := pm.sendBackoff.fail(addr)
+	pm.punishCandidate(addr, err)
+	log.Info("downgrade ", addr, " to candidate after ", failures, " send failures ", err, " backoff ", hold)
+	p.Close()
+	pm.candidates.store(addr, csRequestWait)
+}
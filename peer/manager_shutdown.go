@@ -0,0 +1,62 @@
+package peer
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/fletaio/framework/chain/mesh"
+)
+
+//Stop shuts the manager down without the deadline
+func (pm *manager) Stop() {
+	pm.Shutdown(context.Background())
+}
+
+//Shutdown stops the manage loops, the router and the accept loop, closes the peers and flushes the node store.
+//The handlers which implement mesh.CloseHandler are notified of the closed peers.
+//It returns the first error of the stages, the context bounds the waiting of them
+func (pm *manager) Shutdown(ctx context.Context) error {
+	var err error
+	pm.shutdownOnce.Do(func() {
+		atomic.StoreInt32(&pm.closing, 1)
+		err = pm.lifecycle.Stop(ctx)
+
+		peers := []Peer{}
+		pm.connections.Range(func(addr string, p Peer) bool {
+			peers = append(peers, p)
+			return true
+		})
+		for _, p := range peers {
+			p.Close()
+		}
+		pm.cancel()
+
+		if pm.spool != nil {
+			if e := pm.spool.Close(); err == nil {
+				err = e
+			}
+		}
+		// the store of the embedder is closed by the embedder
+		if pm.Config.NodeStore == nil {
+			if e := pm.nodes.Close(); err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+func (pm *manager) isClosing() bool {
+	return atomic.LoadInt32(&pm.closing) == 1
+}
+
+//emitClosed notifies the handlers of the peer which is closed by the shutdown
+func (pm *manager) emitClosed(p Peer) {
+	pm.eventHandlerLock.RLock()
+	defer pm.eventHandlerLock.RUnlock()
+	for _, eh := range pm.eventHandler {
+		if ch, ok := eh.(mesh.CloseHandler); ok {
+			ch.OnClosed(p)
+		}
+	}
+}
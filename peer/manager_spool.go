@@ -0,0 +1,116 @@
+package peer
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
+)
+
+//spool stores the undeliverable messages of the designated peers until they are reconnected
+type spool struct {
+	l        sync.Mutex
+	db       kvstore.KVStore
+	seq      uint64
+	maxBytes int64
+	maxAge   time.Duration
+	clock    clock.Clock
+}
+
+func newSpool(db kvstore.KVStore, clk clock.Clock, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	clk = clock.Or(clk)
+	s := &spool{
+		db:       db,
+		seq:      uint64(clk.Now().UnixNano()),
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		clock:    clk,
+	}
+	return s, nil
+}
+
+func spoolPrefix(addr string) []byte {
+	return append([]byte(addr), 0)
+}
+
+//Push appends the message of the address and drops the oldest messages over the size cap
+func (s *spool) Push(addr string, bs []byte) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.seq++
+	key := append(spoolPrefix(addr), util.Uint64ToBytes(s.seq)...)
+	value := append(util.Uint64ToBytes(uint64(s.clock.Now().UnixNano())), bs...)
+	if err := s.db.Put(key, value); err != nil {
+		return err
+	}
+	if s.maxBytes <= 0 {
+		return nil
+	}
+
+	keys := [][]byte{}
+	sizes := []int64{}
+	var total int64
+	prefix := spoolPrefix(addr)
+	if err := s.db.Iterate(func(k []byte, v []byte) bool {
+		if bytes.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+			sizes = append(sizes, int64(len(v)-8))
+			total += int64(len(v) - 8)
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	for i := 0; i < len(keys) && total > s.maxBytes; i++ {
+		if err := s.db.Delete(keys[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+//Pop removes and returns the messages of the address in order except the messages over the age cap
+func (s *spool) Pop(addr string) ([][]byte, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	keys := [][]byte{}
+	list := [][]byte{}
+	now := s.clock.Now()
+	prefix := spoolPrefix(addr)
+	if err := s.db.Iterate(func(k []byte, v []byte) bool {
+		if !bytes.HasPrefix(k, prefix) {
+			return true
+		}
+		keys = append(keys, k)
+		if len(v) < 8 {
+			return true
+		}
+		spooled := time.Unix(0, int64(util.BytesToUint64(v[:8])))
+		if s.maxAge > 0 && now.Sub(spooled) > s.maxAge {
+			return true
+		}
+		list = append(list, v[8:])
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if err := s.db.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+//Close closes the database of the spooled messages
+func (s *spool) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.db.Close()
+}
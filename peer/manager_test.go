@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,13 +13,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fletaio/common/hash"
 	"github.com/fletaio/common/util"
 	"github.com/fletaio/network/simulations"
 
 	"github.com/fletaio/common"
 	"github.com/fletaio/framework/chain/mesh"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
 	"github.com/fletaio/framework/log"
 	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/peer/peermessage"
 	"github.com/fletaio/framework/router"
 	"github.com/fletaio/framework/router/evilnode"
 
@@ -135,7 +140,7 @@ func init() {
 func (tm *testMessage) Type() message.Type {
 	return testMessageType
 }
-func (tm *testMessage) OnRecv(p mesh.Peer, r io.Reader, t message.Type) error {
+func (tm *testMessage) OnRecv(ctx context.Context, p mesh.Peer, r io.Reader, t message.Type) error {
 	return tm.onRecv(p, r, t)
 }
 func (tm *testMessage) OnClosed(p mesh.Peer) {
@@ -144,8 +149,8 @@ func (tm *testMessage) OnClosed(p mesh.Peer) {
 	}
 }
 
-func (tm *testMessage) OnConnected(p mesh.Peer)    {}
-func (tm *testMessage) OnDisconnected(p mesh.Peer) {}
+func (tm *testMessage) OnConnected(ctx context.Context, p mesh.Peer)    {}
+func (tm *testMessage) OnDisconnected(ctx context.Context, p mesh.Peer) {}
 
 func upVisulaization(tms []*testMessage) {
 	mc := make(chan simulations.Msg)
@@ -1211,7 +1216,11 @@ func TestNewByTime(t *testing.T) {
 			for i, w := range tt.want {
 				key := fmt.Sprintf("%v", i)
 				if got := b.IsBan(key); w != got {
-					t.Errorf("i = %v isBan = %v, overTime = %v want %v", key, got, b.Map[key].OverTime, w)
+					var v int64
+					if info, has := b.Get(key); has {
+						v = info.OverTime
+					}
+					t.Errorf("i = %v isBan = %v, overTime = %v want %v", key, got, v, w)
 				}
 			}
 
@@ -1385,3 +1394,326 @@ func Test_manager_BroadCastContinuery(t *testing.T) {
 		})
 	}
 }
+
+var recordMessageType = message.DefineType("peer.recordMessage")
+
+// recordMessage is the message of a short body which is recorded by the recordHandler
+type recordMessage struct {
+	body []byte
+}
+
+func (m *recordMessage) Type() message.Type {
+	return recordMessageType
+}
+
+func (m *recordMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(append([]byte{byte(len(m.body))}, m.body...))
+	return int64(n), err
+}
+
+func (m *recordMessage) ReadFrom(r io.Reader) (int64, error) {
+	l := make([]byte, 1)
+	if _, err := io.ReadFull(r, l); err != nil {
+		return 0, err
+	}
+	m.body = make([]byte, l[0])
+	n, err := io.ReadFull(r, m.body)
+	return int64(n + 1), err
+}
+
+type recordHandler struct {
+	mesh.BaseEventHandler
+	lock   sync.Mutex
+	bodies []string
+}
+
+func (h *recordHandler) OnRecv(ctx context.Context, p mesh.Peer, r io.Reader, t message.Type) error {
+	if t != recordMessageType {
+		return message.ErrUnknownMessage
+	}
+	m := &recordMessage{}
+	if _, err := m.ReadFrom(r); err != nil {
+		return err
+	}
+	h.lock.Lock()
+	h.bodies = append(h.bodies, string(m.body))
+	h.lock.Unlock()
+	return nil
+}
+
+// wait returns the recorded bodies after n bodies are recorded or the timeout
+func (h *recordHandler) wait(n int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		h.lock.Lock()
+		bodies := append([]string{}, h.bodies...)
+		h.lock.Unlock()
+		if len(bodies) >= n || time.Now().After(deadline) {
+			return bodies
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newLoopbackManager returns the manager whose router listens the loopback address, so the tests run over the real connections
+func newLoopbackManager(t *testing.T, dir string, host string, port int, opts ...Option) *manager {
+	coord := &common.Coordinate{}
+	r, err := router.New(coord, router.WithPort(port), router.WithBindAddr(host), router.WithEvilNodeStore(dir+"/router/"+host, "memory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewManager(coord, r, append([]Option{WithStorePath(dir+"/peer/"+host, "memory")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+// connectLoopback connects the started managers and returns the peer of b in a
+func connectLoopback(t *testing.T, a *manager, b *manager, addr string) *peer {
+	a.AddNode(addr)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var found *peer
+		a.connections.Range(func(_ string, p Peer) bool {
+			found, _ = p.(*peer)
+			return found == nil
+		})
+		if found != nil && b.connections.Len() > 0 {
+			return found
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the managers are not connected")
+	return nil
+}
+
+func TestGossipDuplicateOnConnection(t *testing.T) {
+	dir := t.TempDir()
+	a := newLoopbackManager(t, dir, "127.0.0.11", 47301, WithGossipTTL(time.Minute))
+	b := newLoopbackManager(t, dir, "127.0.0.12", 47302, WithGossipTTL(time.Minute))
+	h := &recordHandler{}
+	b.RegisterEventHandler(h)
+	a.StartManage()
+	b.StartManage()
+	defer a.Stop()
+	defer b.Stop()
+
+	p := connectLoopback(t, a, b, "127.0.0.12:47302")
+	if p.Features()&router.FeatureGossip == 0 {
+		t.Fatal("the gossip is not negotiated")
+	}
+	hello, _ := encodeMessage(&recordMessage{body: []byte("hello")})
+	world, _ := encodeMessage(&recordMessage{body: []byte("world")})
+	// the same envelope is sent twice over the connection and the body of the duplicate is discarded
+	frame := encodeGossip(hash.Hash(hello), hello)
+	for _, bs := range [][]byte{frame, frame, encodeGossip(hash.Hash(world), world)} {
+		if err := p.sendRaw(bs, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := h.wait(2, 5*time.Second)
+	if strings.Join(got, ",") != "hello,world" {
+		t.Errorf("received = %v, want [hello world]", got)
+	}
+	if p.IsClose() {
+		t.Error("the connection is closed by the duplicate")
+	}
+}
+
+func TestGossipCastClamp(t *testing.T) {
+	dir := t.TempDir()
+	a := newLoopbackManager(t, dir, "127.0.0.21", 47311)
+	b := newLoopbackManager(t, dir, "127.0.0.22", 47312, WithGossipHops(2), WithGossipFanout(1))
+	others := []*manager{
+		newLoopbackManager(t, dir, "127.0.0.23", 47313),
+		newLoopbackManager(t, dir, "127.0.0.24", 47314),
+		newLoopbackManager(t, dir, "127.0.0.25", 47315),
+	}
+	hb := &recordHandler{}
+	b.RegisterEventHandler(hb)
+	hs := []*recordHandler{}
+	for _, pm := range append([]*manager{a, b}, others...) {
+		if pm != a && pm != b {
+			h := &recordHandler{}
+			hs = append(hs, h)
+			pm.RegisterEventHandler(h)
+		}
+		pm.StartManage()
+		defer pm.Stop()
+	}
+
+	p := connectLoopback(t, a, b, "127.0.0.22:47312")
+	connectLoopback(t, others[0], b, "127.0.0.22:47312")
+	connectLoopback(t, others[1], b, "127.0.0.22:47312")
+	// each of the peers of b has another peer to forward to
+	connectLoopback(t, others[2], others[0], "127.0.0.23:47313")
+	others[2].AddNode("127.0.0.24:47314")
+	for deadline := time.Now().Add(5 * time.Second); others[2].connections.Len() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("the managers are not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the remote asks to forward it to all of the peers in the max hops
+	bs, _ := encodeMessage(&recordMessage{body: []byte("cast")})
+	if err := a.sendGossipCast(p, hash.Hash(bs), maxGossipHops, 0, bs); err != nil {
+		t.Fatal(err)
+	}
+	if got := hb.wait(1, 5*time.Second); len(got) != 1 {
+		t.Fatalf("received by b = %v, want [cast]", got)
+	}
+	time.Sleep(500 * time.Millisecond)
+	received := 0
+	for _, h := range hs {
+		received += len(h.wait(0, 0))
+	}
+	if received != 1 {
+		t.Errorf("received by the others = %v, want 1 by the fanout and the hops of b", received)
+	}
+}
+
+// waitPeerList waits the peer list from the address which is received after the time and returns the time of it
+func waitPeerList(t *testing.T, pm *manager, addr string, after int64) int64 {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if since := pm.peerLists.sinceOf(addr); since > after {
+			return since
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the peer list is not received")
+	return 0
+}
+
+// knowsNode returns true when the node is stored or it is a candidate of the manager
+func knowsNode(pm *manager, addr string) bool {
+	if _, has := pm.candidates.load(addr); has {
+		return true
+	}
+	_, has := pm.nodes.Load(addr)
+	return has
+}
+
+func TestPeerListDiff(t *testing.T) {
+	dir := t.TempDir()
+	unthrottled := optionFunc(func(c *Config) {
+		c.PeerListInterval = -1
+		c.PeerListFilterRate = -1
+	})
+	a := newLoopbackManager(t, dir, "127.0.0.31", 47321, unthrottled)
+	b := newLoopbackManager(t, dir, "127.0.0.32", 47322, unthrottled)
+	a.nodes.Store("127.0.0.39:1", a.nodes.newConnectInfo("127.0.0.39:1", 0))
+	a.StartManage()
+	b.StartManage()
+	defer a.Stop()
+	defer b.Stop()
+
+	// the accepted side asks the lists because the dialer tells its outbound address without the AdvertiseAddr
+	connectLoopback(t, a, b, "127.0.0.32:47322")
+	ap, has := b.connections.Load("127.0.0.31:47321")
+	if !has {
+		t.Fatal("the peer is not connected")
+	}
+	p := ap.(*peer)
+	if p.Features()&router.FeaturePeerListDiff == 0 {
+		t.Fatal("the peer list diff is not negotiated")
+	}
+	since := waitPeerList(t, b, p.NetAddr(), 0)
+	if !knowsNode(b, "127.0.0.39:1") {
+		t.Fatal("the node of the full list is not received")
+	}
+
+	// the next list has only the nodes updated after the last one
+	a.nodes.Store("127.0.0.39:2", a.nodes.newConnectInfo("127.0.0.39:2", 0))
+	if diff := a.nodesSince(since, nil); len(diff) != 1 {
+		t.Errorf("nodesSince() = %v, want the updated node", diff)
+	}
+	b.requestPeerList(p)
+	next := waitPeerList(t, b, p.NetAddr(), since)
+	if !knowsNode(b, "127.0.0.39:2") {
+		t.Error("the node of the diff is not received")
+	}
+	if diff := a.nodesSince(next, nil); len(diff) != 0 {
+		t.Errorf("nodesSince() = %v, want empty after the diff", diff)
+	}
+}
+
+func TestPeerListFilterRetry(t *testing.T) {
+	dir := t.TempDir()
+	unthrottled := optionFunc(func(c *Config) {
+		c.PeerListInterval = -1
+	})
+	a := newLoopbackManager(t, dir, "127.0.0.41", 47331, unthrottled)
+	b := newLoopbackManager(t, dir, "127.0.0.42", 47332, unthrottled)
+	a.StartManage()
+	b.StartManage()
+	defer a.Stop()
+	defer b.Stop()
+
+	connectLoopback(t, a, b, "127.0.0.42:47332")
+	ap, has := b.connections.Load("127.0.0.41:47331")
+	if !has {
+		t.Fatal("the peer is not connected")
+	}
+	p := ap.(*peer)
+	since := waitPeerList(t, b, p.NetAddr(), 0)
+
+	// the filter of the request has the unknown node as a false positive
+	unknown := "127.0.0.49:1"
+	a.nodes.Store(unknown, a.nodes.newConnectInfo(unknown, 0))
+	filter := peermessage.NewBloom(1, 0.01, 1)
+	filter.Add(unknown)
+	if err := p.Send(&peermessage.PeerList{Request: true, From: b.advertiseAddr(p), Diff: true, Filter: filter}); err != nil {
+		t.Fatal(err)
+	}
+	since = waitPeerList(t, b, p.NetAddr(), since)
+	if knowsNode(b, unknown) {
+		t.Fatal("the node in the filter is answered")
+	}
+
+	// the later requests of the other seeds answer it
+	for i := 0; i < 5 && !knowsNode(b, unknown); i++ {
+		b.requestPeerList(p)
+		since = waitPeerList(t, b, p.NetAddr(), since)
+	}
+	if !knowsNode(b, unknown) {
+		t.Error("the node left out by the false positive is not answered by the later requests")
+	}
+}
+
+func TestNodeTimesClock(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	ns, err := newNodeStore(kvstore.NewMemory(), clk, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "127.0.0.51:3000"
+	ns.Store(addr, ns.newConnectInfo(addr, 0))
+	if firstSeen, lastSuccess := ns.Times(addr); firstSeen != clk.Now().UnixNano() || lastSuccess != 0 {
+		t.Errorf("Times() = %v, %v, want %v, 0", firstSeen, lastSuccess, clk.Now().UnixNano())
+	}
+	seen := clk.Now().UnixNano()
+	clk.Advance(time.Minute)
+	ns.StoreSuccess(addr, ns.newConnectInfo(addr, 0))
+	if firstSeen, lastSuccess := ns.Times(addr); firstSeen != seen || lastSuccess != clk.Now().UnixNano() {
+		t.Errorf("Times() = %v, %v, want %v, %v", firstSeen, lastSuccess, seen, clk.Now().UnixNano())
+	}
+
+	// the spooled messages are expired by the same clock
+	sp, err := newSpool(kvstore.NewMemory(), clk, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.Push(addr, []byte("old"))
+	clk.Advance(2 * time.Minute)
+	sp.Push(addr, []byte("new"))
+	list, err := sp.Pop(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || string(list[0]) != "new" {
+		t.Errorf("Pop() = %q, want [new]", list)
+	}
+}
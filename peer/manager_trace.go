@@ -0,0 +1,63 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/log"
+	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/router"
+)
+
+//TracePeer logs the frames and the handlers of the peer of the address verbosely for the duration,
+//so a problematic connection is debugged without the global debug logs. The zero duration stops the trace.
+func (pm *manager) TracePeer(addr string, d time.Duration) {
+	if d <= 0 {
+		pm.traces.Delete(addr)
+		log.Info("trace stopped ", addr)
+		return
+	}
+	pm.traces.Set(addr, pm.clock.Now().Add(d), d)
+	log.Info("trace ", addr, " for ", d)
+}
+
+//TracedPeers returns the addresses of the traced peers and the end times of their traces
+func (pm *manager) TracedPeers() map[string]time.Time {
+	traces := map[string]time.Time{}
+	pm.traces.Range(func(k interface{}, v interface{}) bool {
+		traces[k.(string)] = v.(time.Time)
+		return true
+	})
+	return traces
+}
+
+func (pm *manager) isTraced(addr string) bool {
+	return pm.traces.Has(addr)
+}
+
+func (pm *manager) trace(addr string, v ...interface{}) {
+	if pm.isTraced(addr) {
+		log.Info(append([]interface{}{"trace ", addr, " "}, v...)...)
+	}
+}
+
+//traceSend logs the frame which is written to the traced peer
+func (p *peer) traceSend(bs []byte, high bool, wait time.Duration, elapsed time.Duration, err error) {
+	if p.tracer == nil || !p.tracer(p.NetAddr()) {
+		return
+	}
+	name := ""
+	if len(bs) >= 8 {
+		name = message.NameOfType(message.Type(util.BytesToUint64(bs[:8])))
+	}
+	log.Info("trace ", p.NetAddr(), " send ", name, " ", len(bs), " bytes high ", high, " queued ", wait, " written ", elapsed, " err ", err)
+}
+
+//onRouterEvent logs the dials, the handshakes and the closes of the traced peers
+func (pm *manager) onRouterEvent(ev router.Event) {
+	if ev.Err != nil {
+		pm.trace(ev.Addr, "router ", ev.Kind, " err ", ev.Err)
+	} else {
+		pm.trace(ev.Addr, "router ", ev.Kind)
+	}
+}
@@ -2,30 +2,48 @@ package peer
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fletaio/common/util"
 	"github.com/fletaio/framework/log"
 	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/peer/peermessage"
 	"github.com/fletaio/framework/router"
 )
 
+//channelTypes are sent by the logical channels of the connection, so the application messages don't starve them
+var channelTypes = map[message.Type]uint8{
+	peermessage.PeerListMessageType:     router.ChannelPeerExchange,
+	peermessage.PeerListDiffMessageType: router.ChannelPeerExchange,
+	peermessage.ProbeMessageType:        router.ChannelControl,
+}
+
 //Peer is manages connections between nodes that cause logical connections.
 type Peer interface {
 	router.Conn
 	Send(m message.Message) error
+	SendData(m message.Message) error
+	SendBroadcast(m message.Message) error
+	Pending() int
 	PingTime() time.Duration
 	SetPingTime(t time.Duration)
 	ConnectedTime() int64
 	IsClose() bool
+	SetData(key string, value interface{})
+	GetData(key string) (interface{}, bool)
 	Remove()
 	NetAddr() string
 }
 
-type onRecv func(p *peer, t message.Type) error
+type onRecv func(p *peer, r io.Reader, t message.Type) error
 type peer struct {
 	router.Conn
+	ctx      context.Context
+	cancel   context.CancelFunc
 	pingTime time.Duration
 	score    int64
 	closed   bool
@@ -34,25 +52,41 @@ type peer struct {
 	connectedTime  int64
 	deletePeer     func(addr string)
 
-	writeLock          sync.Mutex
+	sched              *sendScheduler
 	onRecvEventHandler onRecv
+	tracer             func(addr string) bool
+	dataTypes          map[message.Type]bool
+	onSendError        func(p *peer, failures int, err error)
+	sendFailures       int32
+	downgraded         int32
+
+	dataLock sync.Mutex
+	data     map[string]interface{}
 }
 
-//NewPeer is the peer creator.
-func newPeer(conn router.Conn, pingTime time.Duration, deletePeer func(addr string), OnRecvEventHandler onRecv) *peer {
+//NewPeer is the peer creator, the connected time is taken from the clock of the manager.
+func newPeer(ctx context.Context, conn router.Conn, pingTime time.Duration, connected time.Time, deletePeer func(addr string), OnRecvEventHandler onRecv, targetCastRatio int) *peer {
+	ctx, cancel := context.WithCancel(ctx)
 	p := &peer{
 		Conn:               conn,
+		ctx:                ctx,
+		cancel:             cancel,
 		pingTime:           pingTime,
 		closed:             false,
 		deletePeer:         deletePeer,
-		connectedTime:      time.Now().UnixNano(),
+		connectedTime:      connected.UnixNano(),
 		onRecvEventHandler: OnRecvEventHandler,
+		sched:              newSendScheduler(targetCastRatio),
 	}
 
 	return p
 }
 
+// ID returns the verified public key id of the peer so that it survives the address changes, the address is used when the peer doesn't have a key
 func (p *peer) ID() string {
+	if id := p.Conn.RemoteID(); id != "" {
+		return id
+	}
 	return p.NetAddr()
 }
 
@@ -69,6 +103,11 @@ func (p *peer) NetAddr() string {
 	return p.Conn.ID()
 }
 
+// Context returns the context which is canceled when the peer is closed
+func (p *peer) Context() context.Context {
+	return p.ctx
+}
+
 func (p *peer) ConnectedTime() int64 {
 	return p.connectedTime
 }
@@ -81,8 +120,34 @@ func (p *peer) readPacket() {
 	defer func() {
 		p.Close()
 	}()
+	p.readFrom(p)
+}
+
+//readData reads the messages of the data plane connection after it is associated with the peer
+//The peer is kept when the data plane is closed and the data is sent by the primary connection.
+func (p *peer) readData() {
+	select {
+	case <-p.Conn.DataReady():
+	case <-p.ctx.Done():
+		return
+	}
+	if dc := p.Conn.Data(); dc != nil {
+		defer dc.Close()
+		p.readFrom(dc)
+	}
+}
+
+//readChannel reads the messages of the logical channel, the messages are read by the connection itself when the channel is not supported
+func (p *peer) readChannel(ch uint8) {
+	if c := p.Conn.Channel(ch); c != nil {
+		defer c.Close()
+		p.readFrom(c)
+	}
+}
+
+func (p *peer) readFrom(r io.Reader) {
 	for !p.closed {
-		t, n, err := util.ReadUint64(p)
+		t, n, err := util.ReadUint64(r)
 		if n != 0 && message.NameOfType(message.Type(t)) == "" {
 			log.Error("not defind message type recived", t)
 			return
@@ -97,7 +162,7 @@ func (p *peer) readPacket() {
 			log.Error("recv read type error : invalied packet length")
 			return
 		}
-		err = p.onRecvEventHandler(p, message.Type(t))
+		err = p.onRecvEventHandler(p, r, message.Type(t))
 		if err != nil {
 			log.Error("onRecv error : ", err)
 			return
@@ -106,29 +171,115 @@ func (p *peer) readPacket() {
 }
 
 //Send conveys a message to the connected node.
+//Send sends the message with the targeted priority
 func (p *peer) Send(m message.Message) error {
-	p.writeLock.Lock()
-	defer p.writeLock.Unlock()
+	if ch, has := channelTypes[m.Type()]; has {
+		return p.sendChannel(ch, m)
+	}
+	if p.dataTypes[m.Type()] {
+		return p.SendData(m)
+	}
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+	return p.sendRaw(bs, true)
+}
 
-	bf := bytes.Buffer{}
-	_, err := util.WriteUint64(&bf, uint64(m.Type()))
+//SendData sends the bulk message by the data plane connection so it doesn't delay the control messages.
+//It is sent by the primary connection when the data plane is not established.
+func (p *peer) SendData(m message.Message) error {
+	bs, err := encodeMessage(m)
 	if err != nil {
 		return err
 	}
-	_, err = m.WriteTo(&bf)
+	dc := p.Conn.Data()
+	if dc == nil {
+		return p.sendRaw(bs, true)
+	}
+	_, err = dc.Write(bs)
+	p.sendDone(err)
+	return err
+}
+
+//SendBroadcast sends the message with the broadcast priority which yields to the targeted messages under load
+func (p *peer) SendBroadcast(m message.Message) error {
+	if ch, has := channelTypes[m.Type()]; has {
+		return p.sendChannel(ch, m)
+	}
+	bs, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+	return p.sendRaw(bs, false)
+}
+
+//sendChannel sends the message by the logical channel apart from the queued sends of the peer.
+//It is sent as a targeted message when the channel is not supported.
+func (p *peer) sendChannel(ch uint8, m message.Message) error {
+	bs, err := encodeMessage(m)
 	if err != nil {
 		return err
 	}
+	c := p.Conn.Channel(ch)
+	if c == nil {
+		return p.sendRaw(bs, true)
+	}
+	_, err = c.Write(bs)
+	p.sendDone(err)
+	return err
+}
+
+func (p *peer) sendRaw(bs []byte, high bool) error {
+	start := time.Now()
+	p.sched.acquire(high)
+	defer p.sched.release()
 
-	_, err = p.Write(bf.Bytes())
+	written := time.Now()
+	_, err := p.Write(bs)
+	p.traceSend(bs, high, written.Sub(start), time.Now().Sub(written), err)
+	p.sendDone(err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-//PingTime return pingTime
+//sendDone counts the consecutive failed sends and reports them to the manager
+func (p *peer) sendDone(err error) {
+	if err == nil {
+		atomic.StoreInt32(&p.sendFailures, 0)
+		return
+	}
+	n := atomic.AddInt32(&p.sendFailures, 1)
+	if p.onSendError != nil {
+		p.onSendError(p, int(n), err)
+	}
+}
+
+//Pending returns the number of the sends in the outbound queue of the peer including the one being written
+func (p *peer) Pending() int {
+	return p.sched.pending()
+}
+
+func encodeMessage(m message.Message) ([]byte, error) {
+	bf := bytes.Buffer{}
+	_, err := util.WriteUint64(&bf, uint64(m.Type()))
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.WriteTo(&bf)
+	if err != nil {
+		return nil, err
+	}
+	return bf.Bytes(), nil
+}
+
+//PingTime returns the smoothed round trip time of the connection, it is the ping time of the handshake until the connection is probed
 func (p *peer) PingTime() time.Duration {
+	if q := p.Conn.Quality(); q.Samples > 0 {
+		return q.RTT
+	}
 	return p.pingTime
 }
 
@@ -155,8 +306,44 @@ func (p *peer) Remove() {
 //Close is used to break logical connections and delete stored peer data.
 func (p *peer) Close() error {
 	p.closed = true
+	p.cancel()
 	p.deletePeer(p.NetAddr())
 	p.Conn.Close()
+	p.clearData()
 
 	return nil
 }
+
+//SetData attaches the application data of the key to the peer, it is removed when the peer is disconnected
+func (p *peer) SetData(key string, value interface{}) {
+	p.dataLock.Lock()
+	defer p.dataLock.Unlock()
+
+	if p.data == nil {
+		p.data = map[string]interface{}{}
+	}
+	p.data[key] = value
+}
+
+//GetData returns the application data of the key
+func (p *peer) GetData(key string) (interface{}, bool) {
+	p.dataLock.Lock()
+	defer p.dataLock.Unlock()
+
+	v, has := p.data[key]
+	return v, has
+}
+
+//clearData removes the application data after the disconnected events and closes the data which is an io.Closer
+func (p *peer) clearData() {
+	p.dataLock.Lock()
+	data := p.data
+	p.data = nil
+	p.dataLock.Unlock()
+
+	for _, v := range data {
+		if c, ok := v.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package peer
+
+import (
+	"sync"
+)
+
+//sendScheduler grants the write turns of a peer to the waiting senders in order.
+//The targeted senders get ratio turns for each turn of the broadcast senders while both are waiting.
+type sendScheduler struct {
+	l      sync.Mutex
+	busy   bool
+	ratio  int
+	streak int
+	high   []chan struct{}
+	low    []chan struct{}
+}
+
+func newSendScheduler(ratio int) *sendScheduler {
+	if ratio <= 0 {
+		ratio = 4
+	}
+	return &sendScheduler{
+		ratio: ratio,
+		high:  []chan struct{}{},
+		low:   []chan struct{}{},
+	}
+}
+
+//acquire waits the turn, the targeted sender has the high priority
+func (s *sendScheduler) acquire(high bool) {
+	s.l.Lock()
+	if !s.busy {
+		s.busy = true
+		s.l.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	if high {
+		s.high = append(s.high, ch)
+	} else {
+		s.low = append(s.low, ch)
+	}
+	s.l.Unlock()
+	<-ch
+}
+
+//release hands the turn over to the next sender
+func (s *sendScheduler) release() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	var ch chan struct{}
+	if len(s.high) > 0 && (len(s.low) == 0 || s.streak < s.ratio) {
+		ch, s.high = s.high[0], s.high[1:]
+		if len(s.low) > 0 {
+			s.streak++
+		}
+	} else if len(s.low) > 0 {
+		ch, s.low = s.low[0], s.low[1:]
+		s.streak = 0
+	} else {
+		s.busy = false
+		return
+	}
+	close(ch)
+}
+
+//pending returns the number of the senders which hold or wait the turn
+func (s *sendScheduler) pending() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	n := len(s.high) + len(s.low)
+	if s.busy {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+package peermessage
+
+import (
+	"hash/fnv"
+	"io"
+	"math"
+
+	"github.com/fletaio/common/util"
+)
+
+// bounds of the bloom filter which is read from the peer
+const (
+	MaxBloomBytes  = 64 * 1024
+	MaxBloomHashes = 16
+)
+
+// Bloom is the bloom filter of the addresses which the requester of the peer list knows, the responder doesn't send them.
+// The Seed is chosen for each request so the false positives of a request are sent by the next one.
+type Bloom struct {
+	Seed   uint32
+	Hashes uint8
+	Bits   []byte
+}
+
+// NewBloom returns the Bloom sized for the count of the addresses at the false positive rate, it is capped by MaxBloomBytes
+func NewBloom(count int, rate float64, seed uint32) *Bloom {
+	if count < 1 {
+		count = 1
+	}
+	bits := math.Ceil(-float64(count) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	size := int(math.Ceil(bits / 8))
+	if size < 1 {
+		size = 1
+	} else if size > MaxBloomBytes {
+		size = MaxBloomBytes
+	}
+	k := int(math.Round(float64(size*8) / float64(count) * math.Ln2))
+	if k < 1 {
+		k = 1
+	} else if k > MaxBloomHashes {
+		k = MaxBloomHashes
+	}
+	return &Bloom{
+		Seed:   seed,
+		Hashes: uint8(k),
+		Bits:   make([]byte, size),
+	}
+}
+
+// positions calls the function with the bit positions of the address by the double hashing
+func (b *Bloom) positions(addr string, f func(pos uint32) bool) bool {
+	h := fnv.New64a()
+	h.Write(util.Uint32ToBytes(b.Seed))
+	h.Write([]byte(addr))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	m := uint32(len(b.Bits) * 8)
+	for i := uint32(0); i < uint32(b.Hashes); i++ {
+		if !f((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Add adds the address to the filter
+func (b *Bloom) Add(addr string) {
+	b.positions(addr, func(pos uint32) bool {
+		b.Bits[pos/8] |= 1 << (pos % 8)
+		return true
+	})
+}
+
+// Has returns true when the address is possibly added, the empty filter has nothing
+func (b *Bloom) Has(addr string) bool {
+	if len(b.Bits) == 0 || b.Hashes == 0 {
+		return false
+	}
+	return b.positions(addr, func(pos uint32) bool {
+		return b.Bits[pos/8]&(1<<(pos%8)) != 0
+	})
+}
+
+// WriteTo is a serialization function
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	var wrote int64
+	{
+		n, err := util.WriteUint32(w, b.Seed)
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+	}
+	{
+		n, err := util.WriteUint8(w, b.Hashes)
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+	}
+	{
+		n, err := util.WriteUint32(w, uint32(len(b.Bits)))
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+
+		nint, err := w.Write(b.Bits)
+		if err != nil {
+			return wrote, err
+		}
+		wrote += int64(nint)
+	}
+	return wrote, nil
+}
+
+// ReadFrom is a deserialization function
+func (b *Bloom) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	{
+		v, n, err := util.ReadUint32(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		b.Seed = v
+	}
+	{
+		v, n, err := util.ReadUint8(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		if v > MaxBloomHashes {
+			return read, ErrTooLargeBloom
+		}
+		b.Hashes = v
+	}
+	{
+		v, n, err := util.ReadUint32(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		if v > MaxBloomBytes {
+			return read, ErrTooLargeBloom
+		}
+		b.Bits = make([]byte, v)
+		nint, err := util.FillBytes(r, b.Bits)
+		if err != nil {
+			return read, err
+		}
+		read += int64(nint)
+	}
+	return read, nil
+}
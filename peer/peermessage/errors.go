@@ -0,0 +1,8 @@
+package peermessage
+
+import "errors"
+
+// errors
+var (
+	ErrTooLargeBloom = errors.New("too large bloom filter")
+)
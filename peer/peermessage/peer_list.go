@@ -9,10 +9,16 @@ import (
 )
 
 // PeerList is struct of peer list
+// The Diff list is sent to the peer which supports router.FeaturePeerListDiff, its Since is the time of the peer which answers the list.
+// The request asks the nodes updated after the Since and the answer carries the time of the list for the next request.
+// The Filter of the Diff request is the nodes which the requester knows, they are not answered.
 type PeerList struct {
 	Request bool
 	From    string
 	List    map[string]ConnectInfo
+	Diff    bool
+	Since   int64
+	Filter  *Bloom
 }
 
 // ConnectInfo is a structure of connection information that includes ping time and score board.
@@ -95,12 +101,16 @@ func (ci *ConnectInfo) ReadFrom(r io.Reader) (int64, error) {
 
 // Score is calculated and returned based on the ping time.
 func (ci *ConnectInfo) Score() (score int64) {
+	var count int64
 	ci.PingScoreBoard.Range(func(addr string, t time.Duration) bool {
 		score += int64(t)
+		count++
 		return true
 	})
-	score /= int64(ci.PingScoreBoard.Len())
-	return score
+	if count == 0 {
+		return 0
+	}
+	return score / count
 }
 
 const (
@@ -117,11 +127,24 @@ func PeerListCreator(r io.Reader, mt message.Type) (message.Message, error) {
 	return p, nil
 }
 
+// PeerListDiffCreator reconstructs the Diff PeerList from the Reader.
+func PeerListDiffCreator(r io.Reader, mt message.Type) (message.Message, error) {
+	p := &PeerList{Diff: true}
+	if _, err := p.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 // PeerListMessageType is define message type
 var PeerListMessageType message.Type
 
+// PeerListDiffMessageType is the message type of the Diff PeerList
+var PeerListDiffMessageType message.Type
+
 func init() {
 	PeerListMessageType = message.DefineType("PeerList")
+	PeerListDiffMessageType = message.DefineType("PeerListDiff")
 }
 
 // SendRequestPeerList transfers the peer list structure to a given peer
@@ -136,6 +159,9 @@ func SendRequestPeerList(p message.Sender, from string) {
 // Type is the basic function of "message".
 // Returns the type of message.
 func (p *PeerList) Type() message.Type {
+	if p.Diff {
+		return PeerListDiffMessageType
+	}
 	return PeerListMessageType
 }
 
@@ -175,6 +201,24 @@ func (p *PeerList) WriteTo(w io.Writer) (int64, error) {
 		}
 	}
 
+	if p.Diff {
+		n, err := util.WriteUint64(w, uint64(p.Since))
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+
+		filter := &Bloom{}
+		if p.Filter != nil {
+			filter = p.Filter
+		}
+		n, err = filter.WriteTo(w)
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+	}
+
 	return wrote, nil
 }
 
@@ -222,5 +266,24 @@ func (p *PeerList) ReadFrom(r io.Reader) (int64, error) {
 		p.List = list
 	}
 
+	if p.Diff {
+		v, n, err := util.ReadUint64(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		p.Since = int64(v)
+
+		filter := &Bloom{}
+		n, err = filter.ReadFrom(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		if len(filter.Bits) > 0 {
+			p.Filter = filter
+		}
+	}
+
 	return read, nil
 }
@@ -5,30 +5,89 @@ import (
 	"time"
 )
 
+// score board bounds
+const (
+	DefaultScoreBoardSize   = 64
+	DefaultScoreBoardMaxAge = time.Hour
+)
+
 //ScoreBoardMap is the structure that stores the ping time of each peer.
+//It keeps the maxSize recent entries which are updated in the maxAge (the defaults when they are zero, negative is unlimited).
 type ScoreBoardMap struct {
-	l sync.Mutex
-	m map[string]time.Duration
+	l       sync.Mutex
+	m       map[string]scoreEntry
+	maxSize int
+	maxAge  time.Duration
+}
+
+type scoreEntry struct {
+	pingTime  time.Duration
+	updatedAt time.Time
+}
+
+// NewScoreBoardMap returns a ScoreBoardMap which has the bounds
+func NewScoreBoardMap(maxSize int, maxAge time.Duration) *ScoreBoardMap {
+	return &ScoreBoardMap{
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+}
+
+func (n *ScoreBoardMap) size() int {
+	if n.maxSize == 0 {
+		return DefaultScoreBoardSize
+	}
+	return n.maxSize
+}
+
+func (n *ScoreBoardMap) age() time.Duration {
+	if n.maxAge == 0 {
+		return DefaultScoreBoardMaxAge
+	}
+	return n.maxAge
+}
+
+func (n *ScoreBoardMap) isStale(e scoreEntry, now time.Time) bool {
+	age := n.age()
+	return age > 0 && now.Sub(e.updatedAt) > age
 }
 
 // Len returns the length of this map.
 func (n *ScoreBoardMap) Len() int {
 	n.l.Lock()
 	defer n.l.Unlock()
+
+	n.unsafePrune(time.Now())
 	return len(n.m)
 }
 
-// Store sets the value for a key.
+// Store sets the value for a key, the oldest entry is evicted when the map is full.
 func (n *ScoreBoardMap) Store(key string, value time.Duration, test string) {
 	n.l.Lock()
-	if 0 == len(n.m) {
-		n.m = map[string]time.Duration{
-			key: value,
+	defer n.l.Unlock()
+
+	now := time.Now()
+	if n.m == nil {
+		n.m = map[string]scoreEntry{}
+	}
+	n.m[key] = scoreEntry{
+		pingTime:  value,
+		updatedAt: now,
+	}
+	if size := n.size(); size > 0 && len(n.m) > size {
+		n.unsafePrune(now)
+		for len(n.m) > size {
+			var oldest string
+			var oldestAt time.Time
+			for k, e := range n.m {
+				if oldestAt.IsZero() || e.updatedAt.Before(oldestAt) {
+					oldest = k
+					oldestAt = e.updatedAt
+				}
+			}
+			delete(n.m, oldest)
 		}
-	} else {
-		n.m[key] = value
 	}
-	n.l.Unlock()
 }
 
 // Load returns the value stored in the map for a key, or nil if no
@@ -37,8 +96,11 @@ func (n *ScoreBoardMap) Store(key string, value time.Duration, test string) {
 func (n *ScoreBoardMap) Load(key string) (time.Duration, bool) {
 	n.l.Lock()
 	defer n.l.Unlock()
-	v, has := n.m[key]
-	return v, has
+	e, has := n.m[key]
+	if !has || n.isStale(e, time.Now()) {
+		return 0, false
+	}
+	return e.pingTime, true
 }
 
 // Delete deletes the value for a key.
@@ -49,14 +111,37 @@ func (n *ScoreBoardMap) Delete(key string) {
 	delete(n.m, key)
 }
 
+// Prune removes the stale entries and returns the number of them.
+func (n *ScoreBoardMap) Prune() int {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	return n.unsafePrune(time.Now())
+}
+
+func (n *ScoreBoardMap) unsafePrune(now time.Time) int {
+	count := 0
+	for k, e := range n.m {
+		if n.isStale(e, now) {
+			delete(n.m, k)
+			count++
+		}
+	}
+	return count
+}
+
 // Range calls f sequentially for each key and value present in the map.
 // If f returns false, range stops the iteration.
 func (n *ScoreBoardMap) Range(f func(string, time.Duration) bool) {
 	n.l.Lock()
 	defer n.l.Unlock()
 
-	for key, value := range n.m {
-		if !f(key, value) {
+	now := time.Now()
+	for key, e := range n.m {
+		if n.isStale(e, now) {
+			continue
+		}
+		if !f(key, e.pingTime) {
 			break
 		}
 	}
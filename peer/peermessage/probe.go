@@ -0,0 +1,111 @@
+package peermessage
+
+import (
+	"io"
+
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/message"
+)
+
+// Probe measures the propagation latency of the network.
+// The origin floods the probe up to the TTL hops and every receiver echoes it back along the reverse path,
+// so the origin measures the round trip time to each receiver without synchronized clocks.
+type Probe struct {
+	ID       uint64
+	Origin   string
+	Receiver string
+	Hops     uint8
+	TTL      uint8
+	Echo     bool
+}
+
+// ProbeMessageType is define message type
+var ProbeMessageType message.Type
+
+func init() {
+	ProbeMessageType = message.DefineType("Probe")
+}
+
+// ProbeCreator reconstructs the Probe from the Reader.
+func ProbeCreator(r io.Reader, mt message.Type) (message.Message, error) {
+	p := &Probe{}
+	if _, err := p.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Type is the basic function of "message".
+// Returns the type of message.
+func (p *Probe) Type() message.Type {
+	return ProbeMessageType
+}
+
+// WriteTo is a serialization function
+func (p *Probe) WriteTo(w io.Writer) (int64, error) {
+	var wrote int64
+	{
+		n, err := util.WriteUint64(w, p.ID)
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+	}
+	for _, s := range []string{p.Origin, p.Receiver} {
+		n, err := util.WriteString(w, s)
+		if err != nil {
+			return wrote, err
+		}
+		wrote += n
+	}
+	{
+		echo := uint8(requestFalse)
+		if p.Echo {
+			echo = uint8(requestTrue)
+		}
+		for _, v := range []uint8{p.Hops, p.TTL, echo} {
+			n, err := util.WriteUint8(w, v)
+			if err != nil {
+				return wrote, err
+			}
+			wrote += n
+		}
+	}
+	return wrote, nil
+}
+
+// ReadFrom is a deserialization function
+func (p *Probe) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	{
+		v, n, err := util.ReadUint64(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		p.ID = v
+	}
+	for _, s := range []*string{&p.Origin, &p.Receiver} {
+		v, n, err := util.ReadString(r)
+		if err != nil {
+			return read, err
+		}
+		read += n
+		*s = v
+	}
+	{
+		vs := make([]uint8, 3)
+		for i := range vs {
+			v, n, err := util.ReadUint8(r)
+			if err != nil {
+				return read, err
+			}
+			read += n
+			vs[i] = v
+		}
+		p.Hops = vs[0]
+		p.TTL = vs[1]
+		p.Echo = vs[2] == requestTrue
+	}
+	return read, nil
+}
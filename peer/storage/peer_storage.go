@@ -60,9 +60,13 @@ type peerStorage struct {
 // PeerStorage is a list of functions to be exposed to external sources.
 type PeerStorage interface {
 	Add(peer Peer, scoreFunc Score) bool
+	Remove(addr string) bool
 	List() []string
 	Have(addr string) bool
 	NotEnoughPeer() bool
+	Len() int
+	Regroup() int
+	Scores() []ScoreComponents
 }
 
 // Peer is a functional list of Peer structures to be used internally.
@@ -97,18 +101,35 @@ type peerInfomation struct {
 	affiliation    peerGroupType
 }
 
-func (p *peerInfomation) score() (t time.Duration) {
-	t = p.p.PingTime()
+// ScoreComponents are the parts of the score of a group member, the lower score is preferred.
+// Ping is the round trip time, Stability is the bonus of the time in the group and of the affiliation to another group,
+// and Usefulness is the average score of the group which the other nodes reported when the peer was added.
+type ScoreComponents struct {
+	ID         string
+	Group      int
+	Ping       time.Duration
+	Stability  time.Duration
+	Usefulness time.Duration
+	Score      time.Duration
+}
 
+func (p *peerInfomation) components() ScoreComponents {
+	sc := ScoreComponents{
+		ID:         p.p.ID(),
+		Group:      int(p.affiliation),
+		Ping:       p.p.PingTime(),
+		Stability:  -time.Now().Sub(p.registeredTime) / 1000,
+		Usefulness: p.advantage.score[p.affiliation],
+	}
 	if p.group != p.affiliation {
-		t -= distance3
+		sc.Stability -= distance3
 	}
+	sc.Score = sc.Ping + sc.Stability + sc.Usefulness
+	return sc
+}
 
-	t -= time.Now().Sub(p.registeredTime) / 1000
-
-	t += p.advantage.score[p.affiliation]
-
-	return
+func (p *peerInfomation) score() time.Duration {
+	return p.components().Score
 }
 
 //Add a new peer
@@ -119,6 +140,7 @@ func (ps *peerStorage) Add(p Peer, score Score) (inserted bool) {
 	ps.mapLock.RLock()
 	if _, has := ps.peerMap[addr]; has {
 		ps.updatePingtime(addr)
+		ps.mapLock.RUnlock()
 		return
 	}
 	ps.mapLock.RUnlock()
@@ -129,13 +151,7 @@ func (ps *peerStorage) Add(p Peer, score Score) (inserted bool) {
 		advantage:      advantage,
 		registeredTime: time.Now(),
 	}
-	if pi.p.PingTime() < distance1 {
-		pi.group = 1
-	} else if pi.p.PingTime() < distance2 {
-		pi.group = 2
-	} else {
-		pi.group = 3
-	}
+	pi.group = groupOf(pi.p.PingTime())
 
 	ps.mapLock.Lock()
 	defer ps.mapLock.Unlock()
@@ -143,6 +159,63 @@ func (ps *peerStorage) Add(p Peer, score Score) (inserted bool) {
 	return ps.insertSort(pi)
 }
 
+func groupOf(pingTime time.Duration) peerGroupType {
+	if pingTime < distance1 {
+		return group1
+	} else if pingTime < distance2 {
+		return group2
+	}
+	return group3
+}
+
+//Regroup moves the peers whose ping times are changed over the distance into the group of the ping time.
+//It returns the number of the moved peers.
+func (ps *peerStorage) Regroup() int {
+	ps.mapLock.Lock()
+	defer ps.mapLock.Unlock()
+
+	moved := []*peerInfomation{}
+	for _, pi := range ps.peerMap {
+		if g := groupOf(pi.p.PingTime()); g != pi.group {
+			pi.group = g
+			moved = append(moved, pi)
+		}
+	}
+	for _, pi := range moved {
+		ps.unsafeRemove(pi)
+	}
+	for _, pi := range moved {
+		ps.insertSort(pi)
+	}
+	return len(moved)
+}
+
+//Remove deletes the peer from the group and pulls up the following peers of the group.
+func (ps *peerStorage) Remove(addr string) bool {
+	ps.mapLock.Lock()
+	defer ps.mapLock.Unlock()
+
+	pi, has := ps.peerMap[addr]
+	if !has {
+		return false
+	}
+	ps.unsafeRemove(pi)
+	return true
+}
+
+func (ps *peerStorage) unsafeRemove(pi *peerInfomation) {
+	delete(ps.peerMap, pi.p.ID())
+
+	nl := ps.peerGroup[pi.affiliation]
+	for i, v := range nl {
+		if v == pi {
+			copy(nl[i:], nl[i+1:])
+			nl[groupLength-1] = nil
+			break
+		}
+	}
+}
+
 //List returns the peers that are included in the group in order.
 func (ps *peerStorage) NotEnoughPeer() bool {
 	if ps.peerGroup[group1][groupLength-1] == nil {
@@ -161,6 +234,22 @@ func (ps *peerStorage) NotEnoughPeer() bool {
 	return false
 }
 
+//Scores returns the score components of the group members in order
+func (ps *peerStorage) Scores() []ScoreComponents {
+	ps.mapLock.RLock()
+	defer ps.mapLock.RUnlock()
+
+	list := []ScoreComponents{}
+	for _, g := range []peerGroupType{group1, group2, group3} {
+		for _, pi := range ps.peerGroup[g] {
+			if pi != nil {
+				list = append(list, pi.components())
+			}
+		}
+	}
+	return list
+}
+
 //List returns the peers that are included in the group in order.
 func (ps *peerStorage) List() []string {
 	list := make([]string, 0)
@@ -188,6 +277,20 @@ func (ps *peerStorage) List() []string {
 	return list
 }
 
+//Len returns the number of the connected peers in the groups.
+func (ps *peerStorage) Len() int {
+	ps.mapLock.RLock()
+	defer ps.mapLock.RUnlock()
+
+	count := 0
+	for _, pi := range ps.peerMap {
+		if pi.p != nil && !pi.p.IsClose() {
+			count++
+		}
+	}
+	return count
+}
+
 //Have indicates whether the group peer is included or not.
 func (ps *peerStorage) Have(addr string) bool {
 	ps.mapLock.RLock()
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"github.com/fletaio/framework/admin"
+)
+
+// RegisterAdmin adds the router methods to the admin endpoint
+func (r *router) RegisterAdmin(am *admin.Manager) {
+	am.Add("router.connStats", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		if arg.Len() == 0 {
+			return r.allConnStats(), nil
+		}
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		return r.ConnStats(addr)
+	})
+	am.Add("router.ping", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		rtt, err := r.Ping(addr)
+		if err != nil {
+			return nil, err
+		}
+		return rtt.String(), nil
+	})
+	am.Add("router.pendingHandshakes", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return r.PendingHandshakes(), nil
+	})
+	am.Add("router.blacklisted", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return r.Blacklisted(), nil
+	})
+	am.Add("router.blacklist", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Blacklist(addr); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+	am.Add("router.unblacklist", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Unblacklist(addr); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+}
@@ -20,4 +20,33 @@ var (
 	ErrWriteTimeout              = errors.New("write timeout")
 	ErrNotHandshakeFormate       = errors.New("not handshake formate")
 	ErrPeerTimeout               = errors.New("peer timeout")
+	ErrAlreadyRegisteredCoord    = errors.New("already registered coordinate")
+	ErrTooManyCoords             = errors.New("too many coordinates")
+	ErrDialTimeout               = errors.New("dial timeout")
+	ErrHandshakeTimeout          = errors.New("handshake timeout")
+	ErrTooLargeBody              = errors.New("too large body")
+	ErrTooLargeExtension         = errors.New("too large extension")
+	ErrInvalidExtension          = errors.New("invalid extension")
+	ErrRouterClosed              = errors.New("router closed")
+	ErrRedialBackoff             = errors.New("redial backoff")
+	ErrMismatchNetworkMagic      = errors.New("mismatch network magic")
+	ErrTooLargeNetworkMagic      = errors.New("too large network magic")
+	ErrInvalidSignature          = errors.New("invalid signature")
+	ErrInvalidPrivateKey         = errors.New("invalid private key")
+	ErrDeniedAddress             = errors.New("denied address")
+	ErrTooManyConnections        = errors.New("too many connections")
+	ErrListenerClosed            = errors.New("listener closed")
+	ErrInvalidSourcePortRange    = errors.New("invalid source port range")
+	ErrInvalidAdvertiseAddr      = errors.New("invalid advertise address")
+	ErrNotResolved               = errors.New("not resolved")
+	ErrBlacklisted               = errors.New("blacklisted")
+	ErrReplayedHandshake         = errors.New("replayed handshake")
+	ErrNoPrimaryConn             = errors.New("no primary connection")
+	ErrPingNotSupported          = errors.New("ping not supported")
+	ErrPingTimeout               = errors.New("ping timeout")
+	ErrInvalidLocalAddr          = errors.New("invalid local address")
+	ErrNoInterfaceAddr           = errors.New("no address of the interface")
+	ErrInvalidProxyHeader        = errors.New("invalid proxy header")
+	ErrHandshakeOverload         = errors.New("handshake overload")
+	ErrNoProxyCIDRs              = errors.New("no proxy cidrs")
 )
@@ -0,0 +1,60 @@
+package evilnode
+
+import (
+	"github.com/fletaio/framework/admin"
+)
+
+// RegisterAdmin adds the evil score methods to the admin endpoint
+func (r *Manager) RegisterAdmin(am *admin.Manager) {
+	am.Add("evilnode.scores", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		return r.Scores(), nil
+	})
+	am.Add("evilnode.score", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		return r.Score(addr)
+	})
+	am.Add("evilnode.adjust", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		delta, err := arg.Int(1)
+		if err != nil {
+			return nil, err
+		}
+		return r.AdjustScore(addr, delta)
+	})
+	am.Add("evilnode.adjustAll", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		delta, err := arg.Int(0)
+		if err != nil {
+			return nil, err
+		}
+		deltas := map[string]int{}
+		if err := r.List.Range(func(pi ConnectionInfo) bool {
+			deltas[pi.Addr] = delta
+			return true
+		}); err != nil {
+			return nil, err
+		}
+		return r.AdjustScores(deltas)
+	})
+	am.Add("evilnode.reset", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		addr, err := arg.String(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Reset(addr); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+	am.Add("evilnode.resetAll", func(ID interface{}, arg *admin.Argument) (interface{}, error) {
+		if err := r.ResetAll(); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+}
@@ -2,16 +2,13 @@ package evilnode
 
 import (
 	"bytes"
-	"os"
-	"path/filepath"
-	"time"
 
-	"github.com/dgraph-io/badger"
+	"github.com/fletaio/framework/kvstore"
 )
 
 // ConnList stores information for peers even connected at least once.
 type ConnList struct {
-	db *badger.DB
+	store kvstore.KVStore
 }
 
 //evilScoreTable
@@ -22,46 +19,44 @@ const (
 
 // NewConnList is creator of physical Connection list
 func NewConnList(dbpath string) (*ConnList, error) {
-	db, err := openNodesDB(dbpath)
+	store, err := kvstore.OpenBadger(dbpath)
 	if err != nil {
 		return nil, err
 	}
-	n := &ConnList{
-		db: db,
+	return NewConnListWithStore(store), nil
+}
+
+// NewConnListWithStore returns the physical Connection list which is kept in the store
+func NewConnListWithStore(store kvstore.KVStore) *ConnList {
+	return &ConnList{
+		store: store,
 	}
-	return n, nil
 }
 
 // Store is strore the ConnectionInfo
 func (pl *ConnList) Store(v ConnectionInfo) error {
-	err := pl.db.Update(func(txn *badger.Txn) error {
+	bf := bytes.Buffer{}
+	v.WriteTo(&bf)
+	return pl.store.Put([]byte(v.Addr), bf.Bytes())
+}
+
+// StoreBatch stores the ConnectionInfos in a transaction of the store
+func (pl *ConnList) StoreBatch(list []ConnectionInfo) error {
+	keys := make([][]byte, 0, len(list))
+	values := make([][]byte, 0, len(list))
+	for _, v := range list {
 		bf := bytes.Buffer{}
 		v.WriteTo(&bf)
-		if err := txn.Set([]byte(v.Addr), bf.Bytes()); err != nil {
-			return err
-		}
-		return nil
-	})
-
-	return err
+		keys = append(keys, []byte(v.Addr))
+		values = append(values, bf.Bytes())
+	}
+	return kvstore.PutBatch(pl.store, keys, values)
 }
 
-// Get is returned strored ConnectionInfo
+// Get is returned strored ConnectionInfo, the error is kvstore.ErrNotFound when it is not stored
 func (pl *ConnList) Get(addr string) (p ConnectionInfo, err error) {
-	var v []byte
-	if err2 := pl.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(addr))
-		if err != nil {
-			return err
-		}
-		value, err := item.ValueCopy(nil)
-		v = value
-		if err != nil {
-			return err
-		}
-		return nil
-	}); err2 != nil {
-		err = err2
+	v, err := pl.store.Get([]byte(addr))
+	if err != nil {
 		return
 	}
 	bf := bytes.NewBuffer(v)
@@ -69,41 +64,44 @@ func (pl *ConnList) Get(addr string) (p ConnectionInfo, err error) {
 	return
 }
 
-func openNodesDB(dbPath string) (*badger.DB, error) {
-	opts := badger.DefaultOptions
-	opts.Dir = dbPath
-	opts.ValueDir = dbPath
-	opts.Truncate = true
-	opts.SyncWrites = true
-	opts.ValueLogFileSize = 1 << 24
-	lockfilePath := filepath.Join(opts.Dir, "LOCK")
-	os.MkdirAll(dbPath, os.ModeDir)
+// Close flushes and closes the store
+func (pl *ConnList) Close() error {
+	return pl.store.Close()
+}
 
-	os.Remove(lockfilePath)
+// Delete removes the ConnectionInfo of the address
+func (pl *ConnList) Delete(addr string) error {
+	return pl.store.Delete([]byte(addr))
+}
 
-	db, err := badger.Open(opts)
-	if err != nil {
-		return nil, err
+// Clear removes all stored ConnectionInfo
+func (pl *ConnList) Clear() error {
+	keys := [][]byte{}
+	if err := pl.store.Iterate(func(key []byte, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
 	}
-
-	{
-	again:
-		if err := db.RunValueLogGC(0.7); err != nil {
-		} else {
-			goto again
+	for _, k := range keys {
+		if err := pl.store.Delete(k); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	ticker := time.NewTicker(5 * time.Minute)
-	go func() {
-		for range ticker.C {
-		again:
-			if err := db.RunValueLogGC(0.7); err != nil {
-			} else {
-				goto again
-			}
+// Range calls f for all stored ConnectionInfo until f returns false
+func (pl *ConnList) Range(f func(ConnectionInfo) bool) error {
+	var err error
+	if e := pl.store.Iterate(func(key []byte, value []byte) bool {
+		var p ConnectionInfo
+		if _, err = p.ReadFrom(bytes.NewReader(value)); err != nil {
+			return false
 		}
-	}()
-
-	return db, nil
+		return f(p)
+	}); e != nil {
+		return e
+	}
+	return err
 }
@@ -2,16 +2,30 @@ package evilnode
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
 	"github.com/fletaio/framework/log"
-	"github.com/dgraph-io/badger"
 )
 
 // Config is router config
 type Config struct {
 	StorePath    string
 	BanEvilScore uint16
+	// StoreBackend is the kvstore backend of the StorePath (badger when it is empty)
+	// and Store is used instead of opening the StorePath when it is not nil, so the embedder can share its database
+	StoreBackend string
+	Store        kvstore.KVStore
+	// StoreKey encrypts the store at rest, the StoreKeyEnv environment variable of the kvstore is used when it is empty
+	// and the store is kept in plaintext when both are empty
+	StoreKey string
+	// Clock is the time source of the ban expiry and the score reduction, the real clock is used when it is nil
+	Clock clock.Clock
 }
 
 // NoticeEvil is interface for notifies the registered object when the evil node appears.
@@ -24,6 +38,9 @@ type Manager struct {
 	Config     *Config
 	List       *ConnList
 	NoticeList map[string]NoticeEvil
+	clock      clock.Clock
+	eventLock  sync.Mutex
+	events     map[KindOfEvil]uint64
 }
 
 // KindOfEvil is define evil table type
@@ -31,9 +48,21 @@ type KindOfEvil uint16
 
 // evilnode evil score table
 const (
-	BadBehaviour KindOfEvil = 40
+	BadBehaviour  KindOfEvil = 40
+	SlowHandshake KindOfEvil = 20
 )
 
+func (k KindOfEvil) String() string {
+	switch k {
+	case BadBehaviour:
+		return "bad-behaviour"
+	case SlowHandshake:
+		return "slow-handshake"
+	default:
+		return fmt.Sprintf("evil-%d", uint16(k))
+	}
+}
+
 const reduceEvilScorePerMinute uint16 = 1
 
 // NewManager is creator of evilnode Manager
@@ -47,15 +76,29 @@ func NewManager(c *Config) *Manager {
 		c.StorePath = "./_data/router/"
 	}
 
-	pl, err := NewConnList(c.StorePath)
-	if err != nil {
-		panic(err)
+	store := c.Store
+	if store == nil {
+		s, err := kvstore.Open(c.StoreBackend, c.StorePath)
+		if err != nil {
+			panic(err)
+		}
+		store = s
+	}
+	if key := kvstore.StoreKey(c.StoreKey); key != "" {
+		s, err := kvstore.NewEncrypted(store, key)
+		if err != nil {
+			panic(err)
+		}
+		store = s
 	}
+	pl := NewConnListWithStore(store)
 
 	return &Manager{
 		Config:     c,
 		List:       pl,
 		NoticeList: map[string]NoticeEvil{},
+		clock:      clock.Or(c.Clock),
+		events:     map[KindOfEvil]uint64{},
 	}
 }
 
@@ -67,13 +110,14 @@ func (r *Manager) RegisterNoticeEvilNode(ne NoticeEvil) {
 
 // IsBanNode is return true value when the target node over the base evil score
 func (r *Manager) IsBanNode(addr string) bool {
+	addr = nodeKey(addr)
 	pi, err := r.List.Get(addr)
 	if err != nil {
-		if err == badger.ErrKeyNotFound {
+		if err == kvstore.ErrNotFound {
 			pi = ConnectionInfo{
 				Addr:      addr,
 				EvilScore: 0,
-				Time:      time.Now(),
+				Time:      r.clock.Now(),
 			}
 			r.List.Store(pi)
 		} else {
@@ -82,17 +126,9 @@ func (r *Manager) IsBanNode(addr string) bool {
 		}
 	}
 
-	passedSecond := (time.Now().Sub(pi.Time) / time.Minute) * time.Duration(reduceEvilScorePerMinute)
-
-	var evilScore uint16
-	if pi.EvilScore < uint16(passedSecond) {
-		evilScore = 0
-	} else {
-		evilScore = pi.EvilScore - uint16(passedSecond)
-	}
-
+	evilScore := currentScore(pi, r.clock.Now())
 	if evilScore > r.Config.BanEvilScore {
-		log.Info("IsBanNode func evilScore : ", addr, " : ", evilScore, passedSecond)
+		log.Info("IsBanNode func evilScore : ", addr, " : ", evilScore)
 		return true
 	}
 	return false
@@ -100,9 +136,14 @@ func (r *Manager) IsBanNode(addr string) bool {
 
 // TellOn is update nodes evil score
 func (r *Manager) TellOn(addr string, es KindOfEvil) error {
+	r.eventLock.Lock()
+	r.events[es]++
+	r.eventLock.Unlock()
+
+	addr = nodeKey(addr)
 	pi, err := r.List.Get(addr)
 	if err != nil {
-		if err == badger.ErrKeyNotFound {
+		if err == kvstore.ErrNotFound {
 			pi = ConnectionInfo{
 				Addr:      addr,
 				EvilScore: 0,
@@ -112,15 +153,148 @@ func (r *Manager) TellOn(addr string, es KindOfEvil) error {
 		}
 	}
 
-	passedSecond := (time.Now().Sub(pi.Time) / time.Minute) * time.Duration(reduceEvilScorePerMinute)
-	if pi.EvilScore <= uint16(passedSecond) {
-		pi.EvilScore = 0
-	} else {
-		pi.EvilScore -= uint16(passedSecond)
-	}
-	pi.EvilScore += uint16(es)
-	pi.Time = time.Now()
+	pi.EvilScore = currentScore(pi, r.clock.Now()) + uint16(es)
+	pi.Time = r.clock.Now()
 	log.Info("TellOn ", r.Config.StorePath, ":", addr, ":", pi.EvilScore)
 
 	return r.List.Store(pi)
 }
+
+// Events returns the number of the TellOn calls of each kind since the manager is created
+func (r *Manager) Events() map[KindOfEvil]uint64 {
+	r.eventLock.Lock()
+	defer r.eventLock.Unlock()
+
+	events := make(map[KindOfEvil]uint64, len(r.events))
+	for k, v := range r.events {
+		events[k] = v
+	}
+	return events
+}
+
+// Scores returns the current evil scores of all stored nodes
+func (r *Manager) Scores() map[string]uint16 {
+	scores := map[string]uint16{}
+	if err := r.List.Range(func(pi ConnectionInfo) bool {
+		scores[pi.Addr] = currentScore(pi, r.clock.Now())
+		return true
+	}); err != nil {
+		log.Error("Manager Scores ", err)
+	}
+	return scores
+}
+
+// Score returns the current evil score of the node
+func (r *Manager) Score(addr string) (uint16, error) {
+	pi, err := r.List.Get(nodeKey(addr))
+	if err != nil {
+		if err == kvstore.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return currentScore(pi, r.clock.Now()), nil
+}
+
+// AdjustScore adds the delta to the current evil score of the node and returns the adjusted score
+// The negative delta reduces the score and the score is kept between 0 and the max of uint16
+func (r *Manager) AdjustScore(addr string, delta int) (uint16, error) {
+	addr = nodeKey(addr)
+	pi, err := r.List.Get(addr)
+	if err != nil {
+		if err != kvstore.ErrNotFound {
+			return 0, err
+		}
+		pi = ConnectionInfo{
+			Addr: addr,
+		}
+	}
+
+	pi.EvilScore = adjustedScore(pi, delta, r.clock.Now())
+	pi.Time = r.clock.Now()
+	log.Info("AdjustScore ", r.Config.StorePath, ":", addr, ":", pi.EvilScore)
+
+	if err := r.List.Store(pi); err != nil {
+		return 0, err
+	}
+	return pi.EvilScore, nil
+}
+
+// AdjustScores adds the deltas to the current evil scores of the nodes in a batch and returns the adjusted scores
+func (r *Manager) AdjustScores(deltas map[string]int) (map[string]uint16, error) {
+	scores := make(map[string]uint16, len(deltas))
+	list := make([]ConnectionInfo, 0, len(deltas))
+	now := r.clock.Now()
+	for addr, delta := range deltas {
+		addr = nodeKey(addr)
+		pi, err := r.List.Get(addr)
+		if err != nil {
+			if err != kvstore.ErrNotFound {
+				return nil, err
+			}
+			pi = ConnectionInfo{
+				Addr: addr,
+			}
+		}
+		pi.EvilScore = adjustedScore(pi, delta, now)
+		pi.Time = now
+		scores[addr] = pi.EvilScore
+		list = append(list, pi)
+	}
+	log.Info("AdjustScores ", r.Config.StorePath, ":", len(list))
+	if err := r.List.StoreBatch(list); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// Reset removes the evil score of the node
+func (r *Manager) Reset(addr string) error {
+	addr = nodeKey(addr)
+	log.Info("Reset ", r.Config.StorePath, ":", addr)
+	return r.List.Delete(addr)
+}
+
+// ResetAll removes the evil scores of all nodes
+func (r *Manager) ResetAll() error {
+	log.Info("ResetAll ", r.Config.StorePath)
+	return r.List.Clear()
+}
+
+// adjustedScore returns the current score with the delta between 0 and the max of uint16
+func adjustedScore(pi ConnectionInfo, delta int, now time.Time) uint16 {
+	score := int(currentScore(pi, now)) + delta
+	if score < 0 {
+		score = 0
+	} else if score > math.MaxUint16 {
+		score = math.MaxUint16
+	}
+	return uint16(score)
+}
+
+// currentScore returns the evil score reduced by the passed minutes since it is updated
+func currentScore(pi ConnectionInfo, now time.Time) uint16 {
+	elapsed := now.Sub(pi.Time)
+	if elapsed < 0 {
+		return pi.EvilScore
+	}
+	passed := uint64(elapsed/time.Minute) * uint64(reduceEvilScorePerMinute)
+	if uint64(pi.EvilScore) <= passed {
+		return 0
+	}
+	return pi.EvilScore - uint16(passed)
+}
+
+// Close closes the evil score store
+func (r *Manager) Close() error {
+	return r.List.Close()
+}
+
+// nodeKey returns the host part of the address so that the evil score is kept per node
+// regardless of the port and the brackets of an IPv6 address
+func nodeKey(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -1,16 +1,26 @@
 package router
 
 import (
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/hex"
 	"io"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/fletaio/framework/admin"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
 	"github.com/fletaio/framework/router/evilnode"
+	"github.com/fletaio/framework/ttlcache"
 
 	"github.com/fletaio/common"
-	"github.com/fletaio/network"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/fletaio/framework/log"
 )
@@ -21,8 +31,164 @@ type Config struct {
 	Address        string
 	Port           int
 	EvilNodeConfig evilnode.Config
+	// BindAddr is the IP of the interface which is listened on the Port and which outbound dials bind to.
+	// All interfaces are used when it is empty.
+	BindAddr string
+	// LocalAddr is the source IP of the outbound dials instead of the BindAddr, so the multi-homed server dials from an interface
+	// which is not listened. Interface is the name of the network interface whose address of the family of the target is the source
+	// when LocalAddr is empty. The BindAddr is used when both are empty.
+	LocalAddr string
+	Interface string
+	// ListenAddresses are the addresses to be listened (e.g. one public and one private VLAN).
+	// The Port of the BindAddr is listened when it is empty.
+	ListenAddresses []string
+	// NodeID identifies the node regardless of its addresses, a random id is used when it is empty
+	NodeID string
+	// NetworkMagic identifies the network (e.g. the genesis hash) and the nodes of the other networks are rejected in the handshake
+	NetworkMagic []byte
+	// PrivateKey signs the handshake challenges and its public key is the remote id of the node, a random key is used when it is nil
+	PrivateKey ed25519.PrivateKey
+	// Dialer dials the requested addresses in the Network instead of the router (e.g. the proxies, the policy routing and the tests).
+	// The local addresses, the source ports and the resolving of the hostnames are left to it. The router dials by itself when it is nil.
+	Dialer Dialer
+	// Clock is the time source of the redial backoffs, the resumable sessions, the handshake replay window and the evil node scores
+	// (when the Clock of the EvilNodeConfig is nil), so the simulations control the time. The real clock is used when it is nil.
+	Clock clock.Clock
+	// PinnedKeys are the hex encoded public keys of the trusted nodes (e.g. the validators).
+	// The connections of them bypass the evil node checks.
+	PinnedKeys []string
+	// AllowCIDRs and DenyCIDRs filter the inbound connections before the handshake.
+	// Only the addresses in the AllowCIDRs are accepted when it is not empty and the DenyCIDRs are always rejected.
+	AllowCIDRs []string
+	DenyCIDRs  []string
+	// ProxyProtocol reads the PROXY protocol v1 or v2 header of the inbound connections from the ProxyCIDRs (e.g. HAProxy and the NLBs),
+	// so the evil scores, the bans and the peer lists use the real addresses of the clients. The header is required from the proxies and
+	// the connections of the other addresses are accepted as they are. The config is rejected when ProxyCIDRs is empty,
+	// because the clients would claim any address by the header.
+	ProxyProtocol bool
+	ProxyCIDRs    []string
+	// MaxConnsPerIP is the max number of the simultaneous inbound physical connections of each IP, zero is unlimited
+	MaxConnsPerIP int
+	// SessionTTL is how long the session of a closed connection is resumable.
+	// The resumed connection skips the signatures of the handshake, zero disables the resumption.
+	SessionTTL time.Duration
+	// AcceptCoords are the chain coordinates of the inbound connections accepted in addition to the own one,
+	// AcceptAnyCoord accepts all the chain coordinates. The rejected dialers receive a RejectionError.
+	AcceptCoords   []*common.Coordinate
+	AcceptAnyCoord bool
+	// DataPlane dials the second connection of the requested peer which carries the bulk data apart from the control messages.
+	// It is ignored with the peers which don't support it.
+	DataPlane bool
+	// DisabledFeatures are the wire features which are not offered in the handshake (e.g. FeatureBatch | FeatureChecksumC),
+	// so a new feature is rolled out gradually. The frames to the peers which lack a feature are encoded in the old format.
+	DisabledFeatures uint32
+	// ChannelWindow is the bytes of each logical channel which the other side sends before the node reads them,
+	// so the application frames don't starve the control and the peer exchange ones. 256KB is used when it is zero.
+	ChannelWindow int
+	// Compressions is the list of the supported compression types in order of preference.
+	// It is exchanged in the handshake and the first one supported by both sides is used.
+	Compressions []uint8
+	// CompressionThreshold is the minimum body size to be compressed, zero disables the compression
+	CompressionThreshold int
+	// StreamThreshold is the body size over which the uncompressed frames are read directly from the connection by the message creators
+	// instead of being buffered and written without copying the body. 256KB is used when it is zero and a negative value disables it.
+	StreamThreshold int
+	// ReadLimit and WriteLimit are the bandwidth limits of each physical connection in bytes per second, zero is unlimited
+	ReadLimit  int64
+	WriteLimit int64
+	// RateLimitBurst is the bytes allowed at once over the limits, the limit itself is used when it is zero
+	RateLimitBurst int64
+	// TotalReadLimit and TotalWriteLimit are the bandwidth budgets shared by all physical connections in bytes per second, zero is unlimited
+	TotalReadLimit  int64
+	TotalWriteLimit int64
+	// DialTimeout, HandshakeTimeout and WriteTimeout are the deadlines of dialing, handshaking and writing a frame.
+	// The default timeouts are used when they are zero.
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	WriteTimeout     time.Duration
+	// ReadTimeout is the deadline of each read of a frame after the handshake (DefaultReadTimeout when it is zero),
+	// the reads in the handshake are bounded by the HeaderTimeout and the HandshakeTimeout
+	ReadTimeout time.Duration
+	// KeepAliveInterval is the period of the keep-alive frames and the connection is closed
+	// when nothing is received in KeepAliveProbes consecutive periods. The defaults are used when they are zero.
+	KeepAliveInterval time.Duration
+	KeepAliveProbes   int
+	// HandshakeWorkers is the number of the workers which process the CPU-bound part of the handshakes
+	// and HandshakeQueueSize is the number of the handshakes waiting the workers, the handshakes over it are rejected.
+	// The number of CPUs and 128 are used when they are zero.
+	HandshakeWorkers   int
+	HandshakeQueueSize int
+	// RedialBackoffBase and RedialBackoffMax are the first and the max backoff of the redials to the failed address.
+	// The backoff is doubled with jitter on every failure and the defaults are used when they are zero.
+	RedialBackoffBase time.Duration
+	RedialBackoffMax  time.Duration
+	// SourcePortMin and SourcePortMax are the range of the source ports of the outbound dials (e.g. for the strict egress rules of a firewall).
+	// A random port of the range is used and the ephemeral port of the operating system is used when both are zero.
+	SourcePortMin int
+	SourcePortMax int
+	// TCPNagle enables the Nagle's algorithm of the TCP connections which is disabled (TCP_NODELAY) by default.
+	// TCPKeepAlivePeriod is the period of the TCP keep-alive probes, the default of the operating system is used when it is zero
+	// and a negative period disables them. TCPReadBuffer and TCPWriteBuffer are the socket buffer sizes, zero is the default.
+	TCPNagle           bool
+	TCPKeepAlivePeriod time.Duration
+	TCPReadBuffer      int
+	TCPWriteBuffer     int
+	// AdvertiseAddr is the reachable "host:port" of the node told to the peers instead of the bound address
+	// (e.g. behind a load balancer or a static NAT). It overrides the Localhost and the address of the handshake.
+	AdvertiseAddr string
+	// DNSRefreshInterval is the lifetime of the resolved IPs of the hostnames of the dialed addresses, 5m is used when it is zero.
+	// The hostname is resolved again before it when all of the IPs fail.
+	DNSRefreshInterval time.Duration
+	// BlacklistPath is the store of the hosts denied by the operator, it is next to the StorePath of the EvilNodeConfig when it is empty.
+	// It is opened by the StoreBackend and encrypted by the StoreKey of the EvilNodeConfig, and BlacklistStore is used
+	// instead of opening the BlacklistPath when it is not nil, so the embedder can share its database
+	BlacklistPath  string
+	BlacklistStore kvstore.KVStore
+	// LogRedaction hides the IP addresses in the log entries by truncating or hashing them with the LogRedactionSalt.
+	// It is process wide and the admin API still returns the full addresses.
+	LogRedaction     log.RedactMode
+	LogRedactionSalt []byte
+	// HandshakeRate is the number of the inbound handshakes per second allowed for each source and
+	// HandshakeBurst is the number allowed at once, the excess connections are closed before the handshake.
+	// The sources are grouped by HandshakePrefixV4 and HandshakePrefixV6 (32 and 64 when they are zero).
+	// Zero HandshakeRate disables the limit.
+	HandshakeRate     float64
+	HandshakeBurst    int
+	HandshakePrefixV4 int
+	HandshakePrefixV6 int
+	// HeaderTimeout is the deadline of receiving the whole handshake of an inbound connection from its first byte,
+	// the connection is closed and its source is scored as a SlowHandshake on expiry. The HandshakeTimeout is used when it is zero.
+	HeaderTimeout time.Duration
+	// PingTimeout is the timeout of Ping (DefaultPingTimeout when it is zero)
+	PingTimeout time.Duration
+	// IdleTimeout closes the logical connection which has no application traffic during it, so the slot is filled by a fresher peer.
+	// The heartbits are not the traffic and zero disables it.
+	IdleTimeout time.Duration
+	// MaxPendingHandshakes caps the inbound connections which are in the handshake goroutines,
+	// the new connections are closed before the handshake over it. Zero is unlimited.
+	MaxPendingHandshakes int
+	// HandshakeReplayWindow rejects the inbound handshake whose key and challenge were seen in it, zero disables it.
+	HandshakeReplayWindow time.Duration
+	// PersistTimedState saves the recent handshakes and the redial backoffs to the TimedStatePath on Close and restores them on NewRouter,
+	// so a quick restart doesn't reset the windows. The path is next to the StorePath of the EvilNodeConfig when it is empty.
+	PersistTimedState bool
+	TimedStatePath    string
 }
 
+// default timeouts
+const (
+	DefaultDialTimeout      = 2 * time.Second
+	DefaultHandshakeTimeout = 5 * time.Second
+	DefaultWriteTimeout     = 5 * time.Second
+	DefaultReadTimeout      = 15 * time.Second
+)
+
+// default keep-alive
+const (
+	DefaultKeepAliveInterval = 3 * time.Second
+	DefaultKeepAliveProbes   = 5
+)
+
 type TypeIs bool
 
 const (
@@ -34,30 +200,158 @@ const (
 type Router interface {
 	Listen() error
 	Request(addrStr string) error
+	RequestContext(ctx context.Context, addrStr string, coord *common.Coordinate) error
 	Accept() (Conn, time.Duration, error)
+	AcceptContext(ctx context.Context, coord *common.Coordinate) (Conn, time.Duration, error)
+	Listener(coord *common.Coordinate) net.Listener
 	Localhost() string
+	NodeID() string
+	RemoteID() string
+	ListenAddrs() []string
+	IsPinned(idOrAddr string) bool
+	SetAcceptFilter(filter AcceptFilter)
+	SetHandshakeCheck(extra []byte, check HandshakeCheck)
+	SetCoordFilter(filter CoordFilter)
+	Blacklist(addr string) error
+	Unblacklist(addr string) error
+	Blacklisted() []string
 	EvilNodeManager() *evilnode.Manager
 	Conf() *Config
+	ApplyConfig(c *Config) error
 	ConnList() []string
 	WaitHandshackConnList() []string
+	RegisterCoord(coord *common.Coordinate) error
+	AcceptCoord(coord *common.Coordinate) (Conn, time.Duration, error)
+	Close() error
+	Shutdown(ctx context.Context) error
+	HandshakeStats() WorkerPoolStats
+	ConnStats(addr string) (ConnStats, error)
+	OnEvent(handler EventHandler)
+	Ping(addr string) (time.Duration, error)
+	RegisterAdmin(am *admin.Manager)
+	Metrics() prometheus.Collector
 }
 
 type router struct {
 	Config                *Config
+	configLock            sync.RWMutex
 	ChainCoord            *common.Coordinate
 	localhost             string
 	evilNodeManager       *evilnode.Manager
-	listener              net.Listener
+	listeners             []net.Listener
+	listenerLock          sync.Mutex
 	AcceptConnChan        chan *RouterConn
 	ConnMap               map[string]*RouterConn
 	ConnMapLock           *NamedLock
 	WaitHandshackConn     map[string]struct{}
 	WaitHandshackConnLock *NamedLock
+	coords                *coordRegistry
+	readBudget            *rateLimiter
+	writeBudget           *rateLimiter
+	closeOnce             sync.Once
+	closeCh               chan struct{}
+	handshakePool         *workerPool
+	backoff               *redialBackoff
+	id                    string
+	privateKey            ed25519.PrivateKey
+	pinned                *pinnedKeys
+	acceptFilter          *acceptFilter
+	proxies               []*net.IPNet
+	ipCounter             *ipCounter
+	resumes               *resumeCache
+	coordAcceptor         *coordAcceptor
+	handshakeLock         sync.RWMutex
+	extra                 []byte
+	extraCheck            HandshakeCheck
+	acceptErrCh           chan error
+	sourcePorts           *sourcePorts
+	advertiseHost         string
+	advertisePort         int
+	dns                   *dnsCache
+	blacklist             *blacklist
+	unixSeq               uint64
+	handshakeLimit        *handshakeLimiter
+	pendingHandshakes     int32
+	recentHandshakes      *ttlcache.Cache
+	recentLock            sync.Mutex
+	events                *eventHub
+	meter                 *routerMetrics
+	clock                 clock.Clock
 }
 
 // NewRouter is creator of router
 func NewRouter(Config *Config, ChainCoord *common.Coordinate) (Router, error) {
+	// the defaults of the router and the evil node store are not written into the config of the caller
+	conf := *Config
+	Config = &conf
+	workers := Config.HandshakeWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queueSize := Config.HandshakeQueueSize
+	if queueSize <= 0 {
+		queueSize = 128
+	}
+	privateKey := Config.PrivateKey
+	if privateKey == nil {
+		_, key, err := ed25519.GenerateKey(crand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+	} else if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidPrivateKey
+	}
+	af, err := newAcceptFilter(Config.AllowCIDRs, Config.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := parseCIDRs(Config.ProxyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	if Config.ProxyProtocol && len(proxies) == 0 {
+		return nil, ErrNoProxyCIDRs
+	}
+	advertiseHost, advertisePort, err := parseAdvertiseAddr(Config.AdvertiseAddr)
+	if err != nil {
+		return nil, err
+	}
+	if Config.EvilNodeConfig.Clock == nil {
+		Config.EvilNodeConfig.Clock = Config.Clock
+	}
+	if Config.LogRedaction != log.RedactNone {
+		log.SetRedaction(Config.LogRedaction, Config.LogRedactionSalt)
+	}
+	if Config.LocalAddr != "" && net.ParseIP(Config.LocalAddr) == nil {
+		return nil, ErrInvalidLocalAddr
+	}
+	sp, err := newSourcePorts(Config.SourcePortMin, Config.SourcePortMax)
+	if err != nil {
+		return nil, err
+	}
+	id := Config.NodeID
+	if id == "" {
+		id = hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+	}
 	r := &router{
+		id:             id,
+		privateKey:     privateKey,
+		pinned:         newPinnedKeys(Config.PinnedKeys),
+		acceptFilter:   af,
+		proxies:        proxies,
+		ipCounter:      newIPCounter(Config.MaxConnsPerIP),
+		resumes:        newResumeCache(Config.SessionTTL, Config.Clock),
+		acceptErrCh:    make(chan error, 16),
+		sourcePorts:    sp,
+		dns:            newDNSCache(Config.DNSRefreshInterval),
+		handshakeLimit: newHandshakeLimiter(Config.HandshakeRate, Config.HandshakeBurst, Config.HandshakePrefixV4, Config.HandshakePrefixV6),
+		advertiseHost:  advertiseHost,
+		advertisePort:  advertisePort,
+		coordAcceptor: &coordAcceptor{
+			any:    Config.AcceptAnyCoord,
+			coords: Config.AcceptCoords,
+		},
 		Config:          Config,
 		ChainCoord:      ChainCoord,
 		evilNodeManager: evilnode.NewManager(&Config.EvilNodeConfig),
@@ -67,102 +361,620 @@ func NewRouter(Config *Config, ChainCoord *common.Coordinate) (Router, error) {
 
 		WaitHandshackConn:     map[string]struct{}{},
 		WaitHandshackConnLock: NewNamedLock("WaitHandshackConn"),
+		coords:                newCoordRegistry(),
+		readBudget:            newRateLimiter(Config.TotalReadLimit, 0),
+		writeBudget:           newRateLimiter(Config.TotalWriteLimit, 0),
+		closeCh:               make(chan struct{}),
+		handshakePool:         newWorkerPool(workers, queueSize),
+		backoff:               newRedialBackoff(Config.RedialBackoffBase, Config.RedialBackoffMax, Config.Clock),
+		recentHandshakes:      ttlcache.NewWithClock(0, Config.Clock),
+		clock:                 clock.Or(Config.Clock),
+		events:                newEventHub(),
+		meter:                 newRouterMetrics(),
+	}
+	bl, err := newBlacklist(Config)
+	if err != nil {
+		r.evilNodeManager.Close()
+		return nil, err
+	}
+	r.blacklist = bl
+	if err := r.loadTimedState(); err != nil {
+		log.Error("loadTimedState ", err)
 	}
+	go r.expireTimedState()
 	return r, nil
 }
 
+// Conf returns the current config, it is replaced by ApplyConfig
 func (r *router) Conf() *Config {
-	return r.Config
+	return r.conf()
 }
 
 //AddListen registers a logical connection as a waiting-for-connect condition.
+//It binds all of the ListenAddresses and nothing is listened when one of them fails.
 func (r *router) Listen() error {
-	listenAddr := ":" + strconv.Itoa(r.Config.Port)
-	l, err := network.Listen(r.Config.Network, listenAddr)
-	if err != nil {
-		return err
+	if r.isClosed() {
+		return ErrRouterClosed
 	}
-	r.listener = l
-	localhost := l.Addr().String()
-	if r.localhost == "" {
-		if !strings.HasPrefix(localhost, ":") && !strings.HasPrefix(localhost, "[") {
-			r.localhost = localhost
+	listenAddrs := r.conf().ListenAddresses
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{net.JoinHostPort(r.conf().BindAddr, strconv.Itoa(r.conf().Port))}
+	}
+
+	listeners := make([]net.Listener, 0, len(listenAddrs))
+	for _, listenAddr := range listenAddrs {
+		l, err := r.listen(listenAddr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
 		}
+		listeners = append(listeners, l)
 	}
 
-	go r.listening()
+	r.listenerLock.Lock()
+	r.listeners = append(r.listeners, listeners...)
+	r.listenerLock.Unlock()
+
+	for _, l := range listeners {
+		if r.localhost == "" {
+			localhost := l.Addr().String()
+			host, _ := RemovePort(localhost)
+			if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+				r.localhost = localhost
+			}
+		}
+		go r.listening(l)
+	}
 
 	return nil
 }
 
+// ListenAddrs returns the addresses of the listeners
+func (r *router) ListenAddrs() []string {
+	r.listenerLock.Lock()
+	defer r.listenerLock.Unlock()
+
+	list := make([]string, 0, len(r.listeners))
+	for _, l := range r.listeners {
+		if l.Addr().Network() == "unix" {
+			list = append(list, UnixScheme+l.Addr().String())
+		} else {
+			list = append(list, l.Addr().String())
+		}
+	}
+	return list
+}
+
+// closeListeners stops listening of all listeners
+func (r *router) closeListeners() error {
+	r.listenerLock.Lock()
+	listeners := r.listeners
+	r.listeners = nil
+	r.listenerLock.Unlock()
+
+	var err error
+	for _, l := range listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// isLocal returns the address is the one of the listeners of the router
+func (r *router) isLocal(addr string) bool {
+	if IsUnixAddress(addr) {
+		for _, l := range r.ListenAddrs() {
+			if l == addr {
+				return true
+			}
+		}
+		return false
+	}
+	if r.localhost != "" && IsSameAddress(addr, r.localhost) {
+		return true
+	}
+	r.listenerLock.Lock()
+	defer r.listenerLock.Unlock()
+	for _, l := range r.listeners {
+		host := hostOf(l.Addr())
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		if IsSameAddress(addr, l.Addr().String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// advertise returns the address and the port which is told to the peer connected through the local address.
+// The listener bound to the local IP of the connection is preferred,
+// so the peer on a private network learns the private address.
+func (r *router) advertise(local net.Addr) (string, int) {
+	if advertiseHost, advertisePort := r.advertised(); advertiseHost != "" {
+		return advertiseHost, advertisePort
+	}
+	host := hostOf(local)
+	r.listenerLock.Lock()
+	defer r.listenerLock.Unlock()
+
+	for _, l := range r.listeners {
+		lhost, lport, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(lport)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(lhost); ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		if IsSameHost(lhost, host) {
+			return lhost, port
+		}
+	}
+	for _, l := range r.listeners {
+		lhost, lport, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(lhost); ip != nil && ip.IsUnspecified() {
+			if port, err := strconv.Atoi(lport); err == nil {
+				return r.conf().Address, port
+			}
+		}
+	}
+	return r.conf().Address, r.conf().Port
+}
+
 //Request requests the connection by entering the address when a logical connection is required.
 //The chain coordinates support the connection between subchains.
 func (r *router) Request(addr string) error {
-	if r.localhost != "" && strings.HasPrefix(addr, r.localhost) {
+	return r.RequestContext(context.Background(), addr, r.ChainCoord)
+}
+
+//RequestContext requests the connection of the chain coordinate and it is canceled when the context is done.
+//The chain coordinate should be the one of the router or a registered one and nil is treated as the one of the router.
+//The registered coordinate is carried by the physical connection of the address when it is already connected.
+func (r *router) RequestContext(ctx context.Context, addr string, coord *common.Coordinate) error {
+	if r.isClosed() {
+		return ErrRouterClosed
+	}
+	if coord != nil && !coord.Equal(r.ChainCoord) {
+		if _, has := r.coordAccept(coord); !has {
+			return ErrMismatchCoordinate
+		}
+		if pc, has := r.connOf(addr); has {
+			if pc.sharesCoord(coord) {
+				return nil
+			}
+			return ErrMismatchCoordinate
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.isLocal(addr) {
 		return ErrCannotRequestToLocal
 	}
-	if r.evilNodeManager.IsBanNode(addr) {
+	if r.blacklist.has(addr) {
+		return ErrBlacklisted
+	}
+	if !r.pinned.has(addr) && r.evilNodeManager.IsBanNode(addr) {
 		return ErrCanNotConnectToEvilNode
 	}
+	if r.backoff.Wait(addr) > 0 {
+		return ErrRedialBackoff
+	}
 
-	conn, err := network.DialTimeout(r.Config.Network, addr, time.Second*2)
+	r.emit(Event{Kind: EventDialStarted, Addr: addr, TypeIs: IsDial})
+	conn, err := r.dialContext(ctx, addr)
+	if err == nil {
+		conn = r.limitConn(conn)
+	}
 	if err != nil {
 		if conn != nil {
 			conn.Close()
 		}
+		if ctx.Err() == nil {
+			r.backoff.FailureOf(addr, ClassifyDialError(err))
+		}
+		r.emit(Event{Kind: EventDialFailed, Addr: addr, TypeIs: IsDial, Err: err})
 		return err
 	}
 
-	_, err = r.incommingConn(conn, IsDial)
+	pc, err := r.incommingConn(ctx, conn, IsDial, PlanePrimary)
 	if err != nil {
 		conn.Close()
+		if ctx.Err() == nil && err != ErrRouterClosed && err != ErrDuplicateAccept {
+			r.backoff.FailureOf(addr, ClassifyDialError(err))
+		}
+		r.emit(Event{Kind: EventDialFailed, Addr: addr, TypeIs: IsDial, Err: err})
 		return err
 	}
+	r.backoff.Success(addr)
+	if r.conf().DataPlane && pc.hasFeature(FeatureDataPlane) {
+		go r.openDataPlane(addr, pc)
+	}
 
 	return nil
 }
 
 // Accept returns a logical connection when an external connection request is received.
 func (r *router) Accept() (Conn, time.Duration, error) {
-	receiver := <-r.AcceptConnChan
-	var c Conn
-	c = receiver
-	return c, receiver.pingTime, nil
+	return r.AcceptContext(context.Background(), r.ChainCoord)
+}
+
+// AcceptContext returns a logical connection of the chain coordinate and it stops waiting when the context is done.
+//The chain coordinate should be the one of the router or a registered one and nil is treated as the one of the router.
+func (r *router) AcceptContext(ctx context.Context, coord *common.Coordinate) (Conn, time.Duration, error) {
+	if coord != nil && !coord.Equal(r.ChainCoord) {
+		ch, has := r.coordAccept(coord)
+		if !has {
+			return nil, 0, ErrMismatchCoordinate
+		}
+		select {
+		case c := <-ch:
+			return c, c.(*coordConn).pingTime, nil
+		case <-r.closeCh:
+			return nil, 0, ErrRouterClosed
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+	select {
+	case receiver := <-r.AcceptConnChan:
+		var c Conn
+		c = receiver
+		return c, receiver.pingTime, nil
+	case ae := <-r.acceptErrCh:
+		return nil, 0, ae
+	case <-r.closeCh:
+		return nil, 0, ErrRouterClosed
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+// Close stops listening, closes all physical connections and the evil node store
+// and unblocks the pending Accept calls with ErrRouterClosed
+func (r *router) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closeCh)
+		err = r.closeListeners()
+
+		r.ConnMapLock.RLock("Close")
+		pcs := make([]*RouterConn, 0, len(r.ConnMap))
+		for _, pc := range r.ConnMap {
+			pcs = append(pcs, pc)
+		}
+		r.ConnMapLock.RUnlock()
+		for _, pc := range pcs {
+			pc.Close()
+		}
+
+		r.handshakePool.Close()
+		if e := r.evilNodeManager.Close(); e != nil && err == nil {
+			err = e
+		}
+		if e := r.blacklist.Close(); e != nil && err == nil {
+			err = e
+		}
+		if e := r.saveTimedState(); e != nil && err == nil {
+			err = e
+		}
+		r.events.stop()
+	})
+	return err
+}
+
+// Shutdown stops listening and waits the pending handshakes until the context is done, and then closes the router
+func (r *router) Shutdown(ctx context.Context) error {
+	r.closeListeners()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for len(r.WaitHandshackConnList()) > 0 {
+		select {
+		case <-ctx.Done():
+			r.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return r.Close()
+}
+
+// HandshakeStats returns the statistics of the handshake worker pool
+func (r *router) HandshakeStats() WorkerPoolStats {
+	return r.handshakePool.Stats()
+}
+
+func (r *router) isClosed() bool {
+	select {
+	case <-r.closeCh:
+		return true
+	default:
+		return false
+	}
 }
 
 func (r *router) EvilNodeManager() *evilnode.Manager {
 	return r.evilNodeManager
 }
 
+// NodeID returns the id of the node which is sent in the handshake
+func (r *router) NodeID() string {
+	return r.id
+}
+
+func (r *router) nodeID() string {
+	return r.id
+}
+
+func (r *router) networkMagic() []byte {
+	return r.conf().NetworkMagic
+}
+
+// RemoteID returns the hex encoded public key of the node which is verified by the other side
+func (r *router) RemoteID() string {
+	return hex.EncodeToString(r.publicKey())
+}
+
+func (r *router) publicKey() []byte {
+	return r.privateKey.Public().(ed25519.PublicKey)
+}
+
+func (r *router) sign(challenge []byte, share []byte) []byte {
+	return ed25519.Sign(r.privateKey, challengeMessage(challenge, share))
+}
+
+// HandshakeCheck validates the extra data of the other side in the handshake (e.g. the genesis hash)
+type HandshakeCheck func(extra []byte) error
+
+// SetHandshakeCheck sets the extra data sent in the handshake and the check of the extra data of the other side.
+// The connection is rejected in the handshake when the check returns an error.
+func (r *router) SetHandshakeCheck(extra []byte, check HandshakeCheck) {
+	r.handshakeLock.Lock()
+	defer r.handshakeLock.Unlock()
+
+	r.extra = extra
+	r.extraCheck = check
+}
+
+func (r *router) handshakeExtra() []byte {
+	r.handshakeLock.RLock()
+	defer r.handshakeLock.RUnlock()
+
+	return r.extra
+}
+
+func (r *router) checkHandshake(extra []byte) error {
+	r.handshakeLock.RLock()
+	check := r.extraCheck
+	r.handshakeLock.RUnlock()
+
+	if check == nil {
+		return nil
+	}
+	return check(extra)
+}
+
 func (r *router) Localhost() string {
+	if advertiseHost, _ := r.advertised(); advertiseHost != "" {
+		return advertiseHost
+	}
 	return r.localhost
 }
 
 func (r *router) setLocalhost(l string) {
-	addr, _ := removePort(l)
+	addr, _ := RemovePort(l)
 	r.localhost = addr
 }
 
-func (r *router) listening() {
+func (r *router) listening(l net.Listener) {
+	var delay time.Duration
 	for {
-		conn, err := r.listener.Accept()
-		func(conn net.Conn) {
-
-			if err != nil {
-				if conn != nil {
-					conn.Close()
-				}
-				log.Error("router run err : ", err)
+		conn, err := l.Accept()
+		if err != nil {
+			if r.isClosed() {
 				return
 			}
-			_, err = r.incommingConn(conn, IsAccept)
-			if err != nil {
+			if conn != nil {
 				conn.Close()
-				if err != ErrCanNotConnectToEvilNode && err != io.EOF {
-					log.Error("incommingConn err", err)
+			}
+			ae := &AcceptError{
+				Kind:     classifyAcceptError(err),
+				Listener: l.Addr().String(),
+				Err:      err,
+			}
+			if !ae.Temporary() {
+				log.Error("router stops listening : ", ae)
+				r.reportAcceptError(ae)
+				r.removeListener(l)
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		r.tuneConn(conn)
+		if r.proxied(conn) {
+			// the header is read apart from the accept loop in the slot of a pending handshake,
+			// and the address of the client is checked instead of the proxy
+			if !r.acquireHandshake() {
+				conn.Close()
+				continue
+			}
+			go func(conn net.Conn) {
+				defer r.releaseHandshake()
+				pc, err := readProxyHeader(conn, r.headerTimeout())
+				if err != nil {
+					log.Error("invalid proxy header from ", conn.RemoteAddr().String(), " : ", err)
+					conn.Close()
+					return
+				}
+				if pc, ok := r.screenConn(pc); ok {
+					r.acceptConn(r.limitConn(pc))
 				}
+			}(conn)
+			continue
+		}
+		r.admitConn(conn)
+	}
+}
+
+// admitConn checks the address of the accepted connection and starts its handshake
+func (r *router) admitConn(conn net.Conn) {
+	conn, ok := r.screenConn(conn)
+	if !ok {
+		return
+	}
+	if !r.acquireHandshake() {
+		conn.Close()
+		return
+	}
+	// the slow clients don't hold the accept loop
+	go func(conn net.Conn) {
+		defer r.releaseHandshake()
+		r.acceptConn(r.limitConn(conn))
+	}(conn)
+}
+
+// screenConn checks the address of the accepted connection and returns it counted by its IP,
+// the connection is closed when it is not admitted
+func (r *router) screenConn(conn net.Conn) (net.Conn, bool) {
+	// the Unix domain sockets are the local processes which don't have the remote address
+	if isUnixConn(conn) {
+		return conn, true
+	}
+	if r.blacklist.has(conn.RemoteAddr().String()) {
+		conn.Close()
+		return nil, false
+	}
+	if host := hostOf(conn.RemoteAddr()); !r.pinned.has(host) && !r.handshakeLimiter().allow(host) {
+		conn.Close()
+		return nil, false
+	}
+	if err := r.acceptFilter.check(conn.RemoteAddr()); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	counted, err := r.countConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, false
+	}
+	return counted, true
+}
+
+func (r *router) acceptConn(conn net.Conn) {
+	_, err := r.incommingConn(context.Background(), conn, IsAccept, PlanePrimary)
+	if err != nil {
+		conn.Close()
+		if err != ErrCanNotConnectToEvilNode && err != io.EOF {
+			log.Error("incommingConn err", err)
+		}
+	}
+}
+
+// limitConn applies the bandwidth limits and the shared budgets of the config to the physical connection
+func (r *router) limitConn(conn net.Conn) net.Conn {
+	return r.conditionConn(r.rateLimitConn(conn))
+}
+
+func (r *router) rateLimitConn(conn net.Conn) net.Conn {
+	c := r.conf()
+	readBudget, writeBudget := r.budgets()
+	if c.ReadLimit <= 0 && c.WriteLimit <= 0 && readBudget == nil && writeBudget == nil {
+		return conn
+	}
+	return &limitedConn{
+		Conn:         conn,
+		readLimiter:  newRateLimiter(c.ReadLimit, c.RateLimitBurst),
+		writeLimiter: newRateLimiter(c.WriteLimit, c.RateLimitBurst),
+		readBudget:   readBudget,
+		writeBudget:  writeBudget,
+	}
+}
+
+// dial connects to the address in the dial timeout, the hostname of the address is resolved
+func (r *router) dial(addr string) (net.Conn, error) {
+	if r.conf().Dialer != nil {
+		return r.dialWith(context.Background(), addr)
+	}
+	if IsUnixAddress(addr) {
+		return net.DialTimeout("unix", unixPath(addr), r.dialTimeout())
+	}
+	if r.isHostname(addr) {
+		return r.dialHostname(addr)
+	}
+	return r.dialAddr(addr)
+}
+
+// dialAddr connects to the address of the IP in the dial timeout
+func (r *router) dialAddr(addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if r.bindsDial() && isIPNetwork(r.conf().Network) {
+		conn, err = r.dialFrom(addr)
+	} else {
+		conn, err = r.dialTimeoutNetwork(addr, r.dialTimeout())
+	}
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return conn, ErrDialTimeout
+		}
+		return conn, err
+	}
+	r.tuneConn(conn)
+	return conn, nil
+}
+
+// isIPNetwork returns the network is the one of the operating system which can bind the source address
+func isIPNetwork(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	default:
+		return false
+	}
+}
+
+// dialContext dials the address and gives up waiting the dial when the context is done
+func (r *router) dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	if r.conf().Dialer != nil {
+		return r.dialWith(ctx, addr)
+	}
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	resultCh := make(chan dialResult, 1)
+	go func() {
+		conn, err := r.dial(addr)
+		resultCh <- dialResult{conn: conn, err: err}
+	}()
+	select {
+	case res := <-resultCh:
+		return res.conn, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-resultCh; res.conn != nil {
+				res.conn.Close()
 			}
-		}(conn)
+		}()
+		return nil, ctx.Err()
 	}
 }
 
@@ -189,17 +1001,11 @@ func (r *router) ConnList() []string {
 	return s
 }
 
-func (r *router) incommingConn(conn net.Conn, typeis TypeIs) (*RouterConn, error) {
-	if r.localhost == "" {
+func (r *router) incommingConn(ctx context.Context, conn net.Conn, typeis TypeIs, plane uint8) (*RouterConn, error) {
+	if r.localhost == "" && !isUnixConn(conn) {
 		r.setLocalhost(conn.LocalAddr().String())
 	}
-	addr := conn.RemoteAddr().String()
-	if raddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		addr = raddr.IP.String()
-	} else {
-		addrs := strings.Split(addr, ":")
-		addr = strings.Join(addrs[:len(addrs)-1], ":")
-	}
+	addr := r.physicalAddr(conn)
 
 	r.WaitHandshackConnLock.Lock("check")
 	_, has := r.WaitHandshackConn[addr]
@@ -216,37 +1022,67 @@ func (r *router) incommingConn(conn net.Conn, typeis TypeIs) (*RouterConn, error
 		return nil, ErrDuplicateAccept
 	}
 
-	if r.evilNodeManager.IsBanNode(addr) {
+	if !r.pinned.has(addr) && r.evilNodeManager.IsBanNode(addr) {
 		return nil, ErrCanNotConnectToEvilNode
 	}
 
 	pc := newRouterConn(addr, conn, r)
+	pc.typeis = typeis
+	pc.plane = plane
+	if typeis == IsDial && plane == PlanePrimary {
+		if e := r.resumes.dialedSession(addr); e != nil {
+			pc.session, pc.sessionKey, pc.sessionSecret, pc.previous = e.token, e.remoteKey, e.secret, e.pc
+		}
+	}
 
-	errCh := make(chan error)
+	handshakeStart := time.Now()
+	handshakeDeadline := time.Now().Add(r.handshakeTimeout())
+	pc.readDeadline = handshakeDeadline
+	errCh := make(chan error, 1)
 	go func(pc *RouterConn) {
 		var err error
 		if typeis == IsDial {
 			pc.handshakeSend(r.ChainCoord)
 			_, err = pc.handshakeRecv()
+			if err == nil && pc.remoteKey != nil && !pc.resumed {
+				if pc.remoteID == "" {
+					err = ErrInvalidSignature
+				} else if pc.remoteChallenge != nil {
+					pc.handshakeProofSend()
+				}
+			}
 		} else {
+			// the whole handshake and the chain coordinate should be received before the header deadline
+			if d := time.Now().Add(r.headerTimeout()); d.Before(handshakeDeadline) {
+				pc.readDeadline = d
+			}
 			var cc *common.Coordinate
 			cc, err = pc.handshakeRecv()
+			pc.readDeadline = handshakeDeadline
 			if err == nil {
-				if cc.Equal(r.ChainCoord) {
+				if err = r.coordAcceptor.accept(r.ChainCoord, cc); err == nil {
 					pc.handshakeSend(r.ChainCoord)
+					if pc.remoteKey != nil && !pc.resumed {
+						err = pc.handshakeProofRecv()
+					}
 				} else {
-					err = ErrMismatchCoordinate
+					// the dialer is told the reason instead of waiting the handshake timeout
+					pc.rejection = err.Error()
+					pc.handshakeSend(r.ChainCoord)
 				}
 			}
 		}
 		errCh <- err
 	}(pc)
 
-	deadTimer := time.NewTimer(5 * time.Second)
+	deadTimer := time.NewTimer(r.handshakeTimeout())
 	var endErr error
 	select {
 	case <-deadTimer.C:
-		endErr = ErrPeerTimeout
+		endErr = ErrHandshakeTimeout
+	case <-ctx.Done():
+		deadTimer.Stop()
+		endErr = ctx.Err()
 	case err := <-errCh:
 		deadTimer.Stop()
 		if err != nil {
@@ -256,8 +1092,33 @@ func (r *router) incommingConn(conn net.Conn, typeis TypeIs) (*RouterConn, error
 
 	if endErr != nil {
 		pc.Close()
+		if typeis == IsAccept && isTimeout(endErr) {
+			r.punishSlowHandshake(addr)
+		}
+		r.emit(Event{Kind: EventHandshakeFailed, Addr: addr, TypeIs: typeis, Err: endErr})
 		return nil, endErr
 	}
+	pc.readDeadline = time.Time{}
+	pc.handshakeDuration = time.Now().Sub(handshakeStart)
+	r.meter.observeHandshake(pc.handshakeDuration)
+	pc.previous = nil
+	pc.completeNegotiation()
+	if (len(pc.coords) > 0 || pc.channels != nil) && pc.plane == PlanePrimary {
+		pc.startDemux()
+	}
+	if pc.plane == PlaneData {
+		if err := r.associateData(addr, pc); err != nil {
+			pc.Close()
+			return nil, err
+		}
+		return pc, nil
+	}
+	if !pc.resumed {
+		// the learned addresses skip the limits, so they are learned only by the signature over the fresh challenge
+		r.pinned.learn(pc.remoteID, addr, pc.Address)
+	}
+	r.resumes.store(addr, pc, typeis)
+	r.emit(Event{Kind: EventHandshakeCompleted, Addr: addr, TypeIs: typeis, RemoteID: pc.remoteID})
 
 	{
 		r.ConnMapLock.Lock("incommingConn")
@@ -266,9 +1127,18 @@ func (r *router) incommingConn(conn net.Conn, typeis TypeIs) (*RouterConn, error
 			oldPConn.LockFreeClose()
 		}
 		r.ConnMap[addr] = pc
-		r.AcceptConnChan <- pc
+		select {
+		case r.AcceptConnChan <- pc:
+		case <-r.closeCh:
+			pc.LockFreeClose()
+			r.ConnMapLock.Unlock()
+			return nil, ErrRouterClosed
+		}
 		r.ConnMapLock.Unlock()
 	}
+	if pc.demux != nil {
+		r.deliverCoords(pc)
+	}
 
 	return pc, nil
 }
@@ -277,45 +1147,152 @@ func (r *router) chainCoord() *common.Coordinate {
 	return r.ChainCoord
 }
 
-func (r *router) localAddress() string {
-	return r.Config.Address
+func (r *router) compressions() []uint8 {
+	return r.conf().Compressions
 }
 
-func (r *router) port() int {
-	return r.Config.Port
+func (r *router) channelWindow() int {
+	if r.conf().ChannelWindow > 0 {
+		return r.conf().ChannelWindow
+	}
+	return DefaultChannelWindow
 }
 
-func (r *router) acceptConn(pc *RouterConn) error {
-	return nil
+func (r *router) streamThreshold() int {
+	if r.conf().StreamThreshold < 0 {
+		return 0
+	}
+	if r.conf().StreamThreshold > 0 {
+		return r.conf().StreamThreshold
+	}
+	return DefaultStreamThreshold
 }
 
-func (r *router) unsafeRemoveRouterConn(conn net.Conn) {
-	addr := conn.RemoteAddr().String()
-	if raddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		addr = raddr.IP.String()
-	} else {
-		addrs := strings.Split(addr, ":")
-		addr = strings.Join(addrs[:len(addrs)-1], ":")
+func (r *router) compressionThreshold() int {
+	return r.conf().CompressionThreshold
+}
+
+func (r *router) offload(f func() error) error {
+	return r.handshakePool.Do(f)
+}
+
+func (r *router) dialTimeout() time.Duration {
+	if r.conf().DialTimeout > 0 {
+		return r.conf().DialTimeout
+	}
+	return DefaultDialTimeout
+}
+
+func (r *router) handshakeTimeout() time.Duration {
+	if r.conf().HandshakeTimeout > 0 {
+		return r.conf().HandshakeTimeout
 	}
+	return DefaultHandshakeTimeout
+}
 
-	delete(r.ConnMap, addr)
-	conn.Close()
+func (r *router) idleTimeout() time.Duration {
+	return r.conf().IdleTimeout
+}
+
+func (r *router) writeTimeout() time.Duration {
+	if r.conf().WriteTimeout > 0 {
+		return r.conf().WriteTimeout
+	}
+	return DefaultWriteTimeout
+}
+
+func (r *router) readTimeout() time.Duration {
+	if r.conf().ReadTimeout > 0 {
+		return r.conf().ReadTimeout
+	}
+	return DefaultReadTimeout
+}
+
+func (r *router) keepAliveInterval() time.Duration {
+	if r.conf().KeepAliveInterval > 0 {
+		return r.conf().KeepAliveInterval
+	}
+	return DefaultKeepAliveInterval
+}
+
+func (r *router) keepAliveProbes() int {
+	if r.conf().KeepAliveProbes > 0 {
+		return r.conf().KeepAliveProbes
+	}
+	return DefaultKeepAliveProbes
+}
+
+// unsafeRemoveRouterConn removes the connection by the key which it is stored with,
+// the key of the Unix domain socket is not the one of the remote address
+func (r *router) unsafeRemoveRouterConn(pc *RouterConn) {
+	// the connection of the failed handshake doesn't remove the established one of the same host
+	if old, has := r.ConnMap[pc.physical]; has && old == pc {
+		delete(r.ConnMap, pc.physical)
+	}
+	pc.pConn.Close()
 }
 
-func (r *router) removeRouterConn(conn net.Conn) {
+func (r *router) removeRouterConn(pc *RouterConn) {
 	r.ConnMapLock.Lock("removeRouterConn")
 	defer r.ConnMapLock.Unlock()
-	r.unsafeRemoveRouterConn(conn)
+	r.unsafeRemoveRouterConn(pc)
 }
 
-func removePort(addr string) (string, error) {
-	splitPort := strings.Split(addr, ":")
-	if len(splitPort) > 1 {
-		lastOne := splitPort[len(splitPort)-1]
-		if _, err := strconv.Atoi(lastOne); err == nil {
-			addr = strings.Join(splitPort[:len(splitPort)-1], ":")
-			return addr, nil
-		}
+// RemovePort returns the host part of the address.
+// Bracketed IPv6 addresses like [::1]:3000 are returned without brackets.
+// When the address has no valid port, the address itself is returned with ErrNotFoundPort.
+func RemovePort(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), ErrNotFoundPort
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return host, ErrNotFoundPort
+	}
+	return host, nil
+}
+
+// JoinHostPort combines host and port into a network address.
+// IPv6 hosts are bracketed like [::1]:3000.
+func JoinHostPort(host string, port int) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// IsSameHost reports whether both addresses point to the same host regardless of the port
+func IsSameHost(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	hostA, _ := RemovePort(a)
+	hostB, _ := RemovePort(b)
+	return hostA == hostB
+}
+
+// IsSameAddress reports whether both addresses point to the same node.
+// The ports are compared when both addresses have them and only the hosts are compared otherwise,
+// so the other nodes on the same host are not treated as the same node.
+func IsSameAddress(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	hostA, errA := RemovePort(a)
+	hostB, errB := RemovePort(b)
+	if hostA != hostB {
+		return false
+	}
+	if errA != nil || errB != nil {
+		return true
+	}
+	_, portA, _ := net.SplitHostPort(a)
+	_, portB, _ := net.SplitHostPort(b)
+	return portA == portB
+}
+
+func hostOf(addr net.Addr) string {
+	if raddr, ok := addr.(*net.TCPAddr); ok {
+		return raddr.IP.String()
 	}
-	return addr, ErrNotFoundPort
+	host, _ := RemovePort(addr.String())
+	return host
 }
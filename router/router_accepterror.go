@@ -0,0 +1,84 @@
+package router
+
+import (
+	"net"
+	"strings"
+)
+
+// AcceptErrorKind is the class of the error of a listener
+type AcceptErrorKind int
+
+// accept error kinds
+const (
+	// AcceptTemporary is retried by the listener after a backoff
+	AcceptTemporary AcceptErrorKind = 1
+	// AcceptFatal stops the listener
+	AcceptFatal AcceptErrorKind = 2
+	// AcceptListenerClosed is the listener closed without closing the router
+	AcceptListenerClosed AcceptErrorKind = 3
+)
+
+func (k AcceptErrorKind) String() string {
+	switch k {
+	case AcceptTemporary:
+		return "temporary"
+	case AcceptFatal:
+		return "fatal"
+	case AcceptListenerClosed:
+		return "listener closed"
+	default:
+		return "unknown"
+	}
+}
+
+// AcceptError is the classified error of a listener which is returned by Accept
+// The temporary errors are retried by the listener with a backoff and are not returned
+type AcceptError struct {
+	Kind     AcceptErrorKind
+	Listener string
+	Err      error
+}
+
+func (e *AcceptError) Error() string {
+	return e.Kind.String() + " accept error of " + e.Listener + " : " + e.Err.Error()
+}
+
+// Temporary returns true when the listener retries
+func (e *AcceptError) Temporary() bool {
+	return e.Kind == AcceptTemporary
+}
+
+// classifyAcceptError returns the kind of the error of the listener
+func classifyAcceptError(err error) AcceptErrorKind {
+	if err == ErrListenerClosed || strings.Contains(err.Error(), "use of closed network connection") {
+		return AcceptListenerClosed
+	}
+	if ne, ok := err.(net.Error); ok && (ne.Temporary() || ne.Timeout()) {
+		return AcceptTemporary
+	}
+	return AcceptFatal
+}
+
+// reportAcceptError passes the error to Accept without blocking the listener
+func (r *router) reportAcceptError(err error) {
+	select {
+	case r.acceptErrCh <- err:
+	default:
+	}
+}
+
+// removeListener drops the stopped listener and reports ErrListenerClosed when none of the listeners is left
+func (r *router) removeListener(l net.Listener) {
+	r.listenerLock.Lock()
+	defer r.listenerLock.Unlock()
+
+	for i, v := range r.listeners {
+		if v == l {
+			r.listeners = append(r.listeners[:i], r.listeners[i+1:]...)
+			if len(r.listeners) == 0 {
+				r.reportAcceptError(ErrListenerClosed)
+			}
+			return
+		}
+	}
+}
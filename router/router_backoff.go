@@ -0,0 +1,155 @@
+package router
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+)
+
+// default redial backoff
+const (
+	DefaultRedialBackoffBase = time.Second
+	DefaultRedialBackoffMax  = 5 * time.Minute
+)
+
+type backoffState struct {
+	failures uint
+	next     time.Time
+}
+
+// redialBackoff schedules the redials of each address by the exponential backoff with jitter
+type redialBackoff struct {
+	lock   sync.Mutex
+	base   time.Duration
+	max    time.Duration
+	states map[string]*backoffState
+	rand   *rand.Rand
+	clock  clock.Clock
+}
+
+func newRedialBackoff(base time.Duration, max time.Duration, clk clock.Clock) *redialBackoff {
+	if base <= 0 {
+		base = DefaultRedialBackoffBase
+	}
+	if max <= 0 {
+		max = DefaultRedialBackoffMax
+	}
+	if max < base {
+		max = base
+	}
+	return &redialBackoff{
+		base:   base,
+		max:    max,
+		states: map[string]*backoffState{},
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		clock:  clock.Or(clk),
+	}
+}
+
+// Wait returns the remaining time until the address can be dialed again
+func (b *redialBackoff) Wait(addr string) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	st, has := b.states[addr]
+	if !has {
+		return 0
+	}
+	d := st.next.Sub(b.clock.Now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Failure doubles the backoff of the address up to the max and picks the next dial time in the latter half of it
+func (b *redialBackoff) Failure(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	st, has := b.states[addr]
+	if !has {
+		st = &backoffState{}
+		b.states[addr] = st
+	}
+	st.failures++
+
+	d := b.max
+	if st.failures < 32 {
+		if exp := b.base << (st.failures - 1); exp > 0 && exp < b.max {
+			d = exp
+		}
+	}
+	half := d / 2
+	d = half + time.Duration(b.rand.Int63n(int64(d-half)+1))
+	st.next = b.clock.Now().Add(d)
+
+	b.expire()
+}
+
+// FailureOf backs off by the class of the failure, the hosts which are possibly down wait twice as long as the refused ones
+func (b *redialBackoff) FailureOf(addr string, kind DialErrorKind) {
+	if kind == DialTimeout || kind == DialUnreachable {
+		b.lock.Lock()
+		if st, has := b.states[addr]; has {
+			st.failures++
+		} else {
+			b.states[addr] = &backoffState{failures: 1}
+		}
+		b.lock.Unlock()
+	}
+	b.Failure(addr)
+}
+
+// Success resets the backoff of the address
+func (b *redialBackoff) Success(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.states, addr)
+}
+
+// expire removes the states which passed the next dial time longer than the max
+func (b *redialBackoff) expire() {
+	now := b.clock.Now()
+	for addr, st := range b.states {
+		if now.Sub(st.next) > b.max {
+			delete(b.states, addr)
+		}
+	}
+}
+
+// backoffEntry is the persisted state of an address
+type backoffEntry struct {
+	addr     string
+	failures uint
+	next     time.Time
+}
+
+// entries returns the states of the addresses which are still waiting the next dial
+func (b *redialBackoff) entries() []backoffEntry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	list := make([]backoffEntry, 0, len(b.states))
+	for addr, st := range b.states {
+		if st.next.After(now) {
+			list = append(list, backoffEntry{addr: addr, failures: st.failures, next: st.next})
+		}
+	}
+	return list
+}
+
+// restore sets the state of the address which is loaded from the disk
+func (b *redialBackoff) restore(e backoffEntry) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.states[e.addr] = &backoffState{
+		failures: e.failures,
+		next:     e.next,
+	}
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fletaio/framework/kvstore"
+	"github.com/fletaio/framework/log"
+)
+
+// blacklist is the operator controlled deny list of the hosts which is kept in the store
+type blacklist struct {
+	sync.RWMutex
+	store kvstore.KVStore
+	hosts map[string]bool
+}
+
+func newBlacklist(c *Config) (*blacklist, error) {
+	store := c.BlacklistStore
+	if store == nil {
+		s, err := kvstore.Open(c.EvilNodeConfig.StoreBackend, blacklistPath(c))
+		if err != nil {
+			return nil, err
+		}
+		store = s
+	}
+	if key := kvstore.StoreKey(c.EvilNodeConfig.StoreKey); key != "" {
+		s, err := kvstore.NewEncrypted(store, key)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store = s
+	}
+	b := &blacklist{
+		store: store,
+		hosts: map[string]bool{},
+	}
+	if err := store.Iterate(func(key []byte, value []byte) bool {
+		b.hosts[string(key)] = true
+		return true
+	}); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// blacklistKey returns the host of the address so that all ports of the host are denied
+func blacklistKey(addr string) string {
+	host, _ := RemovePort(addr)
+	return host
+}
+
+func (b *blacklist) has(addr string) bool {
+	b.RLock()
+	defer b.RUnlock()
+
+	return b.hosts[blacklistKey(addr)]
+}
+
+func (b *blacklist) add(addr string) error {
+	key := blacklistKey(addr)
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.store.Put([]byte(key), []byte{}); err != nil {
+		return err
+	}
+	b.hosts[key] = true
+	return nil
+}
+
+func (b *blacklist) remove(addr string) error {
+	key := blacklistKey(addr)
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.store.Delete([]byte(key)); err != nil {
+		return err
+	}
+	delete(b.hosts, key)
+	return nil
+}
+
+func (b *blacklist) list() []string {
+	b.RLock()
+	defer b.RUnlock()
+
+	list := make([]string, 0, len(b.hosts))
+	for host := range b.hosts {
+		list = append(list, host)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Close flushes and closes the store
+func (b *blacklist) Close() error {
+	return b.store.Close()
+}
+
+// blacklistPath returns the BlacklistPath or the one next to the evil node store
+func blacklistPath(c *Config) string {
+	if c.BlacklistPath != "" {
+		return c.BlacklistPath
+	}
+	return strings.TrimRight(c.EvilNodeConfig.StorePath, "/\\") + "_blacklist"
+}
+
+// Blacklist denies the host of the address permanently, it is checked before any dial and accept
+// and the connections of the host are closed
+func (r *router) Blacklist(addr string) error {
+	if err := r.blacklist.add(addr); err != nil {
+		return err
+	}
+	log.Info("Blacklist ", blacklistKey(addr))
+
+	pcs := []*RouterConn{}
+	r.ConnMapLock.RLock("Blacklist")
+	for _, pc := range r.ConnMap {
+		if blacklistKey(pc.RemoteAddr().String()) == blacklistKey(addr) {
+			pcs = append(pcs, pc)
+		}
+	}
+	r.ConnMapLock.RUnlock()
+	for _, pc := range pcs {
+		pc.Close()
+	}
+	return nil
+}
+
+// Unblacklist removes the host of the address from the blacklist
+func (r *router) Unblacklist(addr string) error {
+	log.Info("Unblacklist ", blacklistKey(addr))
+	return r.blacklist.remove(addr)
+}
+
+// Blacklisted returns the blacklisted hosts
+func (r *router) Blacklisted() []string {
+	return r.blacklist.list()
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"bytes"
+	"sync"
+)
+
+// frameBufferSizes are the size classes of the pooled frame bodies, the larger bodies are allocated for each frame
+var frameBufferSizes = [...]int{512, 4 * 1024, 32 * 1024, 256 * 1024}
+
+var frameBufferPools [len(frameBufferSizes)]sync.Pool
+
+// writeBufferPool keeps the buffers which assemble the frames to be written
+var writeBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getFrameBuffer returns the buffer of the n bytes from the pool of its size class
+func getFrameBuffer(n int) []byte {
+	for i, size := range frameBufferSizes {
+		if n <= size {
+			if bs, ok := frameBufferPools[i].Get().([]byte); ok {
+				return bs[:n]
+			}
+			return make([]byte, n, size)
+		}
+	}
+	return make([]byte, n)
+}
+
+// putFrameBuffer returns the buffer to the pool of its size class, it should not be used after that
+func putFrameBuffer(bs []byte) {
+	for i, size := range frameBufferSizes {
+		if cap(bs) == size {
+			frameBufferPools[i].Put(bs[:0])
+			return
+		}
+	}
+}
+
+func getWriteBuffer() *bytes.Buffer {
+	buffer := writeBufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return buffer
+}
+
+// putWriteBuffer returns the buffer to the pool, the buffers grown by the huge frames are left to the garbage collector
+func putWriteBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > 4*frameBufferSizes[len(frameBufferSizes)-1] {
+		return
+	}
+	writeBufferPool.Put(buffer)
+}
@@ -0,0 +1,333 @@
+package router
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//logical channels of the physical connection, each of them has its own window
+//so a flood of the application frames doesn't starve the peer exchange and the control messages
+const (
+	ChannelApplication  = uint8(0)
+	ChannelControl      = uint8(1)
+	ChannelPeerExchange = uint8(2)
+	numChannels         = uint8(3)
+)
+
+// CHANNELED is the flag of the frame which is followed by the channel byte, the frames without it are of the application channel
+const CHANNELED = uint8(0x20)
+
+// WINDOWUPDATE is the control byte which returns the read bytes to the window of the channel of the other side,
+// it is followed by the 8 bytes of the channel in the upper half and the bytes in the lower half
+const WINDOWUPDATE = 'W'
+
+// DefaultChannelWindow is the window of each logical channel when the ChannelWindow of the config is zero
+const DefaultChannelWindow = 256 * 1024
+
+// frameQueueLimit is the number of the queued frames of the demultiplexed connection without the windows
+const frameQueueLimit = 16
+
+// frameQueue is the frames of a logical connection waiting to be read
+type frameQueue struct {
+	lock   sync.Mutex
+	frames []rawFrame
+	limit  int
+	ready  chan struct{}
+	space  chan struct{}
+}
+
+func newFrameQueue(limit int) *frameQueue {
+	return &frameQueue{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// push queues the frame, it waits for the space of the limited queue and returns false when the done is closed
+func (q *frameQueue) push(f rawFrame, done <-chan struct{}) bool {
+	for {
+		q.lock.Lock()
+		if q.limit <= 0 || len(q.frames) < q.limit {
+			q.frames = append(q.frames, f)
+			q.lock.Unlock()
+			select {
+			case q.ready <- struct{}{}:
+			default:
+			}
+			return true
+		}
+		q.lock.Unlock()
+		select {
+		case <-q.space:
+		case <-done:
+			return false
+		}
+	}
+}
+
+func (q *frameQueue) pop() (rawFrame, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.frames) == 0 {
+		return rawFrame{}, false
+	}
+	f := q.frames[0]
+	q.frames[0] = rawFrame{}
+	q.frames = q.frames[1:]
+	select {
+	case q.space <- struct{}{}:
+	default:
+	}
+	// the frames left are signaled again for the next reader
+	if len(q.frames) > 0 {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+	return f, true
+}
+
+// channelWindow is the bytes which are allowed to be sent in the channel before the other side reads them
+type channelWindow struct {
+	lock  sync.Mutex
+	avail int64
+	wake  chan struct{}
+}
+
+// acquire takes the bytes from the window, a frame larger than the window is sent when the window is not exhausted
+func (w *channelWindow) acquire(n int, timeout time.Duration, done <-chan struct{}) error {
+	var timer *time.Timer
+	for {
+		w.lock.Lock()
+		if w.avail > 0 {
+			w.avail -= int64(n)
+			w.lock.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		}
+		wake := w.wake
+		w.lock.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+		}
+		select {
+		case <-wake:
+		case <-done:
+			timer.Stop()
+			return ErrNotConnected
+		case <-timer.C:
+			return ErrWriteTimeout
+		}
+	}
+}
+
+func (w *channelWindow) credit(n int64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.avail += n
+	close(w.wake)
+	w.wake = make(chan struct{})
+}
+
+// channelState is the windows of the logical channels of the physical connection
+type channelState struct {
+	send    [numChannels]*channelWindow
+	lock    sync.Mutex
+	window  int64
+	unacked [numChannels]int64
+	conns   [numChannels]*channelConn
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newChannelState makes the windows of the channels, the send window is the one advertised by the other side
+func newChannelState(sendWindow int64, recvWindow int64) *channelState {
+	cs := &channelState{
+		window: recvWindow,
+		done:   make(chan struct{}),
+	}
+	for i := range cs.send {
+		cs.send[i] = &channelWindow{
+			avail: sendWindow,
+			wake:  make(chan struct{}),
+		}
+	}
+	return cs
+}
+
+// read counts the read bytes of the channel and returns the bytes to be returned to the other side,
+// they are returned in a quarter of the window at least so the updates don't follow every frame
+func (cs *channelState) read(ch uint8, n int) int64 {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.unacked[ch] += int64(n)
+	if cs.unacked[ch] < cs.window/4 {
+		return 0
+	}
+	v := cs.unacked[ch]
+	cs.unacked[ch] = 0
+	return v
+}
+
+func (cs *channelState) close() {
+	cs.once.Do(func() {
+		close(cs.done)
+	})
+}
+
+// acquireWindow waits for the window of the channel, the frames are not limited before the negotiation
+func (pc *RouterConn) acquireWindow(ch uint8, n int) error {
+	cs := pc.channels
+	if cs == nil || ch >= numChannels || atomic.LoadInt32(&pc.negotiated) == 0 {
+		return nil
+	}
+	return cs.send[ch].acquire(n, pc.r.writeTimeout(), cs.done)
+}
+
+// creditChannel returns the bytes of the read frame to the window of the other side
+func (pc *RouterConn) creditChannel(f rawFrame) {
+	cs := pc.channels
+	if cs == nil || f.cost == 0 || f.channel >= numChannels {
+		return
+	}
+	if n := cs.read(f.channel, f.cost); n > 0 {
+		pc.writeControl(WINDOWUPDATE, uint64(f.channel)<<32|uint64(uint32(n)))
+	}
+}
+
+// updateWindow returns the bytes read by the other side to the window of the channel
+func (pc *RouterConn) updateWindow(v uint64) {
+	cs := pc.channels
+	ch := uint8(v >> 32)
+	if cs == nil || ch >= numChannels {
+		return
+	}
+	cs.send[ch].credit(int64(uint32(v)))
+}
+
+// Channel returns the logical connection of the channel, it is nil when the other side doesn't support the channels.
+// The application channel is the connection itself and the other channels share the physical connection with their own windows.
+func (pc *RouterConn) Channel(ch uint8) Conn {
+	if ch == ChannelApplication {
+		return pc
+	}
+	cs := pc.channels
+	if cs == nil || pc.demux == nil || ch >= numChannels {
+		return nil
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if cs.conns[ch] == nil {
+		cs.conns[ch] = &channelConn{
+			RouterConn: pc,
+			ch:         ch,
+			q:          pc.demux.channels[ch],
+			done:       make(chan struct{}),
+		}
+	}
+	return cs.conns[ch]
+}
+
+// writeChannel sends the body as a frame of the channel, it is compressed over the threshold as Write does
+func (pc *RouterConn) writeChannel(ch uint8, body []byte, exts []Extension) (int, error) {
+	atomic.StoreInt64(&pc.trafficTime, time.Now().UnixNano())
+	threshold := pc.r.compressionThreshold()
+	if pc.compression != UNCOMPRESSED && threshold > 0 && len(body) >= threshold {
+		return pc.writeFrame(body, pc.compression, 0, exts, nil, ch)
+	}
+	return pc.writeFrame(body, UNCOMPRESSED, 0, exts, nil, ch)
+}
+
+// channelConn is the logical connection of a channel over the physical connection.
+// Closing it drops the frames of the channel and the physical connection is kept.
+type channelConn struct {
+	*RouterConn
+	ch      uint8
+	q       *frameQueue
+	readBuf bytes.Buffer
+	exts    []Extension
+	closed  int32
+	done    chan struct{}
+}
+
+func (c *channelConn) Read(b []byte) (int, error) {
+	if c.readBuf.Len() == 0 {
+		f, err := c.RouterConn.demux.read(c.q, c.done)
+		if err != nil {
+			return 0, err
+		}
+		c.exts = f.exts
+		c.readBuf.Write(f.body)
+		releaseFrame(f)
+	}
+	return c.readBuf.Read(b)
+}
+
+// Release does nothing because the body of the frame is copied and returned to the pool by Read
+func (c *channelConn) Release() error {
+	return nil
+}
+
+// Remaining returns the unread bytes of the copied body of the frame
+func (c *channelConn) Remaining() int {
+	return c.readBuf.Len()
+}
+
+func (c *channelConn) Write(body []byte) (int, error) {
+	return c.RouterConn.writeChannel(c.ch, body, nil)
+}
+
+func (c *channelConn) WriteExtended(body []byte, exts []Extension) (int, error) {
+	if !c.RouterConn.extended {
+		exts = nil
+	}
+	return c.RouterConn.writeChannel(c.ch, body, exts)
+}
+
+func (c *channelConn) WriteBatch(bodies [][]byte) (int, error) {
+	var wrote int
+	for _, body := range bodies {
+		n, err := c.Write(body)
+		wrote += n
+		if err != nil {
+			return wrote, err
+		}
+	}
+	return wrote, nil
+}
+
+func (c *channelConn) Extensions() []Extension {
+	return c.exts
+}
+
+// Features returns the features of the physical connection except the data plane which is of the application channel
+func (c *channelConn) Features() uint32 {
+	return c.RouterConn.Features() &^ FeatureDataPlane
+}
+
+func (c *channelConn) Data() Conn {
+	return nil
+}
+
+func (c *channelConn) DataReady() <-chan struct{} {
+	return nil
+}
+
+func (c *channelConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.RouterConn.demux.removeChannel(c.ch)
+		close(c.done)
+	}
+	return nil
+}
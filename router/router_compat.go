@@ -0,0 +1,172 @@
+package router
+
+import (
+	"bytes"
+	"hash/crc32"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fletaio/common/util"
+)
+
+//features which are negotiated in the handshake
+const (
+	FeatureRTT       = uint32(1) << 0
+	FeatureDataPlane = uint32(1) << 1
+	// FeatureReply is the reply envelope of the messages of the peer manager
+	FeatureReply = uint32(1) << 2
+	// FeatureChecksumC checksums the frames by CRC-32C instead of CRC-32 IEEE after the handshake
+	FeatureChecksumC = uint32(1) << 3
+	// FeatureBatch sends the bodies of WriteBatch in a frame
+	FeatureBatch = uint32(1) << 4
+	// FeatureMultiCoord shares the physical connection between the coordinates registered by both sides
+	FeatureMultiCoord = uint32(1) << 5
+	// FeatureChannels splits the frames into the logical channels which have their own windows
+	FeatureChannels = uint32(1) << 6
+	// FeaturePeerListDiff exchanges the nodes of the peer manager which are updated after the last peer list
+	// and are not in the bloom filter of the known nodes of the requester
+	FeaturePeerListDiff = uint32(1) << 7
+	// FeatureGossip is the gossip envelope of the broadcasts of the peer manager which carries their message IDs
+	FeatureGossip = uint32(1) << 8
+	// FeatureGossipCast is the envelope of the GossipCast of the peer manager which is forwarded by the receivers in its hops
+	FeatureGossipCast = uint32(1) << 9
+)
+
+//features which are negotiated by the older fields of the handshake, they are not sent in the feature bits
+const (
+	FeatureCompression = uint32(1) << 30
+	FeatureExtensions  = uint32(1) << 31
+
+	legacyFeatures = FeatureCompression | FeatureExtensions
+)
+
+// localFeatures are the features which are supported by this node
+const localFeatures = FeatureRTT | FeatureDataPlane | FeatureReply | FeatureChecksumC | FeatureBatch | FeatureMultiCoord | FeatureChannels | FeaturePeerListDiff | FeatureGossip | FeatureGossipCast
+
+// BATCHED is the flag of the frame whose body is the length prefixed bodies of WriteBatch
+const BATCHED = uint8(0x40)
+
+var featureNames = []struct {
+	f    uint32
+	name string
+}{
+	{FeatureRTT, "rtt"},
+	{FeatureDataPlane, "dataplane"},
+	{FeatureReply, "reply"},
+	{FeatureChecksumC, "crc32c"},
+	{FeatureBatch, "batch"},
+	{FeatureMultiCoord, "multicoord"},
+	{FeatureChannels, "channels"},
+	{FeaturePeerListDiff, "peerlistdiff"},
+	{FeatureGossip, "gossip"},
+	{FeatureGossipCast, "gossipcast"},
+	{FeatureCompression, "compression"},
+	{FeatureExtensions, "extensions"},
+}
+
+// FeatureString returns the names of the features joined by commas
+func FeatureString(features uint32) string {
+	names := []string{}
+	for _, v := range featureNames {
+		if features&v.f != 0 {
+			names = append(names, v.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// CastagnoliTable is the table of CRC-32C which is used with FeatureChecksumC
+var CastagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// features returns the local features which are offered in the handshake except the DisabledFeatures
+func (r *router) features() uint32 {
+	return (localFeatures | legacyFeatures) &^ r.conf().DisabledFeatures
+}
+
+// Features returns the features which are supported by both sides, the frames to the peer which lacks one are in the old format
+func (pc *RouterConn) Features() uint32 {
+	return pc.features
+}
+
+func (pc *RouterConn) hasFeature(f uint32) bool {
+	return pc.features&f != 0
+}
+
+// negotiate derives the feature set of the connection from the handshake of the other side
+func (pc *RouterConn) negotiate(h *handshake) {
+	local := pc.r.features()
+	pc.compression = UNCOMPRESSED
+	if local&FeatureCompression != 0 {
+		pc.compression = negotiateCompression(pc.r.compressions(), h.Compressions)
+	}
+	pc.extended = h.Extended && local&FeatureExtensions != 0
+	pc.features = h.Features & local &^ legacyFeatures
+	if pc.compression != UNCOMPRESSED {
+		pc.features |= FeatureCompression
+	}
+	if pc.extended {
+		pc.features |= FeatureExtensions
+	}
+	if pc.hasFeature(FeatureMultiCoord) && pc.plane == PlanePrimary && h.Plane == PlanePrimary {
+		pc.coords = sharedCoords(pc.r.registeredCoords(), h.Coords)
+	}
+	if pc.hasFeature(FeatureChannels) && pc.plane == PlanePrimary && h.Plane == PlanePrimary && h.Window > 0 {
+		pc.channels = newChannelState(int64(h.Window), int64(pc.r.channelWindow()))
+	}
+}
+
+// completeNegotiation switches the frames to the negotiated format after the handshake frames
+func (pc *RouterConn) completeNegotiation() {
+	atomic.StoreInt32(&pc.negotiated, 1)
+}
+
+// checksumTable returns the table of the frame checksums, the handshake frames are always checksummed by CRC-32 IEEE
+func (pc *RouterConn) checksumTable() *crc32.Table {
+	if pc.hasFeature(FeatureChecksumC) && atomic.LoadInt32(&pc.negotiated) == 1 {
+		return CastagnoliTable
+	}
+	return IEEETable
+}
+
+// WriteBatch sends the bodies in a frame when the other side supports FeatureBatch, otherwise in a frame for each body.
+// The other side reads them as the separate frames either way.
+func (pc *RouterConn) WriteBatch(bodies [][]byte) (int, error) {
+	if !pc.hasFeature(FeatureBatch) || len(bodies) == 1 {
+		var wrote int
+		for _, body := range bodies {
+			n, err := pc.Write(body)
+			wrote += n
+			if err != nil {
+				return wrote, err
+			}
+		}
+		return wrote, nil
+	}
+	var buffer bytes.Buffer
+	for _, body := range bodies {
+		util.WriteUint32(&buffer, uint32(len(body)))
+		buffer.Write(body)
+	}
+	return pc.writeFlagged(buffer.Bytes(), BATCHED)
+}
+
+// splitBatch returns the bodies of the batched frame
+func splitBatch(body []byte) ([][]byte, error) {
+	bodies := [][]byte{}
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, ErrInvalidIntegrity
+		}
+		size := util.BytesToUint32(body[:4])
+		body = body[4:]
+		if uint32(len(body)) < size {
+			return nil, ErrInvalidIntegrity
+		}
+		bodies = append(bodies, body[:size])
+		body = body[size:]
+	}
+	if len(bodies) == 0 {
+		return nil, ErrInvalidIntegrity
+	}
+	return bodies, nil
+}
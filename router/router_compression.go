@@ -0,0 +1,141 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxDecompressedSize is the maximum size of the decompressed body
+const MaxDecompressedSize = 64 * 1024 * 1024
+
+// CompressionStats is the statistics of the compressed frames of the connection
+type CompressionStats struct {
+	Compression      uint8
+	CompressedFrames uint64
+	RawBytes         uint64
+	CompressedBytes  uint64
+}
+
+// Saved returns the number of bytes saved by the compression
+func (cs CompressionStats) Saved() int64 {
+	return int64(cs.RawBytes) - int64(cs.CompressedBytes)
+}
+
+type compressionCounter struct {
+	compressedFrames uint64
+	rawBytes         uint64
+	compressedBytes  uint64
+}
+
+func (cc *compressionCounter) add(raw int, compressed int) {
+	atomic.AddUint64(&cc.compressedFrames, 1)
+	atomic.AddUint64(&cc.rawBytes, uint64(raw))
+	atomic.AddUint64(&cc.compressedBytes, uint64(compressed))
+}
+
+var (
+	zstdInit    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdInit.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+	})
+}
+
+// IsSupportedCompression returns the compression type can be handled by the router
+func IsSupportedCompression(compression uint8) bool {
+	switch compression {
+	case UNCOMPRESSED, COMPRESSED, SNAPPY, ZSTD:
+		return true
+	default:
+		return false
+	}
+}
+
+// negotiateCompression returns the first of the local compressions that is supported by the remote
+func negotiateCompression(local []uint8, remote []uint8) uint8 {
+	for _, l := range local {
+		if !IsSupportedCompression(l) {
+			continue
+		}
+		for _, r := range remote {
+			if l == r {
+				return l
+			}
+		}
+	}
+	return UNCOMPRESSED
+}
+
+func compress(compression uint8, body []byte) ([]byte, error) {
+	switch compression {
+	case UNCOMPRESSED:
+		return body, nil
+	case COMPRESSED:
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		if _, err := gw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gw.Flush(); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case SNAPPY:
+		return snappy.Encode(nil, body), nil
+	case ZSTD:
+		initZstd()
+		return zstdEncoder.EncodeAll(body, nil), nil
+	default:
+		return nil, ErrNotMatchCompressionType
+	}
+}
+
+func decompress(compression uint8, body []byte) ([]byte, error) {
+	switch compression {
+	case UNCOMPRESSED:
+		return body, nil
+	case COMPRESSED:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		var buf bytes.Buffer
+		n, err := buf.ReadFrom(io.LimitReader(gr, MaxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if n > MaxDecompressedSize {
+			return nil, ErrTooLargeBody
+		}
+		return buf.Bytes(), nil
+	case SNAPPY:
+		size, err := snappy.DecodedLen(body)
+		if err != nil {
+			return nil, err
+		}
+		if size > MaxDecompressedSize {
+			return nil, ErrTooLargeBody
+		}
+		return snappy.Decode(nil, body)
+	case ZSTD:
+		initZstd()
+		return zstdDecoder.DecodeAll(body, nil)
+	default:
+		return nil, ErrNotMatchCompressionType
+	}
+}
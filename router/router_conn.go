@@ -10,7 +10,22 @@ import (
 type Conn interface {
 	net.Conn
 	ID() string
+	NodeID() string
+	RemoteID() string
 	SendHeartBit()
+	CompressionStats() CompressionStats
+	WriteExtended(body []byte, exts []Extension) (int, error)
+	WriteBatch(bodies [][]byte) (int, error)
+	Extensions() []Extension
+	Quality() Quality
+	Features() uint32
+	Data() Conn
+	DataReady() <-chan struct{}
+	Channel(ch uint8) Conn
+	Release() error
+	Resumed() bool
+	Outbound() bool
+	Remaining() int
 	// Reset()
 	// PrintData() string
 }
@@ -2,10 +2,11 @@ package router
 
 import (
 	"bytes"
-	"compress/gzip"
+	"crypto/ecdh"
 	"hash/crc32"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fletaio/common"
@@ -13,12 +14,32 @@ import (
 )
 
 type routerPhysical interface {
-	acceptConn(pc *RouterConn) error
-	localAddress() string
+	advertise(local net.Addr) (string, int)
 	chainCoord() *common.Coordinate
-	removeRouterConn(conn net.Conn)
-	unsafeRemoveRouterConn(conn net.Conn)
-	port() int
+	registeredCoords() []*common.Coordinate
+	removeRouterConn(pc *RouterConn)
+	unsafeRemoveRouterConn(pc *RouterConn)
+	compressions() []uint8
+	features() uint32
+	channelWindow() int
+	streamThreshold() int
+	compressionThreshold() int
+	writeTimeout() time.Duration
+	readTimeout() time.Duration
+	keepAliveInterval() time.Duration
+	keepAliveProbes() int
+	idleTimeout() time.Duration
+	offload(f func() error) error
+	nodeID() string
+	networkMagic() []byte
+	publicKey() []byte
+	sign(challenge []byte, share []byte) []byte
+	handshakeExtra() []byte
+	checkHandshake(extra []byte) error
+	resumeSession(token []byte, remoteKey []byte, challenge []byte, proof []byte) *resumeEntry
+	checkReplay(publicKey []byte, challenge []byte) error
+	connClosed(pc *RouterConn)
+	metrics() *routerMetrics
 }
 
 //MAGICWORD Start of packet
@@ -30,7 +51,9 @@ const (
 //compression types
 const (
 	UNCOMPRESSED = uint8(0)
-	COMPRESSED   = uint8(1)
+	COMPRESSED   = uint8(1) // gzip
+	SNAPPY       = uint8(2)
+	ZSTD         = uint8(3)
 )
 
 // IEEETable is common table of CRC-32 polynomial.
@@ -39,6 +62,7 @@ var IEEETable = crc32.MakeTable(crc32.IEEE)
 type RouterConn struct {
 	writeLock sync.Mutex
 	pConn     net.Conn
+	physical  string
 	pingTime  time.Duration
 	isClose   bool
 
@@ -48,10 +72,52 @@ type RouterConn struct {
 	connChan chan *readConn
 	connBuff bytes.Buffer
 
-	heartBitTime time.Time
-
-	readBuf bytes.Buffer
-	c       *dataCase
+	heartBitTime int64
+	trafficTime  int64
+
+	coords []*common.Coordinate
+	demux  *frameDemux
+
+	header [13]byte
+	c      dataCase
+	// readDeadline is the fixed deadline of the reads in the handshake, the ReadTimeout is used when it is zero
+	readDeadline time.Time
+
+	compression        uint8
+	compressionCounter compressionCounter
+
+	extended   bool
+	extensions []Extension
+	features   uint32
+	negotiated int32
+	batched    []rawFrame
+	channels   *channelState
+	quality    qualityEstimator
+	pings      pingWaiters
+	plane      uint8
+	data       *dataPlane
+	primary    *RouterConn
+
+	nodeID string
+
+	challenge       []byte
+	remoteKey       []byte
+	remoteChallenge []byte
+	remoteID        string
+	keyShare        *ecdh.PrivateKey
+	remoteShare     []byte
+	session         []byte
+	sessionKey      []byte
+	sessionSecret   []byte
+	resumed         bool
+	previous        *RouterConn
+	closedTime      int64
+	rejection       string
+
+	connectedTime     int64
+	handshakeDuration time.Duration
+	typeis            TypeIs
+	connCounter       connCounter
 
 	Address string
 }
@@ -64,41 +130,146 @@ type readConn struct {
 
 func newRouterConn(addr string, conn net.Conn, r routerPhysical) *RouterConn {
 	pc := &RouterConn{
-		pConn:        conn,
-		isClose:      false,
-		r:            r,
-		heartBitTime: time.Now(),
+		pConn:         conn,
+		physical:      addr,
+		isClose:       false,
+		r:             r,
+		heartBitTime:  time.Now().UnixNano(),
+		trafficTime:   time.Now().UnixNano(),
+		connectedTime: time.Now().UnixNano(),
+		data:          newDataPlane(),
 	}
-	pc.checkHeartBit()
+	pc.connCounter.total = &r.metrics().traffic
+	go pc.keepAlive()
 	return pc
 
 }
 
-func (pc *RouterConn) checkHeartBit() {
-	go func() {
-		for {
-			time.Sleep(3 * time.Second)
-			passed := time.Now().Sub(pc.heartBitTime)
-			if passed > 15*time.Second {
-				// log.Println("no heartbit while", passed, "/", 15*time.Second, ":", pc.ID(), pc.LocalAddr().String(), pc.RemoteAddr().String())
-				pc.Close()
-				return
-			}
+// keepAlive sends the heartbit in every interval and closes the connection
+// when nothing is received from the other side during the probes
+func (pc *RouterConn) keepAlive() {
+	interval := pc.r.keepAliveInterval()
+	limit := interval * time.Duration(pc.r.keepAliveProbes())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if pc.isClose {
+			return
 		}
-	}()
+		passed := time.Now().Sub(time.Unix(0, atomic.LoadInt64(&pc.heartBitTime)))
+		if passed > limit {
+			// log.Println("no heartbit while", passed, "/", limit, ":", pc.ID(), pc.LocalAddr().String(), pc.RemoteAddr().String())
+			pc.Close()
+			return
+		}
+		// the heartbits keep the connection alive but they are not the traffic of the application
+		if idle := pc.r.idleTimeout(); idle > 0 && time.Now().Sub(time.Unix(0, atomic.LoadInt64(&pc.trafficTime))) > idle {
+			pc.Close()
+			return
+		}
+		if pc.hasFeature(FeatureRTT) {
+			// the ping keeps the connection alive as the heartbit does
+			pc.sendPing()
+		} else {
+			pc.SendHeartBit()
+		}
+	}
 }
 
 func (pc *RouterConn) ID() string {
 	return pc.Address
 }
 
+// NodeID returns the node id of the other side, it is empty when the other side doesn't send it
+func (pc *RouterConn) NodeID() string {
+	return pc.nodeID
+}
+
+// RemoteID returns the hex encoded public key of the other side which is verified in the handshake, it is empty when the other side doesn't have a key
+func (pc *RouterConn) RemoteID() string {
+	return pc.remoteID
+}
+
+// Outbound returns true when the connection is dialed by the local node and false when it is accepted
+func (pc *RouterConn) Outbound() bool {
+	return pc.typeis == IsDial
+}
+
+// Write sends the body as a frame
+// The body is compressed by the negotiated compression when it is larger than the compression threshold
 func (pc *RouterConn) Write(body []byte) (int, error) {
-	return pc.write(body, UNCOMPRESSED)
+	atomic.StoreInt64(&pc.trafficTime, time.Now().UnixNano())
+	threshold := pc.r.compressionThreshold()
+	if pc.compression != UNCOMPRESSED && threshold > 0 && len(body) >= threshold {
+		return pc.write(body, pc.compression, nil)
+	}
+	return pc.write(body, UNCOMPRESSED, nil)
+}
+
+// WriteExtended sends the body as a frame with the extension fields
+// The extensions are dropped when the other side doesn't support them
+func (pc *RouterConn) WriteExtended(body []byte, exts []Extension) (int, error) {
+	atomic.StoreInt64(&pc.trafficTime, time.Now().UnixNano())
+	if !pc.extended {
+		exts = nil
+	}
+	threshold := pc.r.compressionThreshold()
+	if pc.compression != UNCOMPRESSED && threshold > 0 && len(body) >= threshold {
+		return pc.write(body, pc.compression, exts)
+	}
+	return pc.write(body, UNCOMPRESSED, exts)
+}
+
+// Extensions returns the extension fields of the frame which is being read
+func (pc *RouterConn) Extensions() []Extension {
+	return pc.extensions
+}
+
+// CompressionStats returns the statistics of the compressed frames sent by the connection
+func (pc *RouterConn) CompressionStats() CompressionStats {
+	return CompressionStats{
+		Compression:      pc.compression,
+		CompressedFrames: atomic.LoadUint64(&pc.compressionCounter.compressedFrames),
+		RawBytes:         atomic.LoadUint64(&pc.compressionCounter.rawBytes),
+		CompressedBytes:  atomic.LoadUint64(&pc.compressionCounter.compressedBytes),
+	}
+}
+
+func (pc *RouterConn) write(body []byte, compression uint8, exts []Extension) (int, error) {
+	return pc.writeFrame(body, compression, 0, exts, nil, ChannelApplication)
+}
+
+// writeCoord sends the body as a frame of the chain coordinate, it is compressed over the threshold as Write does
+func (pc *RouterConn) writeCoord(coord *common.Coordinate, body []byte, exts []Extension) (int, error) {
+	atomic.StoreInt64(&pc.trafficTime, time.Now().UnixNano())
+	threshold := pc.r.compressionThreshold()
+	if pc.compression != UNCOMPRESSED && threshold > 0 && len(body) >= threshold {
+		return pc.writeFrame(body, pc.compression, 0, exts, coord, ChannelApplication)
+	}
+	return pc.writeFrame(body, UNCOMPRESSED, 0, exts, coord, ChannelApplication)
+}
+
+// writeFlagged sends the body with the flag of the frame, it is compressed over the threshold as Write does
+func (pc *RouterConn) writeFlagged(body []byte, flag uint8) (int, error) {
+	atomic.StoreInt64(&pc.trafficTime, time.Now().UnixNano())
+	threshold := pc.r.compressionThreshold()
+	if pc.compression != UNCOMPRESSED && threshold > 0 && len(body) >= threshold {
+		return pc.writeFrame(body, pc.compression, flag, nil, nil, ChannelApplication)
+	}
+	return pc.writeFrame(body, UNCOMPRESSED, flag, nil, nil, ChannelApplication)
 }
 
-func (pc *RouterConn) write(body []byte, compression uint8) (int, error) {
+// writeFrame sends the frame of the chain coordinate in the logical channel, nil is the own coordinate.
+// It waits for the window of the channel when the channels are negotiated.
+func (pc *RouterConn) writeFrame(body []byte, compression uint8, frameFlag uint8, exts []Extension, coord *common.Coordinate, channel uint8) (int, error) {
 	var wrote int
-	var buffer bytes.Buffer
+
+	if err := pc.acquireWindow(channel, len(body)); err != nil {
+		return wrote, err
+	}
+	// the buffer is returned after the write goroutine is done in any case
+	buffer := getWriteBuffer()
+	defer putWriteBuffer(buffer)
 
 	pc.writeLock.Lock()
 	defer pc.writeLock.Unlock()
@@ -107,57 +278,100 @@ func (pc *RouterConn) write(body []byte, compression uint8) (int, error) {
 		return wrote, ErrNotConnected
 	}
 
-	if n, err := util.WriteUint8(&buffer, MAGICWORD); err == nil {
+	if n, err := util.WriteUint8(buffer, MAGICWORD); err == nil {
 		wrote += int(n)
 	} else {
 		return wrote, err
 	}
 
-	if n, err := pc.r.chainCoord().WriteTo(&buffer); err == nil {
-		wrote += int(n)
-	} else {
-		return wrote, err
+	if coord == nil {
+		coord = pc.r.chainCoord()
 	}
-
-	if n, err := util.WriteUint8(&buffer, compression); err == nil {
+	if n, err := coord.WriteTo(buffer); err == nil {
 		wrote += int(n)
 	} else {
 		return wrote, err
 	}
 
-	if compression == COMPRESSED {
-		buf := &bytes.Buffer{}
-		gw := gzip.NewWriter(buf)
-
-		if _, err := gw.Write(body); err != nil {
+	if compression != UNCOMPRESSED {
+		compressed, err := compress(compression, body)
+		if err != nil {
 			return wrote, err
 		}
-		if err := gw.Flush(); err != nil {
-			return wrote, err
+		if len(compressed) < len(body) {
+			pc.compressionCounter.add(len(body), len(compressed))
+			body = compressed
+		} else {
+			compression = UNCOMPRESSED
 		}
-		if err := gw.Close(); err != nil {
+	}
+
+	var extBs []byte
+	if len(exts) > 0 {
+		bs, err := encodeExtensions(exts)
+		if err != nil {
 			return wrote, err
 		}
+		extBs = bs
+	}
 
-		body = buf.Bytes()
+	flag := compression | frameFlag
+	if len(extBs) > 0 {
+		flag |= EXTENDED
+	}
+	if channel != ChannelApplication {
+		flag |= CHANNELED
+	}
+	if n, err := util.WriteUint8(buffer, flag); err == nil {
+		wrote += int(n)
+	} else {
+		return wrote, err
 	}
 
 	size := len(body)
-	if n, err := util.WriteUint32(&buffer, uint32(size)); err == nil {
+	if n, err := util.WriteUint32(buffer, uint32(size)); err == nil {
 		wrote += int(n)
 	} else {
 		return wrote, err
 	}
 
-	if n, err := buffer.Write(body); err == nil {
+	if channel != ChannelApplication {
+		if n, err := util.WriteUint8(buffer, channel); err == nil {
+			wrote += int(n)
+		} else {
+			return wrote, err
+		}
+	}
+
+	var checksum uint32
+	if len(extBs) > 0 {
+		if n, err := util.WriteUint16(buffer, uint16(len(extBs))); err == nil {
+			wrote += int(n)
+		} else {
+			return wrote, err
+		}
+		if n, err := buffer.Write(extBs); err == nil {
+			wrote += int(n)
+		} else {
+			return wrote, err
+		}
+		checksum = crc32.Checksum(extBs, pc.checksumTable())
+	}
+
+	// the large body is written from its own slice instead of being copied to the buffer
+	vectored := pc.streamed(len(body))
+	if vectored {
+		wrote += len(body)
+	} else if n, err := buffer.Write(body); err == nil {
 		wrote += int(n)
 	} else {
 		return wrote, err
 	}
+	headerSize := buffer.Len()
 
-	checksum := crc32.Checksum(body, IEEETable)
+	checksum = crc32.Update(checksum, pc.checksumTable(), body)
 
-	if n, err := util.WriteUint32(&buffer, checksum); err == nil {
+	if n, err := util.WriteUint32(buffer, checksum); err == nil {
 		wrote += int(n)
 	} else {
 		return wrote, err
@@ -168,18 +382,27 @@ func (pc *RouterConn) write(body []byte, compression uint8) (int, error) {
 	wg.Add(1)
 	go func() {
 		wg.Done()
-		_, err := pc.pConn.Write(buffer.Bytes())
+		frame := net.Buffers{buffer.Bytes()}
+		if vectored {
+			bs := buffer.Bytes()
+			frame = net.Buffers{bs[:headerSize], body, bs[headerSize:]}
+		}
+		n, err := frame.WriteTo(pc.pConn)
+		pc.connCounter.addWritten(uint64(n))
 		if err != nil {
 			pc.Close()
+		} else {
+			atomic.AddUint64(&pc.connCounter.framesSent, 1)
 		}
 		errCh <- err
 	}()
 	wg.Wait()
-	deadTimer := time.NewTimer(5 * time.Second)
+	deadTimer := time.NewTimer(pc.r.writeTimeout())
 	select {
 	case <-deadTimer.C:
 		pc.Close()
-		return wrote, <-errCh
+		<-errCh
+		return wrote, ErrWriteTimeout
 	case err := <-errCh:
 		deadTimer.Stop()
 		return wrote, err
@@ -187,122 +410,269 @@ func (pc *RouterConn) write(body []byte, compression uint8) (int, error) {
 }
 
 type dataCase struct {
-	data   []byte
-	size   int
-	readed int
+	data     []byte
+	size     int
+	readed   int
+	pooled   bool
+	streamed bool
+	checksum uint32
+	err      error
 }
 
+// Read reads the body of the frame and the next frame is read after the body is consumed.
+// The consumed body is returned to the pool by the next Read or Release.
 func (pc *RouterConn) Read(b []byte) (int, error) {
-	if pc.readBuf.Len() == 0 {
-		data, err := pc.ReadConn()
+	if pc.c.readed >= pc.c.size {
+		if err := pc.Release(); err != nil {
+			return 0, err
+		}
+		f, err := pc.readFrame(true)
 		if err != nil {
 			return 0, err
 		}
-		pc.c = &dataCase{
-			data: data,
-			size: len(data),
+		pc.extensions = f.exts
+		pc.c = dataCase{
+			data:     f.body,
+			size:     len(f.body),
+			pooled:   f.pooled,
+			streamed: f.streamed,
+			checksum: f.checksum,
 		}
-
-		if len(b) >= len(pc.c.data) {
-			copy(b[:], pc.c.data[:len(pc.c.data)])
-			pc.c.readed += len(pc.c.data)
-			return len(pc.c.data), nil
+		if f.streamed {
+			pc.c.size = f.cost
 		}
-		copy(b[:], pc.c.data[:len(b)])
-		pc.readBuf.Write(pc.c.data[len(b):])
-		pc.c.readed += len(b)
-		return len(b), nil
 	}
-
-	n, err := pc.readBuf.Read(b)
+	if pc.c.streamed {
+		return pc.readStream(b)
+	}
+	n := copy(b, pc.c.data[pc.c.readed:])
 	pc.c.readed += n
-	return n, err
+	return n, nil
+}
+
+// Release returns the body of the frame which is consumed by Read to the pool,
+// the message parsers call it after the message is read so the buffer is reused before the next frame.
+// The unread bytes of the streamed body are discarded so the next frame is read after exactly the declared length,
+// and the error of the checksum of the streamed body is returned, so the message parsed from it is discarded.
+func (pc *RouterConn) Release() error {
+	if pc.c.streamed {
+		err := pc.discardStream()
+		pc.c = dataCase{}
+		return err
+	}
+	if pc.c.pooled && pc.c.readed >= pc.c.size {
+		putFrameBuffer(pc.c.data)
+		pc.c = dataCase{}
+	}
+	return nil
 }
 
 func (pc *RouterConn) ReadConn() (body []byte, returnErr error) {
-	ChainCoord := &common.Coordinate{}
-	var bs []byte
-	var err error
+	f, err := pc.readFrame(false)
+	return f.body, err
+}
+
+// readFrame reads a frame and returns the body with the extension fields of the frame
+// The frames of the shared coordinates and the other channels are passed to their logical connections when the connection is demultiplexed.
+// The large body is left in the connection to be streamed when the stream is true.
+func (pc *RouterConn) readFrame(stream bool) (rawFrame, error) {
+	if d := pc.demux; d != nil {
+		return d.read(d.primary, nil)
+	}
+	return pc.readRawFrame(stream)
+}
+
+// rawFrame is a frame of the physical connection with the chain coordinate and the logical channel of it.
+// The cost is the bytes of the frame counted by the window of the channel, the bodies of a batch after the first one cost nothing.
+// The pooled body is returned to the pool after it is read.
+// The body of the streamed frame is not read yet and the checksum is of the header fields before it.
+type rawFrame struct {
+	coord    *common.Coordinate
+	channel  uint8
+	body     []byte
+	exts     []Extension
+	cost     int
+	pooled   bool
+	streamed bool
+	checksum uint32
+}
+
+// releaseFrame returns the pooled body of the frame which is dropped or copied
+func releaseFrame(f rawFrame) {
+	if f.pooled {
+		putFrameBuffer(f.body)
+	}
+}
+
+// readRawFrame reads a frame of the physical connection
+func (pc *RouterConn) readRawFrame(stream bool) (f rawFrame, returnErr error) {
+	if len(pc.batched) > 0 {
+		f = pc.batched[0]
+		pc.batched = pc.batched[1:]
+		return f, nil
+	}
+	f.coord = &common.Coordinate{}
+	header := pc.header[:]
 	for {
-		bs, err = pc.readBytes(1) // header 1
-		if err != nil {
+		if err := pc.readFull(header[:1]); err != nil { // header 1
 			returnErr = err
 			return
 		}
-		if bs[0] == HEARTBIT {
-			pc.heartBitTime = time.Now()
-		} else {
+		atomic.StoreInt64(&pc.heartBitTime, time.Now().UnixNano())
+		if header[0] == RTTPING || header[0] == RTTPONG || header[0] == WINDOWUPDATE {
+			if err := pc.readControl(header[0]); err != nil {
+				returnErr = err
+				return
+			}
+			continue
+		}
+		if header[0] != HEARTBIT {
 			break
 		}
 	}
-	header, err := pc.readBytes(11) // 6 + 1 + 4
-	if err != nil {
+	atomic.StoreInt64(&pc.trafficTime, time.Now().UnixNano())
+	if err := pc.readFull(header[1:12]); err != nil { // 6 + 1 + 4
 		returnErr = err
 		return
 	}
-	bs = append(bs, header...)
 
-	bf := bytes.NewBuffer(bs[1:7])
-	ChainCoord.ReadFrom(bf)
+	bf := bytes.NewBuffer(header[1:7])
+	f.coord.ReadFrom(bf)
 
-	compression := uint8(bs[7])
+	compression := uint8(header[7])
+	batched := compression&BATCHED != 0
+	compression &^= BATCHED
 
-	bodySize := util.BytesToUint32(bs[8:])
-	body, err = pc.readBytes(bodySize)
-	if err != nil {
+	if compression&CHANNELED != 0 {
+		compression &^= CHANNELED
+		if err := pc.readFull(header[12:13]); err != nil {
+			returnErr = err
+			return
+		}
+		f.channel = header[12]
+	}
+
+	var checksum uint32
+	if compression&EXTENDED != 0 {
+		compression &^= EXTENDED
+		extSizeBs, err := pc.readBytes(2)
+		if err != nil {
+			returnErr = err
+			return
+		}
+		extBs, err := pc.readBytes(uint32(util.BytesToUint16(extSizeBs)))
+		if err != nil {
+			returnErr = err
+			return
+		}
+		f.exts, err = decodeExtensions(extBs)
+		if err != nil {
+			returnErr = err
+			return
+		}
+		checksum = crc32.Checksum(extBs, pc.checksumTable())
+	}
+
+	bodySize := util.BytesToUint32(header[8:12])
+	if stream && compression == UNCOMPRESSED && !batched && pc.streamed(int(bodySize)) {
+		if MAGICWORD != uint8(header[0]) {
+			returnErr = ErrPacketNotStartedMagicword
+			return
+		}
+		f.cost = int(bodySize)
+		f.streamed = true
+		f.checksum = checksum
+		return
+	}
+	raw := getFrameBuffer(int(bodySize))
+	if err := pc.readFull(raw); err != nil {
+		putFrameBuffer(raw)
 		returnErr = err
 		return
 	}
 
-	if MAGICWORD != uint8(bs[0]) {
+	if MAGICWORD != uint8(header[0]) {
+		putFrameBuffer(raw)
 		returnErr = ErrPacketNotStartedMagicword
 		return
 	}
 
-	checksum := crc32.Checksum(body, IEEETable)
+	checksum = crc32.Update(checksum, pc.checksumTable(), raw)
 
-	if compression == COMPRESSED {
-		var buf bytes.Buffer
-		gr, err := gzip.NewReader(bytes.NewBuffer(body))
-		defer gr.Close()
-		_, err = buf.ReadFrom(gr)
-		if err != nil {
-			returnErr = err
-			return
-		}
-		body = buf.Bytes()
-	} else if compression != UNCOMPRESSED {
-		returnErr = ErrNotMatchCompressionType
+	body, err := decompress(compression, raw)
+	if compression != UNCOMPRESSED {
+		putFrameBuffer(raw)
+	}
+	if err != nil {
+		returnErr = err
 		return
 	}
 
-	checksumBs, err := pc.readBytes(4)
-	if err != nil {
+	if err := pc.readFull(header[:4]); err != nil {
+		releaseFrame(rawFrame{body: body, pooled: compression == UNCOMPRESSED})
 		returnErr = err
 		return
 	}
 
-	readedChecksum := util.BytesToUint32(checksumBs)
+	readedChecksum := util.BytesToUint32(header[:4])
 	if readedChecksum != checksum {
+		releaseFrame(rawFrame{body: body, pooled: compression == UNCOMPRESSED})
 		returnErr = ErrInvalidIntegrity
 		return
 	}
+	atomic.AddUint64(&pc.connCounter.framesReceived, 1)
+	f.body = body
+	f.cost = len(body)
+	f.pooled = compression == UNCOMPRESSED
+	if batched {
+		bodies, err := splitBatch(body)
+		if err != nil {
+			returnErr = err
+			return
+		}
+		// the bodies of the batch share the buffer so it is left to the garbage collector
+		f.body = bodies[0]
+		f.pooled = false
+		for _, b := range bodies[1:] {
+			pc.batched = append(pc.batched, rawFrame{coord: f.coord, channel: f.channel, body: b, exts: f.exts})
+		}
+	}
 
 	return
 }
 
+// setReadDeadline sets the deadline of the next read which is not extended beyond the one of the handshake
+func (pc *RouterConn) setReadDeadline() {
+	if !pc.readDeadline.IsZero() {
+		pc.pConn.SetReadDeadline(pc.readDeadline)
+	} else {
+		pc.pConn.SetReadDeadline(time.Now().Add(pc.r.readTimeout()))
+	}
+}
+
+// readFull fills the buffer from the physical connection
+func (pc *RouterConn) readFull(bs []byte) error {
+	pc.setReadDeadline()
+	filled, err := util.FillBytes(pc.pConn, bs)
+	pc.connCounter.addRead(uint64(filled))
+	return err
+}
+
 func (pc *RouterConn) readBytes(n uint32) (read []byte, returnErr error) {
-	pc.SetDeadline(time.Now().Add(time.Second * 15))
 	bs := make([]byte, n)
-	_, err := util.FillBytes(pc.pConn, bs)
-	if err != nil { //has error
+	if err := pc.readFull(bs); err != nil { //has error
 		return nil, err
 	}
 	return bs, nil
 }
 
 func (pc *RouterConn) SendHeartBit() {
-	_, err := pc.pConn.Write([]byte{HEARTBIT})
+	pc.writeLock.Lock()
+	defer pc.writeLock.Unlock()
+
+	pc.pConn.SetWriteDeadline(time.Now().Add(pc.r.writeTimeout()))
+	n, err := pc.pConn.Write([]byte{HEARTBIT})
+	pc.connCounter.addWritten(uint64(n))
 	if err != nil {
 		pc.pConn.Close()
 	}
@@ -318,16 +688,40 @@ func (pc *RouterConn) LocalAddr() net.Addr {
 //Close is used to sever all physical connections and logical connections related to physical connections
 func (pc *RouterConn) Close() (err error) {
 	pc.isClose = true
+	first := atomic.CompareAndSwapInt64(&pc.closedTime, 0, time.Now().UnixNano())
 	err = pc.pConn.Close()
-	pc.r.removeRouterConn(pc.pConn)
+	if pc.plane == PlaneData {
+		// the data plane is not in the connection map
+		if pc.primary != nil {
+			pc.primary.detachData(pc)
+		}
+		return
+	}
+	pc.r.removeRouterConn(pc)
+	pc.closeData()
+	if first && pc.handshakeDuration > 0 {
+		pc.r.connClosed(pc)
+	}
 	return
 }
 
 //LockFreeClose is used to sever all physical connections and logical connections related to physical connections without lock
 func (pc *RouterConn) LockFreeClose() (err error) {
 	pc.isClose = true
+	first := atomic.CompareAndSwapInt64(&pc.closedTime, 0, time.Now().UnixNano())
 	err = pc.pConn.Close()
-	pc.r.unsafeRemoveRouterConn(pc.pConn)
+	if pc.plane == PlaneData {
+		// the data plane is not in the connection map
+		if pc.primary != nil {
+			pc.primary.detachData(pc)
+		}
+		return
+	}
+	pc.r.unsafeRemoveRouterConn(pc)
+	pc.closeData()
+	if first && pc.handshakeDuration > 0 {
+		pc.r.connClosed(pc)
+	}
 	return err
 }
 
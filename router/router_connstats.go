@@ -0,0 +1,80 @@
+package router
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats is the traffic statistics of a physical connection
+type ConnStats struct {
+	Address           string
+	ConnectedTime     time.Time
+	HandshakeDuration time.Duration
+	BytesRead         uint64
+	BytesWritten      uint64
+	FramesReceived    uint64
+	FramesSent        uint64
+	Features          string
+}
+
+type connCounter struct {
+	bytesRead      uint64
+	bytesWritten   uint64
+	framesReceived uint64
+	framesSent     uint64
+	// total sums the traffic of all the connections of the router
+	total *connCounter
+}
+
+func (c *connCounter) addRead(n uint64) {
+	atomic.AddUint64(&c.bytesRead, n)
+	if c.total != nil {
+		atomic.AddUint64(&c.total.bytesRead, n)
+	}
+}
+
+func (c *connCounter) addWritten(n uint64) {
+	atomic.AddUint64(&c.bytesWritten, n)
+	if c.total != nil {
+		atomic.AddUint64(&c.total.bytesWritten, n)
+	}
+}
+
+// ConnStats returns the traffic statistics of the connection
+func (pc *RouterConn) ConnStats() ConnStats {
+	return ConnStats{
+		Address:           pc.Address,
+		ConnectedTime:     time.Unix(0, pc.connectedTime),
+		HandshakeDuration: pc.handshakeDuration,
+		BytesRead:         atomic.LoadUint64(&pc.connCounter.bytesRead),
+		BytesWritten:      atomic.LoadUint64(&pc.connCounter.bytesWritten),
+		FramesReceived:    atomic.LoadUint64(&pc.connCounter.framesReceived),
+		FramesSent:        atomic.LoadUint64(&pc.connCounter.framesSent),
+		Features:          FeatureString(pc.features),
+	}
+}
+
+// ConnStats returns the traffic statistics of the connection of the address
+func (r *router) ConnStats(addr string) (ConnStats, error) {
+	host, _ := RemovePort(addr)
+
+	r.ConnMapLock.RLock("ConnStats")
+	pc, has := r.ConnMap[host]
+	r.ConnMapLock.RUnlock()
+	if !has {
+		return ConnStats{}, ErrNotConnected
+	}
+	return pc.ConnStats(), nil
+}
+
+// allConnStats returns the traffic statistics of all the connections
+func (r *router) allConnStats() []ConnStats {
+	r.ConnMapLock.RLock("allConnStats")
+	defer r.ConnMapLock.RUnlock()
+
+	list := make([]ConnStats, 0, len(r.ConnMap))
+	for _, pc := range r.ConnMap {
+		list = append(list, pc.ConnStats())
+	}
+	return list
+}
@@ -0,0 +1,60 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/fletaio/common"
+)
+
+// CoordFilter decides whether the inbound connection of the chain coordinate is accepted
+type CoordFilter func(coord *common.Coordinate) error
+
+// RejectionError is returned to the dialer when the other side rejects the connection in the handshake
+type RejectionError struct {
+	Reason string
+}
+
+func (e *RejectionError) Error() string {
+	return "rejected by the other side : " + e.Reason
+}
+
+// coordAcceptor decides the chain coordinates accepted in the handshake
+type coordAcceptor struct {
+	lock   sync.RWMutex
+	any    bool
+	coords []*common.Coordinate
+	filter CoordFilter
+}
+
+// accept returns nil when the coordinate is the own one, the filter accepts it or it is in the accept list
+func (ca *coordAcceptor) accept(own *common.Coordinate, coord *common.Coordinate) error {
+	if coord.Equal(own) {
+		return nil
+	}
+	ca.lock.RLock()
+	filter := ca.filter
+	if filter == nil {
+		defer ca.lock.RUnlock()
+		if ca.any {
+			return nil
+		}
+		for _, c := range ca.coords {
+			if coord.Equal(c) {
+				return nil
+			}
+		}
+		return ErrMismatchCoordinate
+	}
+	ca.lock.RUnlock()
+	// the filter is called without the lock so it can set the filter again
+	return filter(coord)
+}
+
+// SetCoordFilter sets the callback which decides the chain coordinates of the inbound connections except the own one.
+// The AcceptCoords and AcceptAnyCoord are ignored while it is set and nil removes it.
+func (r *router) SetCoordFilter(filter CoordFilter) {
+	r.coordAcceptor.lock.Lock()
+	defer r.coordAcceptor.lock.Unlock()
+
+	r.coordAcceptor.filter = filter
+}
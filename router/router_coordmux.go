@@ -0,0 +1,390 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fletaio/common"
+)
+
+// coordKey identifies the chain coordinate in the maps
+func coordKey(coord *common.Coordinate) string {
+	var bf bytes.Buffer
+	coord.WriteTo(&bf)
+	return bf.String()
+}
+
+// coordRegistry is the chain coordinates served in addition to the own one over the same physical connections
+type coordRegistry struct {
+	lock   sync.RWMutex
+	coords []*common.Coordinate
+	accept map[string]chan Conn
+}
+
+func newCoordRegistry() *coordRegistry {
+	return &coordRegistry{
+		accept: map[string]chan Conn{},
+	}
+}
+
+// RegisterCoord serves the chain coordinate in addition to the own one over the same physical connections.
+// The frames are tagged with the coordinate and the logical connections of it are returned by AcceptCoord
+// for the peers which registered it too, so a node of the multiple chains keeps one physical connection per peer.
+func (r *router) RegisterCoord(coord *common.Coordinate) error {
+	if coord == nil || coord.Equal(r.ChainCoord) {
+		return ErrMismatchCoordinate
+	}
+	r.coords.lock.Lock()
+	defer r.coords.lock.Unlock()
+
+	key := coordKey(coord)
+	if _, has := r.coords.accept[key]; has {
+		return ErrAlreadyRegisteredCoord
+	}
+	if len(r.coords.coords) >= maxHandshakeCoords {
+		return ErrTooManyCoords
+	}
+	r.coords.coords = append(r.coords.coords, coord)
+	r.coords.accept[key] = make(chan Conn)
+	return nil
+}
+
+// AcceptCoord returns a logical connection of the registered chain coordinate
+// when a physical connection to the peer which registered it too is established.
+func (r *router) AcceptCoord(coord *common.Coordinate) (Conn, time.Duration, error) {
+	if coord == nil || coord.Equal(r.ChainCoord) {
+		return nil, 0, ErrMismatchCoordinate
+	}
+	return r.AcceptContext(context.Background(), coord)
+}
+
+// registeredCoords returns the chain coordinates registered by RegisterCoord
+func (r *router) registeredCoords() []*common.Coordinate {
+	r.coords.lock.RLock()
+	defer r.coords.lock.RUnlock()
+
+	return append([]*common.Coordinate{}, r.coords.coords...)
+}
+
+func (r *router) coordAccept(coord *common.Coordinate) (chan Conn, bool) {
+	r.coords.lock.RLock()
+	defer r.coords.lock.RUnlock()
+
+	ch, has := r.coords.accept[coordKey(coord)]
+	return ch, has
+}
+
+// connOf returns the physical connection of the address.
+// The connections are kept by the IP, so the hostname is looked up by the IPs which the dial resolves it to
+func (r *router) connOf(addr string) (*RouterConn, bool) {
+	var hosts []string
+	if r.isHostname(addr) {
+		host, _, _ := net.SplitHostPort(addr)
+		ips, err := r.dns.lookup(host, r.dialTimeout(), false)
+		if err != nil {
+			return nil, false
+		}
+		hosts = ips
+	} else {
+		host, _ := RemovePort(addr)
+		hosts = []string{host}
+	}
+
+	r.ConnMapLock.RLock("connOf")
+	defer r.ConnMapLock.RUnlock()
+	for _, host := range hosts {
+		if pc, has := r.ConnMap[host]; has {
+			return pc, true
+		}
+	}
+	return nil, false
+}
+
+// sharedCoords returns the registered coordinates which the other side registered too
+func sharedCoords(local []*common.Coordinate, remote []*common.Coordinate) []*common.Coordinate {
+	list := []*common.Coordinate{}
+	for _, l := range local {
+		for _, c := range remote {
+			if l.Equal(c) {
+				list = append(list, l)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// sharesCoord returns true when the connection carries the frames of the coordinate
+func (pc *RouterConn) sharesCoord(coord *common.Coordinate) bool {
+	for _, c := range pc.coords {
+		if c.Equal(coord) {
+			return true
+		}
+	}
+	return false
+}
+
+// frameDemux reads the frames of the physical connection and queues them by the coordinate tags and the logical channels.
+// The queues are bounded by the windows of the channels when the channels are negotiated,
+// otherwise the frames of a coordinate wait until the previous ones are read, so a slow reader delays the others.
+type frameDemux struct {
+	lock     sync.Mutex
+	primary  *frameQueue
+	queues   map[string]*frameQueue
+	channels [numChannels]*frameQueue
+	onRead   func(f rawFrame)
+	err      error
+	done     chan struct{}
+	once     sync.Once
+}
+
+// startDemux starts reading the frames of the shared coordinates and the other channels apart from the frames of the own one
+func (pc *RouterConn) startDemux() {
+	limit := frameQueueLimit
+	if pc.channels != nil {
+		limit = 0
+	}
+	d := &frameDemux{
+		primary: newFrameQueue(limit),
+		queues:  map[string]*frameQueue{},
+		onRead:  pc.creditChannel,
+		done:    make(chan struct{}),
+	}
+	for _, c := range pc.coords {
+		d.queues[coordKey(c)] = newFrameQueue(limit)
+	}
+	if pc.channels != nil {
+		for ch := ChannelApplication + 1; ch < numChannels; ch++ {
+			d.channels[ch] = newFrameQueue(0)
+		}
+	}
+	pc.demux = d
+	go pc.runDemux(d)
+}
+
+func (pc *RouterConn) runDemux(d *frameDemux) {
+	for {
+		f, err := pc.readRawFrame(false)
+		if err != nil {
+			d.fail(err)
+			if pc.channels != nil {
+				pc.channels.close()
+			}
+			return
+		}
+		// the dropped frames are counted as read for the window of the other side
+		q := d.queue(f)
+		if q == nil || !q.push(f, d.done) {
+			d.onRead(f)
+			releaseFrame(f)
+		}
+	}
+}
+
+func (d *frameDemux) queue(f rawFrame) *frameQueue {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if f.channel != ChannelApplication {
+		// the channels unknown to the node are dropped
+		if f.channel >= numChannels {
+			return nil
+		}
+		return d.channels[f.channel]
+	}
+	// the frames tagged with the other coordinates are of the own one of the other side
+	// and the queue of the closed logical connection is nil and its frames are dropped
+	if q, has := d.queues[coordKey(f.coord)]; has {
+		return q
+	}
+	return d.primary
+}
+
+func (d *frameDemux) fail(err error) {
+	d.once.Do(func() {
+		d.lock.Lock()
+		d.err = err
+		d.lock.Unlock()
+		close(d.done)
+	})
+}
+
+// read returns the next frame of the queue, the queued frames are read before the error of the physical connection.
+// It returns io.EOF when the cancel is closed.
+func (d *frameDemux) read(q *frameQueue, cancel <-chan struct{}) (rawFrame, error) {
+	for {
+		if f, has := q.pop(); has {
+			d.onRead(f)
+			return f, nil
+		}
+		select {
+		case <-q.ready:
+		case <-cancel:
+			return rawFrame{}, io.EOF
+		case <-d.done:
+			if f, has := q.pop(); has {
+				d.onRead(f)
+				return f, nil
+			}
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			return rawFrame{}, d.err
+		}
+	}
+}
+
+// remove drops the frames of the coordinate whose logical connection is closed
+func (d *frameDemux) remove(key string) {
+	d.lock.Lock()
+	q := d.queues[key]
+	d.queues[key] = nil
+	d.lock.Unlock()
+
+	d.drain(q)
+}
+
+// removeChannel drops the frames of the channel whose logical connection is closed
+func (d *frameDemux) removeChannel(ch uint8) {
+	d.lock.Lock()
+	q := d.channels[ch]
+	d.channels[ch] = nil
+	d.lock.Unlock()
+
+	d.drain(q)
+}
+
+func (d *frameDemux) drain(q *frameQueue) {
+	if q == nil {
+		return
+	}
+	for {
+		f, has := q.pop()
+		if !has {
+			return
+		}
+		d.onRead(f)
+		releaseFrame(f)
+	}
+}
+
+// deliverCoords passes the logical connections of the shared coordinates to their AcceptCoord
+func (r *router) deliverCoords(pc *RouterConn) {
+	for _, c := range pc.coords {
+		ch, has := r.coordAccept(c)
+		if !has {
+			continue
+		}
+		go func(c *common.Coordinate, ch chan Conn) {
+			select {
+			case ch <- newCoordConn(pc, c):
+			case <-pc.demux.done:
+			case <-r.closeCh:
+			}
+		}(c, ch)
+	}
+}
+
+// coordConn is the logical connection of a shared coordinate over the physical connection of the own one.
+// Closing it stops the frames of the coordinate and the physical connection is kept.
+type coordConn struct {
+	*RouterConn
+	coord   *common.Coordinate
+	q       *frameQueue
+	readBuf bytes.Buffer
+	exts    []Extension
+	closed  int32
+	done    chan struct{}
+}
+
+func newCoordConn(pc *RouterConn, coord *common.Coordinate) *coordConn {
+	return &coordConn{
+		RouterConn: pc,
+		coord:      coord,
+		q:          pc.demux.queue(rawFrame{coord: coord}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (c *coordConn) Read(b []byte) (int, error) {
+	if c.readBuf.Len() == 0 {
+		f, err := c.RouterConn.demux.read(c.q, c.done)
+		if err != nil {
+			return 0, err
+		}
+		c.exts = f.exts
+		c.readBuf.Write(f.body)
+		releaseFrame(f)
+	}
+	return c.readBuf.Read(b)
+}
+
+// Release does nothing because the body of the frame is copied and returned to the pool by Read
+func (c *coordConn) Release() error {
+	return nil
+}
+
+// Remaining returns the unread bytes of the copied body of the frame
+func (c *coordConn) Remaining() int {
+	return c.readBuf.Len()
+}
+
+func (c *coordConn) Write(body []byte) (int, error) {
+	return c.RouterConn.writeCoord(c.coord, body, nil)
+}
+
+func (c *coordConn) WriteExtended(body []byte, exts []Extension) (int, error) {
+	if !c.RouterConn.extended {
+		exts = nil
+	}
+	return c.RouterConn.writeCoord(c.coord, body, exts)
+}
+
+func (c *coordConn) WriteBatch(bodies [][]byte) (int, error) {
+	var wrote int
+	for _, body := range bodies {
+		n, err := c.Write(body)
+		wrote += n
+		if err != nil {
+			return wrote, err
+		}
+	}
+	return wrote, nil
+}
+
+func (c *coordConn) Extensions() []Extension {
+	return c.exts
+}
+
+// Features returns the features of the physical connection except the data plane which is of the own coordinate
+func (c *coordConn) Features() uint32 {
+	return c.RouterConn.Features() &^ FeatureDataPlane
+}
+
+func (c *coordConn) Data() Conn {
+	return nil
+}
+
+func (c *coordConn) DataReady() <-chan struct{} {
+	return nil
+}
+
+// Channel returns the connection itself for the application channel, the other channels are of the own coordinate
+func (c *coordConn) Channel(ch uint8) Conn {
+	if ch == ChannelApplication {
+		return c
+	}
+	return nil
+}
+
+func (c *coordConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.RouterConn.demux.remove(coordKey(c.coord))
+		close(c.done)
+	}
+	return nil
+}
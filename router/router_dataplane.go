@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/log"
+)
+
+//planes of the connection
+const (
+	PlanePrimary = uint8(0)
+	PlaneData    = uint8(1)
+)
+
+// dataPlane is the bulk data connection which is associated with the primary connection of the peer
+type dataPlane struct {
+	sync.Mutex
+	conn      *RouterConn
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func newDataPlane() *dataPlane {
+	return &dataPlane{
+		ready: make(chan struct{}),
+	}
+}
+
+// Data returns the data plane connection of the peer, it is nil when the data plane is not established
+func (pc *RouterConn) Data() Conn {
+	pc.data.Lock()
+	defer pc.data.Unlock()
+	if pc.data.conn == nil || pc.data.conn.isClose {
+		return nil
+	}
+	return pc.data.conn
+}
+
+// DataReady returns the channel which is closed when the data plane connection is associated
+func (pc *RouterConn) DataReady() <-chan struct{} {
+	return pc.data.ready
+}
+
+// IsDataPlane returns true when the connection is the data plane of the other connection
+func (pc *RouterConn) IsDataPlane() bool {
+	return pc.plane == PlaneData
+}
+
+func (pc *RouterConn) attachData(dc *RouterConn) {
+	pc.data.Lock()
+	old := pc.data.conn
+	pc.data.conn = dc
+	pc.data.Unlock()
+	dc.primary = pc
+	if old != nil {
+		old.Close()
+	}
+	pc.data.readyOnce.Do(func() {
+		close(pc.data.ready)
+	})
+}
+
+// detachData removes the closed data plane connection, the writes of the data plane fall back to the primary
+func (pc *RouterConn) detachData(dc *RouterConn) {
+	pc.data.Lock()
+	if pc.data.conn == dc {
+		pc.data.conn = nil
+	}
+	pc.data.Unlock()
+}
+
+// closeData closes the data plane with the primary connection
+func (pc *RouterConn) closeData() {
+	pc.data.Lock()
+	dc := pc.data.conn
+	pc.data.conn = nil
+	pc.data.Unlock()
+	if dc != nil {
+		dc.Close()
+	}
+}
+
+// associateData attaches the data plane connection to the primary connection of the same address and the same remote id
+func (r *router) associateData(addr string, dc *RouterConn) error {
+	r.ConnMapLock.RLock("associateData")
+	primary, has := r.ConnMap[addr]
+	r.ConnMapLock.RUnlock()
+	if !has || primary.isClose || primary.remoteID != dc.remoteID {
+		return ErrNoPrimaryConn
+	}
+	primary.attachData(dc)
+	return nil
+}
+
+const dataPlaneAttempts = 5
+
+// openDataPlane dials the second connection of the peer which carries the bulk data.
+// It is retried because the other side rejects it while the handshake of the primary is not finished there.
+func (r *router) openDataPlane(addr string, primary *RouterConn) {
+	for i := 1; i <= dataPlaneAttempts; i++ {
+		time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+		if primary.isClose || r.isClosed() {
+			return
+		}
+		err := r.dialDataPlane(addr)
+		if err == nil {
+			return
+		}
+		log.Debug("data plane ", addr, " attempt ", i, " ", err)
+	}
+}
+
+func (r *router) dialDataPlane(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.dialTimeout()+r.handshakeTimeout())
+	defer cancel()
+	conn, err := r.dialContext(ctx, addr)
+	if err != nil {
+		return err
+	}
+	conn = r.limitConn(conn)
+	if _, err := r.incommingConn(ctx, conn, IsDial, PlaneData); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
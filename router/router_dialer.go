@@ -0,0 +1,36 @@
+package router
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer dials the outbound connections of the router instead of the network of the config (e.g. the proxies and the tests)
+// net.Dialer is a Dialer.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// dialWith connects to the address by the dialer of the config in the dial timeout.
+// The hostname is passed as it is, so the dialer decides how it is resolved.
+func (r *router) dialWith(ctx context.Context, addr string) (net.Conn, error) {
+	network := r.conf().Network
+	if IsUnixAddress(addr) {
+		network = "unix"
+		addr = unixPath(addr)
+	}
+	dctx, cancel := context.WithTimeout(ctx, r.dialTimeout())
+	defer cancel()
+	conn, err := r.conf().Dialer.DialContext(dctx, network, addr)
+	if err != nil {
+		if ctx.Err() == nil && dctx.Err() == context.DeadlineExceeded {
+			return nil, ErrDialTimeout
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, ErrDialTimeout
+		}
+		return nil, err
+	}
+	r.tuneConn(conn)
+	return conn, nil
+}
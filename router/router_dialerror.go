@@ -0,0 +1,81 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// DialErrorKind is the class of the failure of a request
+type DialErrorKind int
+
+// dial error kinds
+const (
+	// DialOther is the failure which is not classified, like the handshake errors
+	DialOther DialErrorKind = 0
+	// DialRefused is the host which is up but doesn't listen the port
+	DialRefused DialErrorKind = 1
+	// DialTimeout is the host which is possibly down
+	DialTimeout DialErrorKind = 2
+	// DialReset is the connection which is dropped by the remote after it is established
+	DialReset DialErrorKind = 3
+	// DialUnreachable is the host which has no route or can't be resolved
+	DialUnreachable DialErrorKind = 4
+)
+
+func (k DialErrorKind) String() string {
+	switch k {
+	case DialRefused:
+		return "refused"
+	case DialTimeout:
+		return "timeout"
+	case DialReset:
+		return "reset"
+	case DialUnreachable:
+		return "unreachable"
+	default:
+		return "other"
+	}
+}
+
+// ClassifyDialError returns the class of the error of Request
+func ClassifyDialError(err error) DialErrorKind {
+	switch err {
+	case nil:
+		return DialOther
+	case ErrDialTimeout, context.DeadlineExceeded:
+		return DialTimeout
+	case ErrNotResolved:
+		return DialUnreachable
+	case io.EOF, io.ErrUnexpectedEOF:
+		return DialReset
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return DialTimeout
+	}
+	if _, ok := err.(*net.DNSError); ok {
+		return DialUnreachable
+	}
+	// *net.OpError wraps *os.SyscallError which wraps the errno
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		switch errno {
+		case syscall.ECONNREFUSED:
+			return DialRefused
+		case syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE:
+			return DialReset
+		case syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.EHOSTDOWN, syscall.ENETDOWN:
+			return DialUnreachable
+		case syscall.ETIMEDOUT:
+			return DialTimeout
+		}
+	}
+	return DialOther
+}
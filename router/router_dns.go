@@ -0,0 +1,110 @@
+package router
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultDNSRefreshInterval is the lifetime of the resolved addresses of a hostname
+const DefaultDNSRefreshInterval = 5 * time.Minute
+
+// dnsEntry is the resolved IPs of a hostname
+type dnsEntry struct {
+	ips      []string
+	resolved time.Time
+}
+
+// dnsCache keeps the resolved IPs of the hostnames until they are expired or all of them fail
+type dnsCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]*dnsEntry
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	if ttl <= 0 {
+		ttl = DefaultDNSRefreshInterval
+	}
+	return &dnsCache{
+		ttl:     ttl,
+		entries: map[string]*dnsEntry{},
+	}
+}
+
+// lookup returns the IPs of the host, it resolves the host again when the entry is expired or refresh is true
+func (c *dnsCache) lookup(host string, timeout time.Duration, refresh bool) ([]string, error) {
+	c.Lock()
+	e, has := c.entries[host]
+	c.Unlock()
+	if has && !refresh && time.Now().Sub(e.resolved) < c.ttl {
+		return e.ips, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP.String())
+	}
+	if len(ips) == 0 {
+		return nil, ErrNotResolved
+	}
+
+	c.Lock()
+	c.entries[host] = &dnsEntry{
+		ips:      ips,
+		resolved: time.Now(),
+	}
+	c.Unlock()
+	return ips, nil
+}
+
+// isHostname returns the address has a hostname which should be resolved before dialing
+func (r *router) isHostname(addr string) bool {
+	if _, has := registeredNetwork(r.conf().Network); has {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	return net.ParseIP(host) == nil
+}
+
+// dialHostname resolves the hostname of the address and tries all of the IPs.
+// The hostname is resolved again and the new IPs are tried when all of the cached IPs fail.
+func (r *router) dialHostname(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	tried := map[string]bool{}
+	var lastErr error
+	for _, refresh := range []bool{false, true} {
+		ips, err := r.dns.lookup(host, r.dialTimeout(), refresh)
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		for _, ip := range ips {
+			if tried[ip] {
+				continue
+			}
+			tried[ip] = true
+			conn, err := r.dialAddr(net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
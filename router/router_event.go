@@ -0,0 +1,148 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/log"
+)
+
+// EventKind is the kind of the lifecycle event of the router
+type EventKind int
+
+// lifecycle events of the router
+const (
+	EventDialStarted EventKind = iota + 1
+	EventDialFailed
+	EventHandshakeCompleted
+	EventHandshakeFailed
+	EventConnectionClosed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventDialStarted:
+		return "dial-started"
+	case EventDialFailed:
+		return "dial-failed"
+	case EventHandshakeCompleted:
+		return "handshake-completed"
+	case EventHandshakeFailed:
+		return "handshake-failed"
+	case EventConnectionClosed:
+		return "connection-closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a lifecycle event of the connection of the address
+// Err is set for the failures and RemoteID is set after the handshake
+type Event struct {
+	Kind     EventKind
+	Addr     string
+	TypeIs   TypeIs
+	RemoteID string
+	Err      error
+	Time     time.Time
+}
+
+// EventHandler is called with the lifecycle events in the emitted order
+type EventHandler func(ev Event)
+
+const eventQueueSize = 256
+
+// eventHub delivers the events to the handlers from one goroutine,
+// so the handlers can call the router without deadlocking the connection locks
+type eventHub struct {
+	sync.RWMutex
+	handlers  []EventHandler
+	queue     chan Event
+	done      chan struct{}
+	doneOnce  sync.Once
+	startOnce sync.Once
+	dropped   uint64
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		queue: make(chan Event, eventQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// stop ends the dispatching after the queued events are delivered
+func (h *eventHub) stop() {
+	h.doneOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// OnEvent adds the handler of the lifecycle events of the router
+func (r *router) OnEvent(handler EventHandler) {
+	r.events.Lock()
+	r.events.handlers = append(r.events.handlers, handler)
+	r.events.Unlock()
+	r.events.startOnce.Do(func() {
+		go r.dispatchEvents()
+	})
+}
+
+func (r *router) emit(ev Event) {
+	r.meter.observe(ev)
+	r.events.RLock()
+	has := len(r.events.handlers) > 0
+	r.events.RUnlock()
+	if !has {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case r.events.queue <- ev:
+	default:
+		r.events.Lock()
+		r.events.dropped++
+		dropped := r.events.dropped
+		r.events.Unlock()
+		log.Warn("router event dropped ", ev.Kind, " ", ev.Addr, " total ", dropped)
+	}
+}
+
+func (r *router) connClosed(pc *RouterConn) {
+	r.emit(Event{
+		Kind:     EventConnectionClosed,
+		Addr:     pc.physical,
+		TypeIs:   pc.typeis,
+		RemoteID: pc.remoteID,
+	})
+}
+
+func (r *router) dispatchEvents() {
+	for {
+		select {
+		case ev := <-r.events.queue:
+			r.deliver(ev)
+		case <-r.events.done:
+			// the connections closed by Close are delivered before stopping
+			for {
+				select {
+				case ev := <-r.events.queue:
+					r.deliver(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *router) deliver(ev Event) {
+	r.events.RLock()
+	handlers := r.events.handlers
+	r.events.RUnlock()
+	for _, h := range handlers {
+		h(ev)
+	}
+}
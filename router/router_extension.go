@@ -0,0 +1,85 @@
+package router
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/fletaio/common/util"
+)
+
+// EXTENDED is the flag of the compression byte which marks the frame carries the extension fields
+const EXTENDED = uint8(0x80)
+
+// MaxExtensionSize is the maximum size of the encoded extension fields of a frame
+const MaxExtensionSize = 0xFFFF
+
+//extension types
+const (
+	EXTTRACEID     = uint8(1)
+	EXTPRIORITY    = uint8(2)
+	EXTCOMPRESSION = uint8(3)
+	EXTSIGNATURE   = uint8(4)
+)
+
+// Extension is an optional TLV field that is carried with the frame.
+// The receiver keeps the unknown types as it is, so a new type doesn't require a wire change.
+type Extension struct {
+	Type  uint8
+	Value []byte
+}
+
+// FindExtension returns the value of the first extension of the type
+func FindExtension(exts []Extension, t uint8) ([]byte, bool) {
+	for _, e := range exts {
+		if e.Type == t {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func encodeExtensions(exts []Extension) ([]byte, error) {
+	var buffer bytes.Buffer
+	for _, e := range exts {
+		if len(e.Value) > MaxExtensionSize {
+			return nil, ErrTooLargeExtension
+		}
+		if _, err := util.WriteUint8(&buffer, e.Type); err != nil {
+			return nil, err
+		}
+		if _, err := util.WriteUint16(&buffer, uint16(len(e.Value))); err != nil {
+			return nil, err
+		}
+		if _, err := buffer.Write(e.Value); err != nil {
+			return nil, err
+		}
+	}
+	if buffer.Len() > MaxExtensionSize {
+		return nil, ErrTooLargeExtension
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeExtensions(bs []byte) ([]Extension, error) {
+	r := bytes.NewReader(bs)
+	exts := []Extension{}
+	for r.Len() > 0 {
+		t, _, err := util.ReadUint8(r)
+		if err != nil {
+			return nil, ErrInvalidExtension
+		}
+		size, _, err := util.ReadUint16(r)
+		if err != nil {
+			return nil, ErrInvalidExtension
+		}
+		if int(size) > r.Len() {
+			return nil, ErrInvalidExtension
+		}
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, ErrInvalidExtension
+		}
+		exts = append(exts, Extension{Type: t, Value: value})
+	}
+	return exts, nil
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"net"
+	"sync"
+)
+
+// AcceptFilter decides whether the inbound connection from the address is accepted before the handshake
+type AcceptFilter func(addr net.Addr) error
+
+// acceptFilter rejects the inbound connections by the CIDR lists and the filter callback
+type acceptFilter struct {
+	lock   sync.RWMutex
+	allows []*net.IPNet
+	denies []*net.IPNet
+	filter AcceptFilter
+}
+
+func newAcceptFilter(allows []string, denies []string) (*acceptFilter, error) {
+	af := &acceptFilter{}
+	var err error
+	if af.allows, err = parseCIDRs(allows); err != nil {
+		return nil, err
+	}
+	if af.denies, err = parseCIDRs(denies); err != nil {
+		return nil, err
+	}
+	return af, nil
+}
+
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, v := range list {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns ErrDeniedAddress when the address is in the deny list or out of the allow list, the filter callback is evaluated after the lists
+func (af *acceptFilter) check(addr net.Addr) error {
+	af.lock.RLock()
+	allows, denies, filter := af.allows, af.denies, af.filter
+	af.lock.RUnlock()
+
+	if len(allows) > 0 || len(denies) > 0 {
+		ip := net.ParseIP(hostOf(addr))
+		if ip != nil && containsIP(denies, ip) {
+			return ErrDeniedAddress
+		}
+		if len(allows) > 0 && (ip == nil || !containsIP(allows, ip)) {
+			return ErrDeniedAddress
+		}
+	}
+
+	if filter != nil {
+		return filter(addr)
+	}
+	return nil
+}
+
+// SetAcceptFilter sets the callback which is evaluated for the inbound connections before the handshake, nil removes it
+func (r *router) SetAcceptFilter(filter AcceptFilter) {
+	r.acceptFilter.lock.Lock()
+	defer r.acceptFilter.lock.Unlock()
+
+	r.acceptFilter.filter = filter
+}
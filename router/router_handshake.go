@@ -2,10 +2,11 @@ package router
 
 import (
 	"bytes"
-	"fmt"
+	"crypto/ed25519"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"encoding/hex"
 	"io"
-	"net"
-	"strings"
 	"time"
 
 	"github.com/fletaio/common/hash"
@@ -21,13 +22,31 @@ var (
 )
 
 type handshake struct {
-	RemoteAddr string
-	ChainCoord *common.Coordinate
-	Address    string
-	Port       uint16
-	Time       uint64
+	RemoteAddr   string
+	ChainCoord   *common.Coordinate
+	Address      string
+	Port         uint16
+	Time         uint64
+	Coords       []*common.Coordinate
+	Compressions []uint8
+	Extended     bool
+	NodeID       string
+	NetworkMagic []byte
+	PublicKey    []byte
+	Challenge    []byte
+	Signature    []byte
+	Extra        []byte
+	Session      []byte
+	Rejection    []byte
+	Features     uint32
+	Plane        uint8
+	Window       uint32
+	KeyShare     []byte
 }
 
+// maxHandshakeCoords is the max number of the coordinates registered in addition to the own one
+const maxHandshakeCoords = 16
+
 // WriteTo is a serialization function
 func (h *handshake) WriteTo(w io.Writer) (int64, error) {
 	var wrote int64
@@ -62,6 +81,77 @@ func (h *handshake) WriteTo(w io.Writer) (int64, error) {
 	} else {
 		wrote += n
 	}
+	if len(h.Coords) > maxHandshakeCoords {
+		return wrote, ErrTooManyCoords
+	}
+	if n, err := util.WriteUint8(w, uint8(len(h.Coords))); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	for _, c := range h.Coords {
+		if n, err := c.WriteTo(w); err != nil {
+			return wrote, err
+		} else {
+			wrote += n
+		}
+	}
+	if n, err := util.WriteUint8(w, uint8(len(h.Compressions))); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	for _, c := range h.Compressions {
+		if n, err := util.WriteUint8(w, c); err != nil {
+			return wrote, err
+		} else {
+			wrote += n
+		}
+	}
+	var extended uint8
+	if h.Extended {
+		extended = 1
+	}
+	if n, err := util.WriteUint8(w, extended); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := util.WriteString(w, h.NodeID); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if len(h.NetworkMagic) > 255 {
+		return wrote, ErrTooLargeNetworkMagic
+	}
+	for _, bs := range [][]byte{h.NetworkMagic, h.PublicKey, h.Challenge, h.Signature, h.Extra, h.Session, h.Rejection} {
+		if n, err := writeShortBytes(w, bs); err != nil {
+			return wrote, err
+		} else {
+			wrote += n
+		}
+	}
+	if n, err := util.WriteUint32(w, h.Features); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := util.WriteUint8(w, h.Plane); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := util.WriteUint32(w, h.Window); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := writeShortBytes(w, h.KeyShare); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
 
 	return wrote, nil
 }
@@ -108,28 +198,224 @@ func (h *handshake) ReadFrom(r io.Reader) (int64, error) {
 		read += n
 		h.Time = v
 	}
+	// the nodes before the shared coordinates don't send them
+	if v, n, err := util.ReadUint8(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		if v > maxHandshakeCoords {
+			return read, ErrTooManyCoords
+		}
+		h.Coords = make([]*common.Coordinate, 0, v)
+		for i := 0; i < int(v); i++ {
+			c := &common.Coordinate{}
+			if n, err := c.ReadFrom(r); err != nil {
+				return read, err
+			} else {
+				read += n
+			}
+			h.Coords = append(h.Coords, c)
+		}
+	}
+	// the nodes before the compression negotiation don't send the compressions
+	if Len, n, err := util.ReadUint8(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.Compressions = make([]uint8, 0, Len)
+		for i := 0; i < int(Len); i++ {
+			if v, n, err := util.ReadUint8(r); err != nil {
+				return read, err
+			} else {
+				read += n
+				h.Compressions = append(h.Compressions, v)
+			}
+		}
+	}
+	// the nodes before the frame extensions don't send the flag
+	if v, n, err := util.ReadUint8(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.Extended = v == 1
+	}
+	// the nodes before the node id don't send it
+	if v, n, err := util.ReadString(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.NodeID = v
+	}
+	// the nodes before the network magic and the public key don't send them
+	for _, v := range []*[]byte{&h.NetworkMagic, &h.PublicKey, &h.Challenge, &h.Signature, &h.Extra, &h.Session, &h.Rejection} {
+		if bs, n, err := readShortBytes(r); err != nil {
+			if err == io.EOF {
+				return read, nil
+			}
+			return read, err
+		} else {
+			read += n
+			*v = bs
+		}
+	}
+	// the nodes before the features don't send them
+	if v, n, err := util.ReadUint32(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.Features = v
+	}
+	if v, n, err := util.ReadUint8(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.Plane = v
+	}
+	// the nodes before the logical channels don't send the window
+	if v, n, err := util.ReadUint32(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.Window = v
+	}
+	// the nodes before the session secrets don't send the key share
+	if bs, n, err := readShortBytes(r); err != nil {
+		if err == io.EOF {
+			return read, nil
+		}
+		return read, err
+	} else {
+		read += n
+		h.KeyShare = bs
+	}
 
 	return read, nil
 }
 
+// writeShortBytes writes the bytes shorter than 256 with the length
+func writeShortBytes(w io.Writer, bs []byte) (int64, error) {
+	var wrote int64
+	if len(bs) > 255 {
+		return wrote, ErrTooLargeBody
+	}
+	if n, err := util.WriteUint8(w, uint8(len(bs))); err != nil {
+		return wrote, err
+	} else {
+		wrote += n
+	}
+	if n, err := w.Write(bs); err != nil {
+		return wrote, err
+	} else {
+		wrote += int64(n)
+	}
+	return wrote, nil
+}
+
+// readShortBytes reads the bytes written by writeShortBytes
+func readShortBytes(r io.Reader) ([]byte, int64, error) {
+	var read int64
+	Len, n, err := util.ReadUint8(r)
+	if err != nil {
+		return nil, read, err
+	}
+	read += n
+	bs := make([]byte, Len)
+	if n, err := util.FillBytes(r, bs); err != nil {
+		return nil, read, err
+	} else {
+		read += int64(n)
+	}
+	return bs, read, nil
+}
+
 func (h *handshake) hash() hash.Hash256 {
 	bf := &bytes.Buffer{}
 	h.WriteTo(bf)
 	return hash.Hash(bf.Bytes())
 }
 
+// challengeMessage is the message signed to prove the key of the node, the key share of the signer is signed with the challenge
+// so the secret of the session is not taken over by the one in the middle
+func challengeMessage(challenge []byte, share []byte) []byte {
+	return append(append([]byte("fleta handshake "), challenge...), share...)
+}
+
 func (pc *RouterConn) handshakeSend(ChainCoord *common.Coordinate) {
+	if pc.challenge == nil {
+		pc.challenge = make([]byte, 32)
+		crand.Read(pc.challenge)
+	}
+	address, port := pc.r.advertise(pc.LocalAddr())
+	features := pc.r.features()
+	var compressions []uint8
+	if features&FeatureCompression != 0 {
+		compressions = pc.r.compressions()
+	}
 	h := &handshake{
-		RemoteAddr: pc.RemoteAddr().String(),
-		ChainCoord: pc.r.chainCoord(),
-		Address:    pc.r.localAddress(),
-		Port:       uint16(pc.r.port()),
-		Time:       uint64(time.Now().UnixNano()),
+		RemoteAddr:   pc.RemoteAddr().String(),
+		ChainCoord:   pc.r.chainCoord(),
+		Address:      address,
+		Port:         uint16(port),
+		Time:         uint64(time.Now().UnixNano()),
+		Compressions: compressions,
+		Extended:     features&FeatureExtensions != 0,
+		NodeID:       pc.r.nodeID(),
+		NetworkMagic: pc.r.networkMagic(),
+		PublicKey:    pc.r.publicKey(),
+		Challenge:    pc.challenge,
+		Extra:        pc.r.handshakeExtra(),
+		Features:     features &^ legacyFeatures,
+		Plane:        pc.plane,
+		KeyShare:     pc.ownShare(),
+	}
+	if features&FeatureMultiCoord != 0 && pc.plane == PlanePrimary {
+		h.Coords = pc.r.registeredCoords()
+	}
+	if features&FeatureChannels != 0 && pc.plane == PlanePrimary {
+		h.Window = uint32(pc.r.channelWindow())
+	}
+	if pc.rejection != "" {
+		h.Rejection = []byte(pc.rejection)
+		if len(h.Rejection) > 255 {
+			h.Rejection = h.Rejection[:255]
+		}
+	} else if pc.session != nil {
+		// the dialer offers the session and the acceptor echoes it back when the session is resumed.
+		// Each of them proves the secret of the session by the fresh challenge of the other instead of the signature
+		h.Session = pc.session
+		if pc.typeis == IsDial {
+			h.Signature = resumeProof(pc.sessionSecret, resumeDialLabel, pc.challenge)
+		} else {
+			h.Signature = resumeProof(pc.sessionSecret, resumeAcceptLabel, pc.remoteChallenge)
+		}
+	} else if pc.remoteChallenge != nil {
+		h.Signature = pc.r.sign(pc.remoteChallenge, h.KeyShare)
 	}
 	bf := &bytes.Buffer{}
 	h.WriteTo(bf)
 
-	pc.write(bf.Bytes(), UNCOMPRESSED)
+	pc.write(bf.Bytes(), UNCOMPRESSED, nil)
 }
 
 func (pc *RouterConn) handshakeRecv() (*common.Coordinate, error) {
@@ -138,30 +424,111 @@ func (pc *RouterConn) handshakeRecv() (*common.Coordinate, error) {
 		return nil, err
 	}
 
-	bf := bytes.NewBuffer(body)
 	h := &handshake{}
-	_, err = h.ReadFrom(bf)
-	if err != nil {
+	if err := pc.r.offload(func() error {
+		_, err := h.ReadFrom(bytes.NewBuffer(body))
+		return err
+	}); err != nil {
 		return nil, err
 	}
 
-	if h.Address == "" {
-		addr := pc.RemoteAddr().String()
-		if raddr, ok := pc.RemoteAddr().(*net.TCPAddr); ok {
-			addr = raddr.IP.String()
-		} else {
-			addrs := strings.Split(addr, ":")
-			addr = strings.Join(addrs[:len(addrs)-1], ":")
-		}
-
-		pc.Address = fmt.Sprintf("%v:%v", addr, h.Port)
+	if h.Address == "" && isUnixConn(pc.pConn) {
+		// the local process is identified by the socket
+		pc.Address = pc.physical
+	} else if h.Address == "" {
+		pc.Address = JoinHostPort(hostOf(pc.RemoteAddr()), int(h.Port))
 	} else {
-		pc.Address = fmt.Sprintf("%v:%v", h.Address, h.Port)
+		pc.Address = JoinHostPort(h.Address, int(h.Port))
 	}
 	pc.pingTime = time.Now().Sub(time.Unix(0, int64(h.Time)))
+	pc.negotiate(h)
+	if pc.typeis == IsAccept && pc.hasFeature(FeatureDataPlane) {
+		pc.plane = h.Plane
+	}
+	pc.quality.seed(pc.pingTime)
+	if len(h.Rejection) > 0 {
+		return nil, &RejectionError{Reason: string(h.Rejection)}
+	}
+	if !bytes.Equal(h.NetworkMagic, pc.r.networkMagic()) {
+		return nil, ErrMismatchNetworkMagic
+	}
+	if err := pc.r.checkHandshake(h.Extra); err != nil {
+		return nil, err
+	}
+	pc.nodeID = h.NodeID
+	if h.NodeID != "" && h.NodeID == pc.r.nodeID() {
+		return nil, ErrCannotRequestToLocal
+	}
+	if len(h.PublicKey) > 0 {
+		if len(h.PublicKey) != ed25519.PublicKeySize {
+			return nil, ErrInvalidSignature
+		}
+		if bytes.Equal(h.PublicKey, pc.r.publicKey()) {
+			return nil, ErrCannotRequestToLocal
+		}
+		if err := pc.r.checkReplay(h.PublicKey, h.Challenge); err != nil {
+			return nil, err
+		}
+		pc.remoteKey = h.PublicKey
+		pc.remoteChallenge = h.Challenge
+		pc.remoteShare = h.KeyShare
+		if pc.sessionKey != nil {
+			// the dialer resumes when the acceptor echoes the session of the same key with the proof of its secret
+			pc.resumed = bytes.Equal(h.Session, pc.session) && bytes.Equal(h.PublicKey, pc.sessionKey) &&
+				hmac.Equal(h.Signature, resumeProof(pc.sessionSecret, resumeAcceptLabel, pc.challenge))
+			pc.session = nil
+		} else if len(h.Session) > 0 {
+			if e := pc.r.resumeSession(h.Session, h.PublicKey, h.Challenge, h.Signature); e != nil {
+				pc.resumed = true
+				pc.session = h.Session
+				pc.sessionSecret = e.secret
+				pc.previous = e.pc
+			}
+		}
+		if pc.resumed {
+			pc.remoteID = hex.EncodeToString(pc.remoteKey)
+			pc.inheritQuality(pc.previous)
+		} else if pc.typeis == IsDial && len(h.Signature) > 0 {
+			// the signature is only sent by the acceptor which has the challenge of the dialer
+			if err := pc.verify(h.Signature); err != nil {
+				return nil, err
+			}
+		}
+		pc.previous = nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	return h.ChainCoord, nil
 }
+
+// handshakeProofSend sends the signature of the challenge of the acceptor
+func (pc *RouterConn) handshakeProofSend() {
+	bf := &bytes.Buffer{}
+	writeShortBytes(bf, pc.r.sign(pc.remoteChallenge, pc.ownShare()))
+	pc.write(bf.Bytes(), UNCOMPRESSED, nil)
+}
+
+// handshakeProofRecv receives the signature of the challenge from the dialer
+func (pc *RouterConn) handshakeProofRecv() error {
+	body, err := pc.ReadConn()
+	if err != nil {
+		return err
+	}
+	sig, _, err := readShortBytes(bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	return pc.verify(sig)
+}
+
+func (pc *RouterConn) verify(sig []byte) error {
+	return pc.r.offload(func() error {
+		if !ed25519.Verify(ed25519.PublicKey(pc.remoteKey), challengeMessage(pc.challenge, pc.remoteShare), sig) {
+			return ErrInvalidSignature
+		}
+		pc.remoteID = hex.EncodeToString(pc.remoteKey)
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handshakeLimiter is a leaky bucket of the inbound handshakes of each source prefix
+type handshakeLimiter struct {
+	lock      sync.Mutex
+	rate      float64
+	burst     float64
+	prefix4   int
+	prefix6   int
+	buckets   map[string]*leakyBucket
+	lastSweep time.Time
+}
+
+type leakyBucket struct {
+	level float64
+	last  time.Time
+}
+
+func newHandshakeLimiter(rate float64, burst int, prefix4 int, prefix6 int) *handshakeLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	if prefix4 <= 0 || prefix4 > 32 {
+		prefix4 = 32
+	}
+	if prefix6 <= 0 || prefix6 > 128 {
+		prefix6 = 64
+	}
+	return &handshakeLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		prefix4:   prefix4,
+		prefix6:   prefix6,
+		buckets:   map[string]*leakyBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// sourceKey returns the prefix of the IP which shares a bucket
+func (hl *handshakeLimiter) sourceKey(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(hl.prefix4, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(hl.prefix6, 128)).String()
+}
+
+// allow adds a handshake to the bucket of the source and returns false when the bucket overflows
+func (hl *handshakeLimiter) allow(host string) bool {
+	if hl == nil {
+		return true
+	}
+	key := hl.sourceKey(host)
+
+	hl.lock.Lock()
+	defer hl.lock.Unlock()
+
+	now := time.Now()
+	hl.sweep(now)
+	b, has := hl.buckets[key]
+	if !has {
+		b = &leakyBucket{last: now}
+		hl.buckets[key] = b
+	}
+	b.level -= now.Sub(b.last).Seconds() * hl.rate
+	if b.level < 0 {
+		b.level = 0
+	}
+	b.last = now
+	if b.level+1 > hl.burst {
+		return false
+	}
+	b.level++
+	return true
+}
+
+// sweep removes the drained buckets once a minute so that the spoofed sources don't grow the map
+func (hl *handshakeLimiter) sweep(now time.Time) {
+	if now.Sub(hl.lastSweep) < time.Minute {
+		return
+	}
+	hl.lastSweep = now
+	for key, b := range hl.buckets {
+		if b.level-now.Sub(b.last).Seconds()*hl.rate <= 0 {
+			delete(hl.buckets, key)
+		}
+	}
+}
+
+// acquireHandshake counts an inbound connection which starts the handshake goroutine, it fails over the MaxPendingHandshakes
+func (r *router) acquireHandshake() bool {
+	n := atomic.AddInt32(&r.pendingHandshakes, 1)
+	if r.conf().MaxPendingHandshakes > 0 && int(n) > r.conf().MaxPendingHandshakes {
+		atomic.AddInt32(&r.pendingHandshakes, -1)
+		return false
+	}
+	return true
+}
+
+func (r *router) releaseHandshake() {
+	atomic.AddInt32(&r.pendingHandshakes, -1)
+}
+
+// PendingHandshakes returns the number of the inbound connections in the handshake goroutines
+func (r *router) PendingHandshakes() int {
+	return int(atomic.LoadInt32(&r.pendingHandshakes))
+}
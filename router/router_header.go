@@ -0,0 +1,36 @@
+package router
+
+import (
+	"net"
+	"time"
+
+	"github.com/fletaio/framework/log"
+	"github.com/fletaio/framework/router/evilnode"
+)
+
+// headerTimeout is the deadline of receiving the whole handshake of the inbound connection
+func (r *router) headerTimeout() time.Duration {
+	if r.conf().HeaderTimeout > 0 {
+		return r.conf().HeaderTimeout
+	}
+	return r.handshakeTimeout()
+}
+
+// isTimeout returns the error is the expiry of a deadline
+func isTimeout(err error) bool {
+	if err == ErrHandshakeTimeout {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// punishSlowHandshake scores the source which didn't complete the handshake in time
+func (r *router) punishSlowHandshake(addr string) {
+	if r.pinned.has(addr) {
+		return
+	}
+	if err := r.evilNodeManager.TellOn(addr, evilnode.SlowHandshake); err != nil {
+		log.Error("punishSlowHandshake err ", err)
+	}
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"net"
+	"sync"
+)
+
+// ipCounter counts the inbound physical connections of each IP
+type ipCounter struct {
+	lock   sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+func newIPCounter(max int) *ipCounter {
+	return &ipCounter{
+		max:    max,
+		counts: map[string]int{},
+	}
+}
+
+func (ic *ipCounter) setMax(max int) {
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+
+	ic.max = max
+}
+
+// acquire returns false when the IP already has the max connections
+func (ic *ipCounter) acquire(ip string) bool {
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+
+	if ic.counts[ip] >= ic.max {
+		return false
+	}
+	ic.counts[ip]++
+	return true
+}
+
+func (ic *ipCounter) release(ip string) {
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+
+	if ic.counts[ip] <= 1 {
+		delete(ic.counts, ip)
+	} else {
+		ic.counts[ip]--
+	}
+}
+
+// countedConn releases the count of the IP when it is closed
+type countedConn struct {
+	net.Conn
+	once    sync.Once
+	ip      string
+	counter *ipCounter
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.counter.release(c.ip)
+	})
+	return c.Conn.Close()
+}
+
+// countConn counts the inbound connection by the IP and returns ErrTooManyConnections when the IP is over the MaxConnsPerIP
+func (r *router) countConn(conn net.Conn) (net.Conn, error) {
+	if r.conf().MaxConnsPerIP <= 0 {
+		return conn, nil
+	}
+	ip := hostOf(conn.RemoteAddr())
+	if r.pinned.has(ip) {
+		return conn, nil
+	}
+	if !r.ipCounter.acquire(ip) {
+		return nil, ErrTooManyConnections
+	}
+	return &countedConn{
+		Conn:    conn,
+		ip:      ip,
+		counter: r.ipCounter,
+	}, nil
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fletaio/common"
+)
+
+// ListenerAddr is the address of the Listener of the router, it is the chain coordinate on the addresses listened by the router
+type ListenerAddr struct {
+	Coord *common.Coordinate
+	Addrs []string
+}
+
+// Network returns the name of the network of the router
+func (a *ListenerAddr) Network() string {
+	return "fleta"
+}
+
+func (a *ListenerAddr) String() string {
+	return strconv.FormatUint(uint64(a.Coord.Height), 10) + "/" + strconv.FormatUint(uint64(a.Coord.Index), 10) + "@" + strings.Join(a.Addrs, ",")
+}
+
+// routerListener adapts AcceptContext of a chain coordinate to net.Listener
+type routerListener struct {
+	r         *router
+	coord     *common.Coordinate
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// Listener returns the net.Listener whose Accept returns the logical connections of the chain coordinate,
+// so the servers written against the standard library (e.g. HTTP and RPC servers) run over the router.
+// The listener and the other Accept calls of the same coordinate take the connections from each other, so a registered coordinate
+// dedicated to the server is recommended. Closing the listener doesn't close the router and nil is the own coordinate of the router.
+func (r *router) Listener(coord *common.Coordinate) net.Listener {
+	if coord == nil {
+		coord = r.ChainCoord
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &routerListener{
+		r:      r,
+		coord:  coord,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Accept returns the next logical connection, it returns ErrListenerClosed after the listener is closed
+func (l *routerListener) Accept() (net.Conn, error) {
+	conn, _, err := l.r.AcceptContext(l.ctx, l.coord)
+	if err != nil {
+		if l.ctx.Err() != nil {
+			return nil, ErrListenerClosed
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Close unblocks the pending Accept calls, the accepted connections are not closed
+func (l *routerListener) Close() error {
+	l.closeOnce.Do(l.cancel)
+	return nil
+}
+
+func (l *routerListener) Addr() net.Addr {
+	return &ListenerAddr{
+		Coord: l.coord,
+		Addrs: l.r.ListenAddrs(),
+	}
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"net"
+)
+
+// bindsDial returns true when the outbound dials bind to a source address or a source port
+func (r *router) bindsDial() bool {
+	return r.conf().LocalAddr != "" || r.conf().Interface != "" || r.conf().BindAddr != "" || r.sourcePorts != nil
+}
+
+// localIP returns the source IP of the dial to the address, it is nil when the operating system chooses it
+func (r *router) localIP(addr string) (net.IP, error) {
+	if r.conf().LocalAddr != "" {
+		return net.ParseIP(r.conf().LocalAddr), nil
+	}
+	if r.conf().Interface != "" {
+		host, _ := RemovePort(addr)
+		return interfaceIP(r.conf().Interface, net.ParseIP(host).To4() == nil)
+	}
+	if r.conf().BindAddr != "" {
+		return net.ParseIP(r.conf().BindAddr), nil
+	}
+	return nil, nil
+}
+
+// interfaceIP returns the first unicast address of the interface in the family,
+// it is looked up for every dial because the addresses of the interface can be changed
+func interfaceIP(name string, v6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if (ipn.IP.To4() == nil) == v6 {
+			return ipn.IP, nil
+		}
+	}
+	return nil, ErrNoInterfaceAddr
+}
@@ -0,0 +1,169 @@
+package router
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// handshakeBuckets are the upper bounds of the buckets of the handshake duration histogram in seconds
+var handshakeBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	dialsDesc = prometheus.NewDesc(
+		"fleta_router_dials_total",
+		"The outbound dials attempted by the router.",
+		nil, nil,
+	)
+	dialFailuresDesc = prometheus.NewDesc(
+		"fleta_router_dial_failures_total",
+		"The outbound dials which failed before or in the handshake.",
+		nil, nil,
+	)
+	handshakeFailuresDesc = prometheus.NewDesc(
+		"fleta_router_handshake_failures_total",
+		"The handshakes of the inbound and outbound connections which failed.",
+		nil, nil,
+	)
+	handshakeDurationDesc = prometheus.NewDesc(
+		"fleta_router_handshake_duration_seconds",
+		"The durations of the completed handshakes.",
+		nil, nil,
+	)
+	connectionsDesc = prometheus.NewDesc(
+		"fleta_router_connections",
+		"The active physical connections by the direction.",
+		[]string{"direction"}, nil,
+	)
+	readBytesDesc = prometheus.NewDesc(
+		"fleta_router_read_bytes_total",
+		"The bytes read from the physical connections.",
+		nil, nil,
+	)
+	writtenBytesDesc = prometheus.NewDesc(
+		"fleta_router_written_bytes_total",
+		"The bytes written to the physical connections.",
+		nil, nil,
+	)
+	evilEventsDesc = prometheus.NewDesc(
+		"fleta_router_evil_events_total",
+		"The evil score reports of the nodes by the kind.",
+		[]string{"kind"}, nil,
+	)
+)
+
+// routerMetrics counts the events and the traffic of the router which are exported by Metrics
+type routerMetrics struct {
+	traffic           connCounter
+	dials             uint64
+	dialFailures      uint64
+	handshakeFailures uint64
+
+	handshakeLock  sync.Mutex
+	handshakeCount uint64
+	handshakeSum   float64
+	handshakeHits  []uint64
+}
+
+func newRouterMetrics() *routerMetrics {
+	return &routerMetrics{
+		handshakeHits: make([]uint64, len(handshakeBuckets)),
+	}
+}
+
+// observe counts the lifecycle event, it is called for all the events regardless of the event handlers
+func (m *routerMetrics) observe(ev Event) {
+	switch ev.Kind {
+	case EventDialStarted:
+		atomic.AddUint64(&m.dials, 1)
+	case EventDialFailed:
+		atomic.AddUint64(&m.dialFailures, 1)
+	case EventHandshakeFailed:
+		atomic.AddUint64(&m.handshakeFailures, 1)
+	}
+}
+
+func (m *routerMetrics) observeHandshake(d time.Duration) {
+	m.handshakeLock.Lock()
+	defer m.handshakeLock.Unlock()
+
+	m.handshakeCount++
+	m.handshakeSum += d.Seconds()
+	for i, bound := range handshakeBuckets {
+		if d.Seconds() <= bound {
+			m.handshakeHits[i]++
+			break
+		}
+	}
+}
+
+// handshakeHistogram returns the cumulative counts of the buckets
+func (m *routerMetrics) handshakeHistogram() (uint64, float64, map[float64]uint64) {
+	m.handshakeLock.Lock()
+	defer m.handshakeLock.Unlock()
+
+	buckets := make(map[float64]uint64, len(handshakeBuckets))
+	var cumulative uint64
+	for i, bound := range handshakeBuckets {
+		cumulative += m.handshakeHits[i]
+		buckets[bound] = cumulative
+	}
+	return m.handshakeCount, m.handshakeSum, buckets
+}
+
+func (r *router) metrics() *routerMetrics {
+	return r.meter
+}
+
+// metricsCollector is the prometheus.Collector of the router
+type metricsCollector struct {
+	r *router
+}
+
+// Metrics returns the prometheus.Collector of the dials, the handshakes, the connections, the traffic and the evil score reports
+// of the router, so the embedder registers it to its registry (e.g. prometheus.MustRegister(r.Metrics()))
+func (r *router) Metrics() prometheus.Collector {
+	return &metricsCollector{r: r}
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dialsDesc
+	ch <- dialFailuresDesc
+	ch <- handshakeFailuresDesc
+	ch <- handshakeDurationDesc
+	ch <- connectionsDesc
+	ch <- readBytesDesc
+	ch <- writtenBytesDesc
+	ch <- evilEventsDesc
+}
+
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	m := c.r.meter
+	ch <- prometheus.MustNewConstMetric(dialsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&m.dials)))
+	ch <- prometheus.MustNewConstMetric(dialFailuresDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&m.dialFailures)))
+	ch <- prometheus.MustNewConstMetric(handshakeFailuresDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&m.handshakeFailures)))
+	count, sum, buckets := m.handshakeHistogram()
+	ch <- prometheus.MustNewConstHistogram(handshakeDurationDesc, count, sum, buckets)
+
+	var inbound, outbound int
+	c.r.ConnMapLock.RLock("Metrics")
+	for _, pc := range c.r.ConnMap {
+		if pc.typeis == IsAccept {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+	c.r.ConnMapLock.RUnlock()
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(inbound), "inbound")
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(outbound), "outbound")
+
+	ch <- prometheus.MustNewConstMetric(readBytesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&m.traffic.bytesRead)))
+	ch <- prometheus.MustNewConstMetric(writtenBytesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&m.traffic.bytesWritten)))
+
+	for kind, n := range c.r.evilNodeManager.Events() {
+		ch <- prometheus.MustNewConstMetric(evilEventsDesc, prometheus.CounterValue, float64(n), kind.String())
+	}
+}
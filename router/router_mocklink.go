@@ -0,0 +1,163 @@
+package router
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LinkCondition is the condition of a link of the mock networks used in the tests
+type LinkCondition struct {
+	// Latency is the delay of each write and Jitter is the random deviation of it
+	Latency time.Duration
+	Jitter  time.Duration
+	// DropRate is the probability [0, 1] that a write (a frame) is lost
+	DropRate float64
+}
+
+type mockLinkKey struct {
+	from string
+	to   string
+}
+
+var (
+	mockLinkLock sync.Mutex
+	mockLinks    = map[mockLinkKey]LinkCondition{}
+	mockLinkRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SetMockLink sets the condition of the writes from the host to the host of the mock networks.
+// The empty host matches all the hosts and the condition of the more specific link is used.
+func SetMockLink(from string, to string, cond LinkCondition) {
+	mockLinkLock.Lock()
+	defer mockLinkLock.Unlock()
+
+	mockLinks[mockLinkKey{from: from, to: to}] = cond
+}
+
+// ClearMockLinks removes all the conditions of the mock network links
+func ClearMockLinks() {
+	mockLinkLock.Lock()
+	defer mockLinkLock.Unlock()
+
+	mockLinks = map[mockLinkKey]LinkCondition{}
+}
+
+func findMockLink(from string, to string) (LinkCondition, bool) {
+	mockLinkLock.Lock()
+	defer mockLinkLock.Unlock()
+
+	for _, key := range []mockLinkKey{{from, to}, {from, ""}, {"", to}, {"", ""}} {
+		if cond, has := mockLinks[key]; has {
+			return cond, true
+		}
+	}
+	return LinkCondition{}, false
+}
+
+func mockLinkFloat64() float64 {
+	mockLinkLock.Lock()
+	defer mockLinkLock.Unlock()
+
+	return mockLinkRand.Float64()
+}
+
+// delay returns the latency with the jitter
+func (cond LinkCondition) delay() time.Duration {
+	d := cond.Latency
+	if cond.Jitter > 0 {
+		d += time.Duration((mockLinkFloat64()*2 - 1) * float64(cond.Jitter))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+type delayedWrite struct {
+	at time.Time
+	bs []byte
+}
+
+// mockLinkConn delays and drops the writes by the condition of the link keeping the order of them
+type mockLinkConn struct {
+	net.Conn
+	from      string
+	to        string
+	lock      sync.Mutex
+	last      time.Time
+	queue     chan *delayedWrite
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// conditionConn applies the condition of the link to the connection of the mock network
+func (r *router) conditionConn(conn net.Conn) net.Conn {
+	if !strings.HasPrefix(r.conf().Network, "mock:") {
+		return conn
+	}
+	c := &mockLinkConn{
+		Conn:    conn,
+		from:    strings.TrimPrefix(r.conf().Network, "mock:"),
+		to:      hostOf(conn.RemoteAddr()),
+		queue:   make(chan *delayedWrite, 1024),
+		closeCh: make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *mockLinkConn) Write(bs []byte) (int, error) {
+	cond, has := findMockLink(c.from, c.to)
+	if !has {
+		return c.Conn.Write(bs)
+	}
+	if cond.DropRate > 0 && mockLinkFloat64() < cond.DropRate {
+		return len(bs), nil
+	}
+
+	c.lock.Lock()
+	at := time.Now().Add(cond.delay())
+	if at.Before(c.last) {
+		at = c.last
+	}
+	c.last = at
+	c.lock.Unlock()
+
+	select {
+	case c.queue <- &delayedWrite{at: at, bs: append([]byte{}, bs...)}:
+		return len(bs), nil
+	case <-c.closeCh:
+		return 0, ErrNotConnected
+	}
+}
+
+func (c *mockLinkConn) run() {
+	for {
+		select {
+		case w := <-c.queue:
+			if d := time.Until(w.at); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-c.closeCh:
+					return
+				}
+			}
+			if _, err := c.Conn.Write(w.bs); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+func (c *mockLinkConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,61 @@
+package router
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fletaio/network"
+)
+
+// Network is a transport which is used by the routers of the registered network name instead of the operating system (e.g. an in-memory simulator)
+type Network interface {
+	Listen(addr string) (net.Listener, error)
+	DialTimeout(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+var (
+	networkLock sync.RWMutex
+	networks    = map[string]Network{}
+)
+
+// RegisterNetwork registers the transport of the network name which is used as the Network of the Config
+func RegisterNetwork(name string, n Network) {
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	networks[name] = n
+}
+
+// UnregisterNetwork removes the transport of the network name
+func UnregisterNetwork(name string) {
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	delete(networks, name)
+}
+
+func registeredNetwork(name string) (Network, bool) {
+	networkLock.RLock()
+	defer networkLock.RUnlock()
+
+	n, has := networks[name]
+	return n, has
+}
+
+func (r *router) listen(addr string) (net.Listener, error) {
+	if IsUnixAddress(addr) {
+		return listenUnix(addr)
+	}
+	if n, has := registeredNetwork(r.conf().Network); has {
+		return n.Listen(addr)
+	}
+	return network.Listen(r.conf().Network, addr)
+}
+
+func (r *router) dialTimeoutNetwork(addr string, timeout time.Duration) (net.Conn, error) {
+	if n, has := registeredNetwork(r.conf().Network); has {
+		return n.DialTimeout(addr, timeout)
+	}
+	return network.DialTimeout(r.conf().Network, addr, timeout)
+}
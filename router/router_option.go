@@ -0,0 +1,128 @@
+package router
+
+import (
+	"crypto/ed25519"
+	"time"
+
+	"github.com/fletaio/common"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/router/evilnode"
+)
+
+// Option sets the settings of the router made by New.
+// *Config is the Option which sets all of the settings, so the settings which are omitted are the defaults of it
+type Option interface {
+	apply(c *Config)
+}
+
+type optionFunc func(c *Config)
+
+func (f optionFunc) apply(c *Config) {
+	f(c)
+}
+
+func (c *Config) apply(dst *Config) {
+	if c != nil {
+		*dst = *c
+	}
+}
+
+// New is the creator of the router which applies the options in order to the defaults (tcp network and 100 ban evil score).
+// The leading *Config is used as it is as NewRouter does
+func New(ChainCoord *common.Coordinate, opts ...Option) (Router, error) {
+	c := &Config{
+		Network: "tcp",
+		EvilNodeConfig: evilnode.Config{
+			BanEvilScore: 100,
+		},
+	}
+	for i, opt := range opts {
+		if cfg, ok := opt.(*Config); ok && cfg != nil && i == 0 {
+			c = cfg
+			continue
+		}
+		opt.apply(c)
+	}
+	return NewRouter(c, ChainCoord)
+}
+
+// WithNetwork sets the network of the listeners and the dials (tcp by default)
+func WithNetwork(network string) Option {
+	return optionFunc(func(c *Config) {
+		c.Network = network
+	})
+}
+
+// WithPort sets the port which is listened
+func WithPort(port int) Option {
+	return optionFunc(func(c *Config) {
+		c.Port = port
+	})
+}
+
+// WithBindAddr sets the IP of the interface which is listened and which the dials bind to (all interfaces by default)
+func WithBindAddr(addr string) Option {
+	return optionFunc(func(c *Config) {
+		c.BindAddr = addr
+	})
+}
+
+// WithEvilNodeStore sets the path of the evil node store (./_data/router/ by default) and the kvstore backend of it (badger when it is empty)
+func WithEvilNodeStore(path string, backend string) Option {
+	return optionFunc(func(c *Config) {
+		c.EvilNodeConfig.StorePath = path
+		c.EvilNodeConfig.StoreBackend = backend
+	})
+}
+
+// WithBanEvilScore sets the evil score which bans the node (100 by default)
+func WithBanEvilScore(score uint16) Option {
+	return optionFunc(func(c *Config) {
+		c.EvilNodeConfig.BanEvilScore = score
+	})
+}
+
+// WithPrivateKey sets the key which signs the handshakes (a random key by default)
+func WithPrivateKey(key ed25519.PrivateKey) Option {
+	return optionFunc(func(c *Config) {
+		c.PrivateKey = key
+	})
+}
+
+// WithTimeouts sets the deadlines of dialing, handshaking and writing a frame (the defaults are used for the zeros)
+func WithTimeouts(dial time.Duration, handshake time.Duration, write time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.DialTimeout = dial
+		c.HandshakeTimeout = handshake
+		c.WriteTimeout = write
+	})
+}
+
+// WithReadTimeout sets the deadline of each read of a frame after the handshake (DefaultReadTimeout for the zero)
+func WithReadTimeout(d time.Duration) Option {
+	return optionFunc(func(c *Config) {
+		c.ReadTimeout = d
+	})
+}
+
+// WithKeepAlive sets the period of the keep-alive frames and the number of the silent periods which close the connection
+func WithKeepAlive(interval time.Duration, probes int) Option {
+	return optionFunc(func(c *Config) {
+		c.KeepAliveInterval = interval
+		c.KeepAliveProbes = probes
+	})
+}
+
+// WithMaxConnsPerIP sets the cap of the inbound physical connections of an IP (unlimited by default)
+func WithMaxConnsPerIP(n int) Option {
+	return optionFunc(func(c *Config) {
+		c.MaxConnsPerIP = n
+	})
+}
+
+// WithClock sets the time source of the router and the evil node scores (the real clock by default)
+func WithClock(clk clock.Clock) Option {
+	return optionFunc(func(c *Config) {
+		c.Clock = clk
+	})
+}
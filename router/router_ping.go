@@ -0,0 +1,89 @@
+package router
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultPingTimeout is the timeout of Ping when the PingTimeout of the config is zero
+const DefaultPingTimeout = 5 * time.Second
+
+// pingWaiters are the pending pings of Ping which are keyed by the time of the ping
+type pingWaiters struct {
+	sync.Mutex
+	waits map[uint64]chan time.Duration
+}
+
+func (pw *pingWaiters) add(v uint64) (uint64, chan time.Duration) {
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.waits == nil {
+		pw.waits = map[uint64]chan time.Duration{}
+	}
+	// the concurrent pings of the same nanosecond are distinguished
+	for {
+		if _, has := pw.waits[v]; !has {
+			break
+		}
+		v++
+	}
+	ch := make(chan time.Duration, 1)
+	pw.waits[v] = ch
+	return v, ch
+}
+
+func (pw *pingWaiters) remove(v uint64) {
+	pw.Lock()
+	defer pw.Unlock()
+	delete(pw.waits, v)
+}
+
+func (pw *pingWaiters) done(v uint64, rtt time.Duration) {
+	pw.Lock()
+	ch, has := pw.waits[v]
+	delete(pw.waits, v)
+	pw.Unlock()
+	if has {
+		ch <- rtt
+	}
+}
+
+// Ping measures the round trip time of the connection of the address by the ping frame of the router.
+// The pong is read with the frames of the connection, so the connection should be read by the application (e.g. the peer manager).
+func (r *router) Ping(addr string) (time.Duration, error) {
+	host, _ := RemovePort(addr)
+
+	r.ConnMapLock.RLock("Ping")
+	pc, has := r.ConnMap[host]
+	r.ConnMapLock.RUnlock()
+	if !has {
+		return 0, ErrNotConnected
+	}
+	return pc.ping(r.pingTimeout())
+}
+
+func (r *router) pingTimeout() time.Duration {
+	if r.conf().PingTimeout > 0 {
+		return r.conf().PingTimeout
+	}
+	return DefaultPingTimeout
+}
+
+func (pc *RouterConn) ping(timeout time.Duration) (time.Duration, error) {
+	if !pc.hasFeature(FeatureRTT) {
+		return 0, ErrPingNotSupported
+	}
+	v, ch := pc.pings.add(uint64(time.Now().UnixNano()))
+	defer pc.pings.remove(v)
+	if err := pc.writeControl(RTTPING, v); err != nil {
+		return 0, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case rtt := <-ch:
+		return rtt, nil
+	case <-timer.C:
+		return 0, ErrPingTimeout
+	}
+}
@@ -0,0 +1,59 @@
+package router
+
+import (
+	"strings"
+	"sync"
+)
+
+// pinnedKeys keeps the pinned node ids and the addresses where they have been connected
+type pinnedKeys struct {
+	lock  sync.Mutex
+	ids   map[string]bool
+	addrs map[string]string
+}
+
+func newPinnedKeys(keys []string) *pinnedKeys {
+	pk := &pinnedKeys{
+		ids:   map[string]bool{},
+		addrs: map[string]string{},
+	}
+	for _, key := range keys {
+		pk.ids[strings.ToLower(key)] = true
+	}
+	return pk
+}
+
+// has returns true when the id is pinned or the address is the one of a pinned id
+func (pk *pinnedKeys) has(idOrAddr string) bool {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if len(pk.ids) == 0 {
+		return false
+	}
+	if pk.ids[strings.ToLower(idOrAddr)] {
+		return true
+	}
+	_, has := pk.addrs[idOrAddr]
+	return has
+}
+
+// learn remembers the addresses of the connection when the verified id of it is pinned
+func (pk *pinnedKeys) learn(id string, addrs ...string) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if id == "" || !pk.ids[id] {
+		return
+	}
+	for _, addr := range addrs {
+		if addr != "" {
+			pk.addrs[addr] = id
+		}
+	}
+}
+
+// IsPinned returns true when the node id is in the PinnedKeys or the address has been connected by a pinned node
+func (r *router) IsPinned(idOrAddr string) bool {
+	return r.pinned.has(idOrAddr)
+}
@@ -0,0 +1,137 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyV2Signature starts the binary header of the PROXY protocol v2
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Header is the max length of the text header of the PROXY protocol v1 including the CRLF
+const maxProxyV1Header = 107
+
+// maxProxyV2Length is the max length of the addresses and the TLVs of the PROXY protocol v2 header which is read
+const maxProxyV2Length = 4096
+
+// proxyConn is the inbound connection relayed by a proxy, its remote address is the one of the client in the PROXY header
+type proxyConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// proxied returns true when the PROXY header is expected from the inbound connection
+func (r *router) proxied(conn net.Conn) bool {
+	if !r.conf().ProxyProtocol || isUnixConn(conn) || len(r.proxies) == 0 {
+		return false
+	}
+	ip := net.ParseIP(hostOf(conn.RemoteAddr()))
+	return ip != nil && containsIP(r.proxies, ip)
+}
+
+// readProxyHeader reads the PROXY protocol v1 or v2 header of the connection and returns the connection of the client address.
+// The connection is returned as it is for the health checks of the proxy (LOCAL and UNKNOWN) which don't have the client address.
+func readProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	bs := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(conn, bs); err != nil {
+		return nil, err
+	}
+	var remote net.Addr
+	var err error
+	if bytes.Equal(bs, proxyV2Signature) {
+		remote, err = readProxyV2(conn)
+	} else if bytes.HasPrefix(bs, []byte("PROXY ")) {
+		remote, err = readProxyV1(conn, bs)
+	} else {
+		err = ErrInvalidProxyHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, remote: remote}, nil
+}
+
+// readProxyV1 reads the rest of the text header "PROXY TCP4 src dst sport dport\r\n" byte by byte, so the frames after it are not consumed
+func readProxyV1(conn net.Conn, read []byte) (net.Addr, error) {
+	line := append([]byte{}, read...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxProxyV1Header {
+			return nil, ErrInvalidProxyHeader
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads the binary header after the signature, the TLVs are skipped.
+// The length and the ports are in the network byte order
+func readProxyV2(conn net.Conn) (net.Addr, error) {
+	hd := make([]byte, 4)
+	if _, err := io.ReadFull(conn, hd); err != nil {
+		return nil, err
+	}
+	if hd[0]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	length := binary.BigEndian.Uint16(hd[2:4])
+	if length > maxProxyV2Length {
+		return nil, ErrInvalidProxyHeader
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	// LOCAL is the connection of the proxy itself
+	if hd[0]&0x0F == 0 {
+		return nil, nil
+	}
+	if hd[0]&0x0F != 1 {
+		return nil, ErrInvalidProxyHeader
+	}
+	switch hd[1] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		// the unix sockets and the unspecified family don't have the client address
+		return nil, nil
+	}
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/common/util"
+)
+
+//control bytes of the round trip time probes, they are followed by the 8 bytes time of the ping
+const (
+	RTTPING = 'P'
+	RTTPONG = 'Q'
+)
+
+// Quality is the estimation of the link which is updated by the round trip time probes
+type Quality struct {
+	RTT       time.Duration
+	Deviation time.Duration
+	Samples   uint64
+	Updated   time.Time
+}
+
+// qualityEstimator keeps the smoothed round trip time and its variance as TCP does (RFC 6298)
+type qualityEstimator struct {
+	sync.Mutex
+	q Quality
+}
+
+// seed sets the ping time of the handshake as the estimation before the first probe
+func (qe *qualityEstimator) seed(pingTime time.Duration) {
+	qe.Lock()
+	defer qe.Unlock()
+	if qe.q.Samples == 0 {
+		if pingTime < 0 {
+			pingTime = 0
+		}
+		qe.q.RTT = pingTime
+		qe.q.Deviation = pingTime / 2
+	}
+}
+
+func (qe *qualityEstimator) sample(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+	qe.Lock()
+	defer qe.Unlock()
+	if qe.q.Samples == 0 {
+		qe.q.RTT = rtt
+		qe.q.Deviation = rtt / 2
+	} else {
+		diff := qe.q.RTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		qe.q.Deviation += (diff - qe.q.Deviation) / 4
+		qe.q.RTT += (rtt - qe.q.RTT) / 8
+	}
+	qe.q.Samples++
+	qe.q.Updated = time.Now()
+}
+
+// restore sets the estimation of the previous connection of the same link
+func (qe *qualityEstimator) restore(q Quality) {
+	qe.Lock()
+	defer qe.Unlock()
+	qe.q = q
+}
+
+func (qe *qualityEstimator) get() Quality {
+	qe.Lock()
+	defer qe.Unlock()
+	return qe.q
+}
+
+// Quality returns the estimation of the link, it is the ping time of the handshake until the first probe is answered
+func (pc *RouterConn) Quality() Quality {
+	return pc.quality.get()
+}
+
+func (pc *RouterConn) sendPing() {
+	pc.writeControl(RTTPING, uint64(time.Now().UnixNano()))
+}
+
+// writeControl sends the control byte with the value and closes the connection when it fails as the heartbit does
+func (pc *RouterConn) writeControl(kind byte, v uint64) error {
+	pc.writeLock.Lock()
+	defer pc.writeLock.Unlock()
+
+	pc.pConn.SetWriteDeadline(time.Now().Add(pc.r.writeTimeout()))
+	n, err := pc.pConn.Write(append([]byte{kind}, util.Uint64ToBytes(v)...))
+	pc.connCounter.addWritten(uint64(n))
+	if err != nil {
+		pc.pConn.Close()
+	}
+	return err
+}
+
+// readControl reads the value of the control byte, the ping is answered with its time and the pong is sampled
+func (pc *RouterConn) readControl(kind byte) error {
+	bs, err := pc.readBytes(8)
+	if err != nil {
+		return err
+	}
+	v := util.BytesToUint64(bs)
+	switch kind {
+	case RTTPING:
+		return pc.writeControl(RTTPONG, v)
+	case WINDOWUPDATE:
+		pc.updateWindow(v)
+	case RTTPONG:
+		rtt := time.Now().Sub(time.Unix(0, int64(v)))
+		pc.quality.sample(rtt)
+		pc.pings.done(v, rtt)
+	}
+	return nil
+}
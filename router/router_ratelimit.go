@@ -0,0 +1,126 @@
+package router
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket which allows the bytes per second with the burst
+// It lends tokens over the burst and makes the following callers wait until the debt is paid back
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64, burst int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// set changes the rate and the burst of the limiter in place, zero rate is unlimited
+func (rl *rateLimiter) set(bytesPerSecond int64, burst int64) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	rl.rate = float64(bytesPerSecond)
+	rl.burst = float64(burst)
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+}
+
+// reserve takes n tokens and returns the duration to wait before using them
+func (rl *rateLimiter) reserve(n int) time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rl.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+}
+
+// WaitN blocks until n bytes are allowed
+func (rl *rateLimiter) WaitN(n int) {
+	if rl == nil || n <= 0 {
+		return
+	}
+	if d := rl.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// budgetQuantum is the maximum bytes taken from the shared budget at once.
+// The shared budget lends tokens in the order of the reservations, so splitting the transfers
+// into the quantum makes the connections take turns instead of one large transfer blocking the others.
+const budgetQuantum = 4 * 1024
+
+// limitedConn limits the read and write bandwidth of the physical connection
+// and takes its share from the budgets of the router
+type limitedConn struct {
+	net.Conn
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
+	readBudget   *rateLimiter
+	writeBudget  *rateLimiter
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	if c.readBudget != nil && len(b) > budgetQuantum {
+		b = b[:budgetQuantum]
+	}
+	n, err := c.Conn.Read(b)
+	c.readLimiter.WaitN(n)
+	c.readBudget.WaitN(n)
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	if c.writeBudget == nil {
+		c.writeLimiter.WaitN(len(b))
+		return c.Conn.Write(b)
+	}
+	var wrote int
+	for len(b) > 0 {
+		size := len(b)
+		if size > budgetQuantum {
+			size = budgetQuantum
+		}
+		c.writeLimiter.WaitN(size)
+		c.writeBudget.WaitN(size)
+		n, err := c.Conn.Write(b[:size])
+		wrote += n
+		if err != nil {
+			return wrote, err
+		}
+		b = b[size:]
+	}
+	return wrote, nil
+}
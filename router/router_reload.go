@@ -0,0 +1,126 @@
+package router
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/fletaio/framework/log"
+)
+
+// conf returns the current config which is replaced by ApplyConfig
+func (r *router) conf() *Config {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+
+	return r.Config
+}
+
+// parseAdvertiseAddr returns the host and the port of the AdvertiseAddr, the host is empty when the address is empty
+func parseAdvertiseAddr(addr string) (string, int, error) {
+	if addr == "" {
+		return "", 0, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, ErrInvalidAdvertiseAddr
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || host == "" || p <= 0 || p > 65535 {
+		return "", 0, ErrInvalidAdvertiseAddr
+	}
+	return host, p, nil
+}
+
+func (r *router) advertised() (string, int) {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+
+	return r.advertiseHost, r.advertisePort
+}
+
+func (r *router) handshakeLimiter() *handshakeLimiter {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+
+	return r.handshakeLimit
+}
+
+func (r *router) budgets() (*rateLimiter, *rateLimiter) {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+
+	return r.readBudget, r.writeBudget
+}
+
+// applyBudget changes the shared budget in place so the connections already limited by it follow the new limit
+func applyBudget(rl *rateLimiter, bytesPerSecond int64) *rateLimiter {
+	if rl == nil {
+		return newRateLimiter(bytesPerSecond, 0)
+	}
+	rl.set(bytesPerSecond, 0)
+	return rl
+}
+
+// ApplyConfig applies the tunables of the config to the running router without dropping the connections.
+// The CIDR lists, MaxConnsPerIP, MaxPendingHandshakes, the bandwidth limits, the handshake rate, the AdvertiseAddr,
+// the timeouts and the keep-alive are applied. The per-connection bandwidth limits are applied to the new connections
+// and the other fields are ignored until the router is created again. Nothing is applied when the config is invalid.
+func (r *router) ApplyConfig(c *Config) error {
+	if r.isClosed() {
+		return ErrRouterClosed
+	}
+	allows, err := parseCIDRs(c.AllowCIDRs)
+	if err != nil {
+		return err
+	}
+	denies, err := parseCIDRs(c.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+	advertiseHost, advertisePort, err := parseAdvertiseAddr(c.AdvertiseAddr)
+	if err != nil {
+		return err
+	}
+
+	r.configLock.Lock()
+	nc := *r.Config
+	nc.AllowCIDRs = c.AllowCIDRs
+	nc.DenyCIDRs = c.DenyCIDRs
+	nc.MaxConnsPerIP = c.MaxConnsPerIP
+	nc.MaxPendingHandshakes = c.MaxPendingHandshakes
+	nc.ReadLimit = c.ReadLimit
+	nc.WriteLimit = c.WriteLimit
+	nc.RateLimitBurst = c.RateLimitBurst
+	nc.TotalReadLimit = c.TotalReadLimit
+	nc.TotalWriteLimit = c.TotalWriteLimit
+	nc.HandshakeRate = c.HandshakeRate
+	nc.HandshakeBurst = c.HandshakeBurst
+	nc.HandshakePrefixV4 = c.HandshakePrefixV4
+	nc.HandshakePrefixV6 = c.HandshakePrefixV6
+	nc.AdvertiseAddr = c.AdvertiseAddr
+	nc.DialTimeout = c.DialTimeout
+	nc.HandshakeTimeout = c.HandshakeTimeout
+	nc.WriteTimeout = c.WriteTimeout
+	nc.ReadTimeout = c.ReadTimeout
+	nc.HeaderTimeout = c.HeaderTimeout
+	nc.IdleTimeout = c.IdleTimeout
+	nc.PingTimeout = c.PingTimeout
+	nc.KeepAliveInterval = c.KeepAliveInterval
+	nc.KeepAliveProbes = c.KeepAliveProbes
+	r.Config = &nc
+	r.advertiseHost = advertiseHost
+	r.advertisePort = advertisePort
+	r.handshakeLimit = newHandshakeLimiter(c.HandshakeRate, c.HandshakeBurst, c.HandshakePrefixV4, c.HandshakePrefixV6)
+	r.readBudget = applyBudget(r.readBudget, c.TotalReadLimit)
+	r.writeBudget = applyBudget(r.writeBudget, c.TotalWriteLimit)
+	r.configLock.Unlock()
+
+	r.acceptFilter.lock.Lock()
+	r.acceptFilter.allows = allows
+	r.acceptFilter.denies = denies
+	r.acceptFilter.lock.Unlock()
+	r.ipCounter.setMax(c.MaxConnsPerIP)
+
+	log.Info("router config applied")
+	return nil
+}
@@ -0,0 +1,213 @@
+package router
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+)
+
+// resumeEntry is the session of a connection which is resumable until the TTL passes after the connection is closed
+type resumeEntry struct {
+	token     []byte
+	remoteKey []byte
+	secret    []byte
+	pc        *RouterConn
+}
+
+// labels of the proofs of the session secret
+const (
+	resumeDialLabel   = "fleta resume dial "
+	resumeAcceptLabel = "fleta resume accept "
+)
+
+// resumeCache keeps the session tokens of the dialed addresses and the accepted connections
+type resumeCache struct {
+	lock     sync.Mutex
+	ttl      time.Duration
+	dialed   map[string]*resumeEntry
+	accepted map[string]*resumeEntry
+	clock    clock.Clock
+}
+
+func newResumeCache(ttl time.Duration, clk clock.Clock) *resumeCache {
+	return &resumeCache{
+		ttl:      ttl,
+		clock:    clock.Or(clk),
+		dialed:   map[string]*resumeEntry{},
+		accepted: map[string]*resumeEntry{},
+	}
+}
+
+func (rc *resumeCache) isExpired(e *resumeEntry, now int64) bool {
+	closed := atomic.LoadInt64(&e.pc.closedTime)
+	return closed != 0 && now-closed > int64(rc.ttl)
+}
+
+// store keeps the session of the connection whose remote id is verified
+func (rc *resumeCache) store(addr string, pc *RouterConn, typeis TypeIs) {
+	if rc.ttl <= 0 || pc.remoteID == "" || pc.challenge == nil || pc.remoteChallenge == nil {
+		return
+	}
+	// the challenges are in the order of the dialer and the acceptor
+	challenges := append(append([]byte{}, pc.remoteChallenge...), pc.challenge...)
+	if typeis == IsDial {
+		challenges = append(append([]byte{}, pc.challenge...), pc.remoteChallenge...)
+	}
+	secret := pc.deriveSecret(challenges)
+	if secret == nil {
+		return
+	}
+	// the token only looks up the session, the secret is never sent
+	token := sha256.Sum256(challenges)
+	e := &resumeEntry{
+		token:     token[:],
+		remoteKey: pc.remoteKey,
+		secret:    secret,
+		pc:        pc,
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	now := rc.clock.Now().UnixNano()
+	for k, v := range rc.dialed {
+		if rc.isExpired(v, now) {
+			delete(rc.dialed, k)
+		}
+	}
+	for k, v := range rc.accepted {
+		if rc.isExpired(v, now) {
+			delete(rc.accepted, k)
+		}
+	}
+	if typeis == IsDial {
+		rc.dialed[addr] = e
+	} else {
+		rc.accepted[hex.EncodeToString(e.token)] = e
+	}
+}
+
+// dialedSession returns the last session with the address
+func (rc *resumeCache) dialedSession(addr string) *resumeEntry {
+	if rc.ttl <= 0 {
+		return nil
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	e, has := rc.dialed[addr]
+	if !has {
+		return nil
+	}
+	delete(rc.dialed, addr)
+	if rc.isExpired(e, rc.clock.Now().UnixNano()) {
+		return nil
+	}
+	return e
+}
+
+// resume consumes the accepted session of the token and returns it when the key is the one of the session
+// and the proof is made by the secret of the session over the fresh challenge of the dialer.
+// The session is kept on the wrong proof so the one who only knows the token cannot discard it
+func (rc *resumeCache) resume(token []byte, remoteKey []byte, challenge []byte, proof []byte) *resumeEntry {
+	if rc.ttl <= 0 || len(token) == 0 || len(challenge) == 0 {
+		return nil
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	k := hex.EncodeToString(token)
+	e, has := rc.accepted[k]
+	if !has {
+		return nil
+	}
+	if rc.isExpired(e, rc.clock.Now().UnixNano()) {
+		delete(rc.accepted, k)
+		return nil
+	}
+	if !bytes.Equal(e.remoteKey, remoteKey) || !hmac.Equal(proof, resumeProof(e.secret, resumeDialLabel, challenge)) {
+		return nil
+	}
+	delete(rc.accepted, k)
+	return e
+}
+
+func (r *router) resumeSession(token []byte, remoteKey []byte, challenge []byte, proof []byte) *resumeEntry {
+	return r.resumes.resume(token, remoteKey, challenge, proof)
+}
+
+// resumeProof proves the secret of the session without revealing it
+func resumeProof(secret []byte, label string, challenge []byte) []byte {
+	if secret == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// ownShare returns the public key of the ephemeral key share of the connection which is signed with the challenge
+func (pc *RouterConn) ownShare() []byte {
+	if pc.keyShare == nil {
+		k, err := ecdh.X25519().GenerateKey(crand.Reader)
+		if err != nil {
+			return nil
+		}
+		pc.keyShare = k
+	}
+	return pc.keyShare.PublicKey().Bytes()
+}
+
+// deriveSecret returns the secret of the session which is only known by the two nodes.
+// It is derived from the exchange of the signed key shares and the secret of the resumed session is chained into it,
+// so the key shares of the abbreviated handshake which are not signed are not enough to take over the session.
+// It returns nil when the remote node doesn't send the key share
+func (pc *RouterConn) deriveSecret(challenges []byte) []byte {
+	if pc.keyShare == nil || len(pc.remoteShare) == 0 {
+		return nil
+	}
+	remote, err := ecdh.X25519().NewPublicKey(pc.remoteShare)
+	if err != nil {
+		return nil
+	}
+	shared, err := pc.keyShare.ECDH(remote)
+	if err != nil {
+		return nil
+	}
+	if pc.resumed && pc.sessionSecret == nil {
+		return nil
+	}
+	h := sha256.New()
+	h.Write([]byte("fleta session "))
+	if pc.resumed {
+		h.Write(pc.sessionSecret)
+	}
+	h.Write(shared)
+	h.Write(challenges)
+	return h.Sum(nil)
+}
+
+// Resumed returns true when the connection resumed the session of a closed connection with the abbreviated handshake
+func (pc *RouterConn) Resumed() bool {
+	return pc.resumed
+}
+
+// inheritQuality takes over the round trip time estimation of the connection of the resumed session,
+// so the link is not measured again from the handshake after a brief disconnect
+func (pc *RouterConn) inheritQuality(previous *RouterConn) {
+	if q := previous.Quality(); q.Samples > 0 {
+		pc.quality.restore(q)
+		pc.pingTime = q.RTT
+	} else {
+		pc.pingTime = previous.pingTime
+	}
+}
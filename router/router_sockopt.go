@@ -0,0 +1,42 @@
+package router
+
+import (
+	"net"
+
+	"github.com/fletaio/framework/log"
+)
+
+// tuneConn applies the socket options of the config to the TCP connection, the other connections are not changed
+func (r *router) tuneConn(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if r.conf().TCPNagle {
+		if err := tc.SetNoDelay(false); err != nil {
+			log.Error("SetNoDelay err ", err)
+		}
+	}
+	if r.conf().TCPKeepAlivePeriod < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			log.Error("SetKeepAlive err ", err)
+		}
+	} else if r.conf().TCPKeepAlivePeriod > 0 {
+		if err := tc.SetKeepAlive(true); err != nil {
+			log.Error("SetKeepAlive err ", err)
+		}
+		if err := tc.SetKeepAlivePeriod(r.conf().TCPKeepAlivePeriod); err != nil {
+			log.Error("SetKeepAlivePeriod err ", err)
+		}
+	}
+	if r.conf().TCPReadBuffer > 0 {
+		if err := tc.SetReadBuffer(r.conf().TCPReadBuffer); err != nil {
+			log.Error("SetReadBuffer err ", err)
+		}
+	}
+	if r.conf().TCPWriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(r.conf().TCPWriteBuffer); err != nil {
+			log.Error("SetWriteBuffer err ", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package router
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourcePortAttempts is the max number of the source ports tried by a dial
+const sourcePortAttempts = 8
+
+// sourcePorts selects the random source ports of the outbound dials in the configured range
+type sourcePorts struct {
+	sync.Mutex
+	min int
+	max int
+	rnd *rand.Rand
+}
+
+func newSourcePorts(min int, max int) (*sourcePorts, error) {
+	if min == 0 && max == 0 {
+		return nil, nil
+	}
+	if min <= 0 || max > 65535 || min > max {
+		return nil, ErrInvalidSourcePortRange
+	}
+	return &sourcePorts{
+		min: min,
+		max: max,
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// pick returns the distinct random ports of the range to be tried in order
+func (s *sourcePorts) pick() []int {
+	s.Lock()
+	defer s.Unlock()
+
+	size := s.max - s.min + 1
+	count := sourcePortAttempts
+	if count > size {
+		count = size
+	}
+	ports := make([]int, 0, count)
+	used := map[int]bool{}
+	for len(ports) < count {
+		port := s.min + s.rnd.Intn(size)
+		if !used[port] {
+			used[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// isSourcePortBusy returns the dial failed because the source port is taken
+func isSourcePortBusy(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "address already in use") || strings.Contains(msg, "cannot assign requested address")
+}
+
+// dialFrom connects to the address from the local address and a source port of the configured range
+func (r *router) dialFrom(addr string) (net.Conn, error) {
+	ip, err := r.localIP(addr)
+	if err != nil {
+		return nil, err
+	}
+	ports := []int{0}
+	if r.sourcePorts != nil {
+		ports = r.sourcePorts.pick()
+	}
+
+	for _, port := range ports {
+		d := &net.Dialer{
+			Timeout:   r.dialTimeout(),
+			LocalAddr: &net.TCPAddr{IP: ip, Port: port},
+		}
+		var conn net.Conn
+		conn, err = d.Dial(r.conf().Network, addr)
+		if err == nil || !isSourcePortBusy(err) {
+			return conn, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"hash/crc32"
+	"sync/atomic"
+	"time"
+
+	"github.com/fletaio/common/util"
+)
+
+// DefaultStreamThreshold is the body size over which the frames are streamed when the StreamThreshold of the config is zero
+const DefaultStreamThreshold = 256 * 1024
+
+// streamed returns true when the body of the size is streamed instead of being buffered.
+// The frames of the demultiplexed connections are always buffered because they are queued before they are read.
+func (pc *RouterConn) streamed(size int) bool {
+	threshold := pc.r.streamThreshold()
+	return threshold > 0 && size > threshold
+}
+
+// Remaining returns the unread bytes of the body of the current frame,
+// so the message creators read the large payloads of the declared length directly from the connection
+func (pc *RouterConn) Remaining() int {
+	return pc.c.size - pc.c.readed
+}
+
+// readStream reads the body of the streamed frame directly from the physical connection and the checksum is verified after the body.
+// The bytes are passed to the creator before they are verified, the error is kept until Release so the message is discarded
+// even when the creator doesn't see it (e.g. io.ReadFull which fills the buffer by the last read).
+// The connection is closed on any error because the frames are out of sync.
+func (pc *RouterConn) readStream(b []byte) (int, error) {
+	if rest := pc.c.size - pc.c.readed; len(b) > rest {
+		b = b[:rest]
+	}
+	pc.setReadDeadline()
+	n, err := pc.pConn.Read(b)
+	pc.connCounter.addRead(uint64(n))
+	atomic.StoreInt64(&pc.heartBitTime, time.Now().UnixNano())
+	pc.c.checksum = crc32.Update(pc.c.checksum, pc.checksumTable(), b[:n])
+	pc.c.readed += n
+	if err == nil && pc.c.readed >= pc.c.size {
+		err = pc.verifyStream()
+	}
+	if err != nil {
+		pc.c.err = err
+		pc.Close()
+		return n, err
+	}
+	return n, nil
+}
+
+func (pc *RouterConn) verifyStream() error {
+	if err := pc.readFull(pc.header[:4]); err != nil {
+		return err
+	}
+	if util.BytesToUint32(pc.header[:4]) != pc.c.checksum {
+		return ErrInvalidIntegrity
+	}
+	atomic.AddUint64(&pc.connCounter.framesReceived, 1)
+	return nil
+}
+
+// discardStream reads the rest of the streamed body which is not consumed by the handler and returns the error of the body
+func (pc *RouterConn) discardStream() error {
+	if pc.c.readed >= pc.c.size {
+		return pc.c.err
+	}
+	bs := getFrameBuffer(frameBufferSizes[2])
+	defer putFrameBuffer(bs)
+	for pc.c.readed < pc.c.size {
+		if _, err := pc.readStream(bs); err != nil {
+			return err
+		}
+	}
+	return pc.c.err
+}
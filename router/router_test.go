@@ -0,0 +1,998 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fletaio/common"
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/clock"
+	"github.com/fletaio/framework/kvstore"
+	"github.com/fletaio/framework/message"
+	"github.com/fletaio/framework/router/evilnode"
+)
+
+func TestRemovePort(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "ipv4",
+			addr: "127.0.0.1:3000",
+			want: "127.0.0.1",
+		},
+		{
+			name: "ipv6",
+			addr: "[::1]:3000",
+			want: "::1",
+		},
+		{
+			name:    "ipv6 without port",
+			addr:    "[::1]",
+			want:    "::1",
+			wantErr: ErrNotFoundPort,
+		},
+		{
+			name:    "not numeric port",
+			addr:    "127.0.0.1:http",
+			want:    "127.0.0.1",
+			wantErr: ErrNotFoundPort,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RemovePort(tt.addr)
+			if err != tt.wantErr {
+				t.Errorf("RemovePort() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RemovePort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinHostPort(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		port int
+		want string
+	}{
+		{
+			name: "ipv4",
+			host: "127.0.0.1",
+			port: 3000,
+			want: "127.0.0.1:3000",
+		},
+		{
+			name: "ipv6",
+			host: "::1",
+			port: 3000,
+			want: "[::1]:3000",
+		},
+		{
+			name: "bracketed ipv6",
+			host: "[::1]",
+			port: 3000,
+			want: "[::1]:3000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JoinHostPort(tt.host, tt.port); got != tt.want {
+				t.Errorf("JoinHostPort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSameAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "same address",
+			a:    "127.0.0.1:3000",
+			b:    "127.0.0.1:3000",
+			want: true,
+		},
+		{
+			name: "other port",
+			a:    "127.0.0.1:3000",
+			b:    "127.0.0.1:3001",
+			want: false,
+		},
+		{
+			name: "host only",
+			a:    "127.0.0.1:3000",
+			b:    "127.0.0.1",
+			want: true,
+		},
+		{
+			name: "ipv6",
+			a:    "[::1]:3000",
+			b:    "[::1]:3000",
+			want: true,
+		},
+		{
+			name: "ipv6 other port",
+			a:    "[::1]:3000",
+			b:    "[::1]:3001",
+			want: false,
+		},
+		{
+			name: "other host",
+			a:    "127.0.0.1:3000",
+			b:    "127.0.0.2:3000",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSameAddress(tt.a, tt.b); got != tt.want {
+				t.Errorf("IsSameAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	exts := []Extension{
+		{Type: EXTTRACEID, Value: []byte("trace")},
+		{Type: EXTPRIORITY, Value: []byte{1}},
+		{Type: 200, Value: []byte{}},
+	}
+	bs, err := encodeExtensions(exts)
+	if err != nil {
+		t.Fatalf("encodeExtensions() error = %v", err)
+	}
+	got, err := decodeExtensions(bs)
+	if err != nil {
+		t.Fatalf("decodeExtensions() error = %v", err)
+	}
+	if len(got) != len(exts) {
+		t.Fatalf("decodeExtensions() len = %v, want %v", len(got), len(exts))
+	}
+	for i := range exts {
+		if got[i].Type != exts[i].Type || !bytes.Equal(got[i].Value, exts[i].Value) {
+			t.Errorf("decodeExtensions()[%v] = %v, want %v", i, got[i], exts[i])
+		}
+	}
+	if _, err := decodeExtensions(bs[:len(bs)-2]); err != ErrInvalidExtension {
+		t.Errorf("decodeExtensions() truncated error = %v, want %v", err, ErrInvalidExtension)
+	}
+}
+
+func TestAcceptFilter(t *testing.T) {
+	af, err := newAcceptFilter([]string{"10.0.0.0/8", "fd00::/8"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ip   string
+		want error
+	}{
+		{
+			name: "allowed",
+			ip:   "10.2.3.4",
+		},
+		{
+			name: "allowed ipv6",
+			ip:   "fd00::1",
+		},
+		{
+			name: "denied",
+			ip:   "10.1.2.3",
+			want: ErrDeniedAddress,
+		},
+		{
+			name: "not allowed",
+			ip:   "192.168.0.1",
+			want: ErrDeniedAddress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := af.check(&net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 3000}); err != tt.want {
+				t.Errorf("check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := newAcceptFilter([]string{"10.0.0.0"}, nil); err == nil {
+		t.Errorf("newAcceptFilter() should fail with an invalid CIDR")
+	}
+}
+
+func TestWorkerPoolOverload(t *testing.T) {
+	wp := newWorkerPool(1, 1)
+	defer wp.Close()
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error, 2)
+	go func() {
+		done <- wp.Do(func() error {
+			close(started)
+			<-block
+			return nil
+		})
+	}()
+	<-started
+	go func() {
+		done <- wp.Do(func() error { return nil })
+	}()
+	for len(wp.jobs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := wp.Do(func() error { return nil }); err != ErrHandshakeOverload {
+		t.Fatalf("Do() error = %v, want %v", err, ErrHandshakeOverload)
+	}
+	close(block)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Do() error = %v", err)
+		}
+	}
+	if s := wp.Stats(); s.Rejected != 1 || s.Completed != 2 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestResumeProof(t *testing.T) {
+	dialer := &RouterConn{challenge: []byte("dialer challenge"), remoteChallenge: []byte("acceptor challenge"), remoteKey: []byte("acceptor"), remoteID: "acceptor"}
+	acceptor := &RouterConn{challenge: []byte("acceptor challenge"), remoteChallenge: []byte("dialer challenge"), remoteKey: []byte("dialer"), remoteID: "dialer"}
+	dialer.remoteShare = acceptor.ownShare()
+	acceptor.remoteShare = dialer.ownShare()
+
+	dialed := newResumeCache(time.Minute, nil)
+	dialed.store("acceptor:3000", dialer, IsDial)
+	accepted := newResumeCache(time.Minute, nil)
+	accepted.store("dialer:3000", acceptor, IsAccept)
+
+	e := dialed.dialedSession("acceptor:3000")
+	if e == nil || e.secret == nil {
+		t.Fatal("dialedSession() = nil, want the session")
+	}
+	challenge := []byte("fresh challenge")
+	if got := accepted.resume(e.token, []byte("dialer"), challenge, nil); got != nil {
+		t.Errorf("resume() without the proof = %v, want nil", got)
+	}
+	if got := accepted.resume(e.token, []byte("dialer"), challenge, resumeProof(e.token, resumeDialLabel, challenge)); got != nil {
+		t.Errorf("resume() with the proof of the token = %v, want nil", got)
+	}
+	if got := accepted.resume(e.token, []byte("dialer"), challenge, resumeProof(e.secret, resumeAcceptLabel, challenge)); got != nil {
+		t.Errorf("resume() with the proof of the acceptor = %v, want nil", got)
+	}
+	proof := resumeProof(e.secret, resumeDialLabel, challenge)
+	if got := accepted.resume(e.token, []byte("other"), challenge, proof); got != nil {
+		t.Errorf("resume() of the other key = %v, want nil", got)
+	}
+	if got := accepted.resume(e.token, []byte("dialer"), challenge, proof); got == nil || got.pc != acceptor || !bytes.Equal(got.secret, e.secret) {
+		t.Errorf("resume() = %v, want the session of the acceptor", got)
+	}
+	if got := accepted.resume(e.token, []byte("dialer"), challenge, proof); got != nil {
+		t.Errorf("resume() again = %v, want nil", got)
+	}
+
+	legacy := &RouterConn{challenge: []byte("c1"), remoteChallenge: []byte("c2"), remoteKey: []byte("legacy"), remoteID: "legacy"}
+	dialed.store("legacy:3000", legacy, IsDial)
+	if e := dialed.dialedSession("legacy:3000"); e != nil {
+		t.Errorf("dialedSession() without the key share = %v, want nil", e)
+	}
+}
+
+func TestRemoveUnixConn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := listenUnix(UnixScheme + filepath.Join(dir, "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := net.Dial("unix", l.Addr().String()); err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &router{
+		ConnMap:     map[string]*RouterConn{},
+		ConnMapLock: NewNamedLock("ConnMap"),
+	}
+	pc := &RouterConn{pConn: conn, physical: r.physicalAddr(conn), r: r}
+	other := &RouterConn{pConn: conn, physical: r.physicalAddr(conn), r: r}
+	r.ConnMap[pc.physical] = pc
+	r.ConnMap[other.physical] = other
+	r.removeRouterConn(pc)
+	if _, has := r.ConnMap[pc.physical]; has || len(r.ConnMap) != 1 {
+		t.Errorf("ConnMap = %v, want only the other connection", r.ConnMap)
+	}
+}
+
+func TestHeaderTimeoutNotExtended(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := NewRouter(&Config{
+		Network:        "tcp",
+		Port:           41790,
+		HeaderTimeout:  200 * time.Millisecond,
+		EvilNodeConfig: evilnode.Config{StorePath: dir},
+	}, common.NewCoordinate(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:41790")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn.Read(make([]byte, 1))
+		close(closed)
+	}()
+	// the heartbits are trickled in the handshake to extend the deadline of each read
+	start := time.Now()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("closed after %v, want the HeaderTimeout", d)
+			}
+			return
+		case <-ticker.C:
+			if time.Since(start) > 3*time.Second {
+				t.Fatal("the connection is not closed by the HeaderTimeout")
+			}
+			conn.Write([]byte{HEARTBIT})
+		}
+	}
+}
+
+func TestNewRouterKeepsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Config{
+		Network:        "tcp",
+		Port:           41791,
+		Clock:          clock.NewManual(time.Now()),
+		EvilNodeConfig: evilnode.Config{StorePath: dir},
+	}
+	r, err := NewRouter(c, common.NewCoordinate(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if c.EvilNodeConfig.Clock != nil {
+		t.Errorf("EvilNodeConfig.Clock = %v, want nil", c.EvilNodeConfig.Clock)
+	}
+	if r.Conf().EvilNodeConfig.Clock != c.Clock {
+		t.Errorf("Conf().EvilNodeConfig.Clock = %v, want %v", r.Conf().EvilNodeConfig.Clock, c.Clock)
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd byte, family byte, body ...byte) []byte {
+		bs := append([]byte{}, proxyV2Signature...)
+		bs = append(bs, 0x20|cmd, family, byte(len(body)>>8), byte(len(body)))
+		return append(bs, body...)
+	}
+	v4Body := []byte{10, 1, 2, 3, 10, 0, 0, 1, 0x1F, 0x90, 0, 80}
+	v6Body := make([]byte, 36)
+	copy(v6Body, net.ParseIP("fd00::1"))
+	v6Body[32], v6Body[33] = 0x1F, 0x90
+	tests := []struct {
+		name   string
+		header []byte
+		remote string
+		err    error
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 10.1.2.3 10.0.0.1 8080 80\r\n"), remote: "10.1.2.3:8080"},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 fd00::1 fd00::2 8080 80\r\n"), remote: "[fd00::1]:8080"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 bad family", header: []byte("PROXY UDP4 10.1.2.3 10.0.0.1 8080 80\r\n"), err: ErrInvalidProxyHeader},
+		{name: "v1 bad ip", header: []byte("PROXY TCP4 10.1.2 10.0.0.1 8080 80\r\n"), err: ErrInvalidProxyHeader},
+		{name: "v1 bad port", header: []byte("PROXY TCP4 10.1.2.3 10.0.0.1 80800 80\r\n"), err: ErrInvalidProxyHeader},
+		{name: "v1 too long", header: append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), maxProxyV1Header)...), err: ErrInvalidProxyHeader},
+		{name: "v2 tcp4", header: v2(1, 0x11, v4Body...), remote: "10.1.2.3:8080"},
+		{name: "v2 tcp6", header: v2(1, 0x21, v6Body...), remote: "[fd00::1]:8080"},
+		{name: "v2 tcp4 tlv", header: v2(1, 0x11, append(append([]byte{}, v4Body...), make([]byte, 0x0130)...)...), remote: "10.1.2.3:8080"},
+		{name: "v2 tcp4 port", header: v2(1, 0x11, 10, 1, 2, 3, 10, 0, 0, 1, 0x90, 0x1F, 0, 80), remote: "10.1.2.3:36895"},
+		{name: "v2 local", header: v2(0, 0x11, v4Body...)},
+		{name: "v2 short body", header: v2(1, 0x11, v4Body[:8]...), err: ErrInvalidProxyHeader},
+		{name: "v2 bad command", header: v2(2, 0x11, v4Body...), err: ErrInvalidProxyHeader},
+		{name: "v2 bad version", header: append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0, 0), err: ErrInvalidProxyHeader},
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\n"), err: ErrInvalidProxyHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go client.Write(append(append([]byte{}, tt.header...), "rest"...))
+
+			conn, err := readProxyHeader(server, time.Second)
+			if err != tt.err {
+				t.Fatalf("readProxyHeader() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if tt.remote == "" {
+				if conn != server {
+					t.Errorf("readProxyHeader() = %v, want the connection as it is", conn.RemoteAddr())
+				}
+			} else if conn.RemoteAddr().String() != tt.remote {
+				t.Errorf("RemoteAddr() = %v, want %v", conn.RemoteAddr(), tt.remote)
+			}
+			rest := make([]byte, 4)
+			if _, err := conn.Read(rest); err != nil || string(rest) != "rest" {
+				t.Errorf("Read() = %q, %v, want the bytes after the header", rest, err)
+			}
+		})
+	}
+
+	if _, err := NewRouter(&Config{ProxyProtocol: true}, common.NewCoordinate(0, 0)); err != ErrNoProxyCIDRs {
+		t.Errorf("NewRouter() error = %v, want %v", err, ErrNoProxyCIDRs)
+	}
+}
+
+func TestStreamDiscard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := NewRouter(&Config{Network: "tcp", Port: 41792, EvilNodeConfig: evilnode.Config{StorePath: dir}}, common.NewCoordinate(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	mt := message.DefineType("router.TestStreamDiscard")
+	mm := message.NewManager()
+	// the creator reads less than the declared length of the body
+	mm.SetCreator(mt, func(r io.Reader, mt message.Type) (message.Message, error) {
+		if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+
+	body := bytes.Repeat([]byte{1}, 100)
+	tests := []struct {
+		name     string
+		checksum uint32
+		want     error
+	}{
+		{name: "verified", checksum: crc32.ChecksumIEEE(body)},
+		{name: "corrupted", checksum: crc32.ChecksumIEEE(body) + 1, want: ErrInvalidIntegrity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			pc := &RouterConn{pConn: server, r: r.(*router), data: newDataPlane(), c: dataCase{size: len(body), streamed: true}}
+			written := make(chan error, 1)
+			go func() {
+				_, err := client.Write(append(append([]byte{}, body...), util.Uint32ToBytes(tt.checksum)...))
+				written <- err
+			}()
+			if _, err := mm.ParseMessage(pc, mt); err != tt.want {
+				t.Errorf("ParseMessage() error = %v, want %v", err, tt.want)
+			}
+			select {
+			case err := <-written:
+				if err != nil {
+					t.Errorf("Write() error = %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("the rest of the body is not discarded")
+			}
+			if pc.Remaining() != 0 {
+				t.Errorf("Remaining() = %v, want 0", pc.Remaining())
+			}
+		})
+	}
+}
+
+// connectTestRouters connects the routers of the configs over the loopback from the port of the first one.
+// Both of them serve the coordinates in addition to their own one
+func connectTestRouters(t *testing.T, port int, ca *Config, cb *Config, coords ...*common.Coordinate) (Router, Router, Conn, Conn, func()) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.Network, ca.Port, ca.EvilNodeConfig.StorePath = "tcp", port, filepath.Join(dir, "a")
+	cb.Network, cb.Port, cb.EvilNodeConfig.StorePath = "tcp", port+1, filepath.Join(dir, "b")
+	a, err := NewRouter(ca, common.NewCoordinate(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRouter(cb, common.NewCoordinate(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		a.Close()
+		b.Close()
+		os.RemoveAll(dir)
+	}
+	for _, coord := range coords {
+		if err := a.RegisterCoord(coord); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if err := b.RegisterCoord(coord); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	if err := a.Listen(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := b.Listen(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	go b.Request(JoinHostPort("127.0.0.1", port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ac, _, err := a.AcceptContext(ctx, nil)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	bc, _, err := b.AcceptContext(ctx, nil)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return a, b, ac, bc, cleanup
+}
+
+// readTestBody reads the body of the frame and releases it
+func readTestBody(c Conn, size int) ([]byte, error) {
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c, body); err != nil {
+		return nil, err
+	}
+	return body, c.Release()
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        *Config
+		b        *Config
+		want     uint8
+		wantFeat bool
+	}{
+		{
+			name:     "shared",
+			a:        &Config{Compressions: []uint8{ZSTD, SNAPPY}},
+			b:        &Config{Compressions: []uint8{ZSTD, SNAPPY}},
+			want:     ZSTD,
+			wantFeat: true,
+		},
+		{
+			name:     "subset",
+			a:        &Config{Compressions: []uint8{ZSTD, SNAPPY}},
+			b:        &Config{Compressions: []uint8{SNAPPY}},
+			want:     SNAPPY,
+			wantFeat: true,
+		},
+		{
+			name: "disjoint",
+			a:    &Config{Compressions: []uint8{ZSTD}},
+			b:    &Config{Compressions: []uint8{SNAPPY}},
+			want: UNCOMPRESSED,
+		},
+		{
+			name: "legacy",
+			a:    &Config{Compressions: []uint8{ZSTD, SNAPPY}},
+			b:    &Config{Compressions: []uint8{ZSTD, SNAPPY}, DisabledFeatures: legacyFeatures},
+			want: UNCOMPRESSED,
+		},
+	}
+	body := bytes.Repeat([]byte("compressible "), 1000)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.a.CompressionThreshold = 1024
+			tt.b.CompressionThreshold = 1024
+			_, _, ac, bc, cleanup := connectTestRouters(t, 41793+i*2, tt.a, tt.b)
+			defer cleanup()
+
+			for _, c := range []Conn{ac, bc} {
+				pc := c.(*RouterConn)
+				if got := pc.CompressionStats().Compression; got != tt.want {
+					t.Errorf("Compression = %v, want %v", got, tt.want)
+				}
+				if got := pc.Features()&FeatureCompression != 0; got != tt.wantFeat {
+					t.Errorf("FeatureCompression = %v, want %v", got, tt.wantFeat)
+				}
+			}
+			for _, pair := range [][2]Conn{{ac, bc}, {bc, ac}} {
+				written := make(chan error, 1)
+				go func() {
+					_, err := pair[0].Write(body)
+					written <- err
+				}()
+				got, err := readTestBody(pair[1], len(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := <-written; err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, body) {
+					t.Errorf("the body is corrupted")
+				}
+				stats := pair[0].(*RouterConn).CompressionStats()
+				if compressed := stats.CompressedFrames > 0; compressed != (tt.want != UNCOMPRESSED) {
+					t.Errorf("CompressedFrames = %v, want compressed %v", stats.CompressedFrames, tt.want != UNCOMPRESSED)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled uint32
+	}{
+		{name: "batched"},
+		{name: "legacy", disabled: FeatureBatch},
+	}
+	bodies := [][]byte{[]byte("first"), bytes.Repeat([]byte{2}, 3000), []byte("third body")}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, ac, bc, cleanup := connectTestRouters(t, 41801+i*2, &Config{}, &Config{DisabledFeatures: tt.disabled})
+			defer cleanup()
+
+			if got := ac.(*RouterConn).Features()&FeatureBatch != 0; got != (tt.disabled == 0) {
+				t.Errorf("FeatureBatch = %v, want %v", got, tt.disabled == 0)
+			}
+			written := make(chan error, 1)
+			go func() {
+				_, err := ac.WriteBatch(bodies)
+				written <- err
+			}()
+			for _, want := range bodies {
+				got, err := readTestBody(bc, len(want))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("body = %q, want %q", got, want)
+				}
+				if bc.Remaining() != 0 {
+					t.Errorf("Remaining() = %v, want 0", bc.Remaining())
+				}
+			}
+			if err := <-written; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestChannelFlowControl(t *testing.T) {
+	_, _, ac, bc, cleanup := connectTestRouters(t, 41805, &Config{ChannelWindow: 64 * 1024, WriteTimeout: time.Second}, &Config{ChannelWindow: 64 * 1024, WriteTimeout: time.Second})
+	defer cleanup()
+
+	// the application channel is stalled by the window while the control channel still flows
+	body := make([]byte, 10*1024)
+	sent := 0
+	var err error
+	for i := 0; i < 20; i++ {
+		if _, err = bc.Write(body); err != nil {
+			break
+		}
+		sent++
+	}
+	if err != ErrWriteTimeout {
+		t.Fatalf("Write() error = %v, want %v", err, ErrWriteTimeout)
+	}
+	if _, err := bc.Channel(ChannelControl).Write([]byte("control")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(body))
+	n, err := ac.Channel(ChannelControl).Read(buf)
+	if err != nil || string(buf[:n]) != "control" {
+		t.Fatalf("Read() = %q, %v, want %q", buf[:n], err, "control")
+	}
+	for i := 0; i < sent; i++ {
+		if _, err := readTestBody(ac, len(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, ac, bc, cleanup = connectTestRouters(t, 41807, &Config{}, &Config{DisabledFeatures: FeatureChannels})
+	defer cleanup()
+	if ac.Channel(ChannelControl) != nil || bc.Channel(ChannelControl) != nil {
+		t.Errorf("Channel() is not nil without FeatureChannels")
+	}
+}
+
+func TestSharedCoordinate(t *testing.T) {
+	coord := common.NewCoordinate(1, 0)
+	named := common.NewCoordinate(2, 0)
+	a, b, ac, bc, cleanup := connectTestRouters(t, 41809, &Config{}, &Config{}, coord, named)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.RequestContext(ctx, JoinHostPort("127.0.0.1", 41809), coord); err != nil {
+		t.Fatal(err)
+	}
+	acc, _, err := a.AcceptContext(ctx, coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcc, _, err := b.AcceptContext(ctx, coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.ConnList()) != 1 || len(b.ConnList()) != 1 {
+		t.Errorf("ConnList() = %v, %v, want one physical connection", a.ConnList(), b.ConnList())
+	}
+	if err := a.RegisterCoord(coord); err != ErrAlreadyRegisteredCoord {
+		t.Errorf("RegisterCoord() = %v, want %v", err, ErrAlreadyRegisteredCoord)
+	}
+
+	// the hostname of the address finds the physical connection of its IP instead of dialing another one
+	pc, _ := b.(*router).connOf(JoinHostPort("127.0.0.1", 41809))
+	requested := make(chan error, 1)
+	go func() {
+		requested <- b.RequestContext(ctx, JoinHostPort("localhost", 41809), named)
+	}()
+	select {
+	case err := <-requested:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the address of the hostname is dialed again")
+	}
+	if got, _ := b.(*router).connOf(JoinHostPort("localhost", 41809)); got != pc || pc.isClose {
+		t.Errorf("the physical connection is replaced by the request of the hostname")
+	}
+	if _, _, err := a.AcceptContext(ctx, named); err != nil {
+		t.Fatal(err)
+	}
+
+	// the frames of the coordinates are delivered to their own logical connections
+	for _, pair := range [][2]Conn{{ac, bc}, {acc, bcc}, {bcc, acc}, {bc, ac}} {
+		written := make(chan error, 1)
+		go func() {
+			_, err := pair[0].Write([]byte("coordinate"))
+			written <- err
+		}()
+		got, err := readTestBody(pair[1], len("coordinate"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-written; err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "coordinate" {
+			t.Errorf("body = %q, want %q", got, "coordinate")
+		}
+	}
+}
+
+func TestDataPlane(t *testing.T) {
+	_, b, ac, bc, cleanup := connectTestRouters(t, 41811, &Config{DataPlane: true}, &Config{DataPlane: true})
+	defer cleanup()
+
+	for _, c := range []Conn{ac, bc} {
+		select {
+		case <-c.DataReady():
+		case <-time.After(5 * time.Second):
+			t.Fatal("the data plane is not established")
+		}
+	}
+	ad, bd := ac.Data(), bc.Data()
+	if ad == nil || bd == nil {
+		t.Fatal("Data() is nil after DataReady")
+	}
+	if !ad.(*RouterConn).IsDataPlane() || ac.(*RouterConn).IsDataPlane() {
+		t.Errorf("IsDataPlane() doesn't tell the data plane from the primary")
+	}
+
+	// the bulk data and the control messages are delivered over their own connections
+	for _, pair := range [][2]Conn{{ad, bd}, {bd, ad}, {ac, bc}, {bc, ac}} {
+		written := make(chan error, 1)
+		go func() {
+			_, err := pair[0].Write([]byte("plane"))
+			written <- err
+		}()
+		got, err := readTestBody(pair[1], len("plane"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-written; err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "plane" {
+			t.Errorf("body = %q, want %q", got, "plane")
+		}
+	}
+
+	// closing the data plane keeps the primary connection, the other side closes it as the read fails
+	bd.Close()
+	if _, err := readTestBody(ad, 1); err == nil {
+		t.Fatal("the data plane is readable after it is closed")
+	}
+	ad.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for ac.Data() != nil || bc.Data() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the closed data plane is not detached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(b.ConnList()) != 1 {
+		t.Errorf("ConnList() = %v, want the primary connection", b.ConnList())
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := bc.Write([]byte("primary"))
+		written <- err
+	}()
+	if got, err := readTestBody(ac, len("primary")); err != nil || string(got) != "primary" {
+		t.Errorf("Read() = %q, %v, want %q", got, err, "primary")
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFrameBufferPool(t *testing.T) {
+	tests := []struct {
+		n       int
+		wantCap int
+	}{
+		{n: 1, wantCap: 512},
+		{n: 512, wantCap: 512},
+		{n: 513, wantCap: 4 * 1024},
+		{n: 256 * 1024, wantCap: 256 * 1024},
+		{n: 256*1024 + 1, wantCap: 256*1024 + 1},
+	}
+	for _, tt := range tests {
+		bs := getFrameBuffer(tt.n)
+		if len(bs) != tt.n || cap(bs) != tt.wantCap {
+			t.Errorf("getFrameBuffer(%v) = len %v cap %v, want len %v cap %v", tt.n, len(bs), cap(bs), tt.n, tt.wantCap)
+		}
+		putFrameBuffer(bs)
+	}
+
+	// the reused buffers don't corrupt the bodies of the next frames and the partially read body is kept by Release
+	_, _, ac, bc, cleanup := connectTestRouters(t, 41813, &Config{StreamThreshold: -1}, &Config{StreamThreshold: -1})
+	defer cleanup()
+
+	bodies := [][]byte{}
+	for i, n := range []int{100, 5000, 40000, 300000, 100, 5000, 40000} {
+		bodies = append(bodies, bytes.Repeat([]byte{byte(i + 1)}, n))
+	}
+	written := make(chan error, 1)
+	go func() {
+		for _, body := range bodies {
+			if _, err := ac.Write(body); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	for _, want := range bodies {
+		got := make([]byte, len(want))
+		half := len(want) / 2
+		if _, err := io.ReadFull(bc, got[:half]); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if bc.Remaining() != len(want)-half {
+			t.Errorf("Remaining() = %v, want %v", bc.Remaining(), len(want)-half)
+		}
+		if _, err := io.ReadFull(bc, got[half:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("the body of %v bytes is corrupted", len(want))
+		}
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlacklistStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the embedder shares its store and the hosts are sealed by the key of the evil node store
+	shared := kvstore.NewMemory()
+	newRouter := func() Router {
+		r, err := NewRouter(&Config{
+			Network:        "tcp",
+			BlacklistStore: kvstore.NewPrefix(shared, "blacklist/"),
+			EvilNodeConfig: evilnode.Config{StorePath: dir, StoreBackend: kvstore.Memory, StoreKey: "secret"},
+		}, common.NewCoordinate(0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	r := newRouter()
+	if err := r.Blacklist("10.1.2.3:3000"); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	shared.Iterate(func(key []byte, value []byte) bool {
+		if bytes.Contains(key, []byte("10.1.2.3")) || bytes.Contains(value, []byte("10.1.2.3")) {
+			t.Errorf("the blacklisted host is stored in plaintext")
+		}
+		return true
+	})
+
+	r = newRouter()
+	defer r.Close()
+	if got := r.Blacklisted(); len(got) != 1 || got[0] != "10.1.2.3" {
+		t.Errorf("Blacklisted() = %v, want [10.1.2.3]", got)
+	}
+	if err := r.Request("10.1.2.3:4000"); err != ErrBlacklisted {
+		t.Errorf("Request() error = %v, want %v", err, ErrBlacklisted)
+	}
+}
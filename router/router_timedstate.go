@@ -0,0 +1,169 @@
+package router
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fletaio/common/util"
+	"github.com/fletaio/framework/clock"
+)
+
+// handshakeKey identifies the handshake by the key and the challenge which are fresh for every handshake
+func handshakeKey(publicKey []byte, challenge []byte) string {
+	h := sha256.Sum256(append(append([]byte{}, publicKey...), challenge...))
+	return hex.EncodeToString(h[:])
+}
+
+// checkReplay records the inbound handshake and returns ErrReplayedHandshake when it is seen in the HandshakeReplayWindow
+func (r *router) checkReplay(publicKey []byte, challenge []byte) error {
+	if r.conf().HandshakeReplayWindow <= 0 || len(challenge) == 0 {
+		return nil
+	}
+	key := handshakeKey(publicKey, challenge)
+
+	r.recentLock.Lock()
+	defer r.recentLock.Unlock()
+
+	if r.recentHandshakes.Has(key) {
+		return ErrReplayedHandshake
+	}
+	r.recentHandshakes.Set(key, struct{}{}, r.conf().HandshakeReplayWindow)
+	return nil
+}
+
+// timedStatePath returns the TimedStatePath or the one next to the evil node store
+func timedStatePath(c *Config) string {
+	if c.TimedStatePath != "" {
+		return c.TimedStatePath
+	}
+	return strings.TrimRight(c.EvilNodeConfig.StorePath, "/\\") + "_timed"
+}
+
+// loadTimedState restores the recent handshakes and the redial backoffs which are not expired yet
+func (r *router) loadTimedState() error {
+	if !r.conf().PersistTimedState {
+		return nil
+	}
+	f, err := os.Open(timedStatePath(r.conf()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	now := r.clock.Now()
+	count, _, err := util.ReadUint32(rd)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		key, _, err := util.ReadString(rd)
+		if err != nil {
+			return err
+		}
+		at, _, err := util.ReadUint64(rd)
+		if err != nil {
+			return err
+		}
+		if expireAt := time.Unix(0, int64(at)); expireAt.After(now) {
+			r.recentHandshakes.SetExpireAt(key, struct{}{}, expireAt)
+		}
+	}
+	count, _, err = util.ReadUint32(rd)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		addr, _, err := util.ReadString(rd)
+		if err != nil {
+			return err
+		}
+		failures, _, err := util.ReadUint32(rd)
+		if err != nil {
+			return err
+		}
+		at, _, err := util.ReadUint64(rd)
+		if err != nil {
+			return err
+		}
+		if next := time.Unix(0, int64(at)); next.After(now) {
+			r.backoff.restore(backoffEntry{addr: addr, failures: uint(failures), next: next})
+		}
+	}
+	return nil
+}
+
+// saveTimedState writes the recent handshakes and the redial backoffs so that a quick restart keeps the windows
+func (r *router) saveTimedState() error {
+	if !r.conf().PersistTimedState {
+		return nil
+	}
+	path := timedStatePath(r.conf())
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := r.writeTimedState(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (r *router) writeTimedState(w io.Writer) error {
+	items := r.recentHandshakes.Items()
+	if _, err := util.WriteUint32(w, uint32(len(items))); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if _, err := util.WriteString(w, item.Key.(string)); err != nil {
+			return err
+		}
+		if _, err := util.WriteUint64(w, uint64(item.ExpireAt.UnixNano())); err != nil {
+			return err
+		}
+	}
+	entries := r.backoff.entries()
+	if _, err := util.WriteUint32(w, uint32(len(entries))); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, err := util.WriteString(w, e.addr); err != nil {
+			return err
+		}
+		if _, err := util.WriteUint32(w, uint32(e.failures)); err != nil {
+			return err
+		}
+		if _, err := util.WriteUint64(w, uint64(e.next.UnixNano())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireTimedState removes the expired handshakes every window
+func (r *router) expireTimedState() {
+	window := r.conf().HandshakeReplayWindow
+	if window <= 0 {
+		return
+	}
+	for clock.Sleep(r.clock, window, r.closeCh) {
+		r.recentHandshakes.Expire()
+	}
+}
@@ -0,0 +1,61 @@
+package router
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// UnixScheme is the prefix of the addresses of the Unix domain sockets (e.g. "unix:/var/run/fleta.sock")
+// which are listened and requested for the links between the processes of the same host
+const UnixScheme = "unix:"
+
+// IsUnixAddress returns the address is the one of a Unix domain socket
+func IsUnixAddress(addr string) bool {
+	return strings.HasPrefix(addr, UnixScheme)
+}
+
+func unixPath(addr string) string {
+	return strings.TrimPrefix(addr, UnixScheme)
+}
+
+func isUnixConn(conn net.Conn) bool {
+	return conn.LocalAddr().Network() == "unix"
+}
+
+// listenUnix listens the socket path and removes the stale socket file which is left by a crashed process
+func listenUnix(addr string) (net.Listener, error) {
+	path := unixPath(addr)
+	l, err := net.Listen("unix", path)
+	if err == nil {
+		return l, nil
+	}
+	if _, statErr := os.Stat(path); statErr != nil {
+		return nil, err
+	}
+	if conn, dialErr := net.DialTimeout("unix", path, time.Second); dialErr == nil {
+		// the socket is being listened by the other process
+		conn.Close()
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// physicalAddr returns the key of the physical connection.
+// It is the IP of the TCP connection and the scheme prefixed path of the dialed Unix domain socket.
+// The accepted Unix domain socket doesn't have the remote address, so a unique sequence is used.
+func (r *router) physicalAddr(conn net.Conn) string {
+	if !isUnixConn(conn) {
+		return hostOf(conn.RemoteAddr())
+	}
+	if name := conn.RemoteAddr().String(); name != "" && name != "@" {
+		return UnixScheme + name
+	}
+	return UnixScheme + "@" + strconv.FormatUint(atomic.AddUint64(&r.unixSeq, 1), 10)
+}
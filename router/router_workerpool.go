@@ -0,0 +1,116 @@
+package router
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WorkerPoolStats is the statistics of the handshake worker pool
+type WorkerPoolStats struct {
+	Workers   int
+	Queued    int64
+	Active    int64
+	Completed uint64
+	Rejected  uint64
+	TotalWait time.Duration
+}
+
+// AverageWait returns the average time that the jobs waited in the queue
+func (s WorkerPoolStats) AverageWait() time.Duration {
+	if s.Completed == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Completed)
+}
+
+type poolJob struct {
+	f      func() error
+	queued time.Time
+	errCh  chan error
+}
+
+// workerPool runs the CPU-bound jobs in the bounded number of workers
+// so that they don't occupy the goroutines which handle the network I/O
+type workerPool struct {
+	workers   int
+	jobs      chan *poolJob
+	closeCh   chan struct{}
+	queued    int64
+	active    int64
+	completed uint64
+	rejected  uint64
+	totalWait int64
+}
+
+func newWorkerPool(workers int, queueSize int) *workerPool {
+	wp := &workerPool{
+		workers: workers,
+		jobs:    make(chan *poolJob, queueSize),
+		closeCh: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go wp.run()
+	}
+	return wp
+}
+
+func (wp *workerPool) run() {
+	for {
+		select {
+		case <-wp.closeCh:
+			return
+		case job := <-wp.jobs:
+			atomic.AddInt64(&wp.queued, -1)
+			atomic.AddInt64(&wp.totalWait, int64(time.Now().Sub(job.queued)))
+			atomic.AddInt64(&wp.active, 1)
+			err := job.f()
+			atomic.AddInt64(&wp.active, -1)
+			atomic.AddUint64(&wp.completed, 1)
+			job.errCh <- err
+		}
+	}
+}
+
+// Do queues the job and waits until it is done.
+// It returns ErrHandshakeOverload without waiting when the queue is full, so the connection is rejected instead of stalling the caller
+func (wp *workerPool) Do(f func() error) error {
+	job := &poolJob{
+		f:      f,
+		queued: time.Now(),
+		errCh:  make(chan error, 1),
+	}
+	atomic.AddInt64(&wp.queued, 1)
+	select {
+	case wp.jobs <- job:
+	case <-wp.closeCh:
+		atomic.AddInt64(&wp.queued, -1)
+		return ErrRouterClosed
+	default:
+		atomic.AddInt64(&wp.queued, -1)
+		atomic.AddUint64(&wp.rejected, 1)
+		return ErrHandshakeOverload
+	}
+	select {
+	case err := <-job.errCh:
+		return err
+	case <-wp.closeCh:
+		return ErrRouterClosed
+	}
+}
+
+// Close stops the workers
+func (wp *workerPool) Close() {
+	close(wp.closeCh)
+}
+
+// Stats returns the statistics of the pool
+func (wp *workerPool) Stats() WorkerPoolStats {
+	return WorkerPoolStats{
+		Workers:   wp.workers,
+		Queued:    atomic.LoadInt64(&wp.queued),
+		Active:    atomic.LoadInt64(&wp.active),
+		Completed: atomic.LoadUint64(&wp.completed),
+		Rejected:  atomic.LoadUint64(&wp.rejected),
+		TotalWait: time.Duration(atomic.LoadInt64(&wp.totalWait)),
+	}
+}
@@ -0,0 +1,235 @@
+package ttlcache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+)
+
+// ExpireFunc is called with the entry which is expired
+type ExpireFunc func(key interface{}, value interface{})
+
+type entry struct {
+	value    interface{}
+	expireAt time.Time
+	onExpire ExpireFunc
+}
+
+func (e *entry) isExpired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// Cache is a map whose entries are removed after their TTLs.
+// The expired entries are not returned and they are removed with their callbacks by Expire,
+// which is called in every cleanup interval when the interval is positive.
+type Cache struct {
+	lock      sync.Mutex
+	entries   map[interface{}]*entry
+	onExpire  ExpireFunc
+	clock     clock.Clock
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a Cache which removes the expired entries in every cleanup interval.
+// The entries are only removed by Expire when the interval is not positive.
+func New(cleanup time.Duration) *Cache {
+	return NewWithClock(cleanup, clock.Real)
+}
+
+// NewWithClock returns a Cache whose TTLs and cleanup interval are measured by the clock
+func NewWithClock(cleanup time.Duration, clk clock.Clock) *Cache {
+	c := &Cache{
+		entries: map[interface{}]*entry{},
+		clock:   clock.Or(clk),
+		closeCh: make(chan struct{}),
+	}
+	if cleanup > 0 {
+		go c.run(cleanup)
+	}
+	return c
+}
+
+// SetExpireHandler sets the callback of the expired entries which don't have their own callbacks
+func (c *Cache) SetExpireHandler(onExpire ExpireFunc) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.onExpire = onExpire
+}
+
+// Set stores the value which is expired after the ttl, it never expires when the ttl is not positive
+func (c *Cache) Set(key interface{}, value interface{}, ttl time.Duration) {
+	c.SetWithExpire(key, value, ttl, nil)
+}
+
+// SetWithExpire stores the value with the callback which is called when it is expired
+func (c *Cache) SetWithExpire(key interface{}, value interface{}, ttl time.Duration, onExpire ExpireFunc) {
+	e := &entry{
+		value:    value,
+		onExpire: onExpire,
+	}
+	if ttl > 0 {
+		e.expireAt = c.clock.Now().Add(ttl)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[key] = e
+}
+
+// SetExpireAt stores the value which is expired at the time, it never expires when the time is zero.
+// It is used to restore the entries with their original expiry times.
+func (c *Cache) SetExpireAt(key interface{}, value interface{}, expireAt time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[key] = &entry{
+		value:    value,
+		expireAt: expireAt,
+	}
+}
+
+// Item is an entry of the cache with its expiry time
+type Item struct {
+	Key      interface{}
+	Value    interface{}
+	ExpireAt time.Time
+}
+
+// Items returns the snapshot of the entries which are not expired
+func (c *Cache) Items() []Item {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	list := make([]Item, 0, len(c.entries))
+	for k, e := range c.entries {
+		if !e.isExpired(now) {
+			list = append(list, Item{Key: k, Value: e.value, ExpireAt: e.expireAt})
+		}
+	}
+	return list
+}
+
+// Get returns the value of the key which is not expired
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, has := c.entries[key]
+	if !has || e.isExpired(c.clock.Now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Has returns the key has the value which is not expired
+func (c *Cache) Has(key interface{}) bool {
+	_, has := c.Get(key)
+	return has
+}
+
+// ExpireAt returns the expiry time of the key, it is zero when the entry never expires
+func (c *Cache) ExpireAt(key interface{}) (time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, has := c.entries[key]
+	if !has || e.isExpired(c.clock.Now()) {
+		return time.Time{}, false
+	}
+	return e.expireAt, true
+}
+
+// Delete removes the entry without calling the callback
+func (c *Cache) Delete(key interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, key)
+}
+
+// Len returns the number of the entries which are not expired
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	count := 0
+	for _, e := range c.entries {
+		if !e.isExpired(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// Range calls f sequentially for the snapshot of the entries which are not expired.
+// If f returns false, range stops the iteration.
+func (c *Cache) Range(f func(key interface{}, value interface{}) bool) {
+	type pair struct {
+		key   interface{}
+		value interface{}
+	}
+	c.lock.Lock()
+	now := c.clock.Now()
+	list := make([]pair, 0, len(c.entries))
+	for k, e := range c.entries {
+		if !e.isExpired(now) {
+			list = append(list, pair{key: k, value: e.value})
+		}
+	}
+	c.lock.Unlock()
+
+	for _, p := range list {
+		if !f(p.key, p.value) {
+			return
+		}
+	}
+}
+
+// Expire removes the expired entries and calls their callbacks, it returns the number of them
+func (c *Cache) Expire() int {
+	type expired struct {
+		key      interface{}
+		value    interface{}
+		onExpire ExpireFunc
+	}
+	c.lock.Lock()
+	now := c.clock.Now()
+	list := []expired{}
+	for k, e := range c.entries {
+		if e.isExpired(now) {
+			delete(c.entries, k)
+			onExpire := e.onExpire
+			if onExpire == nil {
+				onExpire = c.onExpire
+			}
+			list = append(list, expired{key: k, value: e.value, onExpire: onExpire})
+		}
+	}
+	c.lock.Unlock()
+
+	for _, e := range list {
+		if e.onExpire != nil {
+			e.onExpire(e.key, e.value)
+		}
+	}
+	return len(list)
+}
+
+// Close stops the cleanup
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+}
+
+func (c *Cache) run(cleanup time.Duration) {
+	for clock.Sleep(c.clock, cleanup, c.closeCh) {
+		c.Expire()
+	}
+}
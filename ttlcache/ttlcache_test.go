@@ -0,0 +1,190 @@
+package ttlcache
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fletaio/framework/clock"
+)
+
+func TestCacheExpire(t *testing.T) {
+	m := clock.NewManual(time.Unix(1000, 0))
+	c := NewWithClock(0, m)
+	defer c.Close()
+
+	expired := map[interface{}]interface{}{}
+	c.SetExpireHandler(func(key interface{}, value interface{}) {
+		expired[key] = value
+	})
+	c.Set("a", 1, 10*time.Millisecond)
+	c.Set("b", 2, 0)
+	called := false
+	c.SetWithExpire(3, "c", 20*time.Millisecond, func(key interface{}, value interface{}) {
+		called = true
+	})
+	if c.Len() != 3 || !c.Has("a") {
+		t.Fatalf("Len() = %v, want %v", c.Len(), 3)
+	}
+	if at, has := c.ExpireAt("a"); !has || !at.Equal(m.Now().Add(10*time.Millisecond)) {
+		t.Errorf("ExpireAt(a) = %v, %v", at, has)
+	}
+
+	m.Advance(10 * time.Millisecond)
+	if c.Has("a") {
+		t.Errorf("Has(a) = true after the ttl")
+	}
+	if !c.Has(3) {
+		t.Errorf("Has(3) = false before the ttl")
+	}
+	if n := c.Expire(); n != 1 {
+		t.Errorf("Expire() = %v, want %v", n, 1)
+	}
+	if expired["a"] != 1 || len(expired) != 1 || called {
+		t.Errorf("expired = %v, called = %v", expired, called)
+	}
+
+	m.Advance(10 * time.Millisecond)
+	if c.Len() != 1 {
+		t.Errorf("Len() = %v, want %v", c.Len(), 1)
+	}
+	if n := c.Expire(); n != 1 {
+		t.Errorf("Expire() = %v, want %v", n, 1)
+	}
+	if len(expired) != 1 || !called {
+		t.Errorf("expired = %v, called = %v", expired, called)
+	}
+	if v, has := c.Get("b"); !has || v != 2 {
+		t.Errorf("Get(b) = %v, %v", v, has)
+	}
+	if at, has := c.ExpireAt("b"); !has || !at.IsZero() {
+		t.Errorf("ExpireAt(b) = %v, %v", at, has)
+	}
+}
+
+func TestCacheRange(t *testing.T) {
+	m := clock.NewManual(time.Unix(1000, 0))
+	c := NewWithClock(0, m)
+	defer c.Close()
+
+	c.Set(1, "a", time.Second)
+	c.Set(2, "b", 0)
+	c.Set(3, "c", 2*time.Second)
+	m.Advance(time.Second)
+
+	keys := []int{}
+	c.Range(func(key interface{}, value interface{}) bool {
+		keys = append(keys, key.(int))
+		return true
+	})
+	sort.Ints(keys)
+	if len(keys) != 2 || keys[0] != 2 || keys[1] != 3 {
+		t.Errorf("Range keys = %v, want %v", keys, []int{2, 3})
+	}
+
+	count := 0
+	c.Range(func(key interface{}, value interface{}) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("Range calls = %v after false, want %v", count, 1)
+	}
+
+	items := c.Items()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key.(int) < items[j].Key.(int)
+	})
+	if len(items) != 2 {
+		t.Fatalf("len(Items()) = %v, want %v", len(items), 2)
+	}
+	if items[0].Key != 2 || items[0].Value != "b" || !items[0].ExpireAt.IsZero() {
+		t.Errorf("Items()[0] = %+v", items[0])
+	}
+	if items[1].Key != 3 || items[1].Value != "c" || !items[1].ExpireAt.Equal(time.Unix(1002, 0)) {
+		t.Errorf("Items()[1] = %+v", items[1])
+	}
+
+	restored := NewWithClock(0, m)
+	defer restored.Close()
+	for _, v := range items {
+		restored.SetExpireAt(v.Key, v.Value, v.ExpireAt)
+	}
+	m.Advance(time.Second)
+	if restored.Has(3) || !restored.Has(2) {
+		t.Errorf("Has(3) = %v, Has(2) = %v after the restored expiry", restored.Has(3), restored.Has(2))
+	}
+}
+
+func TestCacheCleanup(t *testing.T) {
+	m := clock.NewManual(time.Unix(1000, 0))
+	c := NewWithClock(time.Second, m)
+
+	expiredCh := make(chan interface{}, 4)
+	c.SetExpireHandler(func(key interface{}, value interface{}) {
+		expiredCh <- key
+	})
+	c.Set("a", 1, 500*time.Millisecond)
+	c.Set("b", 2, 1500*time.Millisecond)
+
+	waitTimer(t, m)
+	m.Advance(500 * time.Millisecond)
+	select {
+	case key := <-expiredCh:
+		t.Fatalf("%v is expired before the cleanup interval", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.Advance(500 * time.Millisecond)
+	if key := waitExpired(t, expiredCh); key != "a" {
+		t.Errorf("expired = %v, want %v", key, "a")
+	}
+
+	waitTimer(t, m)
+	m.Advance(time.Second)
+	if key := waitExpired(t, expiredCh); key != "b" {
+		t.Errorf("expired = %v, want %v", key, "b")
+	}
+
+	waitTimer(t, m)
+	c.Set("c", 3, 500*time.Millisecond)
+	c.Close()
+	deadline := time.Now().Add(time.Second)
+	for m.Timers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the cleanup timer is not stopped by Close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.Advance(time.Second)
+	select {
+	case key := <-expiredCh:
+		t.Errorf("%v is expired by the cleanup after Close", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if c.Len() != 0 || c.Expire() != 1 {
+		t.Errorf("the closed cache doesn't keep the entry for Expire")
+	}
+}
+
+func waitTimer(t *testing.T, m *clock.Manual) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.Timers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the cleanup timer is not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitExpired(t *testing.T, ch <-chan interface{}) interface{} {
+	t.Helper()
+	select {
+	case key := <-ch:
+		return key
+	case <-time.After(time.Second):
+		t.Fatalf("the entry is not expired by the cleanup")
+		return nil
+	}
+}